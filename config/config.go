@@ -14,6 +14,9 @@ const (
 	BSC_CHAIN  string = "BSC"
 	HECO_CHAIN string = "HECO"
 
+	POLYGON_CHAIN string = "POLYGON"
+	ETC_CHAIN     string = "ETC"
+
 	LOG_DIR    string = "log"
 	LOG_CONFIG string = `{
 		"TimeFormat":"2006-01-02 15:04:05",
@@ -36,13 +39,75 @@ const (
 
 var (
 	ServerConfig Server
+	endpoints    = make(map[string]*Endpoint)
 )
 
+// Endpoint holds the connection options of a single rpc url, durations are
+// strings like "30s".
+type Endpoint struct {
+	Timeout   string            `json:"timeout"`
+	KeepAlive string            `json:"keepalive"`
+	Tls       bool              `json:"tls"`      // grpc only, http follows the url scheme
+	Insecure  bool              `json:"insecure"` // skip server certificate verification
+	CaFile    string            `json:"cafile"`
+	CertFile  string            `json:"certfile"`
+	KeyFile   string            `json:"keyfile"`
+	Headers   map[string]string `json:"headers"`
+	Token     string            `json:"token"` // bearer token
+	User      string            `json:"user"`  // basic auth
+	Password  string            `json:"password"`
+}
+
 type Relayer struct {
 	//submit config
 	Url      []string `json:"url"`
 	Contract string   `json:"contract"`
 	KeyPath  string   `json:"keypath"`
+	// optional directory for cached source chain data
+	CacheDir string `json:"cachedir"`
+	// connection options keyed by url
+	Endpoints map[string]*Endpoint `json:"endpoints"`
+	// headers per json-rpc batch and batch requests per second, zero for defaults
+	HeaderBatch uint64  `json:"headerbatch"`
+	HeaderRate  float64 `json:"headerrate"`
+	// header relayer profile: consensus engine (parlia, congress, bor, clique
+	// or etchash), client contract on TOP, chain id and clique epoch, unset
+	// fields take the builtin profile
+	Engine      string `json:"engine"`
+	TopContract string `json:"topcontract"`
+	ChainId     uint64 `json:"chainid"`
+	Epoch       uint64 `json:"epoch"`
+	// hard fork heights by lowercase name, like {"luban": 29020050} on
+	// parlia or {"delhi": 38189056} on bor, overriding the defaults of the
+	// chain id
+	Forks map[string]uint64 `json:"forks"`
+	// Heimdall rest url of a bor chain, spans are read from it
+	Heimdall string `json:"heimdall"`
+	// ethash DAG and proof cache dirs, empty for ~/.ethash and
+	// ~/.ethashproof, and their disk quota in MB, zero for unlimited; the
+	// quota also bounds the beacon data under cachedir
+	DagDir    string `json:"dagdir"`
+	ProofDir  string `json:"proofdir"`
+	DiskQuota uint64 `json:"diskquota"`
+	// fees of transactions sent to this chain, nil for legacy suggested
+	// gas prices
+	Fee *Fee `json:"fee"`
+	// a transaction not mined within StuckTimeout, like "5m", is sent again
+	// with fees FeeBump percent higher, up to the fee ceiling; empty and
+	// zero for the defaults
+	StuckTimeout string `json:"stucktimeout"`
+	FeeBump      uint64 `json:"feebump"`
+}
+
+// Fee selects how transactions are priced, all amounts are wei per gas.
+type Fee struct {
+	Strategy string `json:"strategy"` // legacy, eip1559 or fixed, empty for legacy
+	// ceilings of the gas price or fee cap and of the tip cap, zero for none
+	MaxFeeCap uint64 `json:"maxfeecap"`
+	MaxTipCap uint64 `json:"maxtipcap"`
+	// caps of the fixed strategy
+	FeeCap uint64 `json:"feecap"`
+	TipCap uint64 `json:"tipcap"`
 }
 
 type Server struct {
@@ -69,11 +134,29 @@ func LoadRelayerConfig(path string) (*Config, error) {
 		return nil, err
 	}
 	ServerConfig = config.ServerConfig
+	for _, c := range config.RelayerConfig {
+		for url, e := range c.Endpoints {
+			endpoints[url] = e
+		}
+	}
 	return config, nil
 }
 
+// EndpointConfig returns the options of url, or the defaults if it has none.
+func EndpointConfig(url string) *Endpoint {
+	if e, ok := endpoints[url]; ok && e != nil {
+		return e
+	}
+	return &Endpoint{}
+}
+
 func InitLogConfig() error {
 	os.Mkdir(LOG_DIR, os.ModePerm)
 	logger.SetLogger(LOG_CONFIG)
 	return nil
 }
+
+// SetEndpointConfig sets the options of url.
+func SetEndpointConfig(url string, e *Endpoint) {
+	endpoints[url] = e
+}
@@ -0,0 +1,198 @@
+package errs
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/rpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Error kinds shared by beacon, execution and TOP clients. Classified errors
+// match them with errors.Is while still unwrapping to the original error.
+var (
+	ErrNotFound          = errors.New("not found")
+	ErrNetwork           = errors.New("transient network error")
+	ErrRateLimited       = errors.New("rate limited")
+	ErrReverted          = errors.New("execution reverted")
+	ErrNonceTooLow       = errors.New("nonce too low")
+	ErrUnderpriced       = errors.New("transaction underpriced")
+	ErrInsufficientFunds = errors.New("insufficient funds")
+	ErrInsufficientSpace = errors.New("insufficient disk space")
+)
+
+// json-rpc error codes used by execution nodes and hosted providers
+const (
+	rpcCodeReverted      = 3
+	rpcCodeLimitExceeded = -32005
+)
+
+type Action int
+
+const (
+	// ActionRetry retries the same work after a delay
+	ActionRetry Action = iota
+	// ActionSkip moves on to the next item, e.g. an empty slot
+	ActionSkip
+	// ActionHalt stops the relayer until an operator steps in
+	ActionHalt
+)
+
+func (a Action) String() string {
+	switch a {
+	case ActionRetry:
+		return "retry"
+	case ActionSkip:
+		return "skip"
+	case ActionHalt:
+		return "halt"
+	}
+	return "unknown"
+}
+
+type kindError struct {
+	kind error
+	err  error
+}
+
+func (e *kindError) Error() string {
+	return e.kind.Error() + ": " + e.err.Error()
+}
+
+func (e *kindError) Unwrap() error {
+	return e.err
+}
+
+func (e *kindError) Is(target error) bool {
+	return e.kind == target
+}
+
+// Wrap marks err with kind. It returns nil if err is nil.
+func Wrap(kind, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &kindError{kind: kind, err: err}
+}
+
+// Kind returns the kind of a classified error, or nil.
+func Kind(err error) error {
+	var e *kindError
+	if errors.As(err, &e) {
+		return e.kind
+	}
+	return nil
+}
+
+// FromGrpc classifies an error returned by a grpc call.
+func FromGrpc(err error) error {
+	if err == nil || Kind(err) != nil {
+		return err
+	}
+	s, ok := status.FromError(err)
+	if !ok {
+		return fromNet(err)
+	}
+	switch s.Code() {
+	case codes.NotFound:
+		return Wrap(ErrNotFound, err)
+	case codes.ResourceExhausted:
+		return Wrap(ErrRateLimited, err)
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Aborted, codes.Canceled:
+		return Wrap(ErrNetwork, err)
+	}
+	return err
+}
+
+// FromHttpStatus classifies a failed http response, err describes the failure.
+func FromHttpStatus(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	switch {
+	case code == http.StatusNotFound:
+		return Wrap(ErrNotFound, err)
+	case code == http.StatusTooManyRequests:
+		return Wrap(ErrRateLimited, err)
+	case code == http.StatusRequestTimeout || code >= http.StatusInternalServerError:
+		return Wrap(ErrNetwork, err)
+	}
+	return err
+}
+
+// FromRpc classifies an error returned by a json-rpc call, including
+// transaction pool rejections which are only reported by message.
+func FromRpc(err error) error {
+	if err == nil || Kind(err) != nil {
+		return err
+	}
+	if errors.Is(err, ethereum.NotFound) {
+		return Wrap(ErrNotFound, err)
+	}
+	var httpErr rpc.HTTPError
+	if errors.As(err, &httpErr) {
+		return FromHttpStatus(httpErr.StatusCode, err)
+	}
+	var rpcErr rpc.Error
+	if errors.As(err, &rpcErr) {
+		switch rpcErr.ErrorCode() {
+		case rpcCodeReverted:
+			return Wrap(ErrReverted, err)
+		case rpcCodeLimitExceeded:
+			return Wrap(ErrRateLimited, err)
+		}
+	}
+	msg := strings.ToLower(err.Error())
+	switch {
+	case strings.Contains(msg, "nonce too low"):
+		return Wrap(ErrNonceTooLow, err)
+	case strings.Contains(msg, "underpriced"):
+		return Wrap(ErrUnderpriced, err)
+	case strings.Contains(msg, "insufficient funds"):
+		return Wrap(ErrInsufficientFunds, err)
+	case strings.Contains(msg, "execution reverted"):
+		return Wrap(ErrReverted, err)
+	case strings.Contains(msg, "rate limit") || strings.Contains(msg, "too many requests"):
+		return Wrap(ErrRateLimited, err)
+	}
+	return fromNet(err)
+}
+
+func fromNet(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return Wrap(ErrNetwork, err)
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return Wrap(ErrNetwork, err)
+	}
+	return err
+}
+
+// Classify tries all known error sources.
+func Classify(err error) error {
+	if err == nil || Kind(err) != nil {
+		return err
+	}
+	if _, ok := status.FromError(err); ok {
+		return FromGrpc(err)
+	}
+	return FromRpc(err)
+}
+
+// ActionFor decides how a relay loop reacts to err. Unclassified errors are
+// retried, as before.
+func ActionFor(err error) Action {
+	switch Kind(Classify(err)) {
+	case ErrNotFound:
+		return ActionSkip
+	case ErrInsufficientFunds, ErrInsufficientSpace:
+		return ActionHalt
+	}
+	return ActionRetry
+}
@@ -0,0 +1,87 @@
+package errs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/rpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type testRpcError struct {
+	code int
+	msg  string
+}
+
+func (e *testRpcError) Error() string  { return e.msg }
+func (e *testRpcError) ErrorCode() int { return e.code }
+
+func TestFromGrpc(t *testing.T) {
+	cases := []struct {
+		code codes.Code
+		kind error
+	}{
+		{codes.NotFound, ErrNotFound},
+		{codes.ResourceExhausted, ErrRateLimited},
+		{codes.Unavailable, ErrNetwork},
+		{codes.DeadlineExceeded, ErrNetwork},
+	}
+	for _, c := range cases {
+		err := FromGrpc(status.Error(c.code, "test"))
+		if !errors.Is(err, c.kind) {
+			t.Fatalf("code %v: got %v, want %v", c.code, err, c.kind)
+		}
+	}
+	if err := FromGrpc(status.Error(codes.InvalidArgument, "test")); Kind(err) != nil {
+		t.Fatal("InvalidArgument should not be classified:", err)
+	}
+}
+
+func TestFromRpc(t *testing.T) {
+	cases := []struct {
+		err  error
+		kind error
+	}{
+		{ethereum.NotFound, ErrNotFound},
+		{rpc.HTTPError{StatusCode: http.StatusTooManyRequests, Status: "429"}, ErrRateLimited},
+		{rpc.HTTPError{StatusCode: http.StatusBadGateway, Status: "502"}, ErrNetwork},
+		{&testRpcError{code: 3, msg: "execution reverted: bad header"}, ErrReverted},
+		{&testRpcError{code: -32005, msg: "limit exceeded"}, ErrRateLimited},
+		{errors.New("nonce too low"), ErrNonceTooLow},
+		{errors.New("replacement transaction underpriced"), ErrUnderpriced},
+		{errors.New("insufficient funds for gas * price + value"), ErrInsufficientFunds},
+		{fmt.Errorf("call: %w", context.DeadlineExceeded), ErrNetwork},
+	}
+	for _, c := range cases {
+		err := FromRpc(c.err)
+		if !errors.Is(err, c.kind) {
+			t.Fatalf("%v: got %v, want %v", c.err, Kind(err), c.kind)
+		}
+		if errors.Unwrap(err) == nil || errors.Unwrap(err).Error() != c.err.Error() {
+			t.Fatalf("%v: original error lost", c.err)
+		}
+	}
+}
+
+func TestActionFor(t *testing.T) {
+	if a := ActionFor(Wrap(ErrNotFound, errors.New("slot"))); a != ActionSkip {
+		t.Fatal("not found:", a)
+	}
+	if a := ActionFor(errors.New("insufficient funds for gas * price + value")); a != ActionHalt {
+		t.Fatal("insufficient funds:", a)
+	}
+	if a := ActionFor(fmt.Errorf("generate dag: %w", Wrap(ErrInsufficientSpace, errors.New("need 1 bytes")))); a != ActionHalt {
+		t.Fatal("insufficient space:", a)
+	}
+	if a := ActionFor(status.Error(codes.Unavailable, "down")); a != ActionRetry {
+		t.Fatal("unavailable:", a)
+	}
+	if a := ActionFor(errors.New("unknown")); a != ActionRetry {
+		t.Fatal("unknown:", a)
+	}
+}
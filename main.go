@@ -32,6 +32,7 @@ func init() {
 	app.Commands = []*cli.Command{
 		util.VersionCommand,
 		util.GetInitDataCommand,
+		util.EthashProofCommand,
 	}
 }
 
@@ -15,14 +15,16 @@ import (
 	"time"
 	"toprelayer/config"
 	"toprelayer/contract/eth/topclient"
+	"toprelayer/errs"
 	"toprelayer/relayer/monitor"
+	"toprelayer/relayer/txmanager"
+	"toprelayer/rpcdial"
 	top "toprelayer/types"
 	"toprelayer/wallet"
 
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
-	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/wonderivan/logger"
 )
@@ -63,9 +65,11 @@ type CrossChainRelayer struct {
 	name         string
 	contract     common.Address
 	wallet       *wallet.Wallet
+	fee          FeeStrategy
 	transactor   *topclient.TopClientTransactor
 	caller       *topclient.TopClientCaller
 	monitor      *monitor.Monitor
+	txm          *txmanager.Manager
 	serverUrl    string
 	serverEnable bool
 	verifyList   *list.List
@@ -86,8 +90,13 @@ func (te *CrossChainRelayer) Init(chainName string, cfg *config.Relayer, listenU
 		return err
 	}
 	te.wallet = w
+	te.fee, err = NewFeeStrategy(cfg.Fee, w)
+	if err != nil {
+		logger.Error("CrossChainRelayer", te.name, "NewFeeStrategy error:", err)
+		return err
+	}
 
-	ethsdk, err := ethclient.Dial(cfg.Url[0])
+	ethsdk, err := rpcdial.DialEth(cfg.Url[0])
 	if err != nil {
 		return err
 	}
@@ -101,11 +110,16 @@ func (te *CrossChainRelayer) Init(chainName string, cfg *config.Relayer, listenU
 		logger.Error("CrossChainRelayer", te.name, "NewTopClientCaller error:", err)
 		return err
 	}
-	te.monitor, err = monitor.New(te.wallet.Address(), cfg.Url[0])
+	te.monitor, err = monitor.New(te.name, te.name, te.wallet.Address(), cfg.Url[0])
 	if err != nil {
 		logger.Error("TopRelayer from", te.name, "New monitor error:", err)
 		return err
 	}
+	te.txm, err = txmanager.New(te.name, te.wallet, cfg, te.monitor.AddTx)
+	if err != nil {
+		logger.Error("CrossChainRelayer", te.name, "New txmanager error:", err)
+		return err
+	}
 	te.serverUrl = server.Url
 	if server.Url != "" && server.Enable == "true" {
 		te.serverEnable = true
@@ -118,13 +132,14 @@ func (te *CrossChainRelayer) Init(chainName string, cfg *config.Relayer, listenU
 
 func (te *CrossChainRelayer) submitTopHeader(headers []byte) error {
 	logger.Info("CrossChainRelayer", te.name, "raw data:", common.Bytes2Hex(headers))
-	nonce, err := te.wallet.NonceAt(context.Background(), te.wallet.Address(), nil)
+	nonce, err := te.txm.Nonce(context.Background())
 	if err != nil {
+		logger.Error("CrossChainRelayer", te.name, "Nonce error:", err)
 		return err
 	}
-	gaspric, err := te.wallet.SuggestGasPrice(context.Background())
+	fees, err := te.fee.Fees(context.Background())
 	if err != nil {
-		logger.Error("CrossChainRelayer", te.name, "GasPrice error:", err)
+		logger.Error("CrossChainRelayer", te.name, "Fees error:", err)
 		return err
 	}
 	packHeaders, err := topclient.PackSyncParam(headers)
@@ -144,28 +159,29 @@ func (te *CrossChainRelayer) submitTopHeader(headers []byte) error {
 	if err != nil {
 		return err
 	}
-	if balance.Uint64() <= gaspric.Uint64()*gaslimit {
-		return fmt.Errorf("CrossChainRelayer %v account[%v] balance not enough:%v", te.name, te.wallet.Address(), balance.Uint64())
+	if balance.Cmp(fees.Cost(gaslimit)) <= 0 {
+		return errs.Wrap(errs.ErrInsufficientFunds, fmt.Errorf("CrossChainRelayer %v account[%v] balance not enough:%v", te.name, te.wallet.Address(), balance))
 	}
 
 	//must init ops as bellow
 	ops := &bind.TransactOpts{
 		From:     te.wallet.Address(),
 		Nonce:    big.NewInt(0).SetUint64(nonce),
-		GasPrice: gaspric,
 		GasLimit: gaslimit,
 		Signer:   te.signTransaction,
 		Context:  context.Background(),
 		NoSend:   false,
 	}
+	fees.Apply(ops)
 
 	sigTx, err := te.transactor.AddLightClientBlocks(ops, headers)
 	if err != nil {
 		logger.Error("CrossChainRelayer", te.name, "AddLightClientBlocks error:", err)
 		return err
 	}
+	te.txm.Track(sigTx)
 	te.monitor.AddTx(sigTx.Hash())
-	logger.Info("CrossChainRelayer %v tx info, account[%v] balance:%v,nonce:%v,gasprice:%v,gaslimit:%v,length:%v,hash:%v", te.name, te.wallet.Address(), balance.Uint64(), nonce, gaspric.Uint64(), gaslimit, len(headers), sigTx.Hash())
+	logger.Info("CrossChainRelayer %v tx info, account[%v] balance:%v,nonce:%v,%v,gaslimit:%v,length:%v,hash:%v", te.name, te.wallet.Address(), balance, nonce, fees, gaslimit, len(headers), sigTx.Hash())
 	return nil
 }
 
@@ -303,9 +319,17 @@ func (te *CrossChainRelayer) verifyAndSendTransaction(height uint64) {
 	te.verifyList.Remove(element)
 }
 
+// Stop stops replacing the stuck transactions.
+func (te *CrossChainRelayer) Stop() {
+	if te.txm != nil {
+		te.txm.Stop()
+	}
+}
+
 func (te *CrossChainRelayer) StartRelayer(wg *sync.WaitGroup) error {
 	logger.Info("Start CrossChainRelayer %v...", te.name)
 	defer wg.Done()
+	defer te.Stop()
 
 	done := make(chan struct{})
 	defer close(done)
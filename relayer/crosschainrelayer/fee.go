@@ -0,0 +1,183 @@
+package crosschainrelayer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"toprelayer/config"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+)
+
+const (
+	FEE_LEGACY  = "legacy"
+	FEE_EIP1559 = "eip1559"
+	FEE_FIXED   = "fixed"
+
+	// blocks of base fee history behind the fee cap
+	FEE_HISTORY_BLOCKS uint64 = 10
+	// the fee cap covers the base fee doubling
+	BASE_FEE_MULTIPLIER int64 = 2
+)
+
+var ErrFeeCeiling = errors.New("fee above ceiling")
+
+// Fees are the prices per gas of a transaction, GasPrice for legacy ones or
+// GasFeeCap and GasTipCap for dynamic fee ones.
+type Fees struct {
+	GasPrice  *big.Int
+	GasFeeCap *big.Int
+	GasTipCap *big.Int
+}
+
+// Max returns the highest price per gas the transaction may pay.
+func (f *Fees) Max() *big.Int {
+	if f.GasPrice != nil {
+		return f.GasPrice
+	}
+	return f.GasFeeCap
+}
+
+// Cost returns the highest fee of gas, to check the balance against.
+func (f *Fees) Cost(gas uint64) *big.Int {
+	return new(big.Int).Mul(f.Max(), new(big.Int).SetUint64(gas))
+}
+
+func (f *Fees) Apply(ops *bind.TransactOpts) {
+	ops.GasPrice, ops.GasFeeCap, ops.GasTipCap = f.GasPrice, f.GasFeeCap, f.GasTipCap
+}
+
+func (f *Fees) String() string {
+	if f.GasPrice != nil {
+		return fmt.Sprintf("gasprice:%v", f.GasPrice)
+	}
+	return fmt.Sprintf("feecap:%v,tipcap:%v", f.GasFeeCap, f.GasTipCap)
+}
+
+// FeeStrategy prices the transactions to a chain.
+type FeeStrategy interface {
+	Fees(ctx context.Context) (*Fees, error)
+}
+
+type feeSource interface {
+	SuggestGasPrice(ctx context.Context) (*big.Int, error)
+	SuggestGasTipCap(ctx context.Context) (*big.Int, error)
+	FeeHistory(ctx context.Context, blockCount uint64, lastBlock *big.Int, rewardPercentiles []float64) (*ethereum.FeeHistory, error)
+}
+
+// NewFeeStrategy returns the strategy of cfg, nil cfg for legacy.
+func NewFeeStrategy(cfg *config.Fee, source feeSource) (FeeStrategy, error) {
+	if cfg == nil {
+		cfg = &config.Fee{}
+	}
+	ceiling := ceilings{feeCap: weiCeiling(cfg.MaxFeeCap), tipCap: weiCeiling(cfg.MaxTipCap)}
+	switch cfg.Strategy {
+	case "", FEE_LEGACY:
+		return &legacyFee{source: source, ceilings: ceiling}, nil
+	case FEE_EIP1559:
+		return &eip1559Fee{source: source, ceilings: ceiling}, nil
+	case FEE_FIXED:
+		if cfg.FeeCap == 0 || cfg.TipCap > cfg.FeeCap {
+			return nil, fmt.Errorf("invalid fixed fee caps %v, %v", cfg.FeeCap, cfg.TipCap)
+		}
+		fees := &Fees{GasFeeCap: new(big.Int).SetUint64(cfg.FeeCap), GasTipCap: new(big.Int).SetUint64(cfg.TipCap)}
+		if err := ceiling.check(fees); err != nil {
+			return nil, err
+		}
+		return &fixedFee{fees: fees}, nil
+	}
+	return nil, fmt.Errorf("unknown fee strategy %v", cfg.Strategy)
+}
+
+func weiCeiling(wei uint64) *big.Int {
+	if wei == 0 {
+		return nil
+	}
+	return new(big.Int).SetUint64(wei)
+}
+
+// ceilings of the fees, nil for none
+type ceilings struct {
+	feeCap *big.Int
+	tipCap *big.Int
+}
+
+func (c ceilings) check(fees *Fees) error {
+	if c.feeCap != nil && fees.Max().Cmp(c.feeCap) > 0 {
+		return fmt.Errorf("%w: %v > %v", ErrFeeCeiling, fees.Max(), c.feeCap)
+	}
+	if c.tipCap != nil && fees.GasTipCap != nil && fees.GasTipCap.Cmp(c.tipCap) > 0 {
+		return fmt.Errorf("%w: tip %v > %v", ErrFeeCeiling, fees.GasTipCap, c.tipCap)
+	}
+	return nil
+}
+
+// legacyFee pays the suggested gas price, it refuses prices above the
+// ceiling rather than sending transactions which would not be mined.
+type legacyFee struct {
+	source feeSource
+	ceilings
+}
+
+func (s *legacyFee) Fees(ctx context.Context) (*Fees, error) {
+	price, err := s.source.SuggestGasPrice(ctx)
+	if err != nil {
+		return nil, err
+	}
+	fees := &Fees{GasPrice: price}
+	return fees, s.check(fees)
+}
+
+// eip1559Fee tips the suggested tip cap, lowered to the ceiling, on a fee
+// cap covering the recent base fees rising. The fee cap is lowered to its
+// ceiling as long as it still covers the latest base fee and the tip.
+type eip1559Fee struct {
+	source feeSource
+	ceilings
+}
+
+func (s *eip1559Fee) Fees(ctx context.Context) (*Fees, error) {
+	tip, err := s.source.SuggestGasTipCap(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if s.tipCap != nil && tip.Cmp(s.tipCap) > 0 {
+		tip = new(big.Int).Set(s.tipCap)
+	}
+	history, err := s.source.FeeHistory(ctx, FEE_HISTORY_BLOCKS, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(history.BaseFee) == 0 {
+		return nil, errors.New("empty base fee history")
+	}
+	// the last base fee is of the next block
+	next := history.BaseFee[len(history.BaseFee)-1]
+	base := new(big.Int)
+	for _, fee := range history.BaseFee {
+		if fee.Cmp(base) > 0 {
+			base.Set(fee)
+		}
+	}
+	feeCap := new(big.Int).Mul(base, big.NewInt(BASE_FEE_MULTIPLIER))
+	feeCap.Add(feeCap, tip)
+	if s.feeCap != nil && feeCap.Cmp(s.feeCap) > 0 {
+		if need := new(big.Int).Add(next, tip); need.Cmp(s.feeCap) > 0 {
+			return nil, fmt.Errorf("%w: base fee %v tip %v > %v", ErrFeeCeiling, next, tip, s.feeCap)
+		}
+		feeCap.Set(s.feeCap)
+	}
+	return &Fees{GasFeeCap: feeCap, GasTipCap: tip}, nil
+}
+
+// fixedFee pays the configured caps.
+type fixedFee struct {
+	fees *Fees
+}
+
+func (s *fixedFee) Fees(ctx context.Context) (*Fees, error) {
+	return &Fees{GasFeeCap: new(big.Int).Set(s.fees.GasFeeCap), GasTipCap: new(big.Int).Set(s.fees.GasTipCap)}, nil
+}
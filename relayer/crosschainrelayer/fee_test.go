@@ -0,0 +1,103 @@
+package crosschainrelayer
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"toprelayer/config"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+)
+
+type fakeFeeSource struct {
+	price   int64
+	tip     int64
+	baseFee []int64
+}
+
+func (s *fakeFeeSource) SuggestGasPrice(ctx context.Context) (*big.Int, error) {
+	return big.NewInt(s.price), nil
+}
+
+func (s *fakeFeeSource) SuggestGasTipCap(ctx context.Context) (*big.Int, error) {
+	return big.NewInt(s.tip), nil
+}
+
+func (s *fakeFeeSource) FeeHistory(ctx context.Context, blockCount uint64, lastBlock *big.Int, rewardPercentiles []float64) (*ethereum.FeeHistory, error) {
+	history := &ethereum.FeeHistory{}
+	for _, fee := range s.baseFee {
+		history.BaseFee = append(history.BaseFee, big.NewInt(fee))
+	}
+	return history, nil
+}
+
+func TestFeeStrategy(t *testing.T) {
+	source := &fakeFeeSource{price: 50, tip: 2, baseFee: []int64{10, 20, 15}}
+	tests := []struct {
+		cfg    *config.Fee
+		price  int64
+		feeCap int64
+		tipCap int64
+		err    error
+	}{
+		{cfg: nil, price: 50},
+		{cfg: &config.Fee{Strategy: FEE_LEGACY, MaxFeeCap: 50}, price: 50},
+		{cfg: &config.Fee{Strategy: FEE_LEGACY, MaxFeeCap: 49}, err: ErrFeeCeiling},
+		// twice the highest base fee plus the tip
+		{cfg: &config.Fee{Strategy: FEE_EIP1559}, feeCap: 42, tipCap: 2},
+		{cfg: &config.Fee{Strategy: FEE_EIP1559, MaxTipCap: 1}, feeCap: 41, tipCap: 1},
+		// lowered to the ceiling while the next base fee fits
+		{cfg: &config.Fee{Strategy: FEE_EIP1559, MaxFeeCap: 30}, feeCap: 30, tipCap: 2},
+		{cfg: &config.Fee{Strategy: FEE_EIP1559, MaxFeeCap: 16}, err: ErrFeeCeiling},
+		{cfg: &config.Fee{Strategy: FEE_FIXED, FeeCap: 100, TipCap: 3}, feeCap: 100, tipCap: 3},
+	}
+	for i, test := range tests {
+		strategy, err := NewFeeStrategy(test.cfg, source)
+		if err != nil {
+			t.Fatal(i, err)
+		}
+		fees, err := strategy.Fees(context.Background())
+		if test.err != nil {
+			if !errors.Is(err, test.err) {
+				t.Errorf("%v: expect %v, got %v", i, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(i, err)
+		}
+		ops := &bind.TransactOpts{}
+		fees.Apply(ops)
+		if test.price != 0 {
+			if ops.GasPrice.Int64() != test.price || ops.GasFeeCap != nil || ops.GasTipCap != nil {
+				t.Errorf("%v: unexpected legacy fees %v", i, fees)
+			}
+		} else if ops.GasPrice != nil || ops.GasFeeCap.Int64() != test.feeCap || ops.GasTipCap.Int64() != test.tipCap {
+			t.Errorf("%v: unexpected dynamic fees %v", i, fees)
+		}
+	}
+
+	for _, cfg := range []*config.Fee{
+		{Strategy: "cheap"},
+		{Strategy: FEE_FIXED},
+		{Strategy: FEE_FIXED, FeeCap: 10, TipCap: 11},
+		{Strategy: FEE_FIXED, FeeCap: 10, MaxFeeCap: 9},
+	} {
+		if _, err := NewFeeStrategy(cfg, source); err == nil {
+			t.Errorf("expect invalid config %+v rejected", cfg)
+		}
+	}
+}
+
+func TestFeesCost(t *testing.T) {
+	// 1000 gwei for 30M gas is beyond uint64 wei
+	price := new(big.Int).SetUint64(1000_000_000_000)
+	fees := &Fees{GasFeeCap: price, GasTipCap: big.NewInt(1)}
+	want, _ := new(big.Int).SetString("30000000000000000000", 10)
+	if cost := fees.Cost(30_000_000); cost.Cmp(want) != 0 {
+		t.Fatal("unexpected cost", cost)
+	}
+}
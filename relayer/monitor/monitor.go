@@ -3,9 +3,12 @@ package monitor
 import (
 	"container/list"
 	"context"
+	"fmt"
 	"math/big"
+	"sync"
 	"time"
 	"toprelayer/config"
+	"toprelayer/rpcdial"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
@@ -24,28 +27,36 @@ const (
 var (
 	topBalanceAlarmLimit = big.NewInt(3000)
 	ethBalanceAlarmLimit = big.NewInt(1e3)
+	reorgAlarmDepth      = uint64(12)
 
 	topBalancePrecision = big.NewInt(1e6)
 	ethBalancePrecision = big.NewInt(1e15)
 )
 
 type Monitor struct {
+	chain     string // chain the transactions are sent to
 	account   common.Address
+	mu        sync.Mutex
 	txList    *list.List
 	ethclient *ethclient.Client
 	rpcclient *rpc.Client
+	metrics   *metrics
 }
 
-func New(account common.Address, url string) (*Monitor, error) {
+// New monitors the transactions sent by account to chain at url, metrics are
+// labelled with the relayer name.
+func New(name, chain string, account common.Address, url string) (*Monitor, error) {
 	monitor := new(Monitor)
 	monitor.txList = list.New()
 	monitor.txList.Init()
+	monitor.chain = chain
 	monitor.account = account
-	rpcclient, err := rpc.Dial(url)
+	monitor.metrics = newMetrics(name)
+	rpcclient, err := rpcdial.DialRpc(url)
 	if err != nil {
 		return nil, err
 	}
-	ethclient, err := ethclient.Dial(url)
+	ethclient, err := rpcdial.DialEth(url)
 	if err != nil {
 		return nil, err
 	}
@@ -65,32 +76,69 @@ func New(account common.Address, url string) (*Monitor, error) {
 			time.Sleep(time.Second * checkAccountInterval)
 		}
 	}()
+	go monitor.metrics.run()
 	return monitor, nil
 }
 
 func (monitor *Monitor) AddTx(hash common.Hash) {
+	monitor.mu.Lock()
+	defer monitor.mu.Unlock()
 	if monitor.txList.Len() == 0 {
-		increaseCounter(TagTotalTxCount, common.Big1)
+		monitor.metrics.increaseCounter(TagTotalTxCount, common.Big1)
 		monitor.txList.PushBack(hash)
 		return
 	}
 	last_hash, _ := monitor.txList.Back().Value.(common.Hash)
 	if last_hash == hash {
-		increaseCounter(TagRepeatTxCount, common.Big1)
+		monitor.metrics.increaseCounter(TagRepeatTxCount, common.Big1)
 	} else {
-		increaseCounter(TagTotalTxCount, common.Big1)
+		monitor.metrics.increaseCounter(TagTotalTxCount, common.Big1)
 		monitor.txList.PushBack(hash)
 	}
 }
 
+// AddReorg records a reorg of the source chain, deep ones raise an alarm.
+func (monitor *Monitor) AddReorg(depth uint64, ancestor uint64) {
+	monitor.metrics.pushRealtime(TagReorgDepth, depth, fmt.Sprint(ancestor))
+	if depth >= reorgAlarmDepth {
+		monitor.metrics.pushAlarm(TagReorgDepth, new(big.Int).SetUint64(depth), DetailReorgWarn)
+		logger.Warn("%v deep reorg: %v blocks, common ancestor %v", monitor.metrics.category, depth, ancestor)
+	}
+}
+
+// SetCacheStats records the hit rate in percent and the disk usage in MB of
+// the source chain data cache.
+func (monitor *Monitor) SetCacheStats(hits, misses, diskUsage uint64) {
+	rate := uint64(0)
+	if hits+misses > 0 {
+		rate = hits * 100 / (hits + misses)
+	}
+	monitor.metrics.modifyCounter(TagCacheHitRate, new(big.Int).SetUint64(rate))
+	monitor.metrics.modifyCounter(TagCacheDisk, new(big.Int).SetUint64(diskUsage>>20))
+}
+
+// front returns the oldest tx but the last one, which is kept to find
+// repeated submissions.
+func (monitor *Monitor) front() *list.Element {
+	monitor.mu.Lock()
+	defer monitor.mu.Unlock()
+	if monitor.txList.Len() <= 1 {
+		return nil
+	}
+	return monitor.txList.Front()
+}
+
+func (monitor *Monitor) remove(element *list.Element) {
+	monitor.mu.Lock()
+	defer monitor.mu.Unlock()
+	monitor.txList.Remove(element)
+}
+
 func (monitor *Monitor) checkTx(errorNum *uint64) {
+	category := monitor.metrics.category
 	for {
-		if monitor.txList.Len() <= 1 {
-			break
-		}
-		element := monitor.txList.Front()
+		element := monitor.front()
 		if element == nil {
-			logger.Error("txList get front nil")
 			break
 		}
 		hash, ok := element.Value.(common.Hash)
@@ -103,7 +151,7 @@ func (monitor *Monitor) checkTx(errorNum *uint64) {
 			*errorNum += 1
 			if *errorNum >= maxErrorNum {
 				*errorNum = 0
-				monitor.txList.Remove(element)
+				monitor.remove(element)
 				logger.Error("%v cannot find tx: %v, drop", category, hash)
 			}
 			break
@@ -111,17 +159,18 @@ func (monitor *Monitor) checkTx(errorNum *uint64) {
 
 		logger.Debug("%v tx: %v, status: %v, gasUsed: %v", category, hash, receipt.Status, receipt.GasUsed)
 		if receipt.Status == 1 {
-			increaseCounter(TagSuccessTxCount, common.Big1)
+			monitor.metrics.increaseCounter(TagSuccessTxCount, common.Big1)
 		}
-		pushRealtime(TagGas, receipt.GasUsed, hash.Hex())
+		monitor.metrics.pushRealtime(TagGas, receipt.GasUsed, hash.Hex())
 
 		*errorNum = 0
-		monitor.txList.Remove(element)
+		monitor.remove(element)
 	}
 }
 
 func (monitor *Monitor) checkAccount() {
-	if relayerName == config.TOP_CHAIN {
+	category := monitor.metrics.category
+	if monitor.chain == config.TOP_CHAIN {
 		var result hexutil.Big
 		err := monitor.rpcclient.CallContext(context.Background(), &result, "top_getBalance", monitor.account, "latest")
 		if err != nil {
@@ -129,25 +178,23 @@ func (monitor *Monitor) checkAccount() {
 		} else {
 			balance := (*big.Int)(&result)
 			topBalance := big.NewInt(0).Div(balance, topBalancePrecision)
-			modifyCounter(TagBalance, topBalance)
+			monitor.metrics.modifyCounter(TagBalance, topBalance)
 			if topBalance.Cmp(topBalanceAlarmLimit) < 0 {
-				pushAlarm(TagBalance, topBalance)
+				monitor.metrics.pushAlarm(TagBalance, topBalance, DetailBalanceWarn)
 				logger.Warn("%v low balance: %v", category, balance)
 			}
 		}
-	} else if relayerName == config.ETH_CHAIN {
+	} else {
 		balance, err := monitor.ethclient.BalanceAt(context.Background(), monitor.account, nil)
 		if err != nil {
 			logger.Error("get balance failed")
 		} else {
 			gwei := big.NewInt(0).Div(balance, ethBalancePrecision)
-			modifyCounter(TagBalance, gwei)
+			monitor.metrics.modifyCounter(TagBalance, gwei)
 			if gwei.Cmp(ethBalanceAlarmLimit) < 0 {
-				pushAlarm(TagBalance, gwei)
+				monitor.metrics.pushAlarm(TagBalance, gwei, DetailBalanceWarn)
 				logger.Warn("%v low balance: %v", category, balance)
 			}
 		}
-	} else {
-		logger.Warn("monitor not support: %v", relayerName)
 	}
 }
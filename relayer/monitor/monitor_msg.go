@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"math/big"
+	"sync"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -19,9 +20,13 @@ const (
 	TagSuccessTxRate  = "success_tx_rate"
 	TagBalance        = "balance"
 	TagGas            = "gas"
+	TagReorgDepth     = "reorg_depth"
+	TagCacheHitRate   = "cache_hit_rate"
+	TagCacheDisk      = "cache_disk"
 
 	// alarm
 	DetailBalanceWarn = "low balance"
+	DetailReorgWarn   = "deep reorg"
 
 	// 5 minutes interval
 	counterUpdateInterval = 300
@@ -29,18 +34,8 @@ const (
 )
 
 var (
-	timerCounter    uint64 = 0
-	alarmCounter    uint64 = 0
-	realtimeCounter uint64 = 0
-
-	totalTxCount   = big.NewInt(0)
-	repeatTxCount  = big.NewInt(0)
-	successTxCount = big.NewInt(0)
-	balance        = big.NewInt(0)
-
-	msgList     = list.New()
-	category    = ""
-	relayerName = ""
+	msgMu   sync.Mutex
+	msgList = list.New()
 )
 
 type counterMsg struct {
@@ -81,54 +76,102 @@ type realtimeMsgContent struct {
 	Detail string `json:"detail"`
 }
 
-func MonitorMsgInit(relayer string) error {
-	relayerName = relayer
-	category = relayer + "-relayer"
-	msgList.Init()
+// metrics are the counters of one relayer, labelled by its category.
+type metrics struct {
+	mu       sync.Mutex
+	category string
+
+	timerCounter    uint64
+	alarmCounter    uint64
+	realtimeCounter uint64
+
+	totalTxCount   *big.Int
+	repeatTxCount  *big.Int
+	successTxCount *big.Int
+	balance        *big.Int
+	cacheHitRate   *big.Int
+	cacheDisk      *big.Int
+}
+
+func newMetrics(name string) *metrics {
+	return &metrics{
+		category:       name + "-relayer",
+		totalTxCount:   big.NewInt(0),
+		repeatTxCount:  big.NewInt(0),
+		successTxCount: big.NewInt(0),
+		balance:        big.NewInt(0),
+		cacheHitRate:   big.NewInt(0),
+		cacheDisk:      big.NewInt(0),
+	}
+}
+
+// MonitorMsgInit starts writing the metrics of all monitors to the log.
+func MonitorMsgInit() error {
 	go func() {
 		for {
 			pushMsg()
 			time.Sleep(time.Second * msgUpdateInterval)
 		}
 	}()
-	go func() {
-		lastTimeStamp := time.Now().Unix()
-		for {
-			newTimestamp := time.Now().Unix()
-			if newTimestamp < (lastTimeStamp + counterUpdateInterval) {
-				time.Sleep(time.Second * 5)
-				continue
-			}
-			pushCounterMsg()
-			lastTimeStamp += counterUpdateInterval
-		}
-	}()
 	return nil
 }
 
-func increaseCounter(tag string, value *big.Int) error {
+func (m *metrics) run() {
+	lastTimeStamp := time.Now().Unix()
+	for {
+		newTimestamp := time.Now().Unix()
+		if newTimestamp < (lastTimeStamp + counterUpdateInterval) {
+			time.Sleep(time.Second * 5)
+			continue
+		}
+		m.pushCounterMsg()
+		lastTimeStamp += counterUpdateInterval
+	}
+}
+
+func (m *metrics) increaseCounter(tag string, value *big.Int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	if tag == TagTotalTxCount {
-		totalTxCount = big.NewInt(0).Add(totalTxCount, value)
+		m.totalTxCount = big.NewInt(0).Add(m.totalTxCount, value)
 	} else if tag == TagRepeatTxCount {
-		repeatTxCount = big.NewInt(0).Add(repeatTxCount, value)
+		m.repeatTxCount = big.NewInt(0).Add(m.repeatTxCount, value)
 	} else if tag == TagSuccessTxCount {
-		successTxCount = big.NewInt(0).Add(successTxCount, value)
+		m.successTxCount = big.NewInt(0).Add(m.successTxCount, value)
 	} else {
 		return fmt.Errorf("increaseCounter not found tag %v", tag)
 	}
 	return nil
 }
 
-func modifyCounter(tag string, value *big.Int) error {
+func (m *metrics) modifyCounter(tag string, value *big.Int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	if tag == TagBalance {
-		balance = value
+		m.balance = value
+	} else if tag == TagCacheHitRate {
+		m.cacheHitRate = value
+	} else if tag == TagCacheDisk {
+		m.cacheDisk = value
 	} else {
 		return fmt.Errorf("modifyCounter not found tag %v", tag)
 	}
 	return nil
 }
 
+func pushBack(msg interface{}) {
+	j, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	msgMu.Lock()
+	msgList.PushBack(string(j))
+	msgMu.Unlock()
+}
+
 func pushMsg() {
+	msgMu.Lock()
+	defer msgMu.Unlock()
 	for {
 		if msgList.Len() == 0 {
 			break
@@ -148,64 +191,44 @@ func pushMsg() {
 	}
 }
 
-func pushCounterMsg() {
-	timerCounter += 1
-	{
-		msg := counterMsg{Category: category, Tag: TagTotalTxCount, Name: "counter", Content: counterMsgContent{Count: timerCounter, Value: totalTxCount}}
-		j, err := json.Marshal(msg)
-		if err == nil {
-			msgList.PushBack(string(j))
-		}
+func (m *metrics) pushCounterMsg() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.timerCounter += 1
+	rate := big.NewInt(0)
+	if m.totalTxCount.Cmp(common.Big0) > 0 {
+		cnt := big.NewInt(0).Mul(m.successTxCount, big.NewInt(100))
+		rate = big.NewInt(0).Div(cnt, m.totalTxCount)
 	}
-	{
-		msg := counterMsg{Category: category, Tag: TagRepeatTxCount, Name: "counter", Content: counterMsgContent{Count: timerCounter, Value: repeatTxCount}}
-		j, err := json.Marshal(msg)
-		if err == nil {
-			msgList.PushBack(string(j))
-		}
+	counters := []struct {
+		tag   string
+		value *big.Int
+	}{
+		{TagTotalTxCount, m.totalTxCount},
+		{TagRepeatTxCount, m.repeatTxCount},
+		{TagSuccessTxCount, m.successTxCount},
+		{TagSuccessTxRate, rate},
+		{TagBalance, m.balance},
+		{TagCacheHitRate, m.cacheHitRate},
+		{TagCacheDisk, m.cacheDisk},
 	}
-	{
-		msg := counterMsg{Category: category, Tag: TagSuccessTxCount, Name: "counter", Content: counterMsgContent{Count: timerCounter, Value: successTxCount}}
-		j, err := json.Marshal(msg)
-		if err == nil {
-			msgList.PushBack(string(j))
-		}
-	}
-	{
-		rate := big.NewInt(0)
-		if totalTxCount.Cmp(common.Big0) > 0 {
-			cnt := big.NewInt(0).Mul(successTxCount, big.NewInt(100))
-			rate = big.NewInt(0).Div(cnt, totalTxCount)
-		}
-		msg := counterMsg{Category: category, Tag: TagSuccessTxRate, Name: "counter", Content: counterMsgContent{Count: timerCounter, Value: rate}}
-		j, err := json.Marshal(msg)
-		if err == nil {
-			msgList.PushBack(string(j))
-		}
-	}
-	{
-		msg := counterMsg{Category: category, Tag: TagBalance, Name: "counter", Content: counterMsgContent{Count: timerCounter, Value: balance}}
-		j, err := json.Marshal(msg)
-		if err == nil {
-			msgList.PushBack(string(j))
-		}
+	for _, c := range counters {
+		pushBack(counterMsg{Category: m.category, Tag: c.tag, Name: "counter", Content: counterMsgContent{Count: m.timerCounter, Value: c.value}})
 	}
 }
 
-func pushAlarm(tag string, value *big.Int) {
-	alarmCounter += 1
-	msg := alarmMsg{Category: category, Tag: tag, Name: "alarm", Content: alarmMsgContent{Count: alarmCounter, Value: value, Detail: DetailBalanceWarn}}
-	j, err := json.Marshal(msg)
-	if err == nil {
-		msgList.PushBack(string(j))
-	}
+func (m *metrics) pushAlarm(tag string, value *big.Int, detail string) {
+	m.mu.Lock()
+	m.alarmCounter += 1
+	msg := alarmMsg{Category: m.category, Tag: tag, Name: "alarm", Content: alarmMsgContent{Count: m.alarmCounter, Value: value, Detail: detail}}
+	m.mu.Unlock()
+	pushBack(msg)
 }
 
-func pushRealtime(tag string, value uint64, detail string) {
-	realtimeCounter += 1
-	msg := realtimeMsg{Category: category, Tag: tag, Name: "real_time", Content: realtimeMsgContent{Count: realtimeCounter, Value: value, Detail: detail}}
-	j, err := json.Marshal(msg)
-	if err == nil {
-		msgList.PushBack(string(j))
-	}
+func (m *metrics) pushRealtime(tag string, value uint64, detail string) {
+	m.mu.Lock()
+	m.realtimeCounter += 1
+	msg := realtimeMsg{Category: m.category, Tag: tag, Name: "real_time", Content: realtimeMsgContent{Count: m.realtimeCounter, Value: value, Detail: detail}}
+	m.mu.Unlock()
+	pushBack(msg)
 }
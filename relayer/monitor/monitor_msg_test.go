@@ -0,0 +1,72 @@
+package monitor
+
+import (
+	"encoding/json"
+	"math/big"
+	"testing"
+)
+
+func drainMsg() []counterMsg {
+	msgMu.Lock()
+	defer msgMu.Unlock()
+	var msgs []counterMsg
+	for msgList.Len() > 0 {
+		element := msgList.Front()
+		var msg counterMsg
+		if err := json.Unmarshal([]byte(element.Value.(string)), &msg); err == nil {
+			msgs = append(msgs, msg)
+		}
+		msgList.Remove(element)
+	}
+	return msgs
+}
+
+func TestMetricsPerRelayer(t *testing.T) {
+	drainMsg()
+	bsc := newMetrics("BSC2TOP")
+	heco := newMetrics("HECO2TOP")
+
+	if err := bsc.increaseCounter(TagTotalTxCount, big.NewInt(4)); err != nil {
+		t.Fatal(err)
+	}
+	if err := bsc.increaseCounter(TagSuccessTxCount, big.NewInt(3)); err != nil {
+		t.Fatal(err)
+	}
+	if err := heco.increaseCounter(TagTotalTxCount, big.NewInt(1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := heco.increaseCounter(TagBalance, big.NewInt(1)); err == nil {
+		t.Fatal("expect error on unknown tag")
+	}
+	bsc.pushCounterMsg()
+	heco.pushCounterMsg()
+
+	values := make(map[string]map[string]int64)
+	for _, msg := range drainMsg() {
+		if values[msg.Category] == nil {
+			values[msg.Category] = make(map[string]int64)
+		}
+		values[msg.Category][msg.Tag] = msg.Content.Value.Int64()
+	}
+	if v := values["BSC2TOP-relayer"]; v[TagTotalTxCount] != 4 || v[TagSuccessTxRate] != 75 {
+		t.Fatal("unexpected bsc metrics:", v)
+	}
+	if v := values["HECO2TOP-relayer"]; v[TagTotalTxCount] != 1 || v[TagSuccessTxCount] != 0 {
+		t.Fatal("unexpected heco metrics:", v)
+	}
+}
+
+func TestCacheStats(t *testing.T) {
+	drainMsg()
+	monitor := &Monitor{metrics: newMetrics("ETH2TOP")}
+	monitor.SetCacheStats(3, 1, 5<<20)
+	monitor.metrics.pushCounterMsg()
+
+	values := make(map[string]int64)
+	for _, msg := range drainMsg() {
+		values[msg.Tag] = msg.Content.Value.Int64()
+	}
+	if values[TagCacheHitRate] != 75 || values[TagCacheDisk] != 5 {
+		t.Fatal("unexpected cache metrics:", values)
+	}
+}
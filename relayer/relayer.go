@@ -15,9 +15,11 @@ import (
 
 var (
 	topRelayers = map[string]IChainRelayer{
-		config.ETH_CHAIN:  new(toprelayer.Eth2TopRelayerV2),
-		config.BSC_CHAIN:  new(toprelayer.Bsc2TopRelayer),
-		config.HECO_CHAIN: new(toprelayer.Heco2TopRelayer)}
+		config.ETH_CHAIN:     new(toprelayer.Eth2TopRelayerV2),
+		config.BSC_CHAIN:     toprelayer.NewHeaderRelayer(config.BSC_CHAIN),
+		config.HECO_CHAIN:    toprelayer.NewHeaderRelayer(config.HECO_CHAIN),
+		config.POLYGON_CHAIN: toprelayer.NewPolygon2TopRelayer(),
+		config.ETC_CHAIN:     toprelayer.NewEtc2TopRelayer()}
 
 	crossChainRelayer = new(crosschainrelayer.CrossChainRelayer)
 )
@@ -28,6 +30,18 @@ type IChainRelayer interface {
 	GetInitData() ([]byte, error)
 }
 
+// IChainConfigRelayer is implemented by relayers which take options from
+// their source chain config, it is called before Init.
+type IChainConfigRelayer interface {
+	SetChainConfig(cfg *config.Relayer)
+}
+
+// IStoppableRelayer is implemented by relayers holding resources to release
+// once they are done, it may be called before StartRelayer.
+type IStoppableRelayer interface {
+	Stop()
+}
+
 type ICrossChainRelayer interface {
 	Init(chainName string, cfg *config.Relayer, listenUrl []string, pass string, server config.Server) error
 	StartRelayer(*sync.WaitGroup) error
@@ -71,7 +85,7 @@ func startCrossChainRelayer(relayer ICrossChainRelayer, chainName string, cfg *c
 
 func StartRelayer(cfg *config.Config, pass string, wg *sync.WaitGroup) error {
 	// start monitor
-	err := monitor.MonitorMsgInit(cfg.RelayerToRun)
+	err := monitor.MonitorMsgInit()
 	if err != nil {
 		logger.Error("MonitorMsgInit fail:", err)
 		return err
@@ -92,15 +106,18 @@ func StartRelayer(cfg *config.Config, pass string, wg *sync.WaitGroup) error {
 			if name == config.TOP_CHAIN {
 				continue
 			}
-			if name != config.ETH_CHAIN && name != config.BSC_CHAIN && name != config.HECO_CHAIN {
-				logger.Warn("TopRelayer not support:", name)
-				continue
-			}
 			topRelayer, exist := topRelayers[name]
+			if !exist && c.Engine != "" {
+				// any other chain with a profile in its config
+				topRelayer, exist = toprelayer.NewHeaderRelayer(name), true
+			}
 			if !exist {
-				logger.Warn("unknown chain config:", name)
+				logger.Warn("TopRelayer not support:", name)
 				continue
 			}
+			if r, ok := topRelayer.(IChainConfigRelayer); ok {
+				r.SetChainConfig(c)
+			}
 			err := startTopRelayer(topRelayer, topConfig, c.Url, pass, wg)
 			if err != nil {
 				logger.Error("StartRelayer %v error: %v", name, err)
@@ -141,10 +158,16 @@ func GetInitData(cfg *config.Config, pass, chainName string) ([]byte, error) {
 		logger.Error(err)
 		return nil, err
 	}
+	if r, ok := topRelayer.(IChainConfigRelayer); ok {
+		r.SetChainConfig(c)
+	}
 	err := topRelayer.Init(c, c.Url, pass)
 	if err != nil {
 		logger.Error("Init error:", err)
 		return nil, err
 	}
+	if r, ok := topRelayer.(IStoppableRelayer); ok {
+		defer r.Stop()
+	}
 	return topRelayer.GetInitData()
 }
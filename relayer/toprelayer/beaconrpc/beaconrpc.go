@@ -2,7 +2,6 @@ package beaconrpc
 
 import (
 	"context"
-	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,6 +9,8 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"toprelayer/errs"
+	"toprelayer/rpcdial"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/rlp"
@@ -19,7 +20,6 @@ import (
 	eth "github.com/prysmaticlabs/prysm/v3/proto/prysm/v1alpha1"
 	"github.com/wonderivan/logger"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
 )
 
 const (
@@ -35,48 +35,141 @@ type BeaconGrpcClient struct {
 
 	httpclient *http.Client
 	httpurl    string
+
+	cache *beaconCache
+	// set once the node served light client ssz we can not decode
+	lcJsonOnly int32
 }
 
 func NewBeaconGrpcClient(grpcUrl, httpUrl string) (*BeaconGrpcClient, error) {
-	grpc, err := grpc.Dial(grpcUrl, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	opts, err := rpcdial.GrpcDialOptions(grpcUrl)
+	if err != nil {
+		return nil, err
+	}
+	grpc, err := grpc.Dial(grpcUrl, opts...)
 	if err != nil {
 		logger.Error("grpc.Dial error:", err)
 		return nil, err
 	}
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	httpclient, err := rpcdial.HttpClient(httpUrl)
+	if err != nil {
+		return nil, err
 	}
+	return NewBeaconClient(grpc, httpUrl, httpclient), nil
+}
 
-	c := &BeaconGrpcClient{
-		client:      pb.NewBeaconChainClient(grpc),
-		debugclient: pb.NewBeaconDebugClient(grpc),
-		httpclient:  &http.Client{Transport: tr},
+// NewBeaconClient creates a client on an established grpc connection.
+func NewBeaconClient(conn grpc.ClientConnInterface, httpUrl string, httpclient *http.Client) *BeaconGrpcClient {
+	return &BeaconGrpcClient{
+		client:      pb.NewBeaconChainClient(conn),
+		debugclient: pb.NewBeaconDebugClient(conn),
+		httpclient:  httpclient,
 		httpurl:     httpUrl,
+		cache:       newBeaconCache(),
 	}
-	return c, nil
 }
 
 func IsErrorNoBlockForSlot(err error) bool {
-	return strings.Contains(err.Error(), ERROR_NO_BLOCK_FOR_SLOT)
+	return errors.Is(err, errs.ErrNotFound)
+}
+
+// grpcError classifies errors of beacon grpc calls, older nodes report a
+// missing block only by message.
+func grpcError(err error) error {
+	if err != nil && strings.Contains(err.Error(), ERROR_NO_BLOCK_FOR_SLOT) {
+		return errs.Wrap(errs.ErrNotFound, err)
+	}
+	return errs.FromGrpc(err)
+}
+
+func (c *BeaconGrpcClient) httpGet(url string) ([]byte, error) {
+	body, _, err := c.httpGetAccept(url, "")
+	return body, err
+}
+
+// httpGetAccept gets url with the accept header, and returns the body with
+// its content type.
+func (c *BeaconGrpcClient) httpGetAccept(url, accept string) ([]byte, string, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		logger.Error("http NewRequest error:", err)
+		return nil, "", err
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	resp, err := c.httpclient.Do(req)
+	if err != nil {
+		logger.Error("http Get error:", err)
+		return nil, "", errs.Classify(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		logger.Error("outil.ReadAll error:", err)
+		return nil, "", errs.Classify(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		logger.Error("http Get %v status %v", url, resp.Status)
+		return nil, "", errs.FromHttpStatus(resp.StatusCode, fmt.Errorf("http status %v: %s", resp.Status, body))
+	}
+	contentType := resp.Header.Get("Content-Type")
+	// an empty ssz list is a valid response
+	if len(body) == 0 && !strings.HasPrefix(contentType, CONTENT_TYPE_SSZ) {
+		logger.Error("body empty")
+		return nil, "", errors.New("http body empty")
+	}
+	return body, contentType, nil
 }
 
 func (c *BeaconGrpcClient) GetBeaconBlockBodyForBlockId(id string) (*v2.BeaconBlockBodyBellatrix, error) {
+	key, cacheable := c.cache.key(id)
+	if cacheable {
+		if body, empty, ok := c.cache.getBody(key); ok {
+			if empty {
+				return nil, errs.Wrap(errs.ErrNotFound, fmt.Errorf("%v %v", ERROR_NO_BLOCK_FOR_SLOT, id))
+			}
+			return body, nil
+		}
+	}
 	resp, err := c.client.GetBlockV2(context.Background(), &v2.BlockRequestV2{BlockId: []byte(id)})
 	if err != nil {
 		logger.Error("GetBlockV2 id %v error %v", id, err)
+		err = grpcError(err)
+		if cacheable && errors.Is(err, errs.ErrNotFound) {
+			c.cache.addEmptySlot(key)
+		}
 		return nil, err
 	}
 	signedBlock, ok := resp.Data.Message.(*v2.SignedBeaconBlockContainer_BellatrixBlock)
 	if !ok {
 		return nil, errors.New("resp.data.message error")
 	}
-	return signedBlock.BellatrixBlock.GetBody(), nil
+	body := signedBlock.BellatrixBlock.GetBody()
+	if cacheable {
+		c.cache.addBody(key, body)
+	}
+	return body, nil
 }
 
 func (c *BeaconGrpcClient) GetBeaconBlockHeaderForBlockId(id string) (*eth.BeaconBlockHeader, error) {
+	key, cacheable := c.cache.key(id)
+	if cacheable {
+		if header, empty, ok := c.cache.getHeader(key); ok {
+			if empty {
+				return nil, errs.Wrap(errs.ErrNotFound, fmt.Errorf("%v %v", ERROR_NO_BLOCK_FOR_SLOT, id))
+			}
+			return header, nil
+		}
+	}
 	resp, err := c.client.GetBlockHeader(context.Background(), &v1.BlockRequest{BlockId: []byte(id)})
 	if err != nil {
 		logger.Error("GetBlockHeader error:", err)
+		err = grpcError(err)
+		if cacheable && errors.Is(err, errs.ErrNotFound) {
+			c.cache.addEmptySlot(key)
+		}
 		return nil, err
 	}
 	header := new(eth.BeaconBlockHeader)
@@ -85,6 +178,9 @@ func (c *BeaconGrpcClient) GetBeaconBlockHeaderForBlockId(id string) (*eth.Beaco
 	header.BodyRoot = resp.Data.Header.Message.BodyRoot
 	header.ParentRoot = resp.Data.Header.Message.ParentRoot
 	header.StateRoot = resp.Data.Header.Message.StateRoot
+	if cacheable {
+		c.cache.addHeader(key, header)
+	}
 	return header, nil
 }
 
@@ -103,6 +199,7 @@ func (c *BeaconGrpcClient) GetLastFinalizedSlotNumber() (uint64, error) {
 		logger.Error("GetBeaconBlockHeaderForBlockId error:", err)
 		return 0, err
 	}
+	c.cache.setFinalizedSlot(uint64(h.Slot))
 	return uint64(h.Slot), nil
 }
 
@@ -129,10 +226,16 @@ func GetPeriodForSlot(slot uint64) uint64 {
 }
 
 func (c *BeaconGrpcClient) GetBeaconState(id string) (*eth.BeaconStateBellatrix, error) {
+	key, cacheable := c.cache.key(id)
+	if cacheable {
+		if state, ok := c.cache.getState(key); ok {
+			return state, nil
+		}
+	}
 	resp, err := c.debugclient.GetBeaconStateSSZV2(context.Background(), &v2.BeaconStateRequestV2{StateId: []byte(id)})
 	if err != nil {
 		logger.Error("GetBeaconStateV2 error:", err)
-		return nil, err
+		return nil, grpcError(err)
 	}
 	var state eth.BeaconStateBellatrix
 	err = state.UnmarshalSSZ(resp.Data)
@@ -140,6 +243,9 @@ func (c *BeaconGrpcClient) GetBeaconState(id string) (*eth.BeaconStateBellatrix,
 		logger.Error("UnmarshalSSZ error:", err)
 		return nil, err
 	}
+	if cacheable {
+		c.cache.addState(key, &state, resp.Data)
+	}
 	return &state, nil
 }
 
@@ -147,7 +253,7 @@ func (c *BeaconGrpcClient) GetCheckpointRoot(id string) (*v1.Checkpoint, error)
 	resp, err := c.client.GetFinalityCheckpoints(context.Background(), &v1.StateRequest{StateId: []byte(id)})
 	if err != nil {
 		logger.Error("GetFinalityCheckpoints error:", err)
-		return nil, err
+		return nil, grpcError(err)
 	}
 
 	return resp.Data.GetFinalized(), nil
@@ -172,55 +278,48 @@ func (c *BeaconGrpcClient) GetNonEmptyBeaconBlockHeader(startSlot uint64) (*eth.
 
 func (c *BeaconGrpcClient) GetLightClientUpdate(period uint64) (*LightClientUpdate, error) {
 	str := fmt.Sprintf("%s/eth/v1/beacon/light_client/updates?start_period=%d&count=1", c.httpurl, period)
-	resp, err := c.httpclient.Get(str)
+	body, ssz, err := c.getLightClientData(str, LIGHT_CLIENT_UPDATE_SSZ_SIZE, true)
 	if err != nil {
-		logger.Error("http Get error:", err)
 		return nil, err
 	}
-	defer resp.Body.Close()
-
-	var result LightClientUpdateMsg
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		logger.Error("outil.ReadAll error:", err)
-		return nil, err
-	}
-	if len(body) == 0 {
-		logger.Error("body empty")
-		return nil, errors.New("http body empty")
+	if ssz {
+		update, err := c.decodeLightClientUpdateSSZ(body, true)
+		if err != nil {
+			logger.Error("decodeLightClientUpdateSSZ error:", err)
+			return nil, err
+		}
+		return update, nil
 	}
+	var result LightClientUpdateMsg
 	err = json.Unmarshal(body, &result)
 	if err != nil {
 		logger.Error("Unmarshal error:", err)
 		return nil, err
 	}
+	if len(result.Data) == 0 {
+		return nil, errs.Wrap(errs.ErrNotFound, fmt.Errorf("no light client update for period %v", period))
+	}
 	return c.LightClientUpdateConvert(&result.Data[0])
 }
 
 func (c *BeaconGrpcClient) GetNextSyncCommitteeUpdate(period uint64) (*SyncCommitteeUpdate, error) {
 	str := fmt.Sprintf("%s/eth/v1/beacon/light_client/updates?start_period=%d&count=1", c.httpurl, period)
-	resp, err := c.httpclient.Get(str)
+	body, ssz, err := c.getLightClientData(str, LIGHT_CLIENT_UPDATE_SSZ_SIZE, true)
 	if err != nil {
-		logger.Error("http Get error:", err)
 		return nil, err
 	}
-	defer resp.Body.Close()
-
-	var result LightClientUpdateMsg
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		logger.Error("outil.ReadAll error:", err)
-		return nil, err
-	}
-	if len(body) == 0 {
-		logger.Error("body empty")
-		return nil, errors.New("http body empty")
+	if ssz {
+		return decodeSyncCommitteeUpdateSSZ(body[LIGHT_CLIENT_HEADER_SSZ_SIZE:]), nil
 	}
+	var result LightClientUpdateMsg
 	err = json.Unmarshal(body, &result)
 	if err != nil {
 		logger.Error("Unmarshal error:", err)
 		return nil, err
 	}
+	if len(result.Data) == 0 {
+		return nil, errs.Wrap(errs.ErrNotFound, fmt.Errorf("no light client update for period %v", period))
+	}
 	committeeUpdate, err := c.CommitteeConvert(result.Data[0].NextSyncCommittee, result.Data[0].NextSyncCommitteeBranch)
 	if err != nil {
 		logger.Error("CommitteeConvert error:", err)
@@ -231,19 +330,19 @@ func (c *BeaconGrpcClient) GetNextSyncCommitteeUpdate(period uint64) (*SyncCommi
 
 func (c *BeaconGrpcClient) GetFinalizedLightClientUpdate() (*LightClientUpdate, error) {
 	str := fmt.Sprintf("%s/eth/v1/beacon/light_client/finality_update", c.httpurl)
-	resp, err := c.httpclient.Get(str)
+	body, ssz, err := c.getLightClientData(str, LIGHT_CLIENT_FINALITY_UPDATE_SSZ_SIZE, false)
 	if err != nil {
-		logger.Error("http Get error:", err)
 		return nil, err
 	}
-	defer resp.Body.Close()
-
-	var result LightClientUpdateNoCommitteeMsg
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		logger.Error("outil.ReadAll error:", err)
-		return nil, err
+	if ssz {
+		update, err := c.decodeLightClientUpdateSSZ(body, false)
+		if err != nil {
+			logger.Error("decodeLightClientUpdateSSZ error:", err)
+			return nil, err
+		}
+		return update, nil
 	}
+	var result LightClientUpdateNoCommitteeMsg
 	err = json.Unmarshal(body, &result)
 	if err != nil {
 		logger.Error("Unmarshal error:", err)
@@ -527,18 +626,24 @@ func (c *BeaconGrpcClient) CommitteeConvert(committee *SyncCommitteeData, branch
 }
 
 func (c *BeaconGrpcClient) FinalizedUpdateConvert(header *BeaconBlockHeaderData, branch []string) (*FinalizedHeaderUpdate, error) {
-	update := new(FinalizedHeaderUpdate)
-
+	var finalityBranch [][]byte
 	for _, s := range branch {
-		update.FinalityBranch = append(update.FinalityBranch, common.Hex2Bytes(s[2:]))
+		finalityBranch = append(finalityBranch, common.Hex2Bytes(s[2:]))
 	}
 
-	headerUpdate := new(HeaderUpdate)
 	h, err := c.BeaconHeaderconvert(header)
 	if err != nil {
 		logger.Error("BeaconHeaderconvert error:", err)
 		return nil, err
 	}
+	return c.finalizedUpdate(h, finalityBranch)
+}
+
+func (c *BeaconGrpcClient) finalizedUpdate(h *BeaconBlockHeader, branch [][]byte) (*FinalizedHeaderUpdate, error) {
+	update := new(FinalizedHeaderUpdate)
+	update.FinalityBranch = branch
+
+	headerUpdate := new(HeaderUpdate)
 	body, err := c.GetBeaconBlockBodyForBlockId(strconv.FormatUint(h.Slot, 10))
 	if err != nil {
 		logger.Error("GetBeaconBlockBodyForBlockId error:", err)
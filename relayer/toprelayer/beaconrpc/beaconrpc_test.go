@@ -1,11 +1,16 @@
 package beaconrpc
 
 import (
+	"bytes"
 	"context"
+	"io"
 	"math/big"
+	"net/http"
+	"net/http/httptest"
 	"strconv"
 	"testing"
 	"time"
+	"toprelayer/relayer/toprelayer/beaconrpc/beacontest"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/ethclient"
@@ -154,3 +159,211 @@ func TestGetFinalizedLightClientUpdateData(t *testing.T) {
 	}
 	t.Log(common.Bytes2Hex(bytes))
 }
+
+func newFakeClient(t *testing.T) (*beacontest.Node, *BeaconGrpcClient) {
+	node, err := beacontest.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(node.Close)
+	return node, NewBeaconClient(node.Conn(), node.HttpURL(), node.HttpClient())
+}
+
+func TestFakeNodeBlocks(t *testing.T) {
+	node, c := newFakeClient(t)
+	node.Extend(100, 10, 11)
+
+	last, err := c.GetLastSlotNumber()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last != 100 {
+		t.Fatal("head slot:", last)
+	}
+	finalized, err := c.GetLastFinalizedSlotNumber()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if finalized != node.Finalized() || finalized != 32 {
+		t.Fatal("finalized slot:", finalized)
+	}
+	hash, err := c.GetBlockHashForSlot(12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != node.ExecutionHash(12) {
+		t.Fatal("hash mismatch")
+	}
+	// empty slots are skipped by block number
+	number, err := c.GetBlockNumberForSlot(12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if number != 10 {
+		t.Fatal("block number:", number)
+	}
+	_, err = c.GetBlockHashForSlot(10)
+	if !IsErrorNoBlockForSlot(err) {
+		t.Fatal("expect no block for slot:", err)
+	}
+	h, err := c.GetNonEmptyBeaconBlockHeader(12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uint64(h.Slot) != 12 {
+		t.Fatal("header slot:", h.Slot)
+	}
+}
+
+func TestFakeNodeReorgAndStall(t *testing.T) {
+	node, c := newFakeClient(t)
+	node.Extend(100)
+	node.StallFinality(true)
+
+	before := node.ExecutionHash(90)
+	node.Reorg(80, 90)
+	if _, err := c.GetBlockHashForSlot(90); !IsErrorNoBlockForSlot(err) {
+		t.Fatal("expect no block after reorg:", err)
+	}
+	after, err := c.GetBlockHashForSlot(91)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after == before || after != node.ExecutionHash(91) {
+		t.Fatal("expect new fork hash")
+	}
+
+	node.Extend(200)
+	finalized, err := c.GetLastFinalizedSlotNumber()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if finalized != 32 {
+		t.Fatal("finality should stall:", finalized)
+	}
+	node.StallFinality(false)
+	if finalized, _ = c.GetLastFinalizedSlotNumber(); finalized != 224 {
+		t.Fatal("finality should resume:", finalized)
+	}
+}
+
+func TestFakeNodeLightClientUpdate(t *testing.T) {
+	node, c := newFakeClient(t)
+	node.Extend(200)
+
+	update, err := c.GetLightClientUpdate(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if update.FinalizedUpdate.HeaderUpdate.BeaconHeader.Slot != node.Finalized() {
+		t.Fatal("finalized slot:", update.FinalizedUpdate.HeaderUpdate.BeaconHeader.Slot)
+	}
+	if common.BytesToHash(update.FinalizedUpdate.HeaderUpdate.ExecutionBlockHash) != node.ExecutionHash(node.Finalized()) {
+		t.Fatal("finalized execution hash mismatch")
+	}
+	if len(update.NextSyncCommitteeUpdate.NextSyncCommittee.Pubkeys) != beacontest.SYNC_COMMITTEE_SIZE {
+		t.Fatal("committee size")
+	}
+	if _, err := c.GetLightClientUpdate(1); !IsErrorNoBlockForSlot(err) {
+		t.Fatal("expect no update for future period:", err)
+	}
+
+	finality, err := c.GetFinalizedLightClientUpdate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if finality.AttestedBeaconHeader.Slot != node.Head() || finality.NextSyncCommitteeUpdate != nil {
+		t.Fatal("unexpected finality update")
+	}
+}
+
+func TestFakeNodeLightClientUpdateSSZ(t *testing.T) {
+	node, c := newFakeClient(t)
+	node.Extend(200)
+
+	jsonUpdate, err := c.GetLightClientUpdate(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jsonFinality, err := c.GetFinalizedLightClientUpdate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	jsonCommittee, err := c.GetNextSyncCommitteeUpdate(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	node.ServeSSZ(true)
+	sszUpdate, err := c.GetLightClientUpdate(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sszFinality, err := c.GetFinalizedLightClientUpdate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sszCommittee, err := c.GetNextSyncCommitteeUpdate(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pair := range [][2]interface{ Encode() ([]byte, error) }{
+		{jsonUpdate, sszUpdate},
+		{jsonFinality, sszFinality},
+		{jsonCommittee, sszCommittee},
+	} {
+		a, err := pair[0].Encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := pair[1].Encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(a, b) {
+			t.Fatal("ssz and json updates differ")
+		}
+	}
+	if _, err := c.GetLightClientUpdate(1); !IsErrorNoBlockForSlot(err) {
+		t.Fatal("expect no update for future period:", err)
+	}
+}
+
+func TestLightClientSSZFallback(t *testing.T) {
+	node, _ := newFakeClient(t)
+	node.Extend(200)
+
+	// a node answering ssz of an unknown layout
+	var sszRequests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept") == ACCEPT_SSZ {
+			sszRequests++
+			w.Header().Set("Content-Type", CONTENT_TYPE_SSZ)
+			w.Write(make([]byte, 100))
+			return
+		}
+		resp, err := http.Get(node.HttpURL() + r.URL.String())
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer resp.Body.Close()
+		w.Header().Set("Content-Type", CONTENT_TYPE_JSON)
+		io.Copy(w, resp.Body)
+	}))
+	defer server.Close()
+
+	c := NewBeaconClient(node.Conn(), server.URL, server.Client())
+	for i := 0; i < 2; i++ {
+		update, err := c.GetFinalizedLightClientUpdate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if update.AttestedBeaconHeader.Slot != node.Head() {
+			t.Fatal("attested slot:", update.AttestedBeaconHeader.Slot)
+		}
+	}
+	if sszRequests != 1 {
+		t.Fatal("expect ssz asked once, got", sszRequests)
+	}
+}
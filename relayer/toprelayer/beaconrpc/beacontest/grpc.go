@@ -0,0 +1,137 @@
+package beacontest
+
+import (
+	"context"
+	"encoding/hex"
+	"strconv"
+	"strings"
+
+	primitives "github.com/prysmaticlabs/prysm/v3/consensus-types/primitives"
+	pb "github.com/prysmaticlabs/prysm/v3/proto/eth/service"
+	v1 "github.com/prysmaticlabs/prysm/v3/proto/eth/v1"
+	v2 "github.com/prysmaticlabs/prysm/v3/proto/eth/v2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type chainServer struct {
+	pb.UnimplementedBeaconChainServer
+	node *Node
+}
+
+type debugServer struct {
+	pb.UnimplementedBeaconDebugServer
+	node *Node
+}
+
+// blockNotFound has the message of prysm, which older clients match on.
+func blockNotFound(id []byte) error {
+	return status.Errorf(codes.NotFound, "Could not find requested block: %s", id)
+}
+
+// lookup resolves a block id: head, finalized, genesis, a slot or a root.
+func (n *Node) lookup(id []byte) (uint64, bool) {
+	switch s := string(id); {
+	case s == "head":
+		for slot := n.head; ; slot-- {
+			if n.blocks[slot] != nil {
+				return slot, true
+			}
+			if slot == 0 {
+				return 0, false
+			}
+		}
+	case s == "finalized":
+		return n.finalized, n.blocks[n.finalized] != nil
+	case s == "genesis":
+		return 0, n.blocks[0] != nil
+	case len(id) == 32 || (len(s) == 66 && strings.HasPrefix(s, "0x")):
+		root := id
+		if len(id) != 32 {
+			b, err := hex.DecodeString(s[2:])
+			if err != nil {
+				return 0, false
+			}
+			root = b
+		}
+		for slot, r := range n.roots {
+			if string(r[:]) == string(root) {
+				return slot, true
+			}
+		}
+		return 0, false
+	default:
+		slot, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return 0, false
+		}
+		return slot, n.blocks[slot] != nil
+	}
+}
+
+func (s *chainServer) GetBlockV2(ctx context.Context, req *v2.BlockRequestV2) (*v2.BlockResponseV2, error) {
+	n := s.node
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	slot, ok := n.lookup(req.BlockId)
+	if !ok {
+		return nil, blockNotFound(req.BlockId)
+	}
+	return &v2.BlockResponseV2{
+		Version: v2.Version_BELLATRIX,
+		Data: &v2.SignedBeaconBlockContainer{
+			Message:   &v2.SignedBeaconBlockContainer_BellatrixBlock{BellatrixBlock: n.blocks[slot]},
+			Signature: make([]byte, 96),
+		},
+	}, nil
+}
+
+func (s *chainServer) GetBlockHeader(ctx context.Context, req *v1.BlockRequest) (*v1.BlockHeaderResponse, error) {
+	n := s.node
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	slot, ok := n.lookup(req.BlockId)
+	if !ok {
+		return nil, blockNotFound(req.BlockId)
+	}
+	root := n.roots[slot]
+	return &v1.BlockHeaderResponse{
+		Data: &v1.BlockHeaderContainer{
+			Root:      root[:],
+			Canonical: true,
+			Header: &v1.BeaconBlockHeaderContainer{
+				Message:   blockHeader(n.blocks[slot]),
+				Signature: make([]byte, 96),
+			},
+		},
+	}, nil
+}
+
+func (s *chainServer) GetFinalityCheckpoints(ctx context.Context, req *v1.StateRequest) (*v1.StateFinalityCheckpointResponse, error) {
+	n := s.node
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	root := n.roots[n.finalized]
+	checkpoint := &v1.Checkpoint{
+		Epoch: primitives.Epoch(n.finalized / SLOTS_PER_EPOCH),
+		Root:  root[:],
+	}
+	return &v1.StateFinalityCheckpointResponse{
+		Data: &v1.StateFinalityCheckpointResponse_StateFinalityCheckpoint{
+			PreviousJustified: checkpoint,
+			CurrentJustified:  checkpoint,
+			Finalized:         checkpoint,
+		},
+	}, nil
+}
+
+func (s *debugServer) GetBeaconStateSSZV2(ctx context.Context, req *v2.BeaconStateRequestV2) (*v2.SSZContainer, error) {
+	n := s.node
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	data, ok := n.states[string(req.StateId)]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "Could not find state: %s", req.StateId)
+	}
+	return &v2.SSZContainer{Version: v2.Version_BELLATRIX, Data: data}, nil
+}
@@ -0,0 +1,364 @@
+package beacontest
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	enginev1 "github.com/prysmaticlabs/prysm/v3/proto/engine/v1"
+	v1 "github.com/prysmaticlabs/prysm/v3/proto/eth/v1"
+)
+
+const (
+	FINALITY_BRANCH_DEPTH       = 6
+	SYNC_COMMITTEE_BRANCH_DEPTH = 5
+	SYNC_COMMITTEE_SIZE         = 512
+)
+
+// ServeSSZ makes the light client endpoints answer in ssz when asked to,
+// updates recorded only as json are always served as json.
+func (n *Node) ServeSSZ(enable bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.ssz = enable
+}
+
+// SetState serves ssz as the beacon state of id, a slot or a root.
+func (n *Node) SetState(id string, ssz []byte) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.states[id] = ssz
+}
+
+// SetLightClientUpdate serves the raw json object as the update of period
+// instead of the generated one.
+func (n *Node) SetLightClientUpdate(period uint64, data []byte) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.updates[period] = data
+}
+
+// SetLightClientUpdateSSZ serves the ssz encoded update as the update of
+// period when ssz is asked for.
+func (n *Node) SetLightClientUpdateSSZ(period uint64, data []byte) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.updatesSSZ[period] = data
+}
+
+// SetFinalityUpdate serves the raw json object as the finality update
+// instead of the generated one.
+func (n *Node) SetFinalityUpdate(data []byte) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.finality = data
+}
+
+// LoadFixtures loads recorded responses from dir: update_<period>.json,
+// update_<period>.ssz, finality_update.json, state_<id>.ssz and
+// payload_<slot>.json, the execution_payload object of a block.
+func (n *Node) LoadFixtures(dir string) error {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		name := f.Name()
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+		switch {
+		case name == "finality_update.json":
+			n.SetFinalityUpdate(data)
+		case strings.HasPrefix(name, "update_") && strings.HasSuffix(name, ".json"):
+			period, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, "update_"), ".json"), 10, 64)
+			if err != nil {
+				return fmt.Errorf("fixture %v: %v", name, err)
+			}
+			n.SetLightClientUpdate(period, data)
+		case strings.HasPrefix(name, "update_") && strings.HasSuffix(name, ".ssz"):
+			period, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, "update_"), ".ssz"), 10, 64)
+			if err != nil {
+				return fmt.Errorf("fixture %v: %v", name, err)
+			}
+			n.SetLightClientUpdateSSZ(period, data)
+		case strings.HasPrefix(name, "payload_") && strings.HasSuffix(name, ".json"):
+			slot, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, "payload_"), ".json"), 10, 64)
+			if err != nil {
+				return fmt.Errorf("fixture %v: %v", name, err)
+			}
+			payload, err := decodePayload(data)
+			if err != nil {
+				return fmt.Errorf("fixture %v: %v", name, err)
+			}
+			n.SetPayload(slot, payload)
+		case strings.HasPrefix(name, "state_") && strings.HasSuffix(name, ".ssz"):
+			n.SetState(strings.TrimSuffix(strings.TrimPrefix(name, "state_"), ".ssz"), data)
+		}
+	}
+	return nil
+}
+
+func (n *Node) httpHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/eth/v1/beacon/light_client/updates", n.serveUpdates)
+	mux.HandleFunc("/eth/v1/beacon/light_client/finality_update", n.serveFinalityUpdate)
+	return mux
+}
+
+func (n *Node) wantSSZ(r *http.Request) bool {
+	return n.ssz && strings.Contains(r.Header.Get("Accept"), "application/octet-stream")
+}
+
+func writeSSZ(w http.ResponseWriter, data []byte) {
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(data)
+}
+
+func writeData(w http.ResponseWriter, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Data interface{} `json:"data"`
+	}{data})
+}
+
+func (n *Node) serveUpdates(w http.ResponseWriter, r *http.Request) {
+	start, err := strconv.ParseUint(r.URL.Query().Get("start_period"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid start_period", http.StatusBadRequest)
+		return
+	}
+	count, err := strconv.ParseUint(r.URL.Query().Get("count"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid count", http.StatusBadRequest)
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.wantSSZ(r) && (n.updates[start] == nil || n.updatesSSZ[start] != nil) {
+		var chunks []byte
+		for period := start; period < start+count; period++ {
+			data, ok := n.updatesSSZ[period]
+			if !ok {
+				update, ok := n.generateUpdate(period, true)
+				if !ok {
+					break
+				}
+				data = update.ssz()
+			}
+			var prefix [12]byte
+			binary.LittleEndian.PutUint64(prefix[:], uint64(len(data)))
+			chunks = append(chunks, prefix[:]...)
+			chunks = append(chunks, data...)
+		}
+		writeSSZ(w, chunks)
+		return
+	}
+	updates := make([]json.RawMessage, 0, count)
+	for period := start; period < start+count; period++ {
+		if data, ok := n.updates[period]; ok {
+			updates = append(updates, data)
+			continue
+		}
+		update, ok := n.generateUpdate(period, true)
+		if !ok {
+			break
+		}
+		updates = append(updates, update.json())
+	}
+	writeData(w, updates)
+}
+
+func (n *Node) serveFinalityUpdate(w http.ResponseWriter, r *http.Request) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.finality != nil {
+		writeData(w, json.RawMessage(n.finality))
+		return
+	}
+	update, ok := n.generateUpdate(n.head/(SLOTS_PER_EPOCH*EPOCHS_PER_PERIOD), false)
+	if !ok {
+		http.Error(w, "no finality update", http.StatusNotFound)
+		return
+	}
+	if n.wantSSZ(r) {
+		writeSSZ(w, update.ssz())
+		return
+	}
+	writeData(w, update.json())
+}
+
+// lightClientUpdate is a generated update, proofs and signatures are zero.
+type lightClientUpdate struct {
+	attested      *v1.BeaconBlockHeader
+	finalized     *v1.BeaconBlockHeader
+	signatureSlot uint64
+	withCommittee bool
+}
+
+func headerJson(h *v1.BeaconBlockHeader) map[string]string {
+	return map[string]string{
+		"slot":           strconv.FormatUint(uint64(h.Slot), 10),
+		"proposer_index": strconv.FormatUint(uint64(h.ProposerIndex), 10),
+		"parent_root":    hexutil.Encode(h.ParentRoot),
+		"state_root":     hexutil.Encode(h.StateRoot),
+		"body_root":      hexutil.Encode(h.BodyRoot),
+	}
+}
+
+func zeroBranch(depth int) []string {
+	branch := make([]string, depth)
+	for i := range branch {
+		branch[i] = hexutil.Encode(make([]byte, 32))
+	}
+	return branch
+}
+
+func (u *lightClientUpdate) json() json.RawMessage {
+	update := map[string]interface{}{
+		"attested_header":  headerJson(u.attested),
+		"finalized_header": headerJson(u.finalized),
+		"finality_branch":  zeroBranch(FINALITY_BRANCH_DEPTH),
+		"sync_aggregate": map[string]string{
+			"sync_committee_bits":      hexutil.Encode(fullBits()),
+			"sync_committee_signature": hexutil.Encode(make([]byte, 96)),
+		},
+		"signature_slot": strconv.FormatUint(u.signatureSlot, 10),
+	}
+	if u.withCommittee {
+		pubkeys := make([]string, SYNC_COMMITTEE_SIZE)
+		for i := range pubkeys {
+			pubkeys[i] = hexutil.Encode(make([]byte, 48))
+		}
+		update["next_sync_committee"] = map[string]interface{}{
+			"pubkeys":          pubkeys,
+			"aggregate_pubkey": hexutil.Encode(make([]byte, 48)),
+		}
+		update["next_sync_committee_branch"] = zeroBranch(SYNC_COMMITTEE_BRANCH_DEPTH)
+	}
+	data, err := json.Marshal(update)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+func (u *lightClientUpdate) ssz() []byte {
+	header := func(h *v1.BeaconBlockHeader) []byte {
+		b, err := h.MarshalSSZ()
+		if err != nil {
+			panic(err)
+		}
+		return b
+	}
+	var b []byte
+	b = append(b, header(u.attested)...)
+	if u.withCommittee {
+		b = append(b, make([]byte, SYNC_COMMITTEE_SIZE*48+48)...)
+		b = append(b, make([]byte, SYNC_COMMITTEE_BRANCH_DEPTH*32)...)
+	}
+	b = append(b, header(u.finalized)...)
+	b = append(b, make([]byte, FINALITY_BRANCH_DEPTH*32)...)
+	b = append(b, fullBits()...)
+	b = append(b, make([]byte, 96)...)
+	var slot [8]byte
+	binary.LittleEndian.PutUint64(slot[:], u.signatureSlot)
+	return append(b, slot[:]...)
+}
+
+// generateUpdate builds an update with the latest finalized block of period
+// and the last block above it.
+func (n *Node) generateUpdate(period uint64, withCommittee bool) (*lightClientUpdate, bool) {
+	if period > n.head/(SLOTS_PER_EPOCH*EPOCHS_PER_PERIOD) {
+		return nil, false
+	}
+	end := (period+1)*SLOTS_PER_EPOCH*EPOCHS_PER_PERIOD - 1
+	finalized := n.finalized
+	if finalized > end {
+		finalized = end
+		for finalized > 0 && n.blocks[finalized] == nil {
+			finalized--
+		}
+	}
+	attested, ok := n.lookup([]byte("head"))
+	if !ok || attested <= finalized || n.blocks[finalized] == nil {
+		return nil, false
+	}
+	if attested > end {
+		attested = end
+		for attested > finalized && n.blocks[attested] == nil {
+			attested--
+		}
+		if attested == finalized {
+			return nil, false
+		}
+	}
+	return &lightClientUpdate{
+		attested:      blockHeader(n.blocks[attested]),
+		finalized:     blockHeader(n.blocks[finalized]),
+		signatureSlot: attested + 1,
+		withCommittee: withCommittee,
+	}, true
+}
+
+// payloadJson is an execution payload as served by the beacon api.
+type payloadJson struct {
+	ParentHash    hexutil.Bytes   `json:"parent_hash"`
+	FeeRecipient  hexutil.Bytes   `json:"fee_recipient"`
+	StateRoot     hexutil.Bytes   `json:"state_root"`
+	ReceiptsRoot  hexutil.Bytes   `json:"receipts_root"`
+	LogsBloom     hexutil.Bytes   `json:"logs_bloom"`
+	PrevRandao    hexutil.Bytes   `json:"prev_randao"`
+	BlockNumber   uint64          `json:"block_number,string"`
+	GasLimit      uint64          `json:"gas_limit,string"`
+	GasUsed       uint64          `json:"gas_used,string"`
+	Timestamp     uint64          `json:"timestamp,string"`
+	ExtraData     hexutil.Bytes   `json:"extra_data"`
+	BaseFeePerGas string          `json:"base_fee_per_gas"`
+	BlockHash     hexutil.Bytes   `json:"block_hash"`
+	Transactions  []hexutil.Bytes `json:"transactions"`
+}
+
+func decodePayload(data []byte) (*enginev1.ExecutionPayload, error) {
+	var p payloadJson
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, err
+	}
+	baseFee, ok := new(big.Int).SetString(p.BaseFeePerGas, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid base_fee_per_gas %v", p.BaseFeePerGas)
+	}
+	// ssz keeps the base fee as a little endian uint256
+	baseFeeBytes := baseFee.FillBytes(make([]byte, 32))
+	for i, j := 0, len(baseFeeBytes)-1; i < j; i, j = i+1, j-1 {
+		baseFeeBytes[i], baseFeeBytes[j] = baseFeeBytes[j], baseFeeBytes[i]
+	}
+	txs := make([][]byte, len(p.Transactions))
+	for i, tx := range p.Transactions {
+		txs[i] = tx
+	}
+	return &enginev1.ExecutionPayload{
+		ParentHash:    p.ParentHash,
+		FeeRecipient:  p.FeeRecipient,
+		StateRoot:     p.StateRoot,
+		ReceiptsRoot:  p.ReceiptsRoot,
+		LogsBloom:     p.LogsBloom,
+		PrevRandao:    p.PrevRandao,
+		BlockNumber:   p.BlockNumber,
+		GasLimit:      p.GasLimit,
+		GasUsed:       p.GasUsed,
+		Timestamp:     p.Timestamp,
+		ExtraData:     p.ExtraData,
+		BaseFeePerGas: baseFeeBytes,
+		BlockHash:     p.BlockHash,
+		Transactions:  txs,
+	}, nil
+}
@@ -0,0 +1,363 @@
+// Package beacontest runs an in-process beacon node serving the grpc and
+// light client http apis used by beaconrpc, for offline tests.
+package beacontest
+
+import (
+	"context"
+	"encoding/binary"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	"toprelayer/relayer/toprelayer/ethtypes"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	primitives "github.com/prysmaticlabs/prysm/v3/consensus-types/primitives"
+	enginev1 "github.com/prysmaticlabs/prysm/v3/proto/engine/v1"
+	pb "github.com/prysmaticlabs/prysm/v3/proto/eth/service"
+	v1 "github.com/prysmaticlabs/prysm/v3/proto/eth/v1"
+	v2 "github.com/prysmaticlabs/prysm/v3/proto/eth/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const (
+	SLOTS_PER_EPOCH   = 32
+	EPOCHS_PER_PERIOD = 256
+	// finalized slot trails the head by two epochs like on a healthy chain
+	FINALITY_DISTANCE = 2 * SLOTS_PER_EPOCH
+
+	GENESIS_TIME     = 1606824023
+	SECONDS_PER_SLOT = 12
+	GAS_LIMIT        = 30000000
+
+	bufSize = 1024 * 1024
+)
+
+// Node is a fake beacon chain. Blocks are generated with valid execution
+// payload hashes and can be removed, reorged or frozen through its knobs.
+type Node struct {
+	mu        sync.Mutex
+	blocks    map[uint64]*v2.BeaconBlockBellatrix
+	roots     map[uint64][32]byte
+	head      uint64
+	finalized uint64
+	stalled   bool
+	fork      uint64
+
+	states     map[string][]byte
+	updates    map[uint64][]byte
+	updatesSSZ map[uint64][]byte
+	finality   []byte
+	ssz        bool
+
+	lis  *bufconn.Listener
+	srv  *grpc.Server
+	http *httptest.Server
+	conn *grpc.ClientConn
+}
+
+// New starts a node with only the genesis block.
+func New() (*Node, error) {
+	n := &Node{
+		blocks:     make(map[uint64]*v2.BeaconBlockBellatrix),
+		roots:      make(map[uint64][32]byte),
+		states:     make(map[string][]byte),
+		updates:    make(map[uint64][]byte),
+		updatesSSZ: make(map[uint64][]byte),
+	}
+	n.addBlock(0)
+
+	n.lis = bufconn.Listen(bufSize)
+	n.srv = grpc.NewServer()
+	pb.RegisterBeaconChainServer(n.srv, &chainServer{node: n})
+	pb.RegisterBeaconDebugServer(n.srv, &debugServer{node: n})
+	go n.srv.Serve(n.lis)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, s string) (net.Conn, error) {
+			return n.lis.Dial()
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		n.srv.Stop()
+		return nil, err
+	}
+	n.conn = conn
+	n.http = httptest.NewServer(n.httpHandler())
+	return n, nil
+}
+
+func (n *Node) Close() {
+	n.conn.Close()
+	n.srv.Stop()
+	n.http.Close()
+}
+
+// Conn returns a client connection to the grpc services.
+func (n *Node) Conn() *grpc.ClientConn {
+	return n.conn
+}
+
+func (n *Node) HttpURL() string {
+	return n.http.URL
+}
+
+func (n *Node) HttpClient() *http.Client {
+	return n.http.Client()
+}
+
+// Extend appends count slots after the head, slots listed in empty get no block.
+func (n *Node) Extend(count uint64, empty ...uint64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	skip := make(map[uint64]bool, len(empty))
+	for _, s := range empty {
+		skip[s] = true
+	}
+	for i := uint64(0); i < count; i++ {
+		slot := n.head + 1
+		if !skip[slot] {
+			n.addBlock(slot)
+		}
+		n.head = slot
+	}
+	n.advanceFinality()
+}
+
+// AddBlock adds a generated block at slot, which may be far past the head,
+// e.g. the finalized block of a recorded update.
+func (n *Node) AddBlock(slot uint64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.addBlock(slot)
+	if slot > n.head {
+		n.head = slot
+	}
+}
+
+// SetPayload adds a block carrying a recorded execution payload at slot, the
+// rest of the block is generated.
+func (n *Node) SetPayload(slot uint64, payload *enginev1.ExecutionPayload) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.putBlock(slot, payload)
+	if slot > n.head {
+		n.head = slot
+	}
+}
+
+// SetEmpty removes the block of slot, which is then reported as missing.
+func (n *Node) SetEmpty(slot uint64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.blocks, slot)
+	delete(n.roots, slot)
+}
+
+// Reorg replaces all blocks from slot to the head with blocks of a new fork,
+// slots listed in empty get no block on the new fork.
+func (n *Node) Reorg(slot uint64, empty ...uint64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	skip := make(map[uint64]bool, len(empty))
+	for _, s := range empty {
+		skip[s] = true
+	}
+	n.fork++
+	for s := slot; s <= n.head; s++ {
+		delete(n.blocks, s)
+		delete(n.roots, s)
+		if !skip[s] {
+			n.addBlock(s)
+		}
+	}
+}
+
+// StallFinality stops or resumes advancing the finalized slot.
+func (n *Node) StallFinality(stalled bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.stalled = stalled
+	n.advanceFinality()
+}
+
+func (n *Node) Head() uint64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.head
+}
+
+func (n *Node) Finalized() uint64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.finalized
+}
+
+// Block returns the block of slot, nil for an empty slot.
+func (n *Node) Block(slot uint64) *v2.BeaconBlockBellatrix {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.blocks[slot]
+}
+
+// ExecutionHash returns the execution block hash of slot, zero for an empty slot.
+func (n *Node) ExecutionHash(slot uint64) common.Hash {
+	b := n.Block(slot)
+	if b == nil {
+		return common.Hash{}
+	}
+	return common.BytesToHash(b.Body.ExecutionPayload.BlockHash)
+}
+
+func (n *Node) advanceFinality() {
+	if n.stalled || n.head < FINALITY_DISTANCE {
+		return
+	}
+	// finalize at epoch boundaries, and only slots which have a block
+	slot := (n.head - FINALITY_DISTANCE) / SLOTS_PER_EPOCH * SLOTS_PER_EPOCH
+	for slot > n.finalized && n.blocks[slot] == nil {
+		slot--
+	}
+	if slot > n.finalized {
+		n.finalized = slot
+	}
+}
+
+func (n *Node) parent(slot uint64) *v2.BeaconBlockBellatrix {
+	for s := slot; s > 0; s-- {
+		if b := n.blocks[s-1]; b != nil {
+			return b
+		}
+	}
+	return nil
+}
+
+func (n *Node) seed(slot uint64, kind byte) []byte {
+	var b [17]byte
+	binary.BigEndian.PutUint64(b[:], slot)
+	binary.BigEndian.PutUint64(b[8:], n.fork)
+	b[16] = kind
+	return crypto.Keccak256(b[:])
+}
+
+func (n *Node) addBlock(slot uint64) {
+	parentHash := make([]byte, 32)
+	number := uint64(0)
+	if p := n.parent(slot); p != nil {
+		parentHash = p.Body.ExecutionPayload.BlockHash
+		number = p.Body.ExecutionPayload.BlockNumber + 1
+	}
+	payload := &enginev1.ExecutionPayload{
+		ParentHash:    parentHash,
+		FeeRecipient:  make([]byte, 20),
+		StateRoot:     n.seed(slot, 's'),
+		ReceiptsRoot:  types.EmptyRootHash.Bytes(),
+		LogsBloom:     make([]byte, types.BloomByteLength),
+		PrevRandao:    n.seed(slot, 'r'),
+		BlockNumber:   number,
+		GasLimit:      GAS_LIMIT,
+		Timestamp:     GENESIS_TIME + slot*SECONDS_PER_SLOT,
+		ExtraData:     []byte{byte(n.fork)},
+		BaseFeePerGas: make([]byte, 32),
+	}
+	payload.BaseFeePerGas[0] = 7
+	payload.BlockHash = executionHash(payload).Bytes()
+	n.putBlock(slot, payload)
+}
+
+func (n *Node) putBlock(slot uint64, payload *enginev1.ExecutionPayload) {
+	parentRoot := make([]byte, 32)
+	if p := n.parent(slot); p != nil {
+		root := n.roots[uint64(p.Slot)]
+		parentRoot = root[:]
+	}
+	block := &v2.BeaconBlockBellatrix{
+		Slot:          primitives.Slot(slot),
+		ProposerIndex: primitives.ValidatorIndex(slot % 64),
+		ParentRoot:    parentRoot,
+		StateRoot:     n.seed(slot, 't'),
+		Body: &v2.BeaconBlockBodyBellatrix{
+			RandaoReveal: make([]byte, 96),
+			Eth1Data: &v1.Eth1Data{
+				DepositRoot: make([]byte, 32),
+				BlockHash:   make([]byte, 32),
+			},
+			Graffiti: make([]byte, 32),
+			SyncAggregate: &v1.SyncAggregate{
+				SyncCommitteeBits:      fullBits(),
+				SyncCommitteeSignature: make([]byte, 96),
+			},
+			ExecutionPayload: payload,
+		},
+	}
+	n.blocks[slot] = block
+	n.roots[slot] = blockRoot(block)
+}
+
+// executionHash is the block hash the execution layer would compute for a
+// payload without transactions.
+func executionHash(p *enginev1.ExecutionPayload) common.Hash {
+	h := &ethtypes.ExecutionHeader{
+		ParentHash:  common.BytesToHash(p.ParentHash),
+		UncleHash:   types.EmptyUncleHash,
+		Coinbase:    common.BytesToAddress(p.FeeRecipient),
+		Root:        common.BytesToHash(p.StateRoot),
+		TxHash:      types.EmptyRootHash,
+		ReceiptHash: common.BytesToHash(p.ReceiptsRoot),
+		Bloom:       types.BytesToBloom(p.LogsBloom),
+		Difficulty:  big.NewInt(0),
+		Number:      new(big.Int).SetUint64(p.BlockNumber),
+		GasLimit:    p.GasLimit,
+		GasUsed:     p.GasUsed,
+		Time:        p.Timestamp,
+		Extra:       p.ExtraData,
+		MixDigest:   common.BytesToHash(p.PrevRandao),
+		BaseFee:     littleEndianToBig(p.BaseFeePerGas),
+	}
+	return h.Hash()
+}
+
+func littleEndianToBig(b []byte) *big.Int {
+	be := make([]byte, len(b))
+	for i := range b {
+		be[len(b)-1-i] = b[i]
+	}
+	return new(big.Int).SetBytes(be)
+}
+
+func fullBits() []byte {
+	bits := make([]byte, 64)
+	for i := range bits {
+		bits[i] = 0xff
+	}
+	return bits
+}
+
+func blockHeader(b *v2.BeaconBlockBellatrix) *v1.BeaconBlockHeader {
+	bodyRoot, err := b.Body.HashTreeRoot()
+	if err != nil {
+		panic(err)
+	}
+	return &v1.BeaconBlockHeader{
+		Slot:          b.Slot,
+		ProposerIndex: b.ProposerIndex,
+		ParentRoot:    b.ParentRoot,
+		StateRoot:     b.StateRoot,
+		BodyRoot:      bodyRoot[:],
+	}
+}
+
+func blockRoot(b *v2.BeaconBlockBellatrix) [32]byte {
+	root, err := blockHeader(b).HashTreeRoot()
+	if err != nil {
+		panic(err)
+	}
+	return root
+}
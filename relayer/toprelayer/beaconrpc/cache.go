@@ -0,0 +1,281 @@
+package beaconrpc
+
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	lru "github.com/hashicorp/golang-lru"
+	v2 "github.com/prysmaticlabs/prysm/v3/proto/eth/v2"
+	eth "github.com/prysmaticlabs/prysm/v3/proto/prysm/v1alpha1"
+	"github.com/wonderivan/logger"
+)
+
+const (
+	inMemoryBlocks = 1024 // Number of finalized block bodies and headers to keep in memory
+	inMemoryStates = 4    // Number of finalized states to keep in memory, each is tens of MB
+
+	pruneTarget = 90 // Percent of the disk quota left after pruning, so not every write prunes
+)
+
+type CacheStats struct {
+	BlockHits   uint64
+	BlockMisses uint64
+	StateHits   uint64
+	StateMisses uint64
+	DiskUsage   uint64 // bytes of the on-disk copy
+	DiskPruned  uint64 // files removed to stay within the quota
+}
+
+// beaconCache keeps beacon data which can no longer change: blocks and states
+// looked up by root, or by slot once the slot is finalized.
+type beaconCache struct {
+	bodies  *lru.Cache
+	headers *lru.Cache
+	states  *lru.Cache
+	dir     string // optional on-disk copy, empty to disable
+	quota   uint64 // bytes the on-disk copy may take, zero for unlimited
+	pruning sync.Mutex
+
+	finalizedSlot uint64
+	stats         CacheStats
+}
+
+// emptySlot marks a finalized slot without block.
+type emptySlot struct{}
+
+func newBeaconCache() *beaconCache {
+	bodies, err := lru.New(inMemoryBlocks)
+	if err != nil {
+		panic(err)
+	}
+	headers, err := lru.New(inMemoryBlocks)
+	if err != nil {
+		panic(err)
+	}
+	states, err := lru.New(inMemoryStates)
+	if err != nil {
+		panic(err)
+	}
+	return &beaconCache{bodies: bodies, headers: headers, states: states}
+}
+
+// SetCacheDir keeps a copy of cached blocks and states in dir, so they survive
+// restarts. The oldest files are removed once the copy takes more than quota
+// bytes, zero for unlimited.
+func (c *BeaconGrpcClient) SetCacheDir(dir string, quota uint64) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		logger.Error("beacon cache mkdir error:", err)
+		return err
+	}
+	c.cache.dir = dir
+	c.cache.quota = quota
+	c.cache.prune()
+	return nil
+}
+
+// CacheStats returns the cache hit counters and the disk usage.
+func (c *BeaconGrpcClient) CacheStats() CacheStats {
+	return CacheStats{
+		BlockHits:   atomic.LoadUint64(&c.cache.stats.BlockHits),
+		BlockMisses: atomic.LoadUint64(&c.cache.stats.BlockMisses),
+		StateHits:   atomic.LoadUint64(&c.cache.stats.StateHits),
+		StateMisses: atomic.LoadUint64(&c.cache.stats.StateMisses),
+		DiskUsage:   atomic.LoadUint64(&c.cache.stats.DiskUsage),
+		DiskPruned:  atomic.LoadUint64(&c.cache.stats.DiskPruned),
+	}
+}
+
+// MarkFinalized tells the cache that all slots up to slot are final, e.g.
+// after reading the finalized slot from the light client on TOP.
+func (c *BeaconGrpcClient) MarkFinalized(slot uint64) {
+	c.cache.setFinalizedSlot(slot)
+}
+
+func (c *beaconCache) setFinalizedSlot(slot uint64) {
+	if slot > atomic.LoadUint64(&c.finalizedSlot) {
+		atomic.StoreUint64(&c.finalizedSlot, slot)
+	}
+}
+
+// key returns the cache key of a block or state id, ids which may still
+// point at different data later, like head or an unfinalized slot, are not cached.
+func (c *beaconCache) key(id string) (string, bool) {
+	if len(id) == 32 {
+		return "0x" + hex.EncodeToString([]byte(id)), true
+	}
+	if len(id) == 66 && strings.HasPrefix(id, "0x") {
+		return strings.ToLower(id), true
+	}
+	slot, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return "", false
+	}
+	return id, slot <= atomic.LoadUint64(&c.finalizedSlot)
+}
+
+func (c *beaconCache) path(kind, key string) string {
+	return filepath.Join(c.dir, kind+"-"+key+".ssz")
+}
+
+func (c *beaconCache) load(kind, key string) ([]byte, bool) {
+	if c.dir == "" {
+		return nil, false
+	}
+	data, err := os.ReadFile(c.path(kind, key))
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+func (c *beaconCache) save(kind, key string, data []byte) {
+	if c.dir == "" {
+		return
+	}
+	// write then rename so a crash never leaves a truncated file behind
+	tmp := c.path(kind, key) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		logger.Warn("beacon cache write %v error: %v", tmp, err)
+		return
+	}
+	if err := os.Rename(tmp, c.path(kind, key)); err != nil {
+		logger.Warn("beacon cache rename %v error: %v", tmp, err)
+		return
+	}
+	if usage := atomic.AddUint64(&c.stats.DiskUsage, uint64(len(data))); c.quota != 0 && usage > c.quota {
+		c.prune()
+	}
+}
+
+// prune measures the on-disk copy and removes the least recently written
+// files until it is back under pruneTarget percent of the quota.
+func (c *beaconCache) prune() {
+	c.pruning.Lock()
+	defer c.pruning.Unlock()
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		logger.Warn("beacon cache read dir %v error: %v", c.dir, err)
+		return
+	}
+	var files []os.FileInfo
+	var usage uint64
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".ssz" {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, info)
+		usage += uint64(info.Size())
+	}
+	if c.quota != 0 && usage > c.quota {
+		sort.Slice(files, func(i, j int) bool { return files[i].ModTime().Before(files[j].ModTime()) })
+		target := c.quota * pruneTarget / 100
+		for _, f := range files {
+			if usage <= target {
+				break
+			}
+			if err := os.Remove(filepath.Join(c.dir, f.Name())); err != nil {
+				logger.Warn("beacon cache remove %v error: %v", f.Name(), err)
+				continue
+			}
+			usage -= uint64(f.Size())
+			atomic.AddUint64(&c.stats.DiskPruned, 1)
+		}
+		logger.Info("beacon cache pruned to %v bytes, quota %v", usage, c.quota)
+	}
+	atomic.StoreUint64(&c.stats.DiskUsage, usage)
+}
+
+func (c *beaconCache) hit(counter *uint64) {
+	atomic.AddUint64(counter, 1)
+}
+
+func (c *beaconCache) getBody(key string) (*v2.BeaconBlockBodyBellatrix, bool, bool) {
+	if v, ok := c.bodies.Get(key); ok {
+		c.hit(&c.stats.BlockHits)
+		if _, empty := v.(emptySlot); empty {
+			return nil, true, true
+		}
+		return v.(*v2.BeaconBlockBodyBellatrix), false, true
+	}
+	if data, ok := c.load("body", key); ok {
+		body := new(v2.BeaconBlockBodyBellatrix)
+		if err := body.UnmarshalSSZ(data); err == nil {
+			c.hit(&c.stats.BlockHits)
+			c.bodies.Add(key, body)
+			return body, false, true
+		}
+	}
+	c.hit(&c.stats.BlockMisses)
+	return nil, false, false
+}
+
+func (c *beaconCache) addBody(key string, body *v2.BeaconBlockBodyBellatrix) {
+	c.bodies.Add(key, body)
+	if data, err := body.MarshalSSZ(); err == nil {
+		c.save("body", key, data)
+	}
+}
+
+func (c *beaconCache) addEmptySlot(key string) {
+	c.bodies.Add(key, emptySlot{})
+	c.headers.Add(key, emptySlot{})
+}
+
+func (c *beaconCache) getHeader(key string) (*eth.BeaconBlockHeader, bool, bool) {
+	if v, ok := c.headers.Get(key); ok {
+		c.hit(&c.stats.BlockHits)
+		if _, empty := v.(emptySlot); empty {
+			return nil, true, true
+		}
+		return v.(*eth.BeaconBlockHeader), false, true
+	}
+	if data, ok := c.load("header", key); ok {
+		header := new(eth.BeaconBlockHeader)
+		if err := header.UnmarshalSSZ(data); err == nil {
+			c.hit(&c.stats.BlockHits)
+			c.headers.Add(key, header)
+			return header, false, true
+		}
+	}
+	c.hit(&c.stats.BlockMisses)
+	return nil, false, false
+}
+
+func (c *beaconCache) addHeader(key string, header *eth.BeaconBlockHeader) {
+	c.headers.Add(key, header)
+	if data, err := header.MarshalSSZ(); err == nil {
+		c.save("header", key, data)
+	}
+}
+
+func (c *beaconCache) getState(key string) (*eth.BeaconStateBellatrix, bool) {
+	if v, ok := c.states.Get(key); ok {
+		c.hit(&c.stats.StateHits)
+		return v.(*eth.BeaconStateBellatrix), true
+	}
+	if data, ok := c.load("state", key); ok {
+		state := new(eth.BeaconStateBellatrix)
+		if err := state.UnmarshalSSZ(data); err == nil {
+			c.hit(&c.stats.StateHits)
+			c.states.Add(key, state)
+			return state, true
+		}
+	}
+	c.hit(&c.stats.StateMisses)
+	return nil, false
+}
+
+func (c *beaconCache) addState(key string, state *eth.BeaconStateBellatrix, raw []byte) {
+	c.states.Add(key, state)
+	c.save("state", key, raw)
+}
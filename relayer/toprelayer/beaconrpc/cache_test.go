@@ -0,0 +1,129 @@
+package beaconrpc
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	eth "github.com/prysmaticlabs/prysm/v3/proto/prysm/v1alpha1"
+)
+
+func TestBeaconCacheKey(t *testing.T) {
+	c := newBeaconCache()
+	c.setFinalizedSlot(100)
+
+	root := "0xABCDEF0000000000000000000000000000000000000000000000000000000001"
+	if key, ok := c.key(root); !ok || key != "0xabcdef0000000000000000000000000000000000000000000000000000000001" {
+		t.Fatal("root key:", key, ok)
+	}
+	if key, ok := c.key(string(make([]byte, 32))); !ok || len(key) != 66 {
+		t.Fatal("raw root key:", key, ok)
+	}
+	if _, ok := c.key("100"); !ok {
+		t.Fatal("finalized slot should be cached")
+	}
+	if _, ok := c.key("101"); ok {
+		t.Fatal("unfinalized slot should not be cached")
+	}
+	if _, ok := c.key("head"); ok {
+		t.Fatal("head should not be cached")
+	}
+	c.setFinalizedSlot(50)
+	if _, ok := c.key("100"); !ok {
+		t.Fatal("finalized slot should not go back")
+	}
+}
+
+func TestBeaconCacheEmptySlot(t *testing.T) {
+	c := newBeaconCache()
+	c.addEmptySlot("10")
+	if _, empty, ok := c.getHeader("10"); !ok || !empty {
+		t.Fatal("expect empty header")
+	}
+	if _, empty, ok := c.getBody("10"); !ok || !empty {
+		t.Fatal("expect empty body")
+	}
+	if _, _, ok := c.getHeader("11"); ok {
+		t.Fatal("expect miss")
+	}
+}
+
+func TestBeaconCacheDisk(t *testing.T) {
+	dir := t.TempDir()
+	header := &eth.BeaconBlockHeader{
+		Slot:          10,
+		ProposerIndex: 3,
+		ParentRoot:    make([]byte, 32),
+		StateRoot:     make([]byte, 32),
+		BodyRoot:      make([]byte, 32),
+	}
+	c := &BeaconGrpcClient{cache: newBeaconCache()}
+	if err := c.SetCacheDir(dir, 0); err != nil {
+		t.Fatal(err)
+	}
+	c.cache.addHeader("10", header)
+
+	// a new cache on the same dir reads it back
+	c2 := &BeaconGrpcClient{cache: newBeaconCache()}
+	if err := c2.SetCacheDir(dir, 0); err != nil {
+		t.Fatal(err)
+	}
+	got, empty, ok := c2.cache.getHeader("10")
+	if !ok || empty {
+		t.Fatal("expect header from disk")
+	}
+	if got.Slot != header.Slot || got.ProposerIndex != header.ProposerIndex {
+		t.Fatal("header mismatch:", got)
+	}
+	stats := c2.CacheStats()
+	if stats.BlockHits != 1 || stats.BlockMisses != 0 {
+		t.Fatal("unexpected stats:", stats)
+	}
+}
+
+func TestBeaconCachePrune(t *testing.T) {
+	dir := t.TempDir()
+	c := &BeaconGrpcClient{cache: newBeaconCache()}
+	// room for two 112 byte headers
+	if err := c.SetCacheDir(dir, 300); err != nil {
+		t.Fatal(err)
+	}
+	newHeader := func() *eth.BeaconBlockHeader {
+		return &eth.BeaconBlockHeader{ParentRoot: make([]byte, 32), StateRoot: make([]byte, 32), BodyRoot: make([]byte, 32)}
+	}
+	now := time.Now()
+	for i, key := range []string{"1", "2"} {
+		c.cache.addHeader(key, newHeader())
+		old := now.Add(time.Duration(i-10) * time.Minute)
+		if err := os.Chtimes(c.cache.path("header", key), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stats := c.CacheStats(); stats.DiskUsage != 224 || stats.DiskPruned != 0 {
+		t.Fatal("unexpected stats:", stats)
+	}
+	c.cache.addHeader("3", newHeader())
+	if _, ok := c.cache.load("header", "1"); ok {
+		t.Fatal("expect the oldest header pruned")
+	}
+	for _, key := range []string{"2", "3"} {
+		if _, ok := c.cache.load("header", key); !ok {
+			t.Fatal("expect header kept:", key)
+		}
+	}
+	if stats := c.CacheStats(); stats.DiskUsage != 224 || stats.DiskPruned != 1 {
+		t.Fatal("unexpected stats:", stats)
+	}
+
+	// a smaller quota prunes on open
+	c2 := &BeaconGrpcClient{cache: newBeaconCache()}
+	if err := c2.SetCacheDir(dir, 150); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c2.cache.load("header", "2"); ok {
+		t.Fatal("expect header 2 pruned")
+	}
+	if stats := c2.CacheStats(); stats.DiskUsage != 112 || stats.DiskPruned != 1 {
+		t.Fatal("unexpected stats:", stats)
+	}
+}
@@ -0,0 +1,155 @@
+package beaconrpc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/wonderivan/logger"
+)
+
+const (
+	EVENT_HEAD                         = "head"
+	EVENT_FINALIZED_CHECKPOINT         = "finalized_checkpoint"
+	EVENT_LIGHT_CLIENT_FINALITY_UPDATE = "light_client_finality_update"
+
+	eventReconnectMinDelay = time.Second
+	eventReconnectMaxDelay = time.Minute
+	eventMaxLineSize       = 4 * 1024 * 1024
+)
+
+type BeaconEvent struct {
+	Topic string
+	Data  []byte
+}
+
+// EventSubscription follows the beacon node event stream and keeps
+// reconnecting until its context is cancelled.
+type EventSubscription struct {
+	client *BeaconGrpcClient
+	topics []string
+
+	wake      chan struct{}
+	events    chan BeaconEvent
+	connected int32
+}
+
+// SubscribeEvents subscribes to the /eth/v1/events stream for the given topics.
+func (c *BeaconGrpcClient) SubscribeEvents(ctx context.Context, topics []string) *EventSubscription {
+	sub := &EventSubscription{
+		client: c,
+		topics: topics,
+		wake:   make(chan struct{}, 1),
+		events: make(chan BeaconEvent, 16),
+	}
+	go sub.loop(ctx)
+	return sub
+}
+
+// Wake fires once for any number of events received since the last read.
+func (sub *EventSubscription) Wake() <-chan struct{} {
+	return sub.wake
+}
+
+// Events delivers received events, events are dropped if nobody reads them.
+func (sub *EventSubscription) Events() <-chan BeaconEvent {
+	return sub.events
+}
+
+// Connected reports whether the stream is up, callers fall back to polling
+// otherwise.
+func (sub *EventSubscription) Connected() bool {
+	return atomic.LoadInt32(&sub.connected) == 1
+}
+
+func (sub *EventSubscription) loop(ctx context.Context) {
+	delay := eventReconnectMinDelay
+	for {
+		start := time.Now()
+		err := sub.stream(ctx)
+		atomic.StoreInt32(&sub.connected, 0)
+		if ctx.Err() != nil {
+			return
+		}
+		logger.Warn("beacon event stream closed: %v, reconnect in %v", err, delay)
+		if time.Since(start) > eventReconnectMaxDelay {
+			delay = eventReconnectMinDelay
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay *= 2
+		if delay > eventReconnectMaxDelay {
+			delay = eventReconnectMaxDelay
+		}
+	}
+}
+
+func (sub *EventSubscription) stream(ctx context.Context) error {
+	url := fmt.Sprintf("%s/eth/v1/events?topics=%s", sub.client.httpurl, strings.Join(sub.topics, ","))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	// the stream stays open, so no overall request timeout
+	client := &http.Client{Transport: sub.client.httpclient.Transport}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("http status %v", resp.Status)
+	}
+	atomic.StoreInt32(&sub.connected, 1)
+	logger.Info("beacon event stream connected, topics: %v", sub.topics)
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), eventMaxLineSize)
+	var topic string
+	var data bytes.Buffer
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if topic != "" || data.Len() > 0 {
+				sub.dispatch(BeaconEvent{Topic: topic, Data: append([]byte(nil), data.Bytes()...)})
+			}
+			topic = ""
+			data.Reset()
+		case strings.HasPrefix(line, ":"):
+			// comment, used as keep alive
+		case strings.HasPrefix(line, "event:"):
+			topic = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("stream eof")
+}
+
+func (sub *EventSubscription) dispatch(event BeaconEvent) {
+	logger.Debug("beacon event: %v", event.Topic)
+	select {
+	case sub.events <- event:
+	default:
+	}
+	select {
+	case sub.wake <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,66 @@
+package beaconrpc
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSubscribeEvents(t *testing.T) {
+	var conns int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/eth/v1/events" || r.URL.Query().Get("topics") != "head,finalized_checkpoint" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		n := atomic.AddInt32(&conns, 1)
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintf(w, ": keep alive\n\n")
+		fmt.Fprintf(w, "event: head\ndata: {\"slot\":\"%d\"}\n\n", n)
+		w.(http.Flusher).Flush()
+		// close the stream to force a reconnect
+	}))
+	defer server.Close()
+
+	c := &BeaconGrpcClient{httpclient: server.Client(), httpurl: server.URL}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sub := c.SubscribeEvents(ctx, []string{EVENT_HEAD, EVENT_FINALIZED_CHECKPOINT})
+
+	for i := 1; i <= 2; i++ {
+		select {
+		case ev := <-sub.Events():
+			if ev.Topic != EVENT_HEAD {
+				t.Fatal("unexpected topic:", ev.Topic)
+			}
+			if string(ev.Data) != fmt.Sprintf("{\"slot\":\"%d\"}", i) {
+				t.Fatal("unexpected data:", string(ev.Data))
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("no event after", i-1)
+		}
+	}
+	select {
+	case <-sub.Wake():
+	default:
+		t.Fatal("expect wake")
+	}
+}
+
+func TestSubscribeEventsUnavailable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	c := &BeaconGrpcClient{httpclient: server.Client(), httpurl: server.URL}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sub := c.SubscribeEvents(ctx, []string{EVENT_HEAD})
+	time.Sleep(100 * time.Millisecond)
+	if sub.Connected() {
+		t.Fatal("expect not connected")
+	}
+}
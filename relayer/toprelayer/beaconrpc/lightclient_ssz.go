@@ -0,0 +1,166 @@
+package beaconrpc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"toprelayer/errs"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	eth "github.com/prysmaticlabs/prysm/v3/proto/prysm/v1alpha1"
+	"github.com/wonderivan/logger"
+)
+
+const (
+	CONTENT_TYPE_SSZ  = "application/octet-stream"
+	CONTENT_TYPE_JSON = "application/json"
+	ACCEPT_SSZ        = CONTENT_TYPE_SSZ + ";q=1.0," + CONTENT_TYPE_JSON + ";q=0.9"
+
+	SYNC_COMMITTEE_SIZE = 512
+
+	// altair and bellatrix light client headers hold only the beacon header,
+	// later forks add the execution header and have a different layout
+	LIGHT_CLIENT_HEADER_SSZ_SIZE          = 8 + 8 + 32 + 32 + 32
+	SYNC_COMMITTEE_SSZ_SIZE               = SYNC_COMMITTEE_SIZE*48 + 48
+	SYNC_COMMITTEE_BRANCH_SSZ_SIZE        = 5 * 32
+	FINALITY_BRANCH_SSZ_SIZE              = 6 * 32
+	SYNC_AGGREGATE_SSZ_SIZE               = SYNC_COMMITTEE_SIZE/8 + 96
+	LIGHT_CLIENT_FINALITY_UPDATE_SSZ_SIZE = 2*LIGHT_CLIENT_HEADER_SSZ_SIZE + FINALITY_BRANCH_SSZ_SIZE + SYNC_AGGREGATE_SSZ_SIZE + 8
+	LIGHT_CLIENT_UPDATE_SSZ_SIZE          = LIGHT_CLIENT_FINALITY_UPDATE_SSZ_SIZE + SYNC_COMMITTEE_SSZ_SIZE + SYNC_COMMITTEE_BRANCH_SSZ_SIZE
+
+	// each update of a list is prefixed by its length and fork digest
+	SSZ_CHUNK_PREFIX_SIZE = 8 + 4
+)
+
+// getLightClientData gets a light client response as ssz if the node serves
+// ssz of the expected size, otherwise as json. list is set for endpoints
+// returning a list of updates, then only the first update is returned.
+func (c *BeaconGrpcClient) getLightClientData(url string, size int, list bool) ([]byte, bool, error) {
+	if atomic.LoadInt32(&c.lcJsonOnly) == 0 {
+		body, contentType, err := c.httpGetAccept(url, ACCEPT_SSZ)
+		if err != nil {
+			return nil, false, err
+		}
+		if !strings.HasPrefix(contentType, CONTENT_TYPE_SSZ) {
+			return body, false, nil
+		}
+		if list {
+			if len(body) == 0 {
+				return nil, false, errs.Wrap(errs.ErrNotFound, fmt.Errorf("no light client update: %v", url))
+			}
+			body, err = firstSSZChunk(body)
+			if err != nil {
+				logger.Error("firstSSZChunk error:", err)
+				return nil, false, err
+			}
+		}
+		if len(body) == size {
+			return body, true, nil
+		}
+		logger.Warn("light client ssz size %v, expect %v, fall back to json", len(body), size)
+		atomic.StoreInt32(&c.lcJsonOnly, 1)
+	}
+	body, _, err := c.httpGetAccept(url, CONTENT_TYPE_JSON)
+	if err != nil {
+		return nil, false, err
+	}
+	return body, false, nil
+}
+
+func firstSSZChunk(b []byte) ([]byte, error) {
+	if len(b) < SSZ_CHUNK_PREFIX_SIZE {
+		return nil, fmt.Errorf("ssz chunk prefix too short: %v", len(b))
+	}
+	size := binary.LittleEndian.Uint64(b[:8])
+	b = b[SSZ_CHUNK_PREFIX_SIZE:]
+	if uint64(len(b)) < size {
+		return nil, fmt.Errorf("ssz chunk size %v, have %v", size, len(b))
+	}
+	return b[:size], nil
+}
+
+type sszReader struct {
+	b []byte
+}
+
+func (r *sszReader) next(n int) []byte {
+	b := make([]byte, n)
+	copy(b, r.b[:n])
+	r.b = r.b[n:]
+	return b
+}
+
+func (r *sszReader) uint64() uint64 {
+	return binary.LittleEndian.Uint64(r.next(8))
+}
+
+func (r *sszReader) roots(n int) [][]byte {
+	roots := make([][]byte, n)
+	for i := range roots {
+		roots[i] = r.next(32)
+	}
+	return roots
+}
+
+func (r *sszReader) beaconHeader() *BeaconBlockHeader {
+	h := new(BeaconBlockHeader)
+	h.Slot = r.uint64()
+	h.ProposerIndex = r.uint64()
+	h.ParentRoot = r.next(32)
+	h.StateRoot = r.next(32)
+	h.BodyRoot = r.next(32)
+	return h
+}
+
+func (r *sszReader) syncCommitteeUpdate() *SyncCommitteeUpdate {
+	committee := new(eth.SyncCommittee)
+	for i := 0; i < SYNC_COMMITTEE_SIZE; i++ {
+		committee.Pubkeys = append(committee.Pubkeys, r.next(48))
+	}
+	committee.AggregatePubkey = r.next(48)
+
+	update := new(SyncCommitteeUpdate)
+	update.NextSyncCommittee = committee
+	update.NextSyncCommitteeBranch = r.roots(SYNC_COMMITTEE_BRANCH_SSZ_SIZE / 32)
+	return update
+}
+
+func decodeSyncCommitteeUpdateSSZ(b []byte) *SyncCommitteeUpdate {
+	r := &sszReader{b: b}
+	return r.syncCommitteeUpdate()
+}
+
+// decodeLightClientUpdateSSZ decodes a LightClientUpdate, or a
+// LightClientFinalityUpdate without the committee.
+func (c *BeaconGrpcClient) decodeLightClientUpdateSSZ(b []byte, withCommittee bool) (*LightClientUpdate, error) {
+	size := LIGHT_CLIENT_FINALITY_UPDATE_SSZ_SIZE
+	if withCommittee {
+		size = LIGHT_CLIENT_UPDATE_SSZ_SIZE
+	}
+	if len(b) != size {
+		return nil, fmt.Errorf("light client update ssz size %v, expect %v", len(b), size)
+	}
+	r := &sszReader{b: b}
+	update := new(LightClientUpdate)
+	update.AttestedBeaconHeader = r.beaconHeader()
+	if withCommittee {
+		update.NextSyncCommitteeUpdate = r.syncCommitteeUpdate()
+	}
+	finalizedHeader := r.beaconHeader()
+	finalityBranch := r.roots(FINALITY_BRANCH_SSZ_SIZE / 32)
+	update.SyncAggregate = &SyncAggregate{
+		// same form as the json field
+		SyncCommitteeBits:      hexutil.Encode(r.next(SYNC_COMMITTEE_SIZE / 8)),
+		SyncCommitteeSignature: r.next(96),
+	}
+	update.SignatureSlot = r.uint64()
+
+	finalizedUpdate, err := c.finalizedUpdate(finalizedHeader, finalityBranch)
+	if err != nil {
+		logger.Error("finalizedUpdate error:", err)
+		return nil, err
+	}
+	update.FinalizedUpdate = finalizedUpdate
+	return update, nil
+}
@@ -0,0 +1,389 @@
+package bor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"toprelayer/relayer/toprelayer/headerbatch"
+
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/wonderivan/logger"
+	"golang.org/x/crypto/sha3"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+const (
+	inMemorySpans      = 16   // Number of recent spans to keep in memory
+	inMemorySignatures = 4096 // Number of recent block signatures to keep in memory
+
+	extraVanity = 32 // Fixed number of extra-data prefix bytes reserved for signer vanity
+	extraSeal   = 65 // Fixed number of extra-data suffix bytes reserved for signer seal
+
+	validatorPowerLength       = 20 // Big endian voting power after each validator address
+	validatorHeaderBytesLength = common.AddressLength + validatorPowerLength
+)
+
+var (
+	uncleHash = types.CalcUncleHash(nil) // Always Keccak256(RLP([])) as uncles are meaningless outside of PoW.
+
+	PolygonChainConfig = &ChainConfig{
+		ChainId:       big.NewInt(137),
+		JaipurBlock:   big.NewInt(23850000),
+		DelhiBlock:    big.NewInt(38189056),
+		ZerothSpanEnd: 255,
+		SpanLength:    6400,
+	}
+	MumbaiChainConfig = &ChainConfig{
+		ChainId:       big.NewInt(80001),
+		JaipurBlock:   big.NewInt(22770000),
+		DelhiBlock:    big.NewInt(29638656),
+		ZerothSpanEnd: 255,
+		SpanLength:    6400,
+	}
+)
+
+// Various error messages to mark blocks invalid.
+var (
+	errUnknownBlock           = errors.New("unknown block")
+	errMissingVanity          = errors.New("extra-data 32 byte vanity prefix missing")
+	errMissingSignature       = errors.New("extra-data 65 byte signature suffix missing")
+	errExtraValidators        = errors.New("non-sprint-end block contains extra validator list")
+	errInvalidSpanValidators  = errors.New("invalid validator list on sprint end block")
+	errInvalidUncleHash       = errors.New("non empty uncle hash")
+	errInvalidMixDigest       = errors.New("non-zero mix digest")
+	errInvalidDifficulty      = errors.New("invalid difficulty")
+	errWrongDifficulty        = errors.New("difficulty above producer count")
+	errInvalidTimestamp       = errors.New("invalid timestamp")
+	errUnauthorizedValidator  = errors.New("unauthorized validator")
+	errSpanNotFound           = errors.New("span does not cover block")
+	errValidatorBytesNotAlign = errors.New("validator bytes not aligned")
+)
+
+// ChainConfig is the hard forks and span layout of a Bor chain. Jaipur seals
+// the base fee, Delhi shrinks sprints from 64 to 16 blocks. A nil fork is not
+// scheduled.
+type ChainConfig struct {
+	ChainId       *big.Int
+	JaipurBlock   *big.Int
+	DelhiBlock    *big.Int
+	ZerothSpanEnd uint64 // End block of the first span
+	SpanLength    uint64 // Number of blocks of every later span
+}
+
+// DefaultChainConfig returns the config of a known chain id, for others one
+// without forks and the span layout of Polygon.
+func DefaultChainConfig(chainId *big.Int) *ChainConfig {
+	for _, c := range []*ChainConfig{PolygonChainConfig, MumbaiChainConfig} {
+		if chainId != nil && c.ChainId.Cmp(chainId) == 0 {
+			cpy := *c
+			return &cpy
+		}
+	}
+	return &ChainConfig{
+		ChainId:       chainId,
+		ZerothSpanEnd: PolygonChainConfig.ZerothSpanEnd,
+		SpanLength:    PolygonChainConfig.SpanLength,
+	}
+}
+
+// SetForks overrides the fork heights by lowercase name.
+func (c *ChainConfig) SetForks(forks map[string]uint64) error {
+	for name, number := range forks {
+		switch name {
+		case "jaipur":
+			c.JaipurBlock = new(big.Int).SetUint64(number)
+		case "delhi":
+			c.DelhiBlock = new(big.Int).SetUint64(number)
+		default:
+			return fmt.Errorf("unknown bor fork %v", name)
+		}
+	}
+	return nil
+}
+
+func (c *ChainConfig) IsJaipur(number *big.Int) bool {
+	return isForked(c.JaipurBlock, number)
+}
+
+func (c *ChainConfig) IsDelhi(number *big.Int) bool {
+	return isForked(c.DelhiBlock, number)
+}
+
+func isForked(fork, number *big.Int) bool {
+	return fork != nil && number.Cmp(fork) >= 0
+}
+
+// SprintSize returns the number of blocks a producer seals in a row.
+func (c *ChainConfig) SprintSize(number uint64) uint64 {
+	if c.IsDelhi(new(big.Int).SetUint64(number)) {
+		return 16
+	}
+	return 64
+}
+
+// IsSprintEnd reports whether number is the last block of a sprint, such
+// headers carry the producers of the next block.
+func (c *ChainConfig) IsSprintEnd(number uint64) bool {
+	return (number+1)%c.SprintSize(number) == 0
+}
+
+// SpanIdAt returns the id of the span containing block number.
+func (c *ChainConfig) SpanIdAt(number uint64) uint64 {
+	if number <= c.ZerothSpanEnd {
+		return 0
+	}
+	return (number-c.ZerothSpanEnd-1)/c.SpanLength + 1
+}
+
+// Bor is the consensus engine of Polygon PoS. Producers come from the spans
+// committed on Heimdall, headers are submitted to TOP as rlp.
+type Bor struct {
+	config     *ChainConfig
+	fetcher    *headerbatch.Fetcher
+	spans      SpanSource
+	spanCache  *lru.ARCCache // Spans by id, they are final once committed
+	signatures *lru.ARCCache // Signatures of recent blocks to speed up verification
+}
+
+func New(fetcher *headerbatch.Fetcher, spans SpanSource) *Bor {
+	spanCache, err := lru.NewARC(inMemorySpans)
+	if err != nil {
+		panic(err)
+	}
+	signatures, err := lru.NewARC(inMemorySignatures)
+	if err != nil {
+		panic(err)
+	}
+	return &Bor{
+		config:     PolygonChainConfig,
+		fetcher:    fetcher,
+		spans:      spans,
+		spanCache:  spanCache,
+		signatures: signatures,
+	}
+}
+
+// SetConfig sets the config of a Bor chain other than Polygon mainnet.
+func (c *Bor) SetConfig(config *ChainConfig) {
+	c.config = config
+}
+
+// Init loads the span of the next header to sync after height.
+func (c *Bor) Init(height uint64) error {
+	span, err := c.getSpan(height + 1)
+	if err != nil {
+		logger.Error(err)
+		return err
+	}
+	logger.Info("bor span %v from %v to %v, producers: %v", span.ID, span.StartBlock, span.EndBlock, len(span.SelectedProducers))
+	return nil
+}
+
+// getSpan returns the span containing block number.
+func (c *Bor) getSpan(number uint64) (*Span, error) {
+	id := c.config.SpanIdAt(number)
+	if s, ok := c.spanCache.Get(id); ok {
+		return s.(*Span), nil
+	}
+	span, err := c.spans.GetSpan(context.Background(), id)
+	if err != nil {
+		logger.Error("GetSpan error:", err)
+		return nil, err
+	}
+	if !span.contains(number) {
+		return nil, fmt.Errorf("%w: span %v [%v, %v], block %v", errSpanNotFound, span.ID, span.StartBlock, span.EndBlock, number)
+	}
+	c.spanCache.Add(id, span)
+	return span, nil
+}
+
+// VerifyHeader checks that header is well formed and sealed by a producer of
+// its span on top of parent, parent is fetched if nil. The succession of
+// producers within a sprint is not tracked, the difficulty is only bounded by
+// the producer count.
+func (c *Bor) VerifyHeader(header, parent *types.Header) error {
+	if header.Number == nil || header.Number.Sign() == 0 {
+		return errUnknownBlock
+	}
+	number := header.Number.Uint64()
+	if err := verifyExtra(header, c.config); err != nil {
+		return err
+	}
+	if header.UncleHash != uncleHash {
+		return errInvalidUncleHash
+	}
+	if header.MixDigest != (common.Hash{}) {
+		return errInvalidMixDigest
+	}
+	if header.Difficulty == nil || header.Difficulty.Sign() <= 0 {
+		return errInvalidDifficulty
+	}
+	if parent == nil {
+		var err error
+		parent, err = c.fetcher.HeaderByHash(context.Background(), header.ParentHash)
+		if err != nil {
+			logger.Error(err)
+			return err
+		}
+	}
+	if parent.Number.Uint64() != number-1 || parent.Hash() != header.ParentHash {
+		return consensus.ErrUnknownAncestor
+	}
+	if header.Time <= parent.Time {
+		return errInvalidTimestamp
+	}
+
+	span, err := c.getSpan(number)
+	if err != nil {
+		return err
+	}
+	signer, err := ecrecover(header, c.signatures, c.config)
+	if err != nil {
+		return err
+	}
+	if span.producer(signer) == nil {
+		return errUnauthorizedValidator
+	}
+	if header.Difficulty.Cmp(big.NewInt(int64(len(span.SelectedProducers)))) > 0 {
+		return errWrongDifficulty
+	}
+	if c.config.IsSprintEnd(number) {
+		next, err := c.getSpan(number + 1)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(validatorBytes(header), next.producerBytes()) {
+			return errInvalidSpanValidators
+		}
+	}
+	return nil
+}
+
+// verifyExtra checks the extra-data layout: producers only on sprint end
+// headers.
+func verifyExtra(header *types.Header, config *ChainConfig) error {
+	if len(header.Extra) < extraVanity {
+		return errMissingVanity
+	}
+	if len(header.Extra) < extraVanity+extraSeal {
+		return errMissingSignature
+	}
+	n := len(validatorBytes(header))
+	if !config.IsSprintEnd(header.Number.Uint64()) {
+		if n != 0 {
+			return errExtraValidators
+		}
+		return nil
+	}
+	if n == 0 || n%validatorHeaderBytesLength != 0 {
+		return errValidatorBytesNotAlign
+	}
+	return nil
+}
+
+func validatorBytes(header *types.Header) []byte {
+	return header.Extra[extraVanity : len(header.Extra)-extraSeal]
+}
+
+// ParseValidators decodes the producers in the extra-data of a sprint end
+// header.
+func ParseValidators(header *types.Header, config *ChainConfig) ([]Validator, error) {
+	if err := verifyExtra(header, config); err != nil {
+		return nil, err
+	}
+	data := validatorBytes(header)
+	validators := make([]Validator, len(data)/validatorHeaderBytesLength)
+	for i := range validators {
+		b := data[i*validatorHeaderBytesLength:]
+		copy(validators[i].Address[:], b[:common.AddressLength])
+		validators[i].VotingPower = new(big.Int).SetBytes(b[common.AddressLength:validatorHeaderBytesLength]).Int64()
+	}
+	return validators, nil
+}
+
+// GetLastSnapBytes returns the rlp of header, Bor has no snapshot to submit.
+func (c *Bor) GetLastSnapBytes(header *types.Header) ([]byte, error) {
+	bytes, err := rlp.EncodeToBytes(header)
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+	return bytes, nil
+}
+
+// Apply does nothing, the spans are fetched from Heimdall when needed.
+func (c *Bor) Apply(header *types.Header) error {
+	return nil
+}
+
+// Rollback keeps every span, they are final on Heimdall whatever the branch.
+func (c *Bor) Rollback(number uint64) {
+	logger.Info("bor rollback to %v, spans kept", number)
+}
+
+// ecrecover extracts the Polygon account address from a signed header.
+func ecrecover(header *types.Header, sigCache *lru.ARCCache, config *ChainConfig) (common.Address, error) {
+	// If the signature's already cached, return that
+	hash := header.Hash()
+	if address, known := sigCache.Get(hash); known {
+		return address.(common.Address), nil
+	}
+	// Retrieve the signature from the header extra-data
+	if len(header.Extra) < extraSeal {
+		return common.Address{}, errMissingSignature
+	}
+	signature := header.Extra[len(header.Extra)-extraSeal:]
+
+	// Recover the public key and the Polygon address
+	pubkey, err := crypto.Ecrecover(SealHash(header, config).Bytes(), signature)
+	if err != nil {
+		return common.Address{}, err
+	}
+	var signer common.Address
+	copy(signer[:], crypto.Keccak256(pubkey[1:])[12:])
+
+	sigCache.Add(hash, signer)
+	return signer, nil
+}
+
+// SealHash returns the hash of a block prior to it being sealed.
+func SealHash(header *types.Header, config *ChainConfig) (hash common.Hash) {
+	hasher := sha3.NewLegacyKeccak256()
+	encodeSigHeader(hasher, header, config)
+	hasher.Sum(hash[:0])
+	return hash
+}
+
+func encodeSigHeader(w io.Writer, header *types.Header, config *ChainConfig) {
+	enc := []interface{}{
+		header.ParentHash,
+		header.UncleHash,
+		header.Coinbase,
+		header.Root,
+		header.TxHash,
+		header.ReceiptHash,
+		header.Bloom,
+		header.Difficulty,
+		header.Number,
+		header.GasLimit,
+		header.GasUsed,
+		header.Time,
+		header.Extra[:len(header.Extra)-extraSeal], // Yes, this will panic if extra is too short
+		header.MixDigest,
+		header.Nonce,
+	}
+	if config.IsJaipur(header.Number) && header.BaseFee != nil {
+		enc = append(enc, header.BaseFee)
+	}
+	if err := rlp.Encode(w, enc); err != nil {
+		panic("can't encode: " + err.Error())
+	}
+}
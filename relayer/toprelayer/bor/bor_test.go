@@ -0,0 +1,266 @@
+package bor
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// heimdallSpan is a /bor/span response in the shape served by Heimdall.
+const heimdallSpan = `{
+	"height": "12345",
+	"result": {
+		"span_id": 2,
+		"start_block": 6656,
+		"end_block": 13055,
+		"validator_set": {"validators": [], "proposer": null},
+		"selected_producers": [
+			{"ID": 4, "startEpoch": 0, "endEpoch": 0, "nonce": 1, "power": 10000, "pubKey": "0x04", "signer": "0x127685d6dd6683085da4b6a041efcef1681e5c9c", "last_updated": "", "jailed": false, "accum": 0},
+			{"ID": 7, "startEpoch": 0, "endEpoch": 0, "nonce": 1, "power": 2500, "pubKey": "0x04", "signer": "0x0375b2fc7140977c9c76d45421564e354ed42277", "last_updated": "", "jailed": false, "accum": 0}
+		],
+		"bor_chain_id": "137"
+	}
+}`
+
+type spanMap map[uint64]*Span
+
+func (m spanMap) GetSpan(ctx context.Context, id uint64) (*Span, error) {
+	if s, ok := m[id]; ok {
+		return s, nil
+	}
+	return nil, fmt.Errorf("span %v not found", id)
+}
+
+type producer struct {
+	key   *ecdsa.PrivateKey
+	power int64
+}
+
+func newProducers(t *testing.T, n int) []producer {
+	var producers []producer
+	for i := 0; i < n; i++ {
+		key, err := crypto.GenerateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		producers = append(producers, producer{key, int64(100 * (i + 1))})
+	}
+	return producers
+}
+
+func newSpan(id, start, end uint64, producers []producer) *Span {
+	span := &Span{ID: id, StartBlock: start, EndBlock: end}
+	for _, p := range producers {
+		span.SelectedProducers = append(span.SelectedProducers, Validator{crypto.PubkeyToAddress(p.key.PublicKey), p.power})
+	}
+	return span
+}
+
+func seal(t *testing.T, header *types.Header, key *ecdsa.PrivateKey) {
+	sig, err := crypto.Sign(SealHash(header, PolygonChainConfig).Bytes(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(header.Extra[len(header.Extra)-extraSeal:], sig)
+}
+
+// newChain seals headers from..to with producers of the spans, each sprint by
+// one producer, sprint end headers carry the producers of the next block.
+func newChain(t *testing.T, spans spanMap, keys map[common.Address]*ecdsa.PrivateKey, from, to uint64) []*types.Header {
+	var headers []*types.Header
+	parent := common.HexToHash("0x01")
+	for i := from; i <= to; i++ {
+		span := spans[PolygonChainConfig.SpanIdAt(i)]
+		signer := span.SelectedProducers[(i/PolygonChainConfig.SprintSize(i))%uint64(len(span.SelectedProducers))]
+		extra := make([]byte, extraVanity)
+		if PolygonChainConfig.IsSprintEnd(i) {
+			extra = append(extra, spans[PolygonChainConfig.SpanIdAt(i+1)].producerBytes()...)
+		}
+		extra = append(extra, make([]byte, extraSeal)...)
+		header := &types.Header{
+			ParentHash: parent,
+			UncleHash:  uncleHash,
+			Coinbase:   common.Address{},
+			Difficulty: big.NewInt(int64(len(span.SelectedProducers))),
+			Number:     new(big.Int).SetUint64(i),
+			GasLimit:   30000000,
+			Time:       i * 2,
+			Extra:      extra,
+			BaseFee:    big.NewInt(30000000000),
+		}
+		seal(t, header, keys[signer.Address])
+		headers = append(headers, header)
+		parent = header.Hash()
+	}
+	return headers
+}
+
+func TestVerifyHeader(t *testing.T) {
+	first, second := newProducers(t, 3), newProducers(t, 2)
+	spans := spanMap{
+		1: newSpan(1, 256, 6655, first),
+		2: newSpan(2, 6656, 13055, second),
+	}
+	keys := make(map[common.Address]*ecdsa.PrivateKey)
+	for _, p := range append(first, second...) {
+		keys[crypto.PubkeyToAddress(p.key.PublicKey)] = p.key
+	}
+	// crosses the sprint and span end at 6655
+	headers := newChain(t, spans, keys, 6590, 6700)
+	c := New(nil, spans)
+	for i := 1; i < len(headers); i++ {
+		if err := c.VerifyHeader(headers[i], headers[i-1]); err != nil {
+			t.Fatal(headers[i].Number, err)
+		}
+	}
+	end := headers[6655-6590]
+	validators, err := ParseValidators(end, PolygonChainConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(validators) != len(second) || spans[2].producer(validators[0].Address) == nil {
+		t.Fatal("unexpected sprint end validators:", validators)
+	}
+
+	stranger, _ := crypto.GenerateKey()
+	tests := []struct {
+		name   string
+		number uint64
+		modify func(h *types.Header) *ecdsa.PrivateKey
+		want   error
+	}{
+		{"unauthorized", 6650, func(h *types.Header) *ecdsa.PrivateKey {
+			return stranger
+		}, errUnauthorizedValidator},
+		{"producer of next span", 6650, func(h *types.Header) *ecdsa.PrivateKey {
+			return second[0].key
+		}, errUnauthorizedValidator},
+		{"difficulty", 6650, func(h *types.Header) *ecdsa.PrivateKey {
+			h.Difficulty = big.NewInt(4)
+			return first[0].key
+		}, errWrongDifficulty},
+		{"zero difficulty", 6650, func(h *types.Header) *ecdsa.PrivateKey {
+			h.Difficulty = big.NewInt(0)
+			return first[0].key
+		}, errInvalidDifficulty},
+		{"timestamp", 6650, func(h *types.Header) *ecdsa.PrivateKey {
+			h.Time = headers[6649-6590].Time
+			return first[0].key
+		}, errInvalidTimestamp},
+		{"extra validators", 6650, func(h *types.Header) *ecdsa.PrivateKey {
+			h.Extra = append(append(make([]byte, extraVanity), spans[1].producerBytes()...), make([]byte, extraSeal)...)
+			return first[0].key
+		}, errExtraValidators},
+		{"wrong sprint end validators", 6655, func(h *types.Header) *ecdsa.PrivateKey {
+			h.Extra = append(append(make([]byte, extraVanity), spans[1].producerBytes()...), make([]byte, extraSeal)...)
+			return first[0].key
+		}, errInvalidSpanValidators},
+		{"missing sprint end validators", 6655, func(h *types.Header) *ecdsa.PrivateKey {
+			h.Extra = make([]byte, extraVanity+extraSeal)
+			return first[0].key
+		}, errValidatorBytesNotAlign},
+		{"uncles", 6650, func(h *types.Header) *ecdsa.PrivateKey {
+			h.UncleHash = common.Hash{}
+			return first[0].key
+		}, errInvalidUncleHash},
+		{"unknown parent", 6650, func(h *types.Header) *ecdsa.PrivateKey {
+			h.ParentHash = common.Hash{}
+			return first[0].key
+		}, consensus.ErrUnknownAncestor},
+	}
+	for _, test := range tests {
+		header := types.CopyHeader(headers[test.number-6590])
+		seal(t, header, test.modify(header))
+		if err := c.VerifyHeader(header, headers[test.number-6591]); !errors.Is(err, test.want) {
+			t.Errorf("%v: expect %v, got %v", test.name, test.want, err)
+		}
+	}
+}
+
+func TestSpanNotCovering(t *testing.T) {
+	producers := newProducers(t, 1)
+	spans := spanMap{1: newSpan(1, 256, 6655, producers), 2: newSpan(2, 6656, 6700, producers)}
+	c := New(nil, spans)
+	if _, err := c.getSpan(7000); err == nil {
+		t.Fatal("expect span lookup error")
+	}
+	spans[2] = newSpan(2, 7000, 13055, producers)
+	c = New(nil, spans)
+	if _, err := c.getSpan(6656); !errors.Is(err, errSpanNotFound) {
+		t.Fatal("expect errSpanNotFound, got", err)
+	}
+}
+
+func TestSpanIdAt(t *testing.T) {
+	for number, id := range map[uint64]uint64{0: 0, 255: 0, 256: 1, 6655: 1, 6656: 2, 13055: 2, 13056: 3} {
+		if got := PolygonChainConfig.SpanIdAt(number); got != id {
+			t.Errorf("block %v: expect span %v, got %v", number, id, got)
+		}
+	}
+	delhi := PolygonChainConfig.DelhiBlock.Uint64()
+	if !PolygonChainConfig.IsSprintEnd(delhi-1) || !PolygonChainConfig.IsSprintEnd(delhi+15) || PolygonChainConfig.IsSprintEnd(delhi+14) {
+		t.Fatal("unexpected sprint ends around Delhi")
+	}
+	// a chain without forks keeps 64 block sprints
+	config := DefaultChainConfig(big.NewInt(1))
+	if config.SprintSize(delhi) != 64 || config.SpanIdAt(256) != 1 {
+		t.Fatal("unexpected default config:", config)
+	}
+	config.SpanLength = 100
+	if config.SpanIdAt(355) != 1 || config.SpanIdAt(356) != 2 {
+		t.Fatal("span length not configured")
+	}
+}
+
+func TestSealHashBaseFee(t *testing.T) {
+	header := &types.Header{Difficulty: common.Big1, Extra: make([]byte, extraVanity+extraSeal), BaseFee: big.NewInt(1)}
+	other := types.CopyHeader(header)
+	other.BaseFee = big.NewInt(2)
+
+	jaipur := PolygonChainConfig.JaipurBlock
+	header.Number, other.Number = new(big.Int).Sub(jaipur, common.Big1), new(big.Int).Sub(jaipur, common.Big1)
+	if SealHash(header, PolygonChainConfig) != SealHash(other, PolygonChainConfig) {
+		t.Fatal("base fee sealed before Jaipur")
+	}
+	header.Number, other.Number = jaipur, jaipur
+	if SealHash(header, PolygonChainConfig) == SealHash(other, PolygonChainConfig) {
+		t.Fatal("base fee not sealed from Jaipur")
+	}
+}
+
+func TestHeimdallGetSpan(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/bor/span/2" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(heimdallSpan))
+	}))
+	defer server.Close()
+
+	client := NewHeimdallClient(server.URL+"/", server.Client())
+	span, err := client.GetSpan(context.Background(), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if span.StartBlock != 6656 || span.EndBlock != 13055 || len(span.SelectedProducers) != 2 {
+		t.Fatal("unexpected span:", span)
+	}
+	v := span.producer(common.HexToAddress("0x0375b2fc7140977c9c76d45421564e354ed42277"))
+	if v == nil || v.VotingPower != 2500 {
+		t.Fatal("unexpected producer:", v)
+	}
+	if _, err := client.GetSpan(context.Background(), 3); err == nil {
+		t.Fatal("expect error for missing span")
+	}
+}
@@ -0,0 +1,125 @@
+package bor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"sort"
+	"strings"
+	"toprelayer/errs"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/wonderivan/logger"
+)
+
+// Validator is a block producer of a span.
+type Validator struct {
+	Address     common.Address `json:"signer"`
+	VotingPower int64          `json:"power"`
+}
+
+// Span is a range of blocks sealed by a fixed producer set, it is committed
+// on Heimdall.
+type Span struct {
+	ID                uint64      `json:"span_id"`
+	StartBlock        uint64      `json:"start_block"`
+	EndBlock          uint64      `json:"end_block"`
+	SelectedProducers []Validator `json:"selected_producers"`
+	ChainId           string      `json:"bor_chain_id"`
+}
+
+// SpanSource fetches committed spans.
+type SpanSource interface {
+	GetSpan(ctx context.Context, id uint64) (*Span, error)
+}
+
+func (s *Span) contains(number uint64) bool {
+	return s.StartBlock <= number && number <= s.EndBlock
+}
+
+// producer returns the producer with address, or nil if it is not in the span.
+func (s *Span) producer(address common.Address) *Validator {
+	for i := range s.SelectedProducers {
+		if s.SelectedProducers[i].Address == address {
+			return &s.SelectedProducers[i]
+		}
+	}
+	return nil
+}
+
+// producerBytes encodes the producers the way they are put in the extra-data
+// of sprint end headers, in address order.
+func (s *Span) producerBytes() []byte {
+	producers := make([]Validator, len(s.SelectedProducers))
+	copy(producers, s.SelectedProducers)
+	sort.Slice(producers, func(i, j int) bool {
+		return bytes.Compare(producers[i].Address[:], producers[j].Address[:]) < 0
+	})
+	var out []byte
+	for _, v := range producers {
+		out = append(out, v.headerBytes()...)
+	}
+	return out
+}
+
+// headerBytes encodes the address and 20 bytes big endian voting power.
+func (v *Validator) headerBytes() []byte {
+	out := make([]byte, validatorHeaderBytesLength)
+	copy(out, v.Address[:])
+	power := common.BigToHash(big.NewInt(v.VotingPower))
+	copy(out[common.AddressLength:], power[common.HashLength-validatorPowerLength:])
+	return out
+}
+
+// HeimdallClient gets spans from the rest api of a Heimdall node.
+type HeimdallClient struct {
+	url    string
+	client *http.Client
+}
+
+func NewHeimdallClient(url string, client *http.Client) *HeimdallClient {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &HeimdallClient{url: strings.TrimSuffix(url, "/"), client: client}
+}
+
+func (c *HeimdallClient) GetSpan(ctx context.Context, id uint64) (*Span, error) {
+	url := fmt.Sprintf("%v/bor/span/%v", c.url, id)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		logger.Error("http NewRequest error:", err)
+		return nil, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		logger.Error("http Get error:", err)
+		return nil, errs.Classify(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		logger.Error("outil.ReadAll error:", err)
+		return nil, errs.Classify(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		logger.Error("http Get %v status %v", url, resp.Status)
+		return nil, errs.FromHttpStatus(resp.StatusCode, fmt.Errorf("http status %v: %s", resp.Status, body))
+	}
+	var res struct {
+		Result *Span `json:"result"`
+	}
+	if err := json.Unmarshal(body, &res); err != nil {
+		logger.Error("span Unmarshal error:", err)
+		return nil, err
+	}
+	if res.Result == nil || res.Result.ID != id {
+		return nil, fmt.Errorf("span %v not found", id)
+	}
+	return res.Result, nil
+}
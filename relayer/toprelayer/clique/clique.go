@@ -0,0 +1,515 @@
+package clique
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"toprelayer/relayer/toprelayer/headerbatch"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/consensus/clique"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/wonderivan/logger"
+)
+
+const (
+	checkpointInterval = 1024  // Number of blocks after which to save the vote snapshot to the database
+	resumeCheckpoints  = 4     // Number of stored checkpoints tried on resume, older ones replay more than an init
+	inmemorySnapshots  = 128   // Number of recent vote snapshots to keep in memory
+	inmemorySignatures = 4096  // Number of recent block signatures to keep in memory
+	DefaultEpoch       = 30000 // Default number of blocks after which to checkpoint and reset the pending votes
+)
+
+// Clique proof-of-authority protocol constants.
+var (
+	extraVanity = 32                     // Fixed number of extra-data prefix bytes reserved for signer vanity
+	extraSeal   = crypto.SignatureLength // Fixed number of extra-data suffix bytes reserved for signer seal
+
+	nonceAuthVote = hexutil.MustDecode("0xffffffffffffffff") // Magic nonce number to vote on adding a new signer
+	nonceDropVote = hexutil.MustDecode("0x0000000000000000") // Magic nonce number to vote on removing a signer.
+
+	uncleHash = types.CalcUncleHash(nil) // Always Keccak256(RLP([])) as uncles are meaningless outside of PoW.
+
+	diffInTurn = big.NewInt(2) // Block difficulty for in-turn signatures
+	diffNoTurn = big.NewInt(1) // Block difficulty for out-of-turn signatures
+)
+
+// Various error messages to mark blocks invalid.
+var (
+	// errUnknownBlock is returned when the list of signers is requested for a block
+	// that is not part of the local blockchain.
+	errUnknownBlock = errors.New("unknown block")
+
+	// errInvalidCheckpointBeneficiary is returned if a checkpoint/epoch transition
+	// block has a beneficiary set to non-zeroes.
+	errInvalidCheckpointBeneficiary = errors.New("beneficiary in checkpoint block non-zero")
+
+	// errInvalidVote is returned if a nonce value is something else that the two
+	// allowed constants of 0x00..0 or 0xff..f.
+	errInvalidVote = errors.New("vote nonce not 0x00..0 or 0xff..f")
+
+	// errInvalidCheckpointVote is returned if a checkpoint/epoch transition block
+	// has a vote nonce set to non-zeroes.
+	errInvalidCheckpointVote = errors.New("vote nonce in checkpoint block non-zero")
+
+	// errMissingVanity is returned if a block's extra-data section is shorter than
+	// 32 bytes, which is required to store the signer vanity.
+	errMissingVanity = errors.New("extra-data 32 byte vanity prefix missing")
+
+	// errMissingSignature is returned if a block's extra-data section doesn't seem
+	// to contain a 65 byte secp256k1 signature.
+	errMissingSignature = errors.New("extra-data 65 byte signature suffix missing")
+
+	// errExtraSigners is returned if non-checkpoint block contain signer data in
+	// their extra-data fields.
+	errExtraSigners = errors.New("non-checkpoint block contains extra signer list")
+
+	// errInvalidCheckpointSigners is returned if a checkpoint block contains an
+	// invalid list of signers (i.e. non divisible by 20 bytes).
+	errInvalidCheckpointSigners = errors.New("invalid signer list on checkpoint block")
+
+	// errMismatchingCheckpointSigners is returned if a checkpoint block contains a
+	// list of signers different than the one the local node calculated.
+	errMismatchingCheckpointSigners = errors.New("mismatching signer list on checkpoint block")
+
+	// errInvalidMixDigest is returned if a block's mix digest is non-zero.
+	errInvalidMixDigest = errors.New("non-zero mix digest")
+
+	// errInvalidUncleHash is returned if a block contains an non-empty uncle list.
+	errInvalidUncleHash = errors.New("non empty uncle hash")
+
+	// errInvalidDifficulty is returned if the difficulty of a block neither 1 or 2.
+	errInvalidDifficulty = errors.New("invalid difficulty")
+
+	// errWrongDifficulty is returned if the difficulty of a block doesn't match the
+	// turn of the signer.
+	errWrongDifficulty = errors.New("wrong difficulty")
+
+	// errInvalidTimestamp is returned if the timestamp of a block is lower than
+	// the previous block's timestamp.
+	errInvalidTimestamp = errors.New("invalid timestamp")
+
+	// errInvalidVotingChain is returned if an authorization list is attempted to
+	// be modified via out-of-range or non-contiguous headers.
+	errInvalidVotingChain = errors.New("invalid voting chain")
+
+	// errUnauthorizedSigner is returned if a header is signed by a non-authorized entity.
+	errUnauthorizedSigner = errors.New("unauthorized signer")
+
+	// errRecentlySigned is returned if a header is signed by an authorized entity
+	// that already signed a header recently, thus is temporarily not allowed to.
+	errRecentlySigned = errors.New("recently signed")
+)
+
+// Clique is the proof-of-authority consensus engine of geth networks, signers
+// are added and removed by votes which are reset at every epoch.
+type Clique struct {
+	epoch      uint64         // Number of blocks after which to reset the pending votes
+	db         ethdb.Database // Database to store and retrieve snapshot checkpoints
+	recents    *lru.ARCCache  // Snapshots for recent block to speed up reorgs
+	signatures *lru.ARCCache  // Signatures of recent blocks to speed up mining
+
+	fetcher *headerbatch.Fetcher
+}
+
+// New creates a Clique engine, epoch is taken from the chain genesis and zero
+// means DefaultEpoch. Snapshot checkpoints are kept in memory if db is nil.
+func New(fetcher *headerbatch.Fetcher, db ethdb.Database, epoch uint64) *Clique {
+	if db == nil {
+		db = rawdb.NewMemoryDatabase()
+	}
+	if epoch == 0 {
+		epoch = DefaultEpoch
+	}
+	// Allocate the snapshot caches and create the engine
+	recents, _ := lru.NewARC(inmemorySnapshots)
+	signatures, _ := lru.NewARC(inmemorySignatures)
+
+	return &Clique{
+		epoch:      epoch,
+		db:         db,
+		recents:    recents,
+		signatures: signatures,
+		fetcher:    fetcher,
+	}
+}
+
+// checkpointSigners returns the signers in the extra-data of an epoch header.
+func checkpointSigners(header *types.Header) []common.Address {
+	signers := make([]common.Address, (len(header.Extra)-extraVanity-extraSeal)/common.AddressLength)
+	for i := 0; i < len(signers); i++ {
+		copy(signers[i][:], header.Extra[extraVanity+i*common.AddressLength:])
+	}
+	return signers
+}
+
+// Init replays the votes of the current epoch up to height.
+func (c *Clique) Init(height uint64) error {
+	baseHeight := height / c.epoch * c.epoch
+
+	if c.resume(height) {
+		return nil
+	}
+
+	logger.Info("initing clique snapshot from %v to %v", baseHeight, height)
+	// init baseheight
+	{
+		header, err := c.fetcher.HeaderByNumber(context.Background(), baseHeight)
+		if err != nil {
+			logger.Error(err)
+			return err
+		}
+		snap := newSnapshot(c.signatures, baseHeight, header.Hash(), checkpointSigners(header))
+		c.recents.Add(snap.Hash, snap)
+	}
+
+	for i := baseHeight + 1; i <= height; {
+		headers, err := c.fetcher.HeadersByNumber(context.Background(), i, height)
+		if err != nil {
+			logger.Error(err)
+			return err
+		}
+		for _, header := range headers {
+			err = c.Apply(header)
+			if err != nil {
+				logger.Error(err)
+				return err
+			}
+		}
+		i += uint64(len(headers))
+	}
+	return nil
+}
+
+// resume rebuilds the snapshot of height from the latest checkpoint stored
+// on disk, falling back to older ones when it is missing or was left on an
+// abandoned branch. It returns false if there is none.
+func (c *Clique) resume(height uint64) bool {
+	number := height / checkpointInterval * checkpointInterval
+	for i := 0; i < resumeCheckpoints && number > 0; i, number = i+1, number-checkpointInterval {
+		checkpoint, err := c.fetcher.HeaderByNumber(context.Background(), number)
+		if err != nil {
+			logger.Error(err)
+			return false
+		}
+		snap, err := loadSnapshot(c.signatures, c.db, checkpoint.Hash())
+		if err != nil {
+			logger.Debug("no clique checkpoint %v: %v", number, err)
+			continue
+		}
+		c.recents.Add(snap.Hash, snap)
+		header, err := c.fetcher.HeaderByNumber(context.Background(), height)
+		if err != nil {
+			logger.Error(err)
+			return false
+		}
+		if _, err = c.GetLastSnap(height, header.Hash()); err != nil {
+			logger.Error(err)
+			return false
+		}
+		logger.Info("resumed clique snapshot from checkpoint %v to %v", number, height)
+		return true
+	}
+	return false
+}
+
+// Close closes the snapshot database.
+func (c *Clique) Close() error {
+	return c.db.Close()
+}
+
+func (c *Clique) GetLastSnap(number uint64, hash common.Hash) (*Snapshot, error) {
+	var (
+		headers []*types.Header
+		pending []*types.Header // headers fetched by number, next one last
+		snap    *Snapshot
+	)
+	for snap == nil {
+		// If an in-memory snapshot was found, use that
+		if s, ok := c.recents.Get(hash); ok {
+			snap = s.(*Snapshot)
+			break
+		}
+		// If an on-disk checkpoint snapshot can be found, use that
+		if number%checkpointInterval == 0 {
+			if s, err := loadSnapshot(c.signatures, c.db, hash); err == nil {
+				logger.Debug("Loaded snapshot from disk", number, hash)
+				snap = s
+				break
+			}
+		}
+		// Epoch headers carry the full signer list, votes start over
+		if number%c.epoch == 0 {
+			checkpoint, err := c.fetcher.HeaderByNumber(context.Background(), number)
+			if err != nil {
+				logger.Error(err)
+				return nil, err
+			}
+			if checkpoint == nil {
+				logger.Error(err)
+				return nil, fmt.Errorf("header is nil")
+			}
+			snap = newSnapshot(c.signatures, number, checkpoint.Hash(), checkpointSigners(checkpoint))
+			if err := snap.store(c.db); err != nil {
+				logger.Error("store snapshot error:", err)
+				return nil, err
+			}
+			break
+		}
+		// the first header is usually the only one missing, fetch further
+		// ones in batches by number
+		if len(pending) == 0 && len(headers) > 0 {
+			lo := uint64(0)
+			if number >= c.fetcher.BatchSize() {
+				lo = number - c.fetcher.BatchSize() + 1
+			}
+			var err error
+			pending, err = c.fetcher.HeadersByNumber(context.Background(), lo, number)
+			if err != nil {
+				logger.Error(err)
+				return nil, fmt.Errorf("HeadersByNumber error")
+			}
+		}
+		var h *types.Header
+		if len(pending) > 0 {
+			h, pending = pending[len(pending)-1], pending[:len(pending)-1]
+		}
+		// not on the canonical chain any more, follow the hash
+		if h == nil || h.Number.Uint64() != number || h.Hash() != hash {
+			var err error
+			h, err = c.fetcher.HeaderByHash(context.Background(), hash)
+			if err != nil {
+				logger.Error(err)
+				return nil, fmt.Errorf("HeaderByHash error")
+			}
+			pending = nil
+		}
+		headers = append(headers, h)
+		number, hash = number-1, h.ParentHash
+	}
+	// Previous snapshot found, apply any pending headers on top of it
+	for i := 0; i < len(headers)/2; i++ {
+		headers[i], headers[len(headers)-1-i] = headers[len(headers)-1-i], headers[i]
+	}
+	snap, err := snap.apply(headers, c.epoch)
+	if err != nil {
+		return nil, err
+	}
+	c.recents.Add(snap.Hash, snap)
+	logger.Debug(snap)
+	// If we've generated a new checkpoint snapshot, save to disk
+	if snap.Number%checkpointInterval == 0 && len(headers) > 0 {
+		if err = snap.store(c.db); err != nil {
+			logger.Error("store snapshot error:", err)
+			return nil, err
+		}
+	}
+	return snap, err
+}
+
+// VerifyHeader checks that header is well formed and sealed by a signer
+// allowed to seal on top of parent, parent is fetched if nil.
+func (c *Clique) VerifyHeader(header, parent *types.Header) error {
+	if header.Number == nil || header.Number.Sign() == 0 {
+		return errUnknownBlock
+	}
+	number := header.Number.Uint64()
+	checkpoint := number%c.epoch == 0
+	if checkpoint && header.Coinbase != (common.Address{}) {
+		return errInvalidCheckpointBeneficiary
+	}
+	if !bytes.Equal(header.Nonce[:], nonceAuthVote) && !bytes.Equal(header.Nonce[:], nonceDropVote) {
+		return errInvalidVote
+	}
+	if checkpoint && !bytes.Equal(header.Nonce[:], nonceDropVote) {
+		return errInvalidCheckpointVote
+	}
+	if err := c.verifyExtra(header); err != nil {
+		return err
+	}
+	if header.MixDigest != (common.Hash{}) {
+		return errInvalidMixDigest
+	}
+	if header.UncleHash != uncleHash {
+		return errInvalidUncleHash
+	}
+	if header.Difficulty == nil || (header.Difficulty.Cmp(diffInTurn) != 0 && header.Difficulty.Cmp(diffNoTurn) != 0) {
+		return errInvalidDifficulty
+	}
+	if parent == nil {
+		var err error
+		parent, err = c.fetcher.HeaderByHash(context.Background(), header.ParentHash)
+		if err != nil {
+			logger.Error(err)
+			return err
+		}
+	}
+	if parent.Number.Uint64() != number-1 || parent.Hash() != header.ParentHash {
+		return consensus.ErrUnknownAncestor
+	}
+	// the block period is a genesis setting, only the order is checked
+	if header.Time < parent.Time {
+		return errInvalidTimestamp
+	}
+	snap, err := c.GetLastSnap(number-1, header.ParentHash)
+	if err != nil {
+		logger.Error(err)
+		return err
+	}
+	if checkpoint {
+		signers := snap.signers()
+		extraSigners := checkpointSigners(header)
+		if len(signers) != len(extraSigners) {
+			return errMismatchingCheckpointSigners
+		}
+		for i, signer := range signers {
+			if signer != extraSigners[i] {
+				return errMismatchingCheckpointSigners
+			}
+		}
+	}
+	return c.verifySeal(snap, header)
+}
+
+// verifySeal checks the signer of header against the signers and recents of
+// the parent snapshot, and the difficulty against its turn.
+func (c *Clique) verifySeal(snap *Snapshot, header *types.Header) error {
+	number := header.Number.Uint64()
+	signer, err := ecrecover(header, c.signatures)
+	if err != nil {
+		return err
+	}
+	if _, ok := snap.Signers[signer]; !ok {
+		return errUnauthorizedSigner
+	}
+	for seen, recent := range snap.Recents {
+		if recent == signer {
+			// Signer is among recents, only fail if the current block doesn't shift it out
+			if limit := uint64(len(snap.Signers)/2 + 1); number < limit || seen > number-limit {
+				return errRecentlySigned
+			}
+		}
+	}
+	inturn := snap.inturn(number, signer)
+	if inturn && header.Difficulty.Cmp(diffInTurn) != 0 {
+		return errWrongDifficulty
+	}
+	if !inturn && header.Difficulty.Cmp(diffNoTurn) != 0 {
+		return errWrongDifficulty
+	}
+	return nil
+}
+
+// verifyExtra checks that only epoch headers carry a list of signers.
+func (c *Clique) verifyExtra(header *types.Header) error {
+	if len(header.Extra) < extraVanity {
+		return errMissingVanity
+	}
+	if len(header.Extra) < extraVanity+extraSeal {
+		return errMissingSignature
+	}
+	signersBytes := len(header.Extra) - extraVanity - extraSeal
+	if header.Number.Uint64()%c.epoch == 0 {
+		if signersBytes == 0 || signersBytes%common.AddressLength != 0 {
+			return errInvalidCheckpointSigners
+		}
+	} else if signersBytes != 0 {
+		return errExtraSigners
+	}
+	return nil
+}
+
+func (c *Clique) GetLastSnapBytes(header *types.Header) ([]byte, error) {
+	snap, err := c.GetLastSnap(header.Number.Uint64()-1, header.ParentHash)
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+	bytes, err := encodeSnapshot(header, snap)
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+	return bytes, nil
+}
+
+// Apply moves the snapshot of the parent of header past header, checkpoints
+// are stored on disk.
+func (c *Clique) Apply(header *types.Header) error {
+	snap, err := c.GetLastSnap(header.Number.Uint64()-1, header.ParentHash)
+	if err != nil {
+		return err
+	}
+	snap, err = snap.apply([]*types.Header{header}, c.epoch)
+	if err != nil {
+		return err
+	}
+	c.recents.Add(snap.Hash, snap)
+	if snap.Number%checkpointInterval == 0 {
+		return snap.store(c.db)
+	}
+	return nil
+}
+
+// Rollback drops the snapshots above number, they were built on a branch
+// abandoned by a reorg.
+func (c *Clique) Rollback(number uint64) {
+	dropped := 0
+	for _, key := range c.recents.Keys() {
+		s, ok := c.recents.Peek(key)
+		if !ok {
+			continue
+		}
+		snap := s.(*Snapshot)
+		if snap.Number <= number {
+			continue
+		}
+		c.recents.Remove(key)
+		if snap.Number%checkpointInterval == 0 {
+			if err := snap.delete(c.db); err != nil {
+				logger.Error("delete snapshot error:", err)
+			}
+		}
+		dropped++
+	}
+	logger.Info("clique rollback to %v, dropped %v snapshots", number, dropped)
+}
+
+// ecrecover extracts the Ethereum account address from a signed header.
+func ecrecover(header *types.Header, sigcache *lru.ARCCache) (common.Address, error) {
+	// If the signature's already cached, return that
+	hash := header.Hash()
+	if address, known := sigcache.Get(hash); known {
+		return address.(common.Address), nil
+	}
+	// Retrieve the signature from the header extra-data
+	if len(header.Extra) < extraSeal {
+		return common.Address{}, errMissingSignature
+	}
+	signature := header.Extra[len(header.Extra)-extraSeal:]
+
+	// Recover the public key and the Ethereum address
+	pubkey, err := crypto.Ecrecover(SealHash(header).Bytes(), signature)
+	if err != nil {
+		return common.Address{}, err
+	}
+	var signer common.Address
+	copy(signer[:], crypto.Keccak256(pubkey[1:])[12:])
+
+	sigcache.Add(hash, signer)
+	return signer, nil
+}
+
+// SealHash returns the hash of a block prior to it being sealed, the base fee
+// is sealed once London is active.
+func SealHash(header *types.Header) common.Hash {
+	return clique.SealHash(header)
+}
@@ -0,0 +1,296 @@
+package clique
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"math/big"
+	"testing"
+	"toprelayer/relayer/toprelayer/headerbatch"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/rpc"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+const testEpoch = 30
+
+type vote struct {
+	address   common.Address
+	authorize bool
+}
+
+// fakeChain serves signed clique headers over json-rpc. Each header is sealed
+// by the in-turn signer if it may sign, otherwise by the first signer allowed.
+type fakeChain struct {
+	keys    map[common.Address]*ecdsa.PrivateKey
+	headers []*types.Header
+}
+
+func newKeys(t *testing.T, n int) (map[common.Address]*ecdsa.PrivateKey, []common.Address) {
+	keys := make(map[common.Address]*ecdsa.PrivateKey)
+	var addrs []common.Address
+	for i := 0; i < n; i++ {
+		key, err := crypto.GenerateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr := crypto.PubkeyToAddress(key.PublicKey)
+		keys[addr] = key
+		addrs = append(addrs, addr)
+	}
+	return keys, addrs
+}
+
+func newFakeChain(t *testing.T, keys map[common.Address]*ecdsa.PrivateKey, signers []common.Address, length uint64, votes map[uint64]vote) *fakeChain {
+	c := &fakeChain{keys: keys}
+	sigcache, _ := lru.NewARC(inmemorySignatures)
+	snap := newSnapshot(sigcache, 0, common.Hash{}, signers)
+	genesis := &types.Header{Number: common.Big0, Difficulty: common.Big1, Extra: checkpointExtra(snap.signers())}
+	snap.Hash = genesis.Hash()
+	c.headers = append(c.headers, genesis)
+	for i := uint64(1); i <= length; i++ {
+		header := &types.Header{
+			ParentHash: c.headers[i-1].Hash(),
+			UncleHash:  uncleHash,
+			Number:     new(big.Int).SetUint64(i),
+			GasLimit:   30000000,
+			Time:       i * 5,
+			Extra:      make([]byte, extraVanity+extraSeal),
+		}
+		if i%testEpoch == 0 {
+			header.Extra = checkpointExtra(snap.signers())
+		} else if v, ok := votes[i]; ok {
+			header.Coinbase = v.address
+			if v.authorize {
+				copy(header.Nonce[:], nonceAuthVote)
+			}
+		}
+		signer := nextSigner(snap, i)
+		header.Difficulty = diffNoTurn
+		if snap.inturn(i, signer) {
+			header.Difficulty = diffInTurn
+		}
+		seal(t, header, keys[signer])
+		next, err := snap.apply([]*types.Header{header}, testEpoch)
+		if err != nil {
+			t.Fatal(i, err)
+		}
+		snap = next
+		c.headers = append(c.headers, header)
+	}
+	return c
+}
+
+func nextSigner(snap *Snapshot, number uint64) common.Address {
+	signers := snap.signers()
+	recent := func(signer common.Address) bool {
+		for seen, r := range snap.Recents {
+			if r == signer && number < seen+uint64(len(signers)/2+1) {
+				return true
+			}
+		}
+		return false
+	}
+	if inturn := signers[number%uint64(len(signers))]; !recent(inturn) {
+		return inturn
+	}
+	for _, signer := range signers {
+		if !recent(signer) {
+			return signer
+		}
+	}
+	panic("no signer allowed")
+}
+
+func checkpointExtra(signers []common.Address) []byte {
+	extra := make([]byte, extraVanity)
+	for _, signer := range signers {
+		extra = append(extra, signer.Bytes()...)
+	}
+	return append(extra, make([]byte, extraSeal)...)
+}
+
+func seal(t *testing.T, header *types.Header, key *ecdsa.PrivateKey) {
+	sig, err := crypto.Sign(SealHash(header).Bytes(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(header.Extra[len(header.Extra)-extraSeal:], sig)
+}
+
+func (c *fakeChain) GetBlockByNumber(number rpc.BlockNumber, full bool) (*types.Header, error) {
+	if number < 0 || int(number) >= len(c.headers) {
+		return nil, nil
+	}
+	return c.headers[number], nil
+}
+
+func (c *fakeChain) GetBlockByHash(hash common.Hash, full bool) (*types.Header, error) {
+	for _, h := range c.headers {
+		if h.Hash() == hash {
+			return h, nil
+		}
+	}
+	return nil, nil
+}
+
+func (c *fakeChain) fetcher(t *testing.T) *headerbatch.Fetcher {
+	server := rpc.NewServer()
+	if err := server.RegisterName("eth", c); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Stop)
+	return headerbatch.New(rpc.DialInProc(server), 8, -1)
+}
+
+func TestVotes(t *testing.T) {
+	keys, addrs := newKeys(t, 4)
+	signers, added := addrs[:3], addrs[3]
+	votes := map[uint64]vote{
+		1:  {added, true},
+		2:  {added, true},
+		25: {signers[0], false},
+	}
+	chain := newFakeChain(t, keys, signers, 2*testEpoch+5, votes)
+
+	c := New(chain.fetcher(t), nil, testEpoch)
+	if err := c.Init(20); err != nil {
+		t.Fatal(err)
+	}
+	snap, err := c.GetLastSnap(20, chain.headers[20].Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := snap.Signers[added]; !ok || len(snap.Signers) != 4 {
+		t.Fatal("expect voted signer authorized:", snap.signers())
+	}
+	snap, err = c.GetLastSnap(29, chain.headers[29].Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tally := snap.Tally[signers[0]]; tally.Votes != 1 || tally.Authorize || len(snap.Votes) != 1 {
+		t.Fatal("expect pending drop vote:", tally, len(snap.Votes))
+	}
+	// votes are reset at the epoch
+	snap, err = c.GetLastSnap(testEpoch, chain.headers[testEpoch].Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snap.Tally) != 0 || len(snap.Votes) != 0 || len(snap.Signers) != 4 {
+		t.Fatal("expect votes reset at epoch")
+	}
+	for i := 1; i < len(chain.headers); i++ {
+		if err := c.VerifyHeader(chain.headers[i], chain.headers[i-1]); err != nil {
+			t.Fatal(i, err)
+		}
+	}
+}
+
+func TestVerifyHeader(t *testing.T) {
+	keys, addrs := newKeys(t, 4)
+	chain := newFakeChain(t, keys, addrs[:3], testEpoch+5, nil)
+	c := New(chain.fetcher(t), nil, testEpoch)
+	if err := c.VerifyHeader(chain.headers[testEpoch], nil); err != nil {
+		t.Fatal(err)
+	}
+	signerOf := func(n uint64) *ecdsa.PrivateKey {
+		signer, err := ecrecover(chain.headers[n], c.signatures)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return keys[signer]
+	}
+	tests := []struct {
+		name   string
+		number uint64
+		modify func(h *types.Header) *ecdsa.PrivateKey
+		want   error
+	}{
+		{"unauthorized", 20, func(h *types.Header) *ecdsa.PrivateKey {
+			return keys[addrs[3]]
+		}, errUnauthorizedSigner},
+		{"recent signer", 20, func(h *types.Header) *ecdsa.PrivateKey {
+			h.Difficulty = diffNoTurn
+			return signerOf(19)
+		}, errRecentlySigned},
+		{"wrong difficulty", 20, func(h *types.Header) *ecdsa.PrivateKey {
+			if h.Difficulty.Cmp(diffInTurn) == 0 {
+				h.Difficulty = diffNoTurn
+			} else {
+				h.Difficulty = diffInTurn
+			}
+			return signerOf(20)
+		}, errWrongDifficulty},
+		{"invalid vote", 20, func(h *types.Header) *ecdsa.PrivateKey {
+			h.Nonce = types.EncodeNonce(1)
+			return signerOf(20)
+		}, errInvalidVote},
+		{"extra signers", 20, func(h *types.Header) *ecdsa.PrivateKey {
+			h.Extra = checkpointExtra(addrs[:3])
+			return signerOf(20)
+		}, errExtraSigners},
+		{"checkpoint beneficiary", testEpoch, func(h *types.Header) *ecdsa.PrivateKey {
+			h.Coinbase = addrs[3]
+			return signerOf(testEpoch)
+		}, errInvalidCheckpointBeneficiary},
+		{"checkpoint vote", testEpoch, func(h *types.Header) *ecdsa.PrivateKey {
+			copy(h.Nonce[:], nonceAuthVote)
+			return signerOf(testEpoch)
+		}, errInvalidCheckpointVote},
+		{"checkpoint signers", testEpoch, func(h *types.Header) *ecdsa.PrivateKey {
+			h.Extra = checkpointExtra(addrs)
+			return signerOf(testEpoch)
+		}, errMismatchingCheckpointSigners},
+		{"timestamp", 20, func(h *types.Header) *ecdsa.PrivateKey {
+			h.Time = chain.headers[19].Time - 1
+			return signerOf(20)
+		}, errInvalidTimestamp},
+		{"unknown parent", 20, func(h *types.Header) *ecdsa.PrivateKey {
+			h.ParentHash = chain.headers[18].Hash()
+			return signerOf(20)
+		}, consensus.ErrUnknownAncestor},
+	}
+	for _, test := range tests {
+		header := types.CopyHeader(chain.headers[test.number])
+		seal(t, header, test.modify(header))
+		if err := c.VerifyHeader(header, chain.headers[test.number-1]); !errors.Is(err, test.want) {
+			t.Errorf("%v: expect %v, got %v", test.name, test.want, err)
+		}
+	}
+}
+
+func TestEncodeSnapshot(t *testing.T) {
+	keys, addrs := newKeys(t, 4)
+	chain := newFakeChain(t, keys, addrs[:3], 10, map[uint64]vote{5: {addrs[3], true}})
+	c := New(chain.fetcher(t), nil, testEpoch)
+	header := chain.headers[8]
+	out, err := c.GetLastSnapBytes(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := c.GetLastSnapBytes(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != string(again) {
+		t.Fatal("snapshot encoding not deterministic")
+	}
+	var snap SnapshotOut
+	if err := rlp.DecodeBytes(out, &snap); err != nil {
+		t.Fatal(err)
+	}
+	if snap.SignersNum != 3 || snap.RecentsNum != 2 || snap.VotesNum != 1 || snap.TallyNum != 1 {
+		t.Fatal("unexpected snapshot:", snap.SignersNum, snap.RecentsNum, snap.VotesNum, snap.TallyNum)
+	}
+	if len(snap.Votes) != 4 || common.BytesToAddress(snap.Votes[2]) != addrs[3] || snap.Votes[3][0] != 1 {
+		t.Fatal("unexpected vote encoding")
+	}
+	var decoded types.Header
+	if err := rlp.DecodeBytes(snap.Header, &decoded); err != nil || decoded.Hash() != header.Hash() {
+		t.Fatal("unexpected header encoding")
+	}
+}
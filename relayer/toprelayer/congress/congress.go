@@ -5,13 +5,14 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"math/big"
-	"time"
+	"toprelayer/relayer/toprelayer/headerbatch"
+	"toprelayer/relayer/toprelayer/posa"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
-	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/rlp"
 	lru "github.com/hashicorp/golang-lru"
 	"github.com/wonderivan/logger"
@@ -20,6 +21,7 @@ import (
 
 const (
 	checkpointInterval = 1024 // Number of blocks after which to save the vote snapshot to the database
+	resumeCheckpoints  = 4    // Number of stored checkpoints tried on resume, older ones replay more than an init
 	inmemorySnapshots  = 128  // Number of recent vote snapshots to keep in memory
 	inmemorySignatures = 4096 // Number of recent block signatures to keep in memory
 	maxValidators      = 21   // Max validators allowed to seal.
@@ -38,42 +40,52 @@ var (
 // codebase, inherently breaking if the engine is swapped out. Please put common
 // error types into the consensus package.
 var (
-	// errMissingSignature is returned if a block's extra-data section doesn't seem
-	// to contain a 65 byte secp256k1 signature.
-	errMissingSignature = errors.New("extra-data 65 byte signature suffix missing")
-
 	// errInvalidVotingChain is returned if an authorization list is attempted to
 	// be modified via out-of-range or non-contiguous headers.
 	errInvalidVotingChain = errors.New("invalid voting chain")
-
-	// errUnauthorizedValidator is returned if a header is signed by a non-authorized entity.
-	errUnauthorizedValidator = errors.New("unauthorized validator")
-
-	// errRecentlySigned is returned if a header is signed by an authorized entity
-	// that already signed a header recently, thus is temporarily not allowed to.
-	errRecentlySigned = errors.New("recently signed")
 )
 
-// TODO: add db
 type Congress struct {
-	recents    *lru.ARCCache // Snapshots for recent block to speed up reorgs
-	signatures *lru.ARCCache // Signatures of recent blocks to speed up mining
+	db         ethdb.Database // Database to store and retrieve snapshot checkpoints
+	recents    *lru.ARCCache  // Snapshots for recent block to speed up reorgs
+	signatures *lru.ARCCache  // Signatures of recent blocks to speed up mining
 
-	client *ethclient.Client
+	fetcher  *headerbatch.Fetcher
+	verifier *posa.Verifier
 }
 
 // New creates a Congress proof-of-stake-authority consensus engine with the initial
-// validators set to the ones provided by the user.
-func New(client *ethclient.Client) *Congress {
+// validators set to the ones provided by the user. Snapshot checkpoints are
+// kept in memory if db is nil.
+func New(fetcher *headerbatch.Fetcher, db ethdb.Database) *Congress {
+	if db == nil {
+		db = rawdb.NewMemoryDatabase()
+	}
 	// Allocate the snapshot caches and create the engine
 	recents, _ := lru.NewARC(inmemorySnapshots)
 	signatures, _ := lru.NewARC(inmemorySignatures)
 
-	return &Congress{
+	c := &Congress{
+		db:         db,
 		recents:    recents,
 		signatures: signatures,
-		client:     client,
+		fetcher:    fetcher,
 	}
+	c.verifier = &posa.Verifier{
+		Fetcher:     fetcher,
+		VerifyExtra: verifyExtra,
+		Ecrecover: func(header *types.Header) (common.Address, error) {
+			return ecrecover(header, c.signatures)
+		},
+		Snapshot: func(number uint64, hash common.Hash) (posa.Snapshot, error) {
+			snap, err := c.GetLastSnap(number, hash)
+			if err != nil {
+				return nil, err
+			}
+			return snap, nil
+		},
+	}
+	return c
 }
 
 func (c *Congress) Init(height uint64) error {
@@ -88,10 +100,14 @@ func (c *Congress) Init(height uint64) error {
 		}
 	}
 
+	if c.resume(height) {
+		return nil
+	}
+
 	logger.Info("initing congress snapshot from %v to %v", baseHeight, height)
 	// init baseheight
 	{
-		header, err := c.client.HeaderByNumber(context.Background(), big.NewInt(0).SetUint64(baseHeight))
+		header, err := c.fetcher.HeaderByNumber(context.Background(), baseHeight)
 		if err != nil {
 			logger.Error(err)
 			return err
@@ -106,30 +122,65 @@ func (c *Congress) Init(height uint64) error {
 		c.recents.Add(snap.Hash, snap)
 	}
 
-	for i := baseHeight + 1; i <= height; i++ {
-		header, err := c.client.HeaderByNumber(context.Background(), big.NewInt(0).SetUint64(i))
+	for i := baseHeight + 1; i <= height; {
+		headers, err := c.fetcher.HeadersByNumber(context.Background(), i, height)
 		if err != nil {
 			logger.Error(err)
 			return err
 		}
-		snap, err := c.GetLastSnap(header.Number.Uint64()-1, header.ParentHash)
+		for _, header := range headers {
+			err = c.Apply(header)
+			if err != nil {
+				logger.Error(err)
+				return err
+			}
+		}
+		i += uint64(len(headers))
+	}
+	return nil
+}
+
+// resume rebuilds the snapshot of height from the latest checkpoint stored
+// on disk, falling back to older ones when it is missing or was left on an
+// abandoned branch. It returns false if there is none.
+func (c *Congress) resume(height uint64) bool {
+	number := height / checkpointInterval * checkpointInterval
+	for i := 0; i < resumeCheckpoints && number > 0; i, number = i+1, number-checkpointInterval {
+		checkpoint, err := c.fetcher.HeaderByNumber(context.Background(), number)
 		if err != nil {
 			logger.Error(err)
-			return err
+			return false
+		}
+		snap, err := loadSnapshot(c.signatures, c.db, checkpoint.Hash())
+		if err != nil {
+			logger.Debug("no congress checkpoint %v: %v", number, err)
+			continue
 		}
-		err = c.Apply(snap, header)
+		c.recents.Add(snap.Hash, snap)
+		header, err := c.fetcher.HeaderByNumber(context.Background(), height)
 		if err != nil {
 			logger.Error(err)
-			return err
+			return false
+		}
+		if _, err = c.GetLastSnap(height, header.Hash()); err != nil {
+			logger.Error(err)
+			return false
 		}
-		time.Sleep(time.Millisecond * 100)
+		logger.Info("resumed congress snapshot from checkpoint %v to %v", number, height)
+		return true
 	}
-	return nil
+	return false
+}
+
+// Close closes the snapshot database.
+func (c *Congress) Close() error {
+	return c.db.Close()
 }
 
 func (c *Congress) GetLastSnap(number uint64, hash common.Hash) (*Snapshot, error) {
 	var (
 		headers []*types.Header
+		pending []*types.Header // headers fetched by number, next one last
 		snap    *Snapshot
 	)
 	for snap == nil {
@@ -138,11 +189,16 @@ func (c *Congress) GetLastSnap(number uint64, hash common.Hash) (*Snapshot, erro
 			snap = s.(*Snapshot)
 			break
 		}
-		// TODO: db
+		// If an on-disk checkpoint snapshot can be found, use that
 		if number%checkpointInterval == 0 {
+			if s, err := loadSnapshot(c.signatures, c.db, hash); err == nil {
+				logger.Debug("Loaded snapshot from disk", number, hash)
+				snap = s
+				break
+			}
 		}
 		if number == 0 || (number%Epoch == 0 && len(headers) >= int(maxValidators)) {
-			checkpoint, err := c.client.HeaderByNumber(context.Background(), big.NewInt(0).SetUint64(number))
+			checkpoint, err := c.fetcher.HeaderByNumber(context.Background(), number)
 			if err != nil {
 				logger.Error(err)
 				return nil, err
@@ -157,13 +213,39 @@ func (c *Congress) GetLastSnap(number uint64, hash common.Hash) (*Snapshot, erro
 				copy(validators[i][:], checkpoint.Extra[extraVanity+i*common.AddressLength:])
 			}
 			snap = newSnapshot(c.signatures, number, hash, validators)
-			// TODO:db
+			if err := snap.store(c.db); err != nil {
+				logger.Error("store snapshot error:", err)
+				return nil, err
+			}
 			break
 		}
-		h, err := c.client.HeaderByHash(context.Background(), hash)
-		if err != nil {
-			logger.Error(err)
-			return nil, fmt.Errorf("HeaderByHash error")
+		// the first header is usually the only one missing, fetch further
+		// ones in batches by number
+		if len(pending) == 0 && len(headers) > 0 {
+			lo := uint64(0)
+			if number >= c.fetcher.BatchSize() {
+				lo = number - c.fetcher.BatchSize() + 1
+			}
+			var err error
+			pending, err = c.fetcher.HeadersByNumber(context.Background(), lo, number)
+			if err != nil {
+				logger.Error(err)
+				return nil, fmt.Errorf("HeadersByNumber error")
+			}
+		}
+		var h *types.Header
+		if len(pending) > 0 {
+			h, pending = pending[len(pending)-1], pending[:len(pending)-1]
+		}
+		// not on the canonical chain any more, follow the hash
+		if h == nil || h.Number.Uint64() != number || h.Hash() != hash {
+			var err error
+			h, err = c.fetcher.HeaderByHash(context.Background(), hash)
+			if err != nil {
+				logger.Error(err)
+				return nil, fmt.Errorf("HeaderByHash error")
+			}
+			pending = nil
 		}
 		headers = append(headers, h)
 		number, hash = number-1, h.ParentHash
@@ -178,10 +260,41 @@ func (c *Congress) GetLastSnap(number uint64, hash common.Hash) (*Snapshot, erro
 	}
 	c.recents.Add(snap.Hash, snap)
 	logger.Debug(snap)
-	// TODO:db
+	// If we've generated a new checkpoint snapshot, save to disk
+	if snap.Number%checkpointInterval == 0 && len(headers) > 0 {
+		if err = snap.store(c.db); err != nil {
+			logger.Error("store snapshot error:", err)
+			return nil, err
+		}
+	}
 	return snap, err
 }
 
+// VerifyHeader checks that header is well formed and sealed by a validator
+// allowed to seal on top of parent, parent is fetched if nil.
+func (c *Congress) VerifyHeader(header, parent *types.Header) error {
+	return c.verifier.VerifyHeader(header, parent)
+}
+
+// verifyExtra checks that only epoch headers carry a list of validators.
+func verifyExtra(header *types.Header) error {
+	if len(header.Extra) < extraVanity {
+		return posa.ErrMissingVanity
+	}
+	if len(header.Extra) < extraVanity+extraSeal {
+		return posa.ErrMissingSignature
+	}
+	validatorsBytes := len(header.Extra) - extraVanity - extraSeal
+	if header.Number.Uint64()%Epoch == 0 {
+		if validatorsBytes == 0 || validatorsBytes%common.AddressLength != 0 {
+			return posa.ErrInvalidCheckpointValidators
+		}
+	} else if validatorsBytes != 0 {
+		return posa.ErrExtraValidators
+	}
+	return nil
+}
+
 func (c *Congress) GetLastSnapBytes(header *types.Header) ([]byte, error) {
 	snap, err := c.GetLastSnap(header.Number.Uint64()-1, header.ParentHash)
 	if err != nil {
@@ -196,17 +309,48 @@ func (c *Congress) GetLastSnapBytes(header *types.Header) ([]byte, error) {
 	return bytes, nil
 }
 
-func (c *Congress) Apply(snap *Snapshot, header *types.Header) error {
-	var headers []*types.Header
-	headers = append(headers, header)
-	snap, err := snap.apply(headers)
+// Apply moves the snapshot of the parent of header past header, checkpoints
+// are stored on disk.
+func (c *Congress) Apply(header *types.Header) error {
+	snap, err := c.GetLastSnap(header.Number.Uint64()-1, header.ParentHash)
+	if err != nil {
+		return err
+	}
+	snap, err = snap.apply([]*types.Header{header})
 	if err != nil {
 		return err
 	}
 	c.recents.Add(snap.Hash, snap)
+	if snap.Number%checkpointInterval == 0 {
+		return snap.store(c.db)
+	}
 	return nil
 }
 
+// Rollback drops the snapshots above number, they were built on a branch
+// abandoned by a reorg.
+func (c *Congress) Rollback(number uint64) {
+	dropped := 0
+	for _, key := range c.recents.Keys() {
+		s, ok := c.recents.Peek(key)
+		if !ok {
+			continue
+		}
+		snap := s.(*Snapshot)
+		if snap.Number <= number {
+			continue
+		}
+		c.recents.Remove(key)
+		if snap.Number%checkpointInterval == 0 {
+			if err := snap.delete(c.db); err != nil {
+				logger.Error("delete snapshot error:", err)
+			}
+		}
+		dropped++
+	}
+	logger.Info("congress rollback to %v, dropped %v snapshots", number, dropped)
+}
+
 // ecrecover extracts the Ethereum account address from a signed header.
 func ecrecover(header *types.Header, sigcache *lru.ARCCache) (common.Address, error) {
 	// If the signature's already cached, return that
@@ -216,7 +360,7 @@ func ecrecover(header *types.Header, sigcache *lru.ARCCache) (common.Address, er
 	}
 	// Retrieve the signature from the header extra-data
 	if len(header.Extra) < extraSeal {
-		return common.Address{}, errMissingSignature
+		return common.Address{}, posa.ErrMissingSignature
 	}
 	signature := header.Extra[len(header.Extra)-extraSeal:]
 
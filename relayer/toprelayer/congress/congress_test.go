@@ -1,12 +1,25 @@
 package congress
 
 import (
+	"bytes"
 	"context"
+	"crypto/ecdsa"
+	"errors"
 	"math/big"
+	"reflect"
+	"sort"
+	"sync/atomic"
 	"testing"
+	"toprelayer/relayer/toprelayer/headerbatch"
+	"toprelayer/relayer/toprelayer/posa"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/wonderivan/logger"
 )
 
@@ -37,12 +50,13 @@ func TestCheckValidatorsNum(t *testing.T) {
 func TestInit(t *testing.T) {
 	var height uint64 = 17276012
 
-	ethsdk, err := ethclient.Dial(hecoUrl)
+	client, err := rpc.Dial(hecoUrl)
 	if err != nil {
 		t.Fatal(err)
 	}
+	ethsdk := ethclient.NewClient(client)
 
-	con := New(ethsdk)
+	con := New(headerbatch.New(client, 0, 0), nil)
 	err = con.Init(height)
 	if err != nil {
 		t.Fatal(err)
@@ -83,3 +97,282 @@ func TestEncodeSnapOut(t *testing.T) {
 	}
 	logger.Debug(common.Bytes2Hex(out))
 }
+
+// fakeChain serves signed congress headers over json-rpc, validators take
+// turns to seal in address order so every header is in-turn.
+type fakeChain struct {
+	keys    []*ecdsa.PrivateKey
+	headers []*types.Header
+	calls   int32
+}
+
+func newFakeChain(t *testing.T, validators int, length uint64) *fakeChain {
+	c := new(fakeChain)
+	var addrs []common.Address
+	for i := 0; i < validators; i++ {
+		key, err := crypto.GenerateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.keys = append(c.keys, key)
+		addrs = append(addrs, crypto.PubkeyToAddress(key.PublicKey))
+	}
+	sort.Sort(validatorsAscending(addrs))
+	sort.Slice(c.keys, func(i, j int) bool {
+		return bytes.Compare(crypto.PubkeyToAddress(c.keys[i].PublicKey).Bytes(), crypto.PubkeyToAddress(c.keys[j].PublicKey).Bytes()) < 0
+	})
+	parent := common.Hash{}
+	for i := uint64(0); i <= length; i++ {
+		extra := make([]byte, extraVanity)
+		if i%Epoch == 0 {
+			for _, addr := range addrs {
+				extra = append(extra, addr.Bytes()...)
+			}
+		}
+		extra = append(extra, make([]byte, extraSeal)...)
+		header := &types.Header{
+			ParentHash: parent,
+			UncleHash:  uncleHash,
+			Difficulty: big.NewInt(2),
+			Number:     new(big.Int).SetUint64(i),
+			GasLimit:   30000000,
+			Time:       i * 3,
+			Extra:      extra,
+		}
+		seal(t, header, c.keys[i%uint64(validators)])
+		c.headers = append(c.headers, header)
+		parent = header.Hash()
+	}
+	return c
+}
+
+func seal(t *testing.T, header *types.Header, key *ecdsa.PrivateKey) {
+	sig, err := crypto.Sign(SealHash(header).Bytes(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(header.Extra[len(header.Extra)-extraSeal:], sig)
+}
+
+func (c *fakeChain) GetBlockByNumber(number rpc.BlockNumber, full bool) (*types.Header, error) {
+	atomic.AddInt32(&c.calls, 1)
+	if number < 0 || int(number) >= len(c.headers) {
+		return nil, nil
+	}
+	return c.headers[number], nil
+}
+
+func (c *fakeChain) GetBlockByHash(hash common.Hash, full bool) (*types.Header, error) {
+	atomic.AddInt32(&c.calls, 1)
+	for _, h := range c.headers {
+		if h.Hash() == hash {
+			return h, nil
+		}
+	}
+	return nil, nil
+}
+
+func (c *fakeChain) fetcher(t *testing.T, size uint64) *headerbatch.Fetcher {
+	server := rpc.NewServer()
+	if err := server.RegisterName("eth", c); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Stop)
+	return headerbatch.New(rpc.DialInProc(server), size, -1)
+}
+
+func TestSnapshotCheckpointResume(t *testing.T) {
+	chain := newFakeChain(t, 3, checkpointInterval+6)
+	db := rawdb.NewMemoryDatabase()
+
+	con := New(chain.fetcher(t, 0), db)
+	checkpoint := chain.headers[checkpointInterval]
+	if _, err := con.GetLastSnap(checkpointInterval, checkpoint.Hash()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadSnapshot(con.signatures, db, checkpoint.Hash()); err != nil {
+		t.Fatal("checkpoint not stored:", err)
+	}
+
+	// a new engine on the same database resumes from the checkpoint instead
+	// of replaying from the epoch
+	resumed := New(chain.fetcher(t, 8), db)
+	atomic.StoreInt32(&chain.calls, 0)
+	height := uint64(checkpointInterval + 6)
+	if err := resumed.Init(height); err != nil {
+		t.Fatal(err)
+	}
+	if calls := atomic.LoadInt32(&chain.calls); calls > 16 {
+		t.Fatal("expect resume from checkpoint, calls:", calls)
+	}
+	head := chain.headers[height]
+	snap, err := resumed.GetLastSnap(height, head.Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := con.GetLastSnap(height, head.Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(snap.validators(), want.validators()) || !reflect.DeepEqual(snap.Recents, want.Recents) {
+		t.Fatal("resumed snapshot mismatch")
+	}
+}
+
+func TestSnapshotResumeOlderCheckpoint(t *testing.T) {
+	chain := newFakeChain(t, 3, 2*checkpointInterval+6)
+	db := rawdb.NewMemoryDatabase()
+
+	// only the first checkpoint is stored, as after a crash before the second
+	con := New(chain.fetcher(t, 0), db)
+	checkpoint := chain.headers[checkpointInterval]
+	snap, err := con.GetLastSnap(checkpointInterval, checkpoint.Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := snap.store(db); err != nil {
+		t.Fatal(err)
+	}
+	resumed := New(chain.fetcher(t, 64), db)
+	height := uint64(2*checkpointInterval + 6)
+	if !resumed.resume(height) {
+		t.Fatal("expect resume from the older checkpoint")
+	}
+	head := chain.headers[height]
+	got, err := resumed.GetLastSnap(height, head.Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := New(chain.fetcher(t, 0), nil).GetLastSnap(height, head.Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.validators(), want.validators()) || !reflect.DeepEqual(got.Recents, want.Recents) {
+		t.Fatal("resumed snapshot mismatch")
+	}
+}
+
+func TestInitBatchReplay(t *testing.T) {
+	chain := newFakeChain(t, 3, Epoch+30)
+	height := uint64(Epoch + 25)
+	con := New(chain.fetcher(t, 8), nil)
+	if err := con.Init(height); err != nil {
+		t.Fatal(err)
+	}
+	// the replayed snapshot is cached, no more headers are needed
+	atomic.StoreInt32(&chain.calls, 0)
+	snap, err := con.GetLastSnap(height, chain.headers[height].Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls := atomic.LoadInt32(&chain.calls); calls != 0 {
+		t.Fatal("expect cached snapshot, calls:", calls)
+	}
+	want, err := New(chain.fetcher(t, 0), nil).GetLastSnap(height, chain.headers[height].Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(snap.Recents, want.Recents) {
+		t.Fatal("replayed snapshot mismatch")
+	}
+}
+
+func TestSnapshotNoCheckpoint(t *testing.T) {
+	chain := newFakeChain(t, 3, Epoch+3)
+	con := New(chain.fetcher(t, 0), nil)
+	if con.resume(Epoch + 3) {
+		t.Fatal("expect no checkpoint below first interval")
+	}
+	snap, err := con.GetLastSnap(Epoch+3, chain.headers[Epoch+3].Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap.Number != Epoch+3 || len(snap.Validators) != 3 {
+		t.Fatal("unexpected snapshot:", snap.Number, len(snap.Validators))
+	}
+}
+
+func TestVerifyHeader(t *testing.T) {
+	chain := newFakeChain(t, 3, 30)
+	con := New(chain.fetcher(t, 0), nil)
+	parent := chain.headers[19]
+	if err := con.VerifyHeader(chain.headers[20], nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := con.VerifyHeader(chain.headers[21], chain.headers[20]); err != nil {
+		t.Fatal(err)
+	}
+
+	stranger, _ := crypto.GenerateKey()
+	tests := []struct {
+		name   string
+		modify func(h *types.Header) *ecdsa.PrivateKey
+		want   error
+	}{
+		{"out of turn difficulty", func(h *types.Header) *ecdsa.PrivateKey {
+			h.Difficulty = big.NewInt(1)
+			return chain.keys[20%3]
+		}, posa.ErrWrongDifficulty},
+		{"bad difficulty", func(h *types.Header) *ecdsa.PrivateKey {
+			h.Difficulty = big.NewInt(5)
+			return chain.keys[20%3]
+		}, posa.ErrInvalidDifficulty},
+		{"recent signer", func(h *types.Header) *ecdsa.PrivateKey {
+			h.Difficulty = big.NewInt(1)
+			return chain.keys[19%3]
+		}, posa.ErrRecentlySigned},
+		{"unauthorized", func(h *types.Header) *ecdsa.PrivateKey {
+			return stranger
+		}, posa.ErrUnauthorizedValidator},
+		{"timestamp", func(h *types.Header) *ecdsa.PrivateKey {
+			h.Time = parent.Time
+			return chain.keys[20%3]
+		}, posa.ErrInvalidTimestamp},
+		{"extra validators", func(h *types.Header) *ecdsa.PrivateKey {
+			h.Extra = append(make([]byte, extraVanity+common.AddressLength), make([]byte, extraSeal)...)
+			return chain.keys[20%3]
+		}, posa.ErrExtraValidators},
+		{"unknown parent", func(h *types.Header) *ecdsa.PrivateKey {
+			h.ParentHash = chain.headers[18].Hash()
+			return chain.keys[20%3]
+		}, consensus.ErrUnknownAncestor},
+	}
+	for _, test := range tests {
+		header := types.CopyHeader(chain.headers[20])
+		seal(t, header, test.modify(header))
+		if err := con.VerifyHeader(header, parent); !errors.Is(err, test.want) {
+			t.Errorf("%v: expect %v, got %v", test.name, test.want, err)
+		}
+	}
+}
+
+func TestRollback(t *testing.T) {
+	chain := newFakeChain(t, 3, checkpointInterval+6)
+	db := rawdb.NewMemoryDatabase()
+	con := New(chain.fetcher(t, 0), db)
+	checkpoint := chain.headers[checkpointInterval]
+	if _, err := con.GetLastSnap(checkpointInterval, checkpoint.Hash()); err != nil {
+		t.Fatal(err)
+	}
+	head := chain.headers[checkpointInterval+6]
+	if _, err := con.GetLastSnap(checkpointInterval+6, head.Hash()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadSnapshot(con.signatures, db, checkpoint.Hash()); err != nil {
+		t.Fatal("checkpoint not stored:", err)
+	}
+
+	con.Rollback(checkpointInterval - 1)
+	if _, ok := con.recents.Get(head.Hash()); ok {
+		t.Fatal("expect abandoned snapshot dropped")
+	}
+	if _, err := loadSnapshot(con.signatures, db, checkpoint.Hash()); err == nil {
+		t.Fatal("expect abandoned checkpoint deleted")
+	}
+	for _, key := range con.recents.Keys() {
+		s, _ := con.recents.Peek(key)
+		if s.(*Snapshot).Number > checkpointInterval-1 {
+			t.Fatal("snapshot above ancestor kept:", s.(*Snapshot).Number)
+		}
+	}
+}
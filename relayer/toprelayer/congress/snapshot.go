@@ -21,6 +21,7 @@ import (
 	"encoding/binary"
 	"encoding/json"
 	"sort"
+	"toprelayer/relayer/toprelayer/posa"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
@@ -93,6 +94,11 @@ func (s *Snapshot) store(db ethdb.Database) error {
 	return db.Put(append([]byte("congress-"), s.Hash[:]...), blob)
 }
 
+// delete removes the snapshot from the database.
+func (s *Snapshot) delete(db ethdb.Database) error {
+	return db.Delete(append([]byte("congress-"), s.Hash[:]...))
+}
+
 // copy creates a deep copy of the snapshot, though not the individual votes.
 func (s *Snapshot) copy() *Snapshot {
 	cpy := &Snapshot{
@@ -144,11 +150,11 @@ func (s *Snapshot) apply(headers []*types.Header) (*Snapshot, error) {
 			return nil, err
 		}
 		if _, ok := snap.Validators[validator]; !ok {
-			return nil, errUnauthorizedValidator
+			return nil, posa.ErrUnauthorizedValidator
 		}
 		for _, recent := range snap.Recents {
 			if recent == validator {
-				return nil, errRecentlySigned
+				return nil, posa.ErrRecentlySigned
 			}
 		}
 		snap.Recents[number] = validator
@@ -185,6 +191,18 @@ func (s *Snapshot) apply(headers []*types.Header) (*Snapshot, error) {
 	return snap, nil
 }
 
+// Signers returns the validators and the recent signers.
+func (s *Snapshot) Signers() (map[common.Address]struct{}, map[uint64]common.Address) {
+	return s.Validators, s.Recents
+}
+
+// Inturn returns if a validator at a given block height is in-turn or not.
+func (s *Snapshot) Inturn(number uint64, validator common.Address) bool {
+	validators := s.validators()
+	offset := number % uint64(len(validators))
+	return validators[offset] == validator
+}
+
 // validators retrieves the list of authorized validators in ascending order.
 func (s *Snapshot) validators() []common.Address {
 	sigs := make([]common.Address, 0, len(s.Validators))
@@ -0,0 +1,62 @@
+package toprelayer
+
+import (
+	"context"
+
+	"toprelayer/config"
+	"toprelayer/relayer/toprelayer/ethash"
+	"toprelayer/relayer/toprelayer/ethashapp"
+	"toprelayer/relayer/toprelayer/ethashproof"
+
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// Etc2TopRelayer relays Ethereum Classic headers with the DAG Merkle proofs
+// of their etchash seals to TOP, the client contract comes from the ETC
+// config.
+type Etc2TopRelayer struct {
+	*HeaderRelayer
+}
+
+func NewEtc2TopRelayer() *Etc2TopRelayer {
+	return &Etc2TopRelayer{HeaderRelayer: NewHeaderRelayer(config.ETC_CHAIN)}
+}
+
+// powEngine submits each header with the DAG Merkle proofs of its seal, a
+// PoW chain keeps no snapshots.
+type powEngine struct {
+	prover *ethashapp.Prover
+}
+
+func newPowEngine(params *ethash.Params, store *ethashproof.Store) *powEngine {
+	return &powEngine{prover: ethashapp.NewProver(params, store)}
+}
+
+func (e *powEngine) Init(height uint64) error {
+	return e.prover.Check(height)
+}
+
+func (e *powEngine) GetLastSnapBytes(header *types.Header) ([]byte, error) {
+	out, err := e.prover.HeaderWithProofs(context.Background(), header.Number.Uint64(), header)
+	if err != nil {
+		return nil, err
+	}
+	return rlp.EncodeToBytes(out)
+}
+
+func (e *powEngine) VerifyHeader(header, parent *types.Header) error {
+	if parent != nil && (header.ParentHash != parent.Hash() || header.Number.Uint64() != parent.Number.Uint64()+1) {
+		return consensus.ErrUnknownAncestor
+	}
+	return e.prover.VerifySeal(header)
+}
+
+func (e *powEngine) Apply(header *types.Header) error { return nil }
+
+func (e *powEngine) Rollback(number uint64) {}
+
+func (e *powEngine) SetHead(height uint64) {
+	e.prover.SetHead(height)
+}
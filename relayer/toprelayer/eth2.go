@@ -12,9 +12,13 @@ import (
 	"time"
 	"toprelayer/config"
 	eth2bridge "toprelayer/contract/top/eth2client"
+	"toprelayer/errs"
+	"toprelayer/relayer/monitor"
 	"toprelayer/relayer/toprelayer/beaconrpc"
 	"toprelayer/relayer/toprelayer/ethashapp"
 	"toprelayer/relayer/toprelayer/ethtypes"
+	"toprelayer/relayer/txmanager"
+	"toprelayer/rpcdial"
 	"toprelayer/wallet"
 
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
@@ -27,6 +31,7 @@ import (
 	state_native "github.com/prysmaticlabs/prysm/v3/beacon-chain/state/state-native"
 	primitives "github.com/prysmaticlabs/prysm/v3/consensus-types/primitives"
 	"github.com/prysmaticlabs/prysm/v3/crypto/bls"
+	enginev1 "github.com/prysmaticlabs/prysm/v3/proto/engine/v1"
 	eth "github.com/prysmaticlabs/prysm/v3/proto/prysm/v1alpha1"
 	"github.com/wonderivan/logger"
 )
@@ -40,13 +45,38 @@ var (
 	eth2ClientSystemContract = common.HexToAddress("0xff00000000000000000000000000000000000009")
 )
 
+// eth2ClientCaller is the part of the eth2 client contract used to find the
+// last submitted block.
+type eth2ClientCaller interface {
+	IsKnownExecutionHeader(hash [32]byte) (bool, error)
+	FinalizedBeaconBlockSlot() (uint64, error)
+}
+
+// eth2ClientSubmitter sends the transactions of the eth2 client contract.
+type eth2ClientSubmitter interface {
+	submitEthHeader(headers []byte) error
+	submitLightClientUpdate(update []byte) error
+}
+
 type Eth2TopRelayerV2 struct {
 	wallet          *wallet.Wallet
 	ethrpcclient    *ethclient.Client
 	beaconrpcclient *beaconrpc.BeaconGrpcClient
 	transactor      *eth2bridge.Eth2ClientTransactor
 	callerSession   *eth2bridge.Eth2ClientCallerSession
+	topCaller       eth2ClientCaller
+	topSubmitter    eth2ClientSubmitter
 	lastSlot        uint64
+	events          *beaconrpc.EventSubscription
+	chainCfg        *config.Relayer
+	monitor         *monitor.Monitor
+	txm             *txmanager.Manager
+	// build execution headers from beacon payloads when no execution rpc is configured
+	payloadHeaders bool
+}
+
+func (relayer *Eth2TopRelayerV2) SetChainConfig(cfg *config.Relayer) {
+	relayer.chainCfg = cfg
 }
 
 func (relayer *Eth2TopRelayerV2) Init(cfg *config.Relayer, listenUrl []string, pass string) error {
@@ -57,22 +87,36 @@ func (relayer *Eth2TopRelayerV2) Init(cfg *config.Relayer, listenUrl []string, p
 	}
 	relayer.wallet = w
 
-	if len(listenUrl) != 3 {
+	// listenUrl: [execution rpc,] beacon grpc, beacon http
+	if len(listenUrl) != 2 && len(listenUrl) != 3 {
 		err := errors.New("listenUrl num error")
 		logger.Error("Eth2TopRelayerV2 listenUrl error:", err)
 		return err
 	}
-	relayer.ethrpcclient, err = ethclient.Dial(listenUrl[0])
-	if err != nil {
-		logger.Error("Eth2TopRelayerV2 ethclient.Dial error:", err)
-		return err
+	if len(listenUrl) == 3 {
+		relayer.ethrpcclient, err = rpcdial.DialEth(listenUrl[0])
+		if err != nil {
+			logger.Error("Eth2TopRelayerV2 ethclient.Dial error:", err)
+			return err
+		}
+		listenUrl = listenUrl[1:]
+	} else {
+		logger.Info("Eth2TopRelayerV2 no execution rpc, build headers from beacon payloads")
+		relayer.payloadHeaders = true
 	}
-	relayer.beaconrpcclient, err = beaconrpc.NewBeaconGrpcClient(listenUrl[1], listenUrl[2])
+	relayer.beaconrpcclient, err = beaconrpc.NewBeaconGrpcClient(listenUrl[0], listenUrl[1])
 	if err != nil {
 		logger.Error("Eth2TopRelayerV2 NewBeaconGrpcClient error:", err)
 		return err
 	}
-	topethlient, err := ethclient.Dial(cfg.Url[0])
+	if relayer.chainCfg != nil && relayer.chainCfg.CacheDir != "" {
+		err = relayer.beaconrpcclient.SetCacheDir(relayer.chainCfg.CacheDir, relayer.chainCfg.DiskQuota<<20)
+		if err != nil {
+			logger.Error("Eth2TopRelayerV2 SetCacheDir error:", err)
+			return err
+		}
+	}
+	topethlient, err := rpcdial.DialEth(cfg.Url[0])
 	if err != nil {
 		logger.Error("Eth2TopRelayerV2 new topethlient error:", err)
 		return err
@@ -96,11 +140,19 @@ func (relayer *Eth2TopRelayerV2) Init(cfg *config.Relayer, listenUrl []string, p
 		BlockNumber: nil,
 		Context:     context.Background(),
 	}
+	relayer.topCaller = relayer.callerSession
+	relayer.topSubmitter = relayer
 	relayer.lastSlot = 0
+	relayer.monitor, err = monitor.New("ETH2TOP", config.TOP_CHAIN, relayer.wallet.Address(), cfg.Url[0])
 	if err != nil {
 		logger.Error("Eth2TopRelayerV2 New monitor error", err)
 		return err
 	}
+	relayer.txm, err = txmanager.New("ETH2TOP", relayer.wallet, cfg, relayer.monitor.AddTx)
+	if err != nil {
+		logger.Error("Eth2TopRelayerV2 New txmanager error", err)
+		return err
+	}
 	return nil
 }
 
@@ -111,7 +163,7 @@ func (relayer *Eth2TopRelayerV2) blockKnownOnTop(slot uint64) (bool, error) {
 		logger.Error("Eth2TopRelayerV2 GetBlockHashForSlot %v error %v", slot, err)
 		return false, err
 	}
-	return relayer.callerSession.IsKnownExecutionHeader(hash)
+	return relayer.topCaller.IsKnownExecutionHeader(hash)
 }
 
 func (relayer *Eth2TopRelayerV2) findLeftNonErrorSlot(leftSlot, rightSlot uint64) (uint64, bool) {
@@ -134,7 +186,7 @@ func (relayer *Eth2TopRelayerV2) linearSearchForward(slot, maxSlot uint64) (uint
 		}
 		known, err := relayer.blockKnownOnTop(slot + 1)
 		if err != nil {
-			if beaconrpc.IsErrorNoBlockForSlot(err) {
+			if shouldSkip(err) {
 				slot += 1
 				continue
 			} else {
@@ -161,7 +213,7 @@ func (relayer *Eth2TopRelayerV2) linearSearchBackward(startSlot, lastSlot uint64
 		}
 		known, err := relayer.blockKnownOnTop(slot)
 		if err != nil {
-			if beaconrpc.IsErrorNoBlockForSlot(err) {
+			if shouldSkip(err) {
 				slot -= 1
 				continue
 			} else {
@@ -186,7 +238,7 @@ func (relayer *Eth2TopRelayerV2) linerSlotSearch(slot, finalizedSlot, lastEthSlo
 	}
 	known, err := relayer.blockKnownOnTop(slot)
 	if err != nil {
-		if beaconrpc.IsErrorNoBlockForSlot(err) {
+		if shouldSkip(err) {
 			leftSlot, known := relayer.findLeftNonErrorSlot(slot+1, lastEthSlot+1)
 			if known {
 				return relayer.linearSearchForward(leftSlot, lastEthSlot)
@@ -212,11 +264,12 @@ func (relayer *Eth2TopRelayerV2) getMaxSlotForSubmission() (uint64, error) {
 }
 
 func (relayer *Eth2TopRelayerV2) getLastEth2SlotOnTop(lastEthSlot uint64) (uint64, error) {
-	finalizedSlot, err := relayer.callerSession.FinalizedBeaconBlockSlot()
+	finalizedSlot, err := relayer.topCaller.FinalizedBeaconBlockSlot()
 	if err != nil {
 		logger.Error("Eth2TopRelayerV2 FinalizedBeaconBlockSlot error", err)
 		return 0, nil
 	}
+	relayer.beaconrpcclient.MarkFinalized(finalizedSlot)
 	lastSubmittedSlot := relayer.lastSlot
 	slot := finalizedSlot
 	if lastSubmittedSlot > finalizedSlot {
@@ -227,7 +280,7 @@ func (relayer *Eth2TopRelayerV2) getLastEth2SlotOnTop(lastEthSlot uint64) (uint6
 }
 
 func (relayer *Eth2TopRelayerV2) getLastFinalizedSlotOnTop() (uint64, error) {
-	return relayer.callerSession.FinalizedBeaconBlockSlot()
+	return relayer.topCaller.FinalizedBeaconBlockSlot()
 }
 
 func (relayer *Eth2TopRelayerV2) getLastFinalizedSlotOnEth() (uint64, error) {
@@ -236,7 +289,7 @@ func (relayer *Eth2TopRelayerV2) getLastFinalizedSlotOnEth() (uint64, error) {
 
 func (relayer *Eth2TopRelayerV2) submitExecutionBlocks(headers []byte, curSlot uint64) error {
 	if len(headers) > 0 {
-		err := relayer.submitEthHeader(headers)
+		err := relayer.topSubmitter.submitEthHeader(headers)
 		if err != nil {
 			logger.Error("Eth2TopRelayerV2 submitHeaders failed:", err)
 			return err
@@ -271,7 +324,7 @@ func (relayer *Eth2TopRelayerV2) sendRegularLightClientUpdate(lastFinalizedTopSl
 		logger.Error("EncodeToBytes error:", err)
 		return err
 	}
-	return relayer.submitLightClientUpdate(bytes)
+	return relayer.topSubmitter.submitLightClientUpdate(bytes)
 }
 
 func (relayer *Eth2TopRelayerV2) sendLightClientUpdatesWithChecks(slot uint64) (bool, error) {
@@ -299,7 +352,7 @@ func (relayer *Eth2TopRelayerV2) sendLightClientUpdatesWithChecks(slot uint64) (
 }
 
 func (relayer *Eth2TopRelayerV2) txOption(packData []byte) (*bind.TransactOpts, error) {
-	nonce, err := relayer.wallet.NonceAt(context.Background(), relayer.wallet.Address(), nil)
+	nonce, err := relayer.txm.Nonce(context.Background())
 	if err != nil {
 		logger.Error("Eth2TopRelayerV2 GetNonce error:", err)
 		return nil, err
@@ -342,8 +395,10 @@ func (relayer *Eth2TopRelayerV2) submitEthHeader(headers []byte) error {
 	sigTx, err := relayer.transactor.SubmitExecutionHeader(ops, headers)
 	if err != nil {
 		logger.Error("Eth2TopRelayer sync error:", err)
-		return err
+		return errs.Classify(err)
 	}
+	relayer.txm.Track(sigTx)
+	relayer.monitor.AddTx(sigTx.Hash())
 	logger.Info("Eth2TopRelayer submitEthHeader tx info, account[%v] hash:%v,size:%v", relayer.wallet.Address(), sigTx.Hash(), len(headers))
 	return nil
 }
@@ -363,8 +418,10 @@ func (relayer *Eth2TopRelayerV2) submitLightClientUpdate(update []byte) error {
 	sigTx, err := relayer.transactor.SubmitBeaconChainLightClientUpdate(ops, update)
 	if err != nil {
 		logger.Error("Eth2TopRelayer SubmitBeaconChainLightClientUpdate error:", err)
-		return err
+		return errs.Classify(err)
 	}
+	relayer.txm.Track(sigTx)
+	relayer.monitor.AddTx(sigTx.Hash())
 	logger.Info("Eth2TopRelayer submitLightClientUpdate tx info, account[%v] hash:%v,size:%v", relayer.wallet.Address(), sigTx.Hash(), len(update))
 	return nil
 }
@@ -381,37 +438,51 @@ func (relayer *Eth2TopRelayerV2) signTransaction(addr common.Address, tx *types.
 	return nil, fmt.Errorf("Eth2TopRelayer address:%v not available", addr)
 }
 
+// Stop stops replacing the stuck transactions.
+func (relayer *Eth2TopRelayerV2) Stop() {
+	if relayer.txm != nil {
+		relayer.txm.Stop()
+	}
+}
+
 func (relayer *Eth2TopRelayerV2) StartRelayer(wg *sync.WaitGroup) error {
 	logger.Info("Start Eth2TopRelayerV2, subBatch: %v certaintyBlocks: %v", BATCH_NUM, CONFIRM_NUM)
 	defer wg.Done()
+	defer relayer.Stop()
 
-	done := make(chan struct{})
+	done := make(chan error)
 	defer close(done)
 
-	go func(done chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	relayer.events = relayer.beaconrpcclient.SubscribeEvents(ctx, []string{
+		beaconrpc.EVENT_HEAD,
+		beaconrpc.EVENT_FINALIZED_CHECKPOINT,
+		beaconrpc.EVENT_LIGHT_CLIENT_FINALITY_UPDATE,
+	})
+
+	go func(done chan error) {
 		timeoutDuration := time.Duration(FATALTIMEOUT) * time.Hour
 		timeout := time.NewTimer(timeoutDuration)
 		defer timeout.Stop()
 		logger.Debug("Eth2TopRelayerV2 set timeout: %v hours", FATALTIMEOUT)
 		var delay time.Duration = time.Duration(1)
-
-		prevPeriod := uint64(0)
-		curPeriod := uint64(0)
+		wakeable := false
 
 		for {
-			time.Sleep(time.Second * delay)
 			select {
 			case <-timeout.C:
-				done <- struct{}{}
+				done <- errFatalTimeout
 				return
 			default:
 				for {
-					time.Sleep(time.Second * delay)
+					relayer.waitForRound(delay, wakeable)
+					wakeable = false
 					// step1: eth slot
 					eth2Slot, err := relayer.getMaxSlotForSubmission()
 					if err != nil {
 						logger.Error(err)
-						delay = time.Duration(ERRDELAY)
+						delay = errDelay(err)
 						break
 					}
 					if eth2Slot == 0 {
@@ -424,7 +495,7 @@ func (relayer *Eth2TopRelayerV2) StartRelayer(wg *sync.WaitGroup) error {
 					topSlot, err := relayer.getLastEth2SlotOnTop(eth2Slot)
 					if err != nil {
 						logger.Error(err)
-						delay = time.Duration(ERRDELAY)
+						delay = errDelay(err)
 						break
 					}
 					if topSlot == 0 {
@@ -440,41 +511,34 @@ func (relayer *Eth2TopRelayerV2) StartRelayer(wg *sync.WaitGroup) error {
 					logger.Info("Eth2TopRelayerV2 check dest top slot:", topSlot)
 					// step3: submit headers
 					if topSlot < eth2Slot {
-						headers, curSlot, err := relayer.getExecutionBlocksBetween(topSlot+1, eth2Slot)
+						curSlot, pending, err := relayer.syncHeaders(topSlot, eth2Slot)
 						if err != nil {
-							logger.Error("Eth2TopRelayerV2 GetExecutionBlocksBetween failed:", err)
-							delay = time.Duration(ERRDELAY)
-							break
-						}
-						err = relayer.submitExecutionBlocks(headers, curSlot)
-						if err != nil {
-							logger.Error("Eth2TopRelayerV2 submitExecutionBlocks failed:", err)
-							delay = time.Duration(ERRDELAY)
-							break
-						}
-						if prevPeriod == 0 {
-							prevPeriod, err = relayer.getLastFinalizedSlotOnTop()
-							if err != nil {
-								logger.Error("Eth2TopRelayerV2 getLastFinalizedSlotOnTop error:", err)
+							if shouldHalt(err) {
+								logger.Error("Eth2TopRelayerV2 halt, need manual intervention")
+								done <- err
+								return
 							}
+							delay = errDelay(err)
+							break
 						}
-						curPeriod = beaconrpc.GetPeriodForSlot(curSlot)
-						logger.Info("Eth2TopRelayerV2 prev_period: %v, cur_period: %v", prevPeriod, curPeriod)
-						if curSlot+8 < eth2Slot {
+						if pending {
 							logger.Info("Eth2TopRelayerV2 headers update not finish, continue update headers next round")
 							delay = time.Duration(SUCCESSDELAY)
+							wakeable = true
 							break
-						} else {
-							topSlot = curSlot
 						}
+						topSlot = curSlot
 					}
 					logger.Info("Eth2TopRelayerV2 headers update finish, update light client update for a while")
-					time.Sleep(time.Second * time.Duration(SUCCESSDELAY))
-					ret, err := relayer.sendLightClientUpdatesWithChecks(topSlot)
-					if err != nil {
+					// let the headers land on TOP, a beacon event says nothing of them
+					relayer.waitForRound(time.Duration(SUCCESSDELAY), false)
+					if _, err := relayer.sendLightClientUpdatesWithChecks(topSlot); err != nil {
 						logger.Error("Eth2TopRelayerV2 sendLightClientUpdatesWithChecks error:", err)
-					} else if ret == true {
-						prevPeriod = curPeriod
+						if shouldHalt(err) {
+							logger.Error("Eth2TopRelayerV2 halt, need manual intervention")
+							done <- err
+							return
+						}
 					}
 
 					if set := timeout.Reset(timeoutDuration); !set {
@@ -482,18 +546,55 @@ func (relayer *Eth2TopRelayerV2) StartRelayer(wg *sync.WaitGroup) error {
 						delay = time.Duration(ERRDELAY)
 						break
 					}
-					logger.Info("Eth2TopRelayerV2 sync round finish")
+					stats := relayer.beaconrpcclient.CacheStats()
+					relayer.monitor.SetCacheStats(stats.BlockHits+stats.StateHits, stats.BlockMisses+stats.StateMisses, stats.DiskUsage)
+					logger.Info("Eth2TopRelayerV2 sync round finish, beacon cache: %+v", stats)
 					delay = time.Duration(SUCCESSDELAY)
+					wakeable = true
 				}
 			}
 		}
 	}(done)
 
-	<-done
-	logger.Error("Eth2TopRelayerV2 timeout")
+	err := <-done
+	logger.Error("Eth2TopRelayerV2 stopped:", errs.Classify(err))
 	return nil
 }
 
+// syncHeaders submits the execution headers of a batch of slots after
+// topSlot. It returns the last slot submitted and whether the headers up to
+// eth2Slot are left for the next round.
+func (relayer *Eth2TopRelayerV2) syncHeaders(topSlot, eth2Slot uint64) (uint64, bool, error) {
+	headers, curSlot, err := relayer.getExecutionBlocksBetween(topSlot+1, eth2Slot)
+	if err != nil {
+		logger.Error("Eth2TopRelayerV2 GetExecutionBlocksBetween failed:", err)
+		return 0, false, err
+	}
+	err = relayer.submitExecutionBlocks(headers, curSlot)
+	if err != nil {
+		logger.Error("Eth2TopRelayerV2 submitExecutionBlocks failed:", err)
+		return 0, false, err
+	}
+	logger.Info("Eth2TopRelayerV2 headers submitted to slot %v, period %v", curSlot, beaconrpc.GetPeriodForSlot(curSlot))
+	return curSlot, curSlot+8 < eth2Slot, nil
+}
+
+// waitForRound sleeps delay seconds. After a successful round a beacon event
+// ends the wait early, without a live event stream it keeps polling.
+func (relayer *Eth2TopRelayerV2) waitForRound(delay time.Duration, wakeable bool) {
+	if !wakeable || relayer.events == nil || !relayer.events.Connected() {
+		time.Sleep(time.Second * delay)
+		return
+	}
+	timer := time.NewTimer(time.Second * delay)
+	defer timer.Stop()
+	select {
+	case <-relayer.events.Wake():
+		logger.Debug("Eth2TopRelayerV2 woken by beacon event")
+	case <-timer.C:
+	}
+}
+
 func (relayer *Eth2TopRelayerV2) getExecutionBlocksBetween(start, end uint64) ([]byte, uint64, error) {
 	curSlot := start
 	headersCnt := 0
@@ -501,7 +602,7 @@ func (relayer *Eth2TopRelayerV2) getExecutionBlocksBetween(start, end uint64) ([
 	for (headersCnt < HEADER_BATCH_SIZE) && (curSlot <= end) {
 		header, err := relayer.getExecutionBlockBySlot(curSlot)
 		if err != nil {
-			if beaconrpc.IsErrorNoBlockForSlot(err) {
+			if shouldSkip(err) {
 				curSlot += 1
 				continue
 			}
@@ -527,18 +628,30 @@ func (relayer *Eth2TopRelayerV2) getExecutionBlocksBetween(start, end uint64) ([
 	return batchHeaders, curSlot, nil
 }
 
-func (relayer *Eth2TopRelayerV2) getExecutionBlockBySlot(slot uint64) (*types.Header, error) {
-	number, err := relayer.beaconrpcclient.GetBlockNumberForSlot(slot)
+func (relayer *Eth2TopRelayerV2) getExecutionBlockBySlot(slot uint64) (*ethtypes.ExecutionHeader, error) {
+	body, err := relayer.beaconrpcclient.GetBeaconBlockBodyForBlockId(strconv.FormatUint(slot, 10))
 	if err != nil {
-		logger.Error("Eth2TopRelayerV2 GetBlockNumberForSlot error", err)
+		logger.Error("Eth2TopRelayerV2 GetBeaconBlockBodyForBlockId error", err)
 		return nil, err
 	}
-	header, err := relayer.ethrpcclient.HeaderByNumber(context.Background(), big.NewInt(0).SetUint64(number))
+	return relayer.getExecutionHeader(body.GetExecutionPayload())
+}
+
+func (relayer *Eth2TopRelayerV2) getExecutionHeader(payload *enginev1.ExecutionPayload) (*ethtypes.ExecutionHeader, error) {
+	if relayer.payloadHeaders {
+		header, err := ethtypes.ExecutionHeaderFromPayload(payload)
+		if err != nil {
+			logger.Error("Eth2TopRelayerV2 ExecutionHeaderFromPayload error:", err)
+			return nil, err
+		}
+		return header, nil
+	}
+	header, err := relayer.ethrpcclient.HeaderByNumber(context.Background(), big.NewInt(0).SetUint64(payload.GetBlockNumber()))
 	if err != nil {
 		logger.Error("Eth2TopRelayerV2 HeaderByNumber error:", err)
 		return nil, err
 	}
-	return header, nil
+	return ethtypes.ExecutionHeaderFromHeader(header), nil
 }
 
 func (relayer *Eth2TopRelayerV2) isEnoughBlocksForLightClientUpdate(lastSubmittedSlot, lastFinalizedTopSlot, lastFinalizedEthSlot uint64) bool {
@@ -886,7 +999,7 @@ func (h *ExtendedBeaconBlockHeader) Encode() ([]byte, error) {
 }
 
 type InitInput struct {
-	FinalizedExecutionHeader *types.Header
+	FinalizedExecutionHeader *ethtypes.ExecutionHeader
 	FinalizedBeaconHeader    *ExtendedBeaconBlockHeader
 	CurrentSyncCommittee     *eth.SyncCommittee
 	NextSyncCommittee        *eth.SyncCommittee
@@ -978,11 +1091,9 @@ func (relayer *Eth2TopRelayerV2) GetInitData() ([]byte, error) {
 		logger.Error("GetBeaconBlockBodyForBlockId error:", err)
 		return nil, err
 	}
-	number := finalizeBody.GetExecutionPayload().BlockNumber
-
-	header, err := relayer.ethrpcclient.HeaderByNumber(context.Background(), big.NewInt(0).SetUint64(number))
+	header, err := relayer.getExecutionHeader(finalizeBody.GetExecutionPayload())
 	if err != nil {
-		logger.Error("HeaderByNumber error:", err)
+		logger.Error("getExecutionHeader error:", err)
 		return nil, err
 	}
 
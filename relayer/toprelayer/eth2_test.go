@@ -1,10 +1,15 @@
 package toprelayer
 
 import (
+	"bytes"
 	"math/big"
+	"strconv"
 	"testing"
 	"toprelayer/contract/top/eth2client"
+	"toprelayer/relayer/toprelayer/beaconrpc"
+	"toprelayer/relayer/toprelayer/beaconrpc/beacontest"
 	"toprelayer/relayer/toprelayer/ethashapp"
+	"toprelayer/relayer/toprelayer/ethtypes"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/rlp"
@@ -202,3 +207,290 @@ func TestPackHeaders(t *testing.T) {
 	}
 	t.Log(common.Bytes2Hex(pack))
 }
+
+type fakeEth2ClientCaller struct {
+	known     map[common.Hash]bool
+	finalized uint64
+}
+
+func (c *fakeEth2ClientCaller) IsKnownExecutionHeader(hash [32]byte) (bool, error) {
+	return c.known[hash], nil
+}
+
+func (c *fakeEth2ClientCaller) FinalizedBeaconBlockSlot() (uint64, error) {
+	return c.finalized, nil
+}
+
+// fakeEth2ClientSubmitter accepts every transaction at once, the submitted
+// headers become known and an update finalizes the finalized slot of node.
+type fakeEth2ClientSubmitter struct {
+	node    *beacontest.Node
+	caller  *fakeEth2ClientCaller
+	headers []common.Hash
+	updates [][]byte
+}
+
+func (s *fakeEth2ClientSubmitter) submitEthHeader(headers []byte) error {
+	stream := rlp.NewStream(bytes.NewReader(headers), 0)
+	for {
+		var out ethashapp.Output
+		if err := stream.Decode(&out); err != nil {
+			break
+		}
+		var header ethtypes.ExecutionHeader
+		if err := rlp.DecodeBytes([]byte(out.HeaderRLP), &header); err != nil {
+			return err
+		}
+		s.caller.known[header.Hash()] = true
+		s.headers = append(s.headers, header.Hash())
+	}
+	return nil
+}
+
+func (s *fakeEth2ClientSubmitter) submitLightClientUpdate(update []byte) error {
+	s.updates = append(s.updates, update)
+	s.caller.finalized = s.node.Finalized()
+	return nil
+}
+
+// newFakeEth2Relayer runs against a fake beacon node of 200 slots, with the
+// blocks of slots (finalized, submitted] known on TOP.
+func newFakeEth2Relayer(t *testing.T, finalized, submitted uint64, empty ...uint64) (*beacontest.Node, *Eth2TopRelayerV2) {
+	node, err := beacontest.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(node.Close)
+	node.Extend(200, empty...)
+
+	caller := &fakeEth2ClientCaller{known: make(map[common.Hash]bool), finalized: finalized}
+	for slot := finalized; slot <= submitted; slot++ {
+		if node.Block(slot) != nil {
+			caller.known[node.ExecutionHash(slot)] = true
+		}
+	}
+	relayer := &Eth2TopRelayerV2{
+		beaconrpcclient: beaconrpc.NewBeaconClient(node.Conn(), node.HttpURL(), node.HttpClient()),
+		topCaller:       caller,
+		payloadHeaders:  true,
+	}
+	return node, relayer
+}
+
+func TestLinerSlotSearch(t *testing.T) {
+	_, relayer := newFakeEth2Relayer(t, 32, 120, 50, 130)
+	cases := []struct {
+		name string
+		slot uint64
+	}{
+		{"finalized", 32},
+		{"known", 100},
+		{"unknown", 150},
+		{"empty known", 50},
+		{"empty unknown", 130},
+	}
+	for _, c := range cases {
+		slot, err := relayer.linerSlotSearch(c.slot, 32, 200)
+		if err != nil {
+			t.Fatal(c.name, err)
+		}
+		if slot != 120 {
+			t.Fatal(c.name, "got slot", slot)
+		}
+	}
+}
+
+func TestLinerSlotSearchLastSlotEmpty(t *testing.T) {
+	_, relayer := newFakeEth2Relayer(t, 32, 120, 121, 122)
+	slot, err := relayer.getLastEth2SlotOnTop(200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// empty slots after the last submitted block are skipped
+	if slot != 122 {
+		t.Fatal("got slot", slot)
+	}
+}
+
+func TestGetExecutionBlocksBetween(t *testing.T) {
+	node, relayer := newFakeEth2Relayer(t, 32, 32, 50)
+	headers, last, err := relayer.getExecutionBlocksBetween(33, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last != 60 {
+		t.Fatal("last slot", last)
+	}
+	stream := rlp.NewStream(bytes.NewReader(headers), 0)
+	slot := uint64(33)
+	for {
+		var out ethashapp.Output
+		if err := stream.Decode(&out); err != nil {
+			break
+		}
+		var header ethtypes.ExecutionHeader
+		if err := rlp.DecodeBytes([]byte(out.HeaderRLP), &header); err != nil {
+			t.Fatal(err)
+		}
+		if slot == 50 {
+			slot++
+		}
+		if header.Hash() != node.ExecutionHash(slot) {
+			t.Fatal("header hash mismatch at slot", slot)
+		}
+		slot++
+	}
+	if slot != 61 {
+		t.Fatal("headers end at slot", slot)
+	}
+
+	// a batch stops at HEADER_BATCH_SIZE headers, one more slot for the empty slot 50
+	_, last, err = relayer.getExecutionBlocksBetween(1, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last != HEADER_BATCH_SIZE+1 {
+		t.Fatal("last slot of full batch", last)
+	}
+}
+
+func TestSyncRound(t *testing.T) {
+	node, relayer := newFakeEth2Relayer(t, 32, 32)
+	submitter := &fakeEth2ClientSubmitter{node: node, caller: relayer.topCaller.(*fakeEth2ClientCaller)}
+	relayer.topSubmitter = submitter
+
+	// a round as run by StartRelayer, without the waits
+	round := func() (uint64, bool) {
+		eth2Slot, err := relayer.getMaxSlotForSubmission()
+		if err != nil {
+			t.Fatal(err)
+		}
+		topSlot, err := relayer.getLastEth2SlotOnTop(eth2Slot)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if topSlot >= eth2Slot {
+			return topSlot, false
+		}
+		slot, pending, err := relayer.syncHeaders(topSlot, eth2Slot)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return slot, pending
+	}
+
+	slot, pending := round()
+	if slot != 32+HEADER_BATCH_SIZE || !pending || len(submitter.headers) != HEADER_BATCH_SIZE {
+		t.Fatal("unexpected first round:", slot, pending, len(submitter.headers))
+	}
+	slot, pending = round()
+	if slot != node.Head() || pending || len(submitter.headers) != int(node.Head()-32) {
+		t.Fatal("unexpected second round:", slot, pending, len(submitter.headers))
+	}
+	for i, hash := range submitter.headers {
+		if hash != node.ExecutionHash(uint64(33+i)) {
+			t.Fatal("header mismatch at slot", 33+i)
+		}
+	}
+
+	// the headers are all on TOP, the finality update follows
+	sent, err := relayer.sendLightClientUpdatesWithChecks(slot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sent || len(submitter.updates) != 1 {
+		t.Fatal("expect a light client update")
+	}
+	update, err := relayer.beaconrpcclient.GetFinalizedLightClientUpdate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := update.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(submitter.updates[0], want) {
+		t.Fatal("light client update mismatch")
+	}
+
+	// nothing new until the beacon chain moves on
+	if slot, _ = round(); slot != node.Head() || len(submitter.headers) != int(node.Head()-32) {
+		t.Fatal("unexpected idle round:", slot, len(submitter.headers))
+	}
+	if sent, err = relayer.sendLightClientUpdatesWithChecks(slot); err != nil || sent {
+		t.Fatal("expect no light client update:", sent, err)
+	}
+	node.Extend(ONE_EPOCH_IN_SLOTS)
+	if slot, pending = round(); slot != node.Head() || pending {
+		t.Fatal("unexpected round after new slots:", slot, pending)
+	}
+}
+
+// fixtures rebuilt from the sepolia responses behind INIT_PARAM and
+// UPDATE_PARAM
+const (
+	RECORDED_FIXTURES        = "beaconrpc/testdata"
+	RECORDED_PAYLOAD_SLOT    = 1024000
+	RECORDED_UPDATE_PERIOD   = 126
+	RECORDED_FINALIZED_SLOT  = 1032192
+	RECORDED_FINALIZED_BLOCK = "d26a8a468987d1ea34406ba622a4ae44eb67922d4166784cc84496a8b04be874"
+)
+
+func newRecordedNode(t *testing.T) (*beacontest.Node, *beaconrpc.BeaconGrpcClient) {
+	node, err := beacontest.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(node.Close)
+	if err := node.LoadFixtures(RECORDED_FIXTURES); err != nil {
+		t.Fatal(err)
+	}
+	return node, beaconrpc.NewBeaconClient(node.Conn(), node.HttpURL(), node.HttpClient())
+}
+
+func TestRecordedExecutionPayload(t *testing.T) {
+	_, c := newRecordedNode(t)
+	body, err := c.GetBeaconBlockBodyForBlockId(strconv.FormatUint(RECORDED_PAYLOAD_SLOT, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, err := ethtypes.ExecutionHeaderFromPayload(body.GetExecutionPayload())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := rlp.EncodeToBytes(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the finalized execution header is the first item of INIT_PARAM
+	_, want, _, err := rlp.Split(common.Hex2Bytes(INIT_PARAM))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatal("execution header mismatch")
+	}
+}
+
+func TestRecordedLightClientUpdate(t *testing.T) {
+	node, c := newRecordedNode(t)
+	// the payload of the finalized block was not recorded, the node
+	// generates one and the expected update carries its hash
+	node.AddBlock(RECORDED_FINALIZED_SLOT)
+	want := bytes.Replace(common.Hex2Bytes(UPDATE_PARAM), common.Hex2Bytes(RECORDED_FINALIZED_BLOCK), node.ExecutionHash(RECORDED_FINALIZED_SLOT).Bytes(), 1)
+
+	for _, ssz := range []bool{false, true} {
+		node.ServeSSZ(ssz)
+		update, err := c.GetLightClientUpdate(RECORDED_UPDATE_PERIOD)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := update.Encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatal("light client update mismatch, ssz:", ssz)
+		}
+	}
+}
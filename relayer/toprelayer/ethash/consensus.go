@@ -532,7 +532,7 @@ func (ethash *Ethash) verifySeal(chain consensus.ChainReader, header *types.Head
 	if !fulldag {
 		cache := ethash.cache(number)
 
-		size := datasetSize(number)
+		size := ethash.params().Epoch(number).DatasetSize()
 		if ethash.config.PowMode == ModeTest {
 			size = 32 * 1024
 		}
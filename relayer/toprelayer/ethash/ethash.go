@@ -19,7 +19,6 @@ package ethash
 
 import (
 	"errors"
-	"fmt"
 	"math"
 	"math/big"
 	"math/rand"
@@ -50,7 +49,7 @@ var (
 	two256 = new(big.Int).Exp(big.NewInt(2), big.NewInt(256), big.NewInt(0))
 
 	// sharedEthash is a full instance that can be shared between multiple users.
-	sharedEthash = New(Config{"", 3, 0, "", 1, 0, ModeNormal}, nil, false)
+	sharedEthash = New(Config{"", 3, 0, "", 1, 0, ModeNormal, nil}, nil, false)
 
 	// algorithmRevision is the data structure version used for file naming.
 	algorithmRevision = 23
@@ -150,18 +149,18 @@ func memoryMapAndGenerate(path string, size uint64, generator func(buffer []uint
 // lru tracks caches or datasets by their last use time, keeping at most N of them.
 type lru struct {
 	what string
-	new  func(epoch uint64) interface{}
+	new  func(epoch Epoch) interface{}
 	mu   sync.Mutex
 	// Items are kept in a LRU cache, but there is a special case:
 	// We always keep an item for (highest seen epoch) + 1 as the 'future item'.
 	cache      *simplelru.LRU
-	future     uint64
+	future     Epoch
 	futureItem interface{}
 }
 
 // newlru create a new least-recently-used cache for either the verification caches
 // or the mining datasets.
-func newlru(what string, maxItems int, new func(epoch uint64) interface{}) *lru {
+func newlru(what string, maxItems int, new func(epoch Epoch) interface{}) *lru {
 	if maxItems <= 0 {
 		maxItems = 1
 	}
@@ -174,14 +173,14 @@ func newlru(what string, maxItems int, new func(epoch uint64) interface{}) *lru
 // get retrieves or creates an item for the given epoch. The first return value is always
 // non-nil. The second return value is non-nil if lru thinks that an item will be useful in
 // the near future.
-func (lru *lru) get(epoch uint64) (item, future interface{}) {
+func (lru *lru) get(epoch Epoch) (item, future interface{}) {
 	lru.mu.Lock()
 	defer lru.mu.Unlock()
 
 	// Get or create the item for the requested epoch.
 	item, ok := lru.cache.Get(epoch)
 	if !ok {
-		if lru.future > 0 && lru.future == epoch {
+		if lru.future.Number > 0 && lru.future == epoch {
 			item = lru.futureItem
 		} else {
 			log.Trace("Requiring new ethash "+lru.what, "epoch", epoch)
@@ -190,10 +189,11 @@ func (lru *lru) get(epoch uint64) (item, future interface{}) {
 		lru.cache.Add(epoch, item)
 	}
 	// Update the 'future item' if epoch is larger than previously seen.
-	if epoch < maxEpoch-1 && lru.future < epoch+1 {
-		log.Trace("Requiring new future ethash "+lru.what, "epoch", epoch+1)
-		future = lru.new(epoch + 1)
-		lru.future = epoch + 1
+	next := Epoch{Number: epoch.Number + 1, Length: epoch.Length}
+	if next.Number < maxEpoch && (lru.future.Length != next.Length || lru.future.Number < next.Number) {
+		log.Trace("Requiring new future ethash "+lru.what, "epoch", next.Number)
+		future = lru.new(next)
+		lru.future = next
 		lru.futureItem = future
 	}
 	return item, future
@@ -201,24 +201,26 @@ func (lru *lru) get(epoch uint64) (item, future interface{}) {
 
 // cache wraps an ethash cache with some metadata to allow easier concurrent use.
 type cache struct {
-	epoch uint64    // Epoch for which this cache is relevant
-	dump  *os.File  // File descriptor of the memory mapped cache
-	mmap  mmap.MMap // Memory map itself to unmap before releasing
-	cache []uint32  // The actual cache data content (may be memory mapped)
-	once  sync.Once // Ensures the cache is generated only once
+	epoch       uint64    // Epoch for which this cache is relevant
+	epochLength uint64    // Blocks per epoch
+	dump        *os.File  // File descriptor of the memory mapped cache
+	mmap        mmap.MMap // Memory map itself to unmap before releasing
+	cache       []uint32  // The actual cache data content (may be memory mapped)
+	once        sync.Once // Ensures the cache is generated only once
 }
 
 // newCache creates a new ethash verification cache and returns it as a plain Go
 // interface to be usable in an LRU cache.
-func newCache(epoch uint64) interface{} {
-	return &cache{epoch: epoch}
+func newCache(epoch Epoch) interface{} {
+	return &cache{epoch: epoch.Number, epochLength: epoch.Length}
 }
 
 // generate ensures that the cache content is generated before use.
 func (c *cache) generate(dir string, limit int, test bool) {
 	c.once.Do(func() {
-		size := cacheSize(c.epoch*epochLength + 1)
-		seed := seedHash(c.epoch*epochLength + 1)
+		epoch := Epoch{Number: c.epoch, Length: c.epochLength}
+		size := epoch.CacheSize()
+		seed := epoch.Seed()
 		if test {
 			size = 1024
 		}
@@ -229,11 +231,7 @@ func (c *cache) generate(dir string, limit int, test bool) {
 			return
 		}
 		// Disk storage is needed, this will get fancy
-		var endian string
-		if !isLittleEndian() {
-			endian = ".be"
-		}
-		path := filepath.Join(dir, fmt.Sprintf("cache-R%d-%x%s", algorithmRevision, seed[:8], endian))
+		path := filepath.Join(dir, epoch.fileName("cache"))
 		logger := log.New("epoch", c.epoch)
 
 		// We're about to mmap the file, ensure that the mapping is cleaned up when the
@@ -259,9 +257,8 @@ func (c *cache) generate(dir string, limit int, test bool) {
 		}
 		// Iterate over all previous instances and delete old ones
 		for ep := int(c.epoch) - limit; ep >= 0; ep-- {
-			seed := seedHash(uint64(ep)*epochLength + 1)
-			path := filepath.Join(dir, fmt.Sprintf("cache-R%d-%x%s", algorithmRevision, seed[:8], endian))
-			os.Remove(path)
+			old := Epoch{Number: uint64(ep), Length: c.epochLength}
+			os.Remove(filepath.Join(dir, old.fileName("cache")))
 		}
 	})
 }
@@ -277,18 +274,19 @@ func (c *cache) finalizer() {
 
 // dataset wraps an ethash dataset with some metadata to allow easier concurrent use.
 type dataset struct {
-	epoch   uint64    // Epoch for which this cache is relevant
-	dump    *os.File  // File descriptor of the memory mapped cache
-	mmap    mmap.MMap // Memory map itself to unmap before releasing
-	dataset []uint32  // The actual cache data content
-	once    sync.Once // Ensures the cache is generated only once
-	done    uint32    // Atomic flag to determine generation status
+	epoch       uint64    // Epoch for which this cache is relevant
+	epochLength uint64    // Blocks per epoch
+	dump        *os.File  // File descriptor of the memory mapped cache
+	mmap        mmap.MMap // Memory map itself to unmap before releasing
+	dataset     []uint32  // The actual cache data content
+	once        sync.Once // Ensures the cache is generated only once
+	done        uint32    // Atomic flag to determine generation status
 }
 
 // newDataset creates a new ethash mining dataset and returns it as a plain Go
 // interface to be usable in an LRU cache.
-func newDataset(epoch uint64) interface{} {
-	return &dataset{epoch: epoch}
+func newDataset(epoch Epoch) interface{} {
+	return &dataset{epoch: epoch.Number, epochLength: epoch.Length}
 }
 
 // generate ensures that the dataset content is generated before use.
@@ -297,9 +295,10 @@ func (d *dataset) generate(dir string, limit int, test bool) {
 		// Mark the dataset generated after we're done. This is needed for remote
 		defer atomic.StoreUint32(&d.done, 1)
 
-		csize := cacheSize(d.epoch*epochLength + 1)
-		dsize := datasetSize(d.epoch*epochLength + 1)
-		seed := seedHash(d.epoch*epochLength + 1)
+		epoch := Epoch{Number: d.epoch, Length: d.epochLength}
+		csize := epoch.CacheSize()
+		dsize := epoch.DatasetSize()
+		seed := epoch.Seed()
 		if test {
 			csize = 1024
 			dsize = 32 * 1024
@@ -315,11 +314,7 @@ func (d *dataset) generate(dir string, limit int, test bool) {
 			return
 		}
 		// Disk storage is needed, this will get fancy
-		var endian string
-		if !isLittleEndian() {
-			endian = ".be"
-		}
-		path := filepath.Join(dir, fmt.Sprintf("full-R%d-%x%s", algorithmRevision, seed[:8], endian))
+		path := epoch.PathToDAG(dir)
 		logger := log.New("epoch", d.epoch)
 
 		// We're about to mmap the file, ensure that the mapping is cleaned up when the
@@ -348,9 +343,8 @@ func (d *dataset) generate(dir string, limit int, test bool) {
 		}
 		// Iterate over all previous instances and delete old ones
 		for ep := int(d.epoch) - limit; ep >= 0; ep-- {
-			seed := seedHash(uint64(ep)*epochLength + 1)
-			path := filepath.Join(dir, fmt.Sprintf("full-R%d-%x%s", algorithmRevision, seed[:8], endian))
-			os.Remove(path)
+			old := Epoch{Number: uint64(ep), Length: d.epochLength}
+			os.Remove(old.PathToDAG(dir))
 		}
 	})
 }
@@ -373,13 +367,13 @@ func (d *dataset) finalizer() {
 
 // MakeCache generates a new ethash cache and optionally stores it to disk.
 func MakeCache(block uint64, dir string) {
-	c := cache{epoch: block / epochLength}
+	c := cache{epoch: block / epochLength, epochLength: epochLength}
 	c.generate(dir, math.MaxInt32, false)
 }
 
 // MakeDataset generates a new ethash dataset and optionally stores it to disk.
 func MakeDataset(block uint64, dir string) {
-	d := dataset{epoch: block / epochLength}
+	d := dataset{epoch: block / epochLength, epochLength: epochLength}
 	d.generate(dir, math.MaxInt32, false)
 }
 
@@ -403,6 +397,7 @@ type Config struct {
 	DatasetsInMem  int
 	DatasetsOnDisk int
 	PowMode        Mode
+	Params         *Params // DAG epoch schedule, nil for ethash
 }
 
 // sealTask wraps a seal block with relative result channel for remote sealer thread.
@@ -588,7 +583,7 @@ func (ethash *Ethash) Close() error {
 // by first checking against a list of in-memory caches, then against caches
 // stored on disk, and finally generating one if none can be found.
 func (ethash *Ethash) cache(block uint64) *cache {
-	epoch := block / epochLength
+	epoch := ethash.params().Epoch(block)
 	currentI, futureI := ethash.caches.get(epoch)
 	current := currentI.(*cache)
 
@@ -611,7 +606,7 @@ func (ethash *Ethash) cache(block uint64) *cache {
 // generates on a background thread.
 func (ethash *Ethash) dataset(block uint64, async bool) *dataset {
 	// Retrieve the requested ethash dataset
-	epoch := block / epochLength
+	epoch := ethash.params().Epoch(block)
 	currentI, futureI := ethash.datasets.get(epoch)
 	current := currentI.(*dataset)
 
@@ -637,6 +632,14 @@ func (ethash *Ethash) dataset(block uint64, async bool) *dataset {
 	return current
 }
 
+// params returns the DAG epoch schedule of the engine.
+func (ethash *Ethash) params() *Params {
+	if ethash.config.Params != nil {
+		return ethash.config.Params
+	}
+	return EthashParams
+}
+
 // Threads returns the number of mining threads currently enabled. This doesn't
 // necessarily mean that mining is running!
 func (ethash *Ethash) Threads() int {
@@ -2,20 +2,18 @@ package ethash
 
 import (
 	"encoding/binary"
-	"fmt"
 	"os"
 	"os/user"
 	"path/filepath"
 	"runtime"
 
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/consensus/ethash"
 	"github.com/ethereum/go-ethereum/crypto"
 	"golang.org/x/crypto/sha3"
 )
 
 var (
-	Instance   = New(Config{"", 3, 0, "", 1, 0, ModeNormal}, nil, false)
+	Instance   = New(Config{"", 3, 0, "", 1, 0, ModeNormal, nil}, nil, false)
 	DefaultDir = defaultDir()
 )
 
@@ -31,14 +29,14 @@ func defaultDir() string {
 }
 
 func DAGSize(blockNum uint64) uint64 {
-	return datasetSizes[blockNum/epochLength]
+	return EthashParams.Epoch(blockNum).DatasetSize()
 }
 
 func (ethash *Ethash) GetVerificationIndices(blockNumber uint64, hash common.Hash, nonce uint64) []uint32 {
 	// Recompute the digest and PoW value and verify against the header
 	cache := ethash.cache(blockNumber)
 
-	size := datasetSize(blockNumber)
+	size := ethash.params().Epoch(blockNumber).DatasetSize()
 	return hashimotoLightIndices(size, cache.cache, hash.Bytes(), nonce)
 }
 
@@ -109,10 +107,5 @@ func MakeDAG(block uint64, dir string) {
 }
 
 func PathToDAG(epoch uint64, dir string) string {
-	seed := ethash.SeedHash(epoch*epochLength + 1)
-	var endian string
-	if !isLittleEndian() {
-		endian = ".be"
-	}
-	return filepath.Join(dir, fmt.Sprintf("full-R%d-%x%s", 23, seed[:8], endian))
+	return Epoch{Number: epoch, Length: epochLength}.PathToDAG(dir)
 }
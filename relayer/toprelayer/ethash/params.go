@@ -0,0 +1,100 @@
+package ethash
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+)
+
+// ECIP1099Block is the Ethereum Classic block from which etchash epochs are
+// 60000 blocks long.
+const ECIP1099Block uint64 = 11700000
+
+var (
+	EthashParams  = &Params{Name: "ethash", EpochLength: epochLength}
+	EtchashParams = &Params{Name: "etchash", EpochLength: epochLength, ForkBlock: ECIP1099Block, ForkEpochLength: 2 * epochLength}
+)
+
+// Params describe the DAG epoch schedule of an ethash flavour. Cache and
+// dataset sizes follow the epoch number while the seed advances once per
+// 30000 blocks, so a longer epoch takes the seed of its first block.
+type Params struct {
+	Name        string
+	EpochLength uint64 // blocks per epoch
+	// epochs are ForkEpochLength blocks from ForkBlock on, zero for never
+	ForkBlock       uint64
+	ForkEpochLength uint64
+}
+
+// Epoch identifies the DAG of a block. Epochs of different lengths may share
+// a number, so the length is part of the identity.
+type Epoch struct {
+	Number uint64
+	Length uint64
+}
+
+// Epoch returns the epoch of block.
+func (p *Params) Epoch(block uint64) Epoch {
+	length := p.EpochLength
+	if p.ForkEpochLength != 0 && block >= p.ForkBlock {
+		length = p.ForkEpochLength
+	}
+	return Epoch{Number: block / length, Length: length}
+}
+
+// NextEpoch returns the epoch following e, which may be longer than e across
+// the fork.
+func (p *Params) NextEpoch(e Epoch) Epoch {
+	return p.Epoch(e.Block() + e.Length)
+}
+
+// Block returns the first block of the epoch.
+func (e Epoch) Block() uint64 {
+	return e.Number * e.Length
+}
+
+// Seed returns the seed hash of the cache and dataset of the epoch.
+func (e Epoch) Seed() []byte {
+	return seedHash(e.Block() + 1)
+}
+
+// CacheSize returns the verification cache size of the epoch in bytes.
+func (e Epoch) CacheSize() uint64 {
+	if e.Number < maxEpoch {
+		return cacheSizes[e.Number]
+	}
+	return calcCacheSize(int(e.Number))
+}
+
+// DatasetSize returns the dataset size of the epoch in bytes.
+func (e Epoch) DatasetSize() uint64 {
+	if e.Number < maxEpoch {
+		return datasetSizes[e.Number]
+	}
+	return calcDatasetSize(int(e.Number))
+}
+
+// PathToDAG returns the path of the dataset of the epoch under dir.
+func (e Epoch) PathToDAG(dir string) string {
+	return filepath.Join(dir, e.fileName("full"))
+}
+
+// MakeDAG generates the dataset of the epoch and stores it under dir.
+func (e Epoch) MakeDAG(dir string) {
+	d := dataset{epoch: e.Number, epochLength: e.Length}
+	d.generate(dir, math.MaxInt32, false)
+}
+
+// fileName names the cache or dataset of the epoch on disk, the seed alone
+// is ambiguous across the fork.
+func (e Epoch) fileName(what string) string {
+	seed := e.Seed()
+	var endian string
+	if !isLittleEndian() {
+		endian = ".be"
+	}
+	if e.Length != epochLength {
+		return fmt.Sprintf("%s-R%d-%d-%x%s", what, algorithmRevision, e.Length, seed[:8], endian)
+	}
+	return fmt.Sprintf("%s-R%d-%x%s", what, algorithmRevision, seed[:8], endian)
+}
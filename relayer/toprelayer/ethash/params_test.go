@@ -0,0 +1,77 @@
+package ethash
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestEtchashEpochs(t *testing.T) {
+	tests := []struct {
+		params *Params
+		block  uint64
+		want   Epoch
+	}{
+		{EthashParams, 0, Epoch{0, 30000}},
+		{EthashParams, ECIP1099Block + 30000, Epoch{391, 30000}},
+		{EtchashParams, ECIP1099Block - 1, Epoch{389, 30000}},
+		{EtchashParams, ECIP1099Block, Epoch{195, 60000}},
+		{EtchashParams, ECIP1099Block + 59999, Epoch{195, 60000}},
+		{EtchashParams, ECIP1099Block + 60000, Epoch{196, 60000}},
+	}
+	for _, test := range tests {
+		if got := test.params.Epoch(test.block); got != test.want {
+			t.Errorf("%v block %v: expect epoch %v, got %v", test.params.Name, test.block, test.want, got)
+		}
+	}
+	if next := EtchashParams.NextEpoch(Epoch{389, 30000}); next != (Epoch{195, 60000}) {
+		t.Fatal("unexpected epoch after the fork:", next)
+	}
+
+	// the seed follows the first block, sizes follow the epoch number
+	epoch := EtchashParams.Epoch(ECIP1099Block + 60000)
+	if !bytes.Equal(epoch.Seed(), seedHash(ECIP1099Block+60001)) || !bytes.Equal(epoch.Seed(), (Epoch{392, 30000}).Seed()) {
+		t.Fatal("unexpected seed")
+	}
+	if epoch.DatasetSize() != datasetSizes[196] || epoch.CacheSize() != cacheSizes[196] {
+		t.Fatal("unexpected sizes")
+	}
+	if (Epoch{392, 30000}).PathToDAG("") == epoch.PathToDAG("") {
+		t.Fatal("expect distinct DAG files across epoch lengths")
+	}
+	if (Epoch{5, 30000}).PathToDAG("") != PathToDAG(5, "") {
+		t.Fatal("ethash DAG file renamed")
+	}
+}
+
+func TestEtchashSeal(t *testing.T) {
+	// halfway through an etchash epoch the seeds of the schedules differ
+	header := &types.Header{Number: new(big.Int).SetUint64(ECIP1099Block + 30005), Difficulty: big.NewInt(100)}
+
+	etchash := NewTester(nil, false)
+	defer etchash.Close()
+	etchash.config.Params = EtchashParams
+
+	results := make(chan *types.Block)
+	if err := etchash.Seal(nil, types.NewBlockWithHeader(header), results, nil); err != nil {
+		t.Fatalf("failed to seal block: %v", err)
+	}
+	select {
+	case block := <-results:
+		header.Nonce = types.EncodeNonce(block.Nonce())
+		header.MixDigest = block.MixDigest()
+	case <-time.NewTimer(5 * time.Second).C:
+		t.Fatal("sealing result timeout")
+	}
+	if err := etchash.VerifySeal(nil, header); err != nil {
+		t.Fatalf("unexpected verification error: %v", err)
+	}
+	ethash := NewTester(nil, false)
+	defer ethash.Close()
+	if err := ethash.VerifySeal(nil, header); err == nil {
+		t.Fatal("expect ethash to reject an etchash seal")
+	}
+}
@@ -1,9 +1,10 @@
 package ethashapp
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"math/big"
-	"os"
-	"time"
 
 	"toprelayer/relayer/toprelayer/ethash"
 	"toprelayer/relayer/toprelayer/ethashproof"
@@ -14,12 +15,58 @@ import (
 	"github.com/wonderivan/logger"
 )
 
-const (
-	BLOCKS_PER_EPOCH uint64 = 30000
+var (
+	defaultProver = NewProver(ethash.EthashParams, ethashproof.DefaultStore)
+
+	ErrInvalidProof = errors.New("dataset merkle proof does not verify")
 )
 
-var futureEpoch uint64 = 0
-var futureEpochProcessing bool = false
+// Prover builds the DAG Merkle proofs of headers sealed under the epoch
+// schedule of params, it precomputes the cache of the next epoch.
+type Prover struct {
+	params    *ethash.Params
+	engine    *ethash.Ethash
+	store     *ethashproof.Store
+	scheduler *Scheduler
+}
+
+func NewProver(params *ethash.Params, store *ethashproof.Store) *Prover {
+	engine := ethash.Instance
+	if params != ethash.EthashParams {
+		engine = ethash.New(ethash.Config{CachesInMem: 3, DatasetsInMem: 1, PowMode: ethash.ModeNormal, Params: params}, nil, false)
+	}
+	return &Prover{params: params, engine: engine, store: store, scheduler: NewScheduler(params, store, PRECOMPUTE_AHEAD)}
+}
+
+// SetHead reports the source head, to generate the next epoch in time.
+func (p *Prover) SetHead(height uint64) {
+	p.scheduler.SetHead(height)
+}
+
+// Servable returns if the proofs of height can be built without generating
+// the cache of an epoch other than those of the head.
+func (p *Prover) Servable(height uint64) bool {
+	return p.scheduler.Servable(p.params.Epoch(height))
+}
+
+// Status returns the states of the epoch caches.
+func (p *Prover) Status() []EpochState {
+	return p.scheduler.Status()
+}
+
+// Check makes sure there is room for the DAGs of the epoch of height and the
+// next one, before generating gigabytes of them.
+func (p *Prover) Check(height uint64) error {
+	epoch := p.params.Epoch(height)
+	next := p.params.NextEpoch(epoch)
+	var need uint64
+	for _, e := range []ethash.Epoch{epoch, next} {
+		if !p.store.ExistCache(e) {
+			need += e.DatasetSize()
+		}
+	}
+	return p.store.Reserve(need, epoch, next)
+}
 
 type Output struct {
 	HeaderRLP    string   `json:"header_rlp"`
@@ -40,77 +87,48 @@ func zeroPad(bytes []byte, num int) []byte {
 	return bytes
 }
 
+// VerifySeal checks the proof of work of header against a verification cache.
+func (p *Prover) VerifySeal(header *types.Header) error {
+	return p.engine.VerifySeal(nil, header)
+}
+
 func EthashWithProofs(h uint64, header *types.Header) (Output, error) {
-	epoch := h / BLOCKS_PER_EPOCH
-	cache, err := ethashproof.LoadCache(int(epoch))
-	if err != nil {
-		if futureEpochProcessing {
-			logger.Debug("waiting for futureEpochProcessing...")
-			totalTime := 0
-			for {
-				time.Sleep(time.Duration(5) * time.Second)
-				totalTime += 5
-				if !futureEpochProcessing || totalTime > 3600 {
-					break
-				}
-			}
-			cache, err = ethashproof.LoadCache(int(epoch))
-		}
+	return defaultProver.HeaderWithProofs(context.Background(), h, header)
+}
 
-		if err != nil {
-			logger.Info("epoch %v cache is missing, calculate dataset merkle tree to create the cache first...", epoch)
-			_, err = ethashproof.CalculateDatasetMerkleRoot(epoch, true)
-			if err != nil {
-				logger.Error("Creating cache failed: ", err)
-				return Output{}, err
-			}
-			cache, err = ethashproof.LoadCache(int(epoch))
-			if err != nil {
-				logger.Error("Getting cache failed after trying to create it, abort: ", err)
-				return Output{}, err
-			}
-		}
-	}
+// SetHead reports the head of Ethereum to the default prover.
+func SetHead(height uint64) {
+	defaultProver.SetHead(height)
+}
 
-	if futureEpoch != epoch+1 {
-		if !futureEpochProcessing && !ethashproof.ExistCache(int(epoch+1)) {
-			futureEpochProcessing = true
-			logger.Info("future epoch %v cache is missing, calculate dataset merkle tree to create the cache first...", epoch+1)
-			go func() {
-				_, e := ethashproof.CalculateDatasetMerkleRoot(epoch+1, true)
-				if e != nil || !ethashproof.ExistCache(int(epoch+1)) {
-					logger.Error("Creating cache failed: ", err)
-				} else {
-					futureEpoch = epoch + 1
-				}
-				futureEpochProcessing = false
-			}()
-		}
+// HeaderWithProofs builds the proofs of header at height h, waiting for the
+// cache of its epoch until ctx is done.
+func (p *Prover) HeaderWithProofs(ctx context.Context, h uint64, header *types.Header) (Output, error) {
+	epoch := p.params.Epoch(h)
+	// the proven block is a lower bound of the head
+	p.scheduler.SetHead(h)
+	if err := p.scheduler.Wait(ctx, epoch); err != nil {
+		logger.Error("Prover wait epoch cache error:", epoch.Number, err)
+		return Output{}, err
+	}
+	cache, err := p.store.LoadCache(epoch)
+	if err != nil {
+		logger.Error("Prover LoadCache error:", epoch.Number, err)
+		return Output{}, err
 	}
 
-	// Remove outdated epoch
-	if epoch > 1 {
-		outdatedEpoch := epoch - 2
-		err = os.Remove(ethash.PathToDAG(outdatedEpoch, ethash.DefaultDir))
-		if err != nil {
-			if os.IsNotExist(err) {
-			} else {
-				logger.Error("Remove DAG: ", err)
-			}
-		}
-
-		err = os.Remove(ethashproof.PathToCache(outdatedEpoch))
+	// Remove outdated epoch, with a quota the store prunes them instead
+	if epoch.Number > 1 && p.store.Quota == 0 {
+		outdatedEpoch := ethash.Epoch{Number: epoch.Number - 2, Length: epoch.Length}
+		err = p.store.Remove(outdatedEpoch)
 		if err != nil {
-			if os.IsNotExist(err) {
-			} else {
-				logger.Error("Remove cache error: ", err)
-			}
+			logger.Error("Remove outdated epoch error: ", err)
 		}
 	}
 
-	indices := ethash.Instance.GetVerificationIndices(
+	indices := p.engine.GetVerificationIndices(
 		h,
-		ethash.Instance.SealHash(header),
+		p.engine.SealHash(header),
 		header.Nonce.Uint64(),
 	)
 	bytes, err := rlp.EncodeToBytes(header)
@@ -125,12 +143,18 @@ func EthashWithProofs(h uint64, header *types.Header) (Output, error) {
 		MerkleProofs: []string{},
 		ProofLength:  cache.ProofLength,
 	}
+	verifier := mtree.NewSHA256DagTree()
 	for _, index := range indices {
-		element, proof, err := ethashproof.CalculateProof(h, index, cache)
+		element, proof, err := p.store.CalculateProof(epoch, index, cache)
 		if err != nil {
 			logger.Error("calculating the proofs failed for index: %d, error: %s", index, err)
 			return Output{}, err
 		}
+		// a corrupted DAG or cache makes TOP reject the header
+		if !verifier.VerifyProof(element, index, proof, cache.RootHash) {
+			logger.Error("Prover VerifyProof failed:", epoch.Number, index)
+			return Output{}, fmt.Errorf("%w: epoch %v index %v", ErrInvalidProof, epoch.Number, index)
+		}
 		es := element.ToUint256Array()
 		for i := 0; i < len(es); i += 2 {
 			eBytes := zeroPad(es[i].Bytes(), 32)
@@ -0,0 +1,189 @@
+package ethashapp
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"toprelayer/relayer/toprelayer/ethash"
+	"toprelayer/relayer/toprelayer/ethashproof"
+
+	"github.com/wonderivan/logger"
+)
+
+// EpochStatus is the state of the dataset merkle tree cache of an epoch.
+type EpochStatus string
+
+const (
+	EPOCH_MISSING    EpochStatus = "missing"
+	EPOCH_GENERATING EpochStatus = "generating"
+	EPOCH_READY      EpochStatus = "ready"
+	EPOCH_FAILED     EpochStatus = "failed"
+
+	// blocks before an epoch boundary to start generating the next epoch
+	PRECOMPUTE_AHEAD uint64 = 10000
+	// a failed generation is not retried before
+	RETRY_DELAY = 10 * time.Minute
+)
+
+type EpochState struct {
+	Number uint64      `json:"number"`
+	Length uint64      `json:"length"`
+	Status EpochStatus `json:"status"`
+	Error  string      `json:"error,omitempty"`
+}
+
+// generation of the cache of an epoch, err is set before done is closed.
+type generation struct {
+	done   chan struct{}
+	err    error
+	failed time.Time
+}
+
+func (g *generation) running() bool {
+	select {
+	case <-g.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// Scheduler generates the dataset merkle tree caches of epochs, and the next
+// epoch ahead of the source head crossing its boundary. Generations run one
+// at a time, so that one making room in the store never prunes the files of
+// another in flight.
+type Scheduler struct {
+	params   *ethash.Params
+	store    *ethashproof.Store
+	ahead    uint64
+	generate func(epoch ethash.Epoch, keep ...ethash.Epoch) error
+	running  sync.Mutex // held by the generation in flight
+
+	lock sync.Mutex
+	head uint64
+	jobs map[ethash.Epoch]*generation // running and failed
+}
+
+func NewScheduler(params *ethash.Params, store *ethashproof.Store, ahead uint64) *Scheduler {
+	s := &Scheduler{params: params, store: store, ahead: ahead, jobs: make(map[ethash.Epoch]*generation)}
+	s.generate = func(epoch ethash.Epoch, keep ...ethash.Epoch) error {
+		if _, err := store.CalculateDatasetMerkleRoot(epoch, true, keep...); err != nil {
+			return err
+		}
+		if !store.ExistCache(epoch) {
+			return fmt.Errorf("epoch %v cache missing after generation", epoch.Number)
+		}
+		return nil
+	}
+	return s
+}
+
+// start returns the generation of epoch, beginning one unless the cache
+// exists or a recent attempt failed. s.lock must be held.
+func (s *Scheduler) start(epoch ethash.Epoch, keep ...ethash.Epoch) *generation {
+	if g, ok := s.jobs[epoch]; ok && (g.running() || time.Since(g.failed) < RETRY_DELAY) {
+		return g
+	}
+	g := &generation{done: make(chan struct{})}
+	if s.store.ExistCache(epoch) {
+		delete(s.jobs, epoch)
+		close(g.done)
+		return g
+	}
+	s.jobs[epoch] = g
+	logger.Info("Scheduler generate epoch %v cache", epoch.Number)
+	go func() {
+		s.running.Lock()
+		err := s.generate(epoch, keep...)
+		s.running.Unlock()
+		s.lock.Lock()
+		if err != nil {
+			logger.Error("Scheduler generate epoch error:", epoch.Number, err)
+			g.err, g.failed = err, time.Now()
+		} else {
+			logger.Info("Scheduler epoch %v cache ready", epoch.Number)
+			delete(s.jobs, epoch)
+		}
+		s.lock.Unlock()
+		close(g.done)
+	}()
+	return g
+}
+
+// Wait generates the cache of epoch if needed and blocks until it is ready
+// or ctx is done, generation goes on for later callers then.
+func (s *Scheduler) Wait(ctx context.Context, epoch ethash.Epoch, keep ...ethash.Epoch) error {
+	s.lock.Lock()
+	g := s.start(epoch, keep...)
+	s.lock.Unlock()
+	select {
+	case <-g.done:
+		return g.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Servable returns if the cache of epoch is ready or may be generated on
+// demand, being the epoch of the head or the next one.
+func (s *Scheduler) Servable(epoch ethash.Epoch) bool {
+	s.lock.Lock()
+	head := s.head
+	s.lock.Unlock()
+	if head > 0 {
+		current := s.params.Epoch(head)
+		if epoch == current || epoch == s.params.NextEpoch(current) {
+			return true
+		}
+	}
+	return s.store.ExistCache(epoch)
+}
+
+// SetHead reports the source head, the next epoch is generated once the
+// head is within ahead blocks of its boundary.
+func (s *Scheduler) SetHead(height uint64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if height > s.head {
+		s.head = height
+	}
+	epoch := s.params.Epoch(s.head)
+	if next := s.params.NextEpoch(epoch); s.head+s.ahead >= next.Block() {
+		s.start(next, epoch)
+	}
+}
+
+// Status returns the states of the epochs of the head and after it, and of
+// the generations running or failed.
+func (s *Scheduler) Status() []EpochState {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	epochs := make(map[ethash.Epoch]bool)
+	for epoch := range s.jobs {
+		epochs[epoch] = true
+	}
+	if s.head > 0 {
+		epoch := s.params.Epoch(s.head)
+		epochs[epoch] = true
+		epochs[s.params.NextEpoch(epoch)] = true
+	}
+	states := make([]EpochState, 0, len(epochs))
+	for epoch := range epochs {
+		state := EpochState{Number: epoch.Number, Length: epoch.Length, Status: EPOCH_MISSING}
+		if g, ok := s.jobs[epoch]; ok && g.running() {
+			state.Status = EPOCH_GENERATING
+		} else if ok {
+			state.Status, state.Error = EPOCH_FAILED, g.err.Error()
+		} else if s.store.ExistCache(epoch) {
+			state.Status = EPOCH_READY
+		}
+		states = append(states, state)
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Number*states[i].Length < states[j].Number*states[j].Length
+	})
+	return states
+}
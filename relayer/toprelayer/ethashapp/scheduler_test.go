@@ -0,0 +1,199 @@
+package ethashapp
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"toprelayer/relayer/toprelayer/ethash"
+	"toprelayer/relayer/toprelayer/ethashproof"
+)
+
+// fakeGenerator writes empty DAG and cache files once released.
+type fakeGenerator struct {
+	store   *ethashproof.Store
+	release chan struct{}
+	err     error
+
+	lock      sync.Mutex
+	calls     []ethash.Epoch
+	keeps     [][]ethash.Epoch
+	active    int
+	maxActive int
+}
+
+func (f *fakeGenerator) generate(epoch ethash.Epoch, keep ...ethash.Epoch) error {
+	f.lock.Lock()
+	f.calls = append(f.calls, epoch)
+	f.keeps = append(f.keeps, keep)
+	f.active++
+	if f.active > f.maxActive {
+		f.maxActive = f.active
+	}
+	f.lock.Unlock()
+	defer func() {
+		f.lock.Lock()
+		f.active--
+		f.lock.Unlock()
+	}()
+	<-f.release
+	if f.err != nil {
+		return f.err
+	}
+	for _, path := range []string{f.store.PathToDAG(epoch), f.store.PathToCache(epoch)} {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *fakeGenerator) numCalls() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return len(f.calls)
+}
+
+func newTestScheduler(t *testing.T) (*Scheduler, *fakeGenerator) {
+	store := ethashproof.NewStore(t.TempDir(), t.TempDir(), 0)
+	s := NewScheduler(ethash.EthashParams, store, 1000)
+	f := &fakeGenerator{store: store, release: make(chan struct{})}
+	s.generate = f.generate
+	return s, f
+}
+
+func checkStatus(t *testing.T, s *Scheduler, number uint64, want EpochStatus) {
+	for _, state := range s.Status() {
+		if state.Number == number {
+			if state.Status != want {
+				t.Fatalf("epoch %v: expect %v, got %v", number, want, state.Status)
+			}
+			return
+		}
+	}
+	t.Fatalf("epoch %v: no status", number)
+}
+
+func TestSchedulerWait(t *testing.T) {
+	s, f := newTestScheduler(t)
+	epoch := ethash.EthashParams.Epoch(100)
+	// status lists the epoch of the head
+	s.SetHead(100)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := s.Wait(ctx, epoch); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("expect deadline exceeded, got", err)
+	}
+	checkStatus(t, s, epoch.Number, EPOCH_GENERATING)
+
+	var wg sync.WaitGroup
+	errs := make([]error, 4)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = s.Wait(context.Background(), epoch)
+		}(i)
+	}
+	close(f.release)
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if f.numCalls() != 1 {
+		t.Fatal("expect one generation, got", f.numCalls())
+	}
+	checkStatus(t, s, epoch.Number, EPOCH_READY)
+	if err := s.Wait(context.Background(), epoch); err != nil || f.numCalls() != 1 {
+		t.Fatal("expect the ready cache used:", err)
+	}
+}
+
+func TestSchedulerOneAtATime(t *testing.T) {
+	s, f := newTestScheduler(t)
+	epochs := []ethash.Epoch{ethash.EthashParams.Epoch(100), ethash.EthashParams.Epoch(30000 + 100)}
+	var wg sync.WaitGroup
+	errs := make([]error, len(epochs))
+	for i := range epochs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = s.Wait(context.Background(), epochs[i])
+		}(i)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if f.numCalls() != 1 {
+		t.Fatal("expect one generation in flight, got", f.numCalls())
+	}
+	close(f.release)
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if f.numCalls() != 2 || f.maxActive != 1 {
+		t.Fatal("expect two generations one at a time, got", f.numCalls(), f.maxActive)
+	}
+}
+
+func TestSchedulerFailure(t *testing.T) {
+	s, f := newTestScheduler(t)
+	f.err = errors.New("disk full")
+	close(f.release)
+	epoch := ethash.EthashParams.Epoch(100)
+	// status lists the epoch of the head
+	s.SetHead(100)
+	if err := s.Wait(context.Background(), epoch); err != f.err {
+		t.Fatal("expect generation error, got", err)
+	}
+	checkStatus(t, s, epoch.Number, EPOCH_FAILED)
+	// no retry right away
+	if err := s.Wait(context.Background(), epoch); err != f.err || f.numCalls() != 1 {
+		t.Fatal("expect the failure kept, got", err, f.numCalls())
+	}
+
+	f.err = nil
+	s.lock.Lock()
+	s.jobs[epoch].failed = time.Now().Add(-RETRY_DELAY)
+	s.lock.Unlock()
+	if err := s.Wait(context.Background(), epoch); err != nil || f.numCalls() != 2 {
+		t.Fatal("expect retry after the delay, got", err, f.numCalls())
+	}
+	checkStatus(t, s, epoch.Number, EPOCH_READY)
+}
+
+func TestSchedulerSetHead(t *testing.T) {
+	s, f := newTestScheduler(t)
+	close(f.release)
+	current := ethash.EthashParams.Epoch(30000*5 + 100)
+	next := ethash.EthashParams.NextEpoch(current)
+
+	s.SetHead(current.Block() + 100)
+	if f.numCalls() != 0 {
+		t.Fatal("expect no generation far from the boundary")
+	}
+	checkStatus(t, s, current.Number, EPOCH_MISSING)
+	checkStatus(t, s, next.Number, EPOCH_MISSING)
+
+	s.SetHead(next.Block() - 1000)
+	// a lower height does not move the head back
+	s.SetHead(10)
+	if err := s.Wait(context.Background(), next); err != nil {
+		t.Fatal(err)
+	}
+	if f.numCalls() != 1 || f.calls[0] != next || len(f.keeps[0]) != 1 || f.keeps[0][0] != current {
+		t.Fatal("expect next epoch generated keeping the current one:", f.calls, f.keeps)
+	}
+	checkStatus(t, s, next.Number, EPOCH_READY)
+}
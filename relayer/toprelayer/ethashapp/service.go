@@ -0,0 +1,180 @@
+package ethashapp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/wonderivan/logger"
+)
+
+const (
+	FORMAT_JSON = "json"
+	FORMAT_RLP  = "rlp"
+
+	PROOF_PATH  = "/proof/"
+	STATUS_PATH = "/status"
+
+	// a request waiting longer for its epoch cache is told to retry
+	REQUEST_TIMEOUT = 30 * time.Second
+)
+
+var (
+	ErrUnknownFormat = errors.New("unknown output format")
+	ErrNotServable   = errors.New("epoch not cached")
+)
+
+// jsonOutput is Output with the byte strings in hex.
+type jsonOutput struct {
+	HeaderRLP    hexutil.Bytes   `json:"header_rlp"`
+	MerkleRoot   hexutil.Bytes   `json:"merkle_root"`
+	Elements     []hexutil.Bytes `json:"elements"`
+	MerkleProofs []hexutil.Bytes `json:"merkle_proofs"`
+	ProofLength  uint64          `json:"proof_length"`
+}
+
+func toHex(ss []string) []hexutil.Bytes {
+	bs := make([]hexutil.Bytes, len(ss))
+	for i, s := range ss {
+		bs[i] = hexutil.Bytes(s)
+	}
+	return bs
+}
+
+// EncodeOutput encodes out in format, the RLP is what relayers submit to TOP.
+func EncodeOutput(out Output, format string) ([]byte, error) {
+	switch format {
+	case FORMAT_RLP:
+		return rlp.EncodeToBytes(out)
+	case FORMAT_JSON:
+		return json.Marshal(jsonOutput{
+			HeaderRLP:    hexutil.Bytes(out.HeaderRLP),
+			MerkleRoot:   hexutil.Bytes(out.MerkleRoot),
+			Elements:     toHex(out.Elements),
+			MerkleProofs: toHex(out.MerkleProofs),
+			ProofLength:  out.ProofLength,
+		})
+	}
+	return nil, fmt.Errorf("%w: %v", ErrUnknownFormat, format)
+}
+
+// HeaderSource provides the headers to prove, ethclient.Client is one.
+type HeaderSource interface {
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+}
+
+// Service builds proofs of the headers of source on request, sharing the
+// epoch caches of one prover between all its consumers.
+type Service struct {
+	prover *Prover
+	source HeaderSource
+}
+
+func NewService(prover *Prover, source HeaderSource) *Service {
+	return &Service{prover: prover, source: source}
+}
+
+// Proof fetches the header at number and builds its proofs, it refuses
+// blocks of epochs neither cached nor of the head, so that requests cannot
+// make the service generate arbitrary epochs.
+func (s *Service) Proof(ctx context.Context, number uint64) (Output, error) {
+	if !s.prover.Servable(number) {
+		return Output{}, fmt.Errorf("%w: block %v", ErrNotServable, number)
+	}
+	header, err := s.source.HeaderByNumber(ctx, new(big.Int).SetUint64(number))
+	if err != nil {
+		return Output{}, err
+	}
+	if header == nil {
+		return Output{}, ethereum.NotFound
+	}
+	return s.HeaderProof(ctx, header)
+}
+
+// HeaderProof builds the proofs of header.
+func (s *Service) HeaderProof(ctx context.Context, header *types.Header) (Output, error) {
+	return s.prover.HeaderWithProofs(ctx, header.Number.Uint64(), header)
+}
+
+// Follow reports the head of source to the prover every interval until ctx
+// is done, so that the next epoch is ready before it is requested.
+func (s *Service) Follow(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		header, err := s.source.HeaderByNumber(ctx, nil)
+		if err != nil {
+			logger.Error("Service HeaderByNumber error:", err)
+		} else {
+			s.prover.SetHead(header.Number.Uint64())
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ServeHTTP serves GET /proof/<number>[?format=json|rlp], json by default,
+// and the epoch cache states on GET /status. Blocks of epochs not servable
+// or still generating get 503.
+func (s *Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.URL.Path == STATUS_PATH {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.prover.Status())
+		return
+	}
+	number, err := strconv.ParseUint(strings.TrimPrefix(r.URL.Path, PROOF_PATH), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid block number", http.StatusBadRequest)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = FORMAT_JSON
+	}
+	if format != FORMAT_JSON && format != FORMAT_RLP {
+		http.Error(w, ErrUnknownFormat.Error(), http.StatusBadRequest)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), REQUEST_TIMEOUT)
+	defer cancel()
+	out, err := s.Proof(ctx, number)
+	if err != nil {
+		logger.Error("Service Proof error:", number, err)
+		if errors.Is(err, ethereum.NotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else if errors.Is(err, ErrNotServable) || errors.Is(err, context.DeadlineExceeded) {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	data, err := EncodeOutput(out, format)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if format == FORMAT_RLP {
+		w.Header().Set("Content-Type", "application/octet-stream")
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.Write(data)
+}
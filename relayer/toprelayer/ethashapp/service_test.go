@@ -0,0 +1,97 @@
+package ethashapp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"toprelayer/relayer/toprelayer/ethash"
+	"toprelayer/relayer/toprelayer/ethashproof"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+type headerSource map[uint64]*types.Header
+
+func (s headerSource) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	if header, ok := s[number.Uint64()]; ok {
+		return header, nil
+	}
+	return nil, ethereum.NotFound
+}
+
+func TestEncodeOutput(t *testing.T) {
+	out := Output{
+		HeaderRLP:    "\x01\x02",
+		MerkleRoot:   "\xff",
+		Elements:     []string{"\x00\x10"},
+		MerkleProofs: []string{"\xab", "\xcd"},
+		ProofLength:  25,
+	}
+	data, err := EncodeOutput(out, FORMAT_JSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatal(err)
+	}
+	if fields["header_rlp"] != "0x0102" || fields["merkle_root"] != "0xff" ||
+		!reflect.DeepEqual(fields["merkle_proofs"], []interface{}{"0xab", "0xcd"}) || fields["proof_length"] != 25.0 {
+		t.Fatal("unexpected json:", string(data))
+	}
+
+	data, err = EncodeOutput(out, FORMAT_RLP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Output
+	if err := rlp.DecodeBytes(data, &decoded); err != nil || !reflect.DeepEqual(decoded, out) {
+		t.Fatal("unexpected rlp:", err)
+	}
+
+	if _, err := EncodeOutput(out, "xml"); !errors.Is(err, ErrUnknownFormat) {
+		t.Fatal("expect unknown format, got", err)
+	}
+}
+
+func TestServiceRequests(t *testing.T) {
+	store := ethashproof.NewStore(t.TempDir(), t.TempDir(), 0)
+	prover := NewProver(ethash.EthashParams, store)
+	service := NewService(prover, headerSource{})
+	// epoch 3, not close enough to epoch 4 to generate it
+	prover.SetHead(100000)
+	tests := []struct {
+		path string
+		code int
+	}{
+		{"/proof/abc", http.StatusBadRequest},
+		{"/proof/", http.StatusBadRequest},
+		{"/proof/100000?format=xml", http.StatusBadRequest},
+		{"/proof/100000", http.StatusNotFound},
+		{"/proof/130000", http.StatusNotFound},
+		// neither cached nor of the head
+		{"/proof/10", http.StatusServiceUnavailable},
+		{"/proof/150000", http.StatusServiceUnavailable},
+		{"/status", http.StatusOK},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		service.ServeHTTP(w, httptest.NewRequest(http.MethodGet, test.path, nil))
+		if w.Code != test.code {
+			t.Errorf("%v: expect %v, got %v", test.path, test.code, w.Code)
+		}
+	}
+	w := httptest.NewRecorder()
+	service.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/proof/10", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expect %v, got %v", http.StatusMethodNotAllowed, w.Code)
+	}
+}
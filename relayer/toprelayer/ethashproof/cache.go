@@ -4,29 +4,52 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"os"
 	"os/user"
 	"path/filepath"
 
+	"toprelayer/relayer/toprelayer/ethash"
 	"toprelayer/relayer/toprelayer/mtree"
+
+	"github.com/wonderivan/logger"
 )
 
 const CACHE_LEVEL uint64 = 15
 
 type DatasetMerkleTreeCache struct {
 	Epoch       uint64         `json:"epoch"`
+	EpochLength uint64         `json:"epoch_length"`
 	ProofLength uint64         `json:"proof_length"`
 	CacheLength uint64         `json:"cache_length"`
 	RootHash    mtree.Hash     `json:"root_hash"`
 	Proofs      [][]mtree.Hash `json:"proofs"`
+
+	// proofs of a cache loaded from a binary file, instead of Proofs
+	mapped *mappedProofs
+}
+
+// NumProofs returns the number of cached subtree proofs.
+func (self *DatasetMerkleTreeCache) NumProofs() uint64 {
+	if self.mapped != nil {
+		return self.mapped.count
+	}
+	return uint64(len(self.Proofs))
+}
+
+// Proof returns the proof of subtree i from its root to the dataset root.
+func (self *DatasetMerkleTreeCache) Proof(i uint64) []mtree.Hash {
+	if self.mapped != nil {
+		return self.mapped.proof(i)
+	}
+	return self.Proofs[i]
 }
 
 func (self *DatasetMerkleTreeCache) Print() {
 	fmt.Printf("Epoch: %d\n", self.Epoch)
 	fmt.Printf("Merkle root: %s\n", self.RootHash.Hex())
 	fmt.Printf("Sub proofs:\n")
-	for i, proof := range self.Proofs {
+	for i := uint64(0); i < self.NumProofs(); i++ {
+		proof := self.Proof(i)
 		fmt.Printf("%d. [", i)
 		for _, node := range proof {
 			fmt.Printf("%s, ", node.Hex())
@@ -35,55 +58,111 @@ func (self *DatasetMerkleTreeCache) Print() {
 	}
 }
 
-func getHomeDir() string {
-	usr, err := user.Current()
-	if err != nil {
-		log.Fatal(err)
+func defaultCacheDir() string {
+	home := os.Getenv("HOME")
+	if usr, err := user.Current(); err == nil {
+		home = usr.HomeDir
 	}
-	return usr.HomeDir
+	return filepath.Join(home, ".ethashproof")
 }
 
-func PersistCache(cache *DatasetMerkleTreeCache) error {
-	content, err := json.Marshal(cache)
-	if err != nil {
-		return err
-	}
-	dirPath := filepath.Join(getHomeDir(), ".ethashproof")
-	err = os.MkdirAll(dirPath, 0777)
-	if err != nil {
-		return err
-	}
-	path := filepath.Join(dirPath, fmt.Sprintf("%d.json", cache.Epoch))
-	return ioutil.WriteFile(path, content, 0644)
+func (s *Store) PersistCache(cache *DatasetMerkleTreeCache) error {
+	epoch := ethash.Epoch{Number: cache.Epoch, Length: cache.EpochLength}
+	s.loaded.Remove(epoch)
+	return writeCacheFile(s.PathToCache(epoch), cache)
 }
 
-func LoadCache(epoch int) (*DatasetMerkleTreeCache, error) {
-	path := filepath.Join(getHomeDir(), ".ethashproof", fmt.Sprintf("%d.json", epoch))
-	content, err := ioutil.ReadFile(path)
-	if err != nil {
+// LoadCache loads the cache of epoch, it fails if the DAG of the epoch has
+// been removed as proofs are read from it. The caches of the last two epochs
+// stay in memory and json caches are migrated to the binary format.
+func (s *Store) LoadCache(epoch ethash.Epoch) (*DatasetMerkleTreeCache, error) {
+	path := s.PathToCache(epoch)
+	if _, err := os.Stat(s.PathToDAG(epoch)); err != nil {
+		s.loaded.Remove(epoch)
 		return nil, err
 	}
-	result := &DatasetMerkleTreeCache{}
-	err = json.Unmarshal(content, &result)
+	touch(path)
+	touch(s.PathToDAG(epoch))
+	if cache, ok := s.loaded.Get(epoch); ok {
+		return cache.(*DatasetMerkleTreeCache), nil
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		if err := s.migrateCache(epoch); err != nil {
+			return nil, err
+		}
+	}
+	result, err := mapCacheFile(path)
 	if err != nil {
 		return nil, err
 	}
+	s.loaded.Add(epoch, result)
 	return result, nil
 }
 
-func ExistCache(epoch int) bool {
-	path := filepath.Join(getHomeDir(), ".ethashproof", fmt.Sprintf("%d.json", epoch))
-	_, err := os.Stat(path)
+// migrateCache converts the json cache of epoch to the binary format.
+func (s *Store) migrateCache(epoch ethash.Epoch) error {
+	jsonPath := s.pathToJsonCache(epoch)
+	content, err := ioutil.ReadFile(jsonPath)
+	if err != nil {
+		return err
+	}
+	cache := &DatasetMerkleTreeCache{}
+	err = json.Unmarshal(content, &cache)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return false
-		} else {
+		return err
+	}
+	// caches before etchash did not record the epoch length
+	cache.Epoch, cache.EpochLength = epoch.Number, epoch.Length
+	if err := writeCacheFile(s.PathToCache(epoch), cache); err != nil {
+		return err
+	}
+	logger.Info("ethashproof migrated %v to %v", jsonPath, s.PathToCache(epoch))
+	return os.Remove(jsonPath)
+}
+
+func (s *Store) ExistCache(epoch ethash.Epoch) bool {
+	if _, err := os.Stat(s.PathToDAG(epoch)); os.IsNotExist(err) {
+		return false
+	}
+	for _, path := range []string{s.PathToCache(epoch), s.pathToJsonCache(epoch)} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
 			return true
 		}
 	}
-	return true
+	return false
+}
+
+// PathToCache returns the cache path of epoch, epochs of other than 30000
+// blocks carry their length in the name.
+func (s *Store) PathToCache(epoch ethash.Epoch) string {
+	return filepath.Join(s.CacheDir, cacheFileName(epoch)+".bin")
+}
+
+// pathToJsonCache returns the path of the cache of epoch in the old json
+// format.
+func (s *Store) pathToJsonCache(epoch ethash.Epoch) string {
+	return filepath.Join(s.CacheDir, cacheFileName(epoch)+".json")
+}
+
+func cacheFileName(epoch ethash.Epoch) string {
+	if epoch.Length != ethash.EthashParams.EpochLength {
+		return fmt.Sprintf("%d-%d", epoch.Number, epoch.Length)
+	}
+	return fmt.Sprintf("%d", epoch.Number)
+}
+
+func PersistCache(cache *DatasetMerkleTreeCache) error {
+	return DefaultStore.PersistCache(cache)
+}
+
+func LoadCache(epoch ethash.Epoch) (*DatasetMerkleTreeCache, error) {
+	return DefaultStore.LoadCache(epoch)
+}
+
+func ExistCache(epoch ethash.Epoch) bool {
+	return DefaultStore.ExistCache(epoch)
 }
 
-func PathToCache(epoch uint64) string {
-	return filepath.Join(getHomeDir(), ".ethashproof", fmt.Sprintf("%d.json", epoch))
+func PathToCache(epoch ethash.Epoch) string {
+	return DefaultStore.PathToCache(epoch)
 }
@@ -0,0 +1,161 @@
+package ethashproof
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+
+	"toprelayer/relayer/toprelayer/mtree"
+
+	mmap "github.com/edsrzf/mmap-go"
+)
+
+// Binary cache file, integers are little endian:
+//
+//	magic, version
+//	epoch, epoch length, proof length, cache length, proofs, hashes per proof
+//	root hash
+//	proofs
+//	crc32c of all the above
+const (
+	cacheVersion    uint32 = 1
+	cacheHeaderSize        = 4 + 4 + 6*8 + mtree.HashLength
+)
+
+var (
+	cacheMagic    = []byte("EPMC")
+	crcTable      = crc32.MakeTable(crc32.Castagnoli)
+	errCacheFile  = errors.New("invalid cache file")
+	errCacheProof = errors.New("uneven cache proofs")
+)
+
+// mappedProofs are the proofs of a cache file mapped into memory.
+type mappedProofs struct {
+	file  *os.File
+	mem   mmap.MMap
+	count uint64
+	width uint64
+}
+
+func (m *mappedProofs) proof(i uint64) []mtree.Hash {
+	proof := make([]mtree.Hash, m.width)
+	offset := cacheHeaderSize + i*m.width*mtree.HashLength
+	for j := range proof {
+		copy(proof[j][:], m.mem[offset:])
+		offset += mtree.HashLength
+	}
+	return proof
+}
+
+// finalizer unmaps the memory and closes the file.
+func (m *mappedProofs) finalizer() {
+	if m.mem != nil {
+		m.mem.Unmap()
+		m.file.Close()
+		m.mem, m.file = nil, nil
+	}
+}
+
+// encodeCache returns the binary cache file of cache.
+func encodeCache(cache *DatasetMerkleTreeCache) ([]byte, error) {
+	count := cache.NumProofs()
+	var width uint64
+	if count > 0 {
+		width = uint64(len(cache.Proof(0)))
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, cacheHeaderSize+count*width*mtree.HashLength+4))
+	buf.Write(cacheMagic)
+	binary.Write(buf, binary.LittleEndian, cacheVersion)
+	for _, n := range []uint64{cache.Epoch, cache.EpochLength, cache.ProofLength, cache.CacheLength, count, width} {
+		binary.Write(buf, binary.LittleEndian, n)
+	}
+	buf.Write(cache.RootHash[:])
+	for i := uint64(0); i < count; i++ {
+		proof := cache.Proof(i)
+		if uint64(len(proof)) != width {
+			return nil, errCacheProof
+		}
+		for _, h := range proof {
+			buf.Write(h[:])
+		}
+	}
+	binary.Write(buf, binary.LittleEndian, crc32.Checksum(buf.Bytes(), crcTable))
+	return buf.Bytes(), nil
+}
+
+// writeCacheFile writes cache to path through a temporary file, so that a
+// mapped older version stays intact.
+func writeCacheFile(path string, cache *DatasetMerkleTreeCache) error {
+	content, err := encodeCache(cache)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	temp := path + "." + strconv.Itoa(rand.Int())
+	if err := os.WriteFile(temp, content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(temp, path)
+}
+
+// mapCacheFile maps the cache file at path into memory after checking it.
+func mapCacheFile(path string) (*DatasetMerkleTreeCache, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	mem, err := mmap.Map(file, mmap.RDONLY, 0)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	cache, err := decodeCacheHeader(mem)
+	if err != nil {
+		mem.Unmap()
+		file.Close()
+		return nil, fmt.Errorf("%v: %w", path, err)
+	}
+	cache.mapped.file, cache.mapped.mem = file, mem
+	runtime.SetFinalizer(cache.mapped, (*mappedProofs).finalizer)
+	return cache, nil
+}
+
+// decodeCacheHeader checks a binary cache file and decodes its header, the
+// proofs stay in data.
+func decodeCacheHeader(data []byte) (*DatasetMerkleTreeCache, error) {
+	if len(data) < cacheHeaderSize+4 || !bytes.Equal(data[:4], cacheMagic) {
+		return nil, errCacheFile
+	}
+	if version := binary.LittleEndian.Uint32(data[4:]); version != cacheVersion {
+		return nil, fmt.Errorf("%w: version %v", errCacheFile, version)
+	}
+	body := data[:len(data)-4]
+	if crc32.Checksum(body, crcTable) != binary.LittleEndian.Uint32(data[len(body):]) {
+		return nil, fmt.Errorf("%w: checksum mismatch", errCacheFile)
+	}
+	fields := make([]uint64, 6)
+	for i := range fields {
+		fields[i] = binary.LittleEndian.Uint64(data[8+8*i:])
+	}
+	cache := &DatasetMerkleTreeCache{
+		Epoch:       fields[0],
+		EpochLength: fields[1],
+		ProofLength: fields[2],
+		CacheLength: fields[3],
+		mapped:      &mappedProofs{count: fields[4], width: fields[5]},
+	}
+	copy(cache.RootHash[:], data[cacheHeaderSize-mtree.HashLength:])
+	if uint64(len(body)) != cacheHeaderSize+cache.mapped.count*cache.mapped.width*mtree.HashLength {
+		return nil, fmt.Errorf("%w: size mismatch", errCacheFile)
+	}
+	return cache, nil
+}
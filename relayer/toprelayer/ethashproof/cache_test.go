@@ -0,0 +1,128 @@
+package ethashproof
+
+import (
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"toprelayer/relayer/toprelayer/ethash"
+	"toprelayer/relayer/toprelayer/mtree"
+)
+
+func randomCache(epoch ethash.Epoch, count, width int) *DatasetMerkleTreeCache {
+	cache := &DatasetMerkleTreeCache{
+		Epoch:       epoch.Number,
+		EpochLength: epoch.Length,
+		ProofLength: 25,
+		CacheLength: CACHE_LEVEL,
+	}
+	rand.Read(cache.RootHash[:])
+	for i := 0; i < count; i++ {
+		proof := make([]mtree.Hash, width)
+		for j := range proof {
+			rand.Read(proof[j][:])
+		}
+		cache.Proofs = append(cache.Proofs, proof)
+	}
+	return cache
+}
+
+func checkCache(t *testing.T, got, want *DatasetMerkleTreeCache) {
+	if got.Epoch != want.Epoch || got.EpochLength != want.EpochLength || got.ProofLength != want.ProofLength ||
+		got.CacheLength != want.CacheLength || got.RootHash != want.RootHash || got.NumProofs() != want.NumProofs() {
+		t.Fatal("unexpected cache header")
+	}
+	for i := uint64(0); i < want.NumProofs(); i++ {
+		if !reflect.DeepEqual(got.Proof(i), want.Proof(i)) {
+			t.Fatal("unexpected proof", i)
+		}
+	}
+}
+
+func TestCacheFile(t *testing.T) {
+	dir := t.TempDir()
+	s := NewStore(filepath.Join(dir, "dag"), filepath.Join(dir, "proof"), 0)
+	epoch := ethash.Epoch{Number: 196, Length: 60000}
+	writeFile(t, s.PathToDAG(epoch), 10, time.Now())
+	want := randomCache(epoch, 100, int(CACHE_LEVEL))
+	if err := s.PersistCache(want); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.LoadCache(epoch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCache(t, got, want)
+	if again, err := s.LoadCache(epoch); err != nil || again != got {
+		t.Fatal("expect the cache kept in memory")
+	}
+
+	// a rewritten cache replaces the one in memory
+	want = randomCache(epoch, 10, int(CACHE_LEVEL))
+	if err := s.PersistCache(want); err != nil {
+		t.Fatal(err)
+	}
+	if got, err = s.LoadCache(epoch); err != nil {
+		t.Fatal(err)
+	}
+	checkCache(t, got, want)
+
+	content, err := os.ReadFile(s.PathToCache(epoch))
+	if err != nil {
+		t.Fatal(err)
+	}
+	content[cacheHeaderSize+5] ^= 1
+	if err := os.WriteFile(s.PathToCache(epoch), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	s = NewStore(s.DagDir, s.CacheDir, 0)
+	if _, err := s.LoadCache(epoch); !errors.Is(err, errCacheFile) {
+		t.Fatal("expect checksum mismatch, got", err)
+	}
+
+	if err := s.PersistCache(randomCache(epoch, 2, 3)); err != nil {
+		t.Fatal(err)
+	}
+	uneven := randomCache(epoch, 2, 3)
+	uneven.Proofs[1] = uneven.Proofs[1][:2]
+	if err := s.PersistCache(uneven); !errors.Is(err, errCacheProof) {
+		t.Fatal("expect uneven proofs rejected, got", err)
+	}
+}
+
+func TestCacheMigration(t *testing.T) {
+	dir := t.TempDir()
+	s := NewStore(filepath.Join(dir, "dag"), filepath.Join(dir, "proof"), 0)
+	epoch := ethash.Epoch{Number: 400, Length: 30000}
+	writeFile(t, s.PathToDAG(epoch), 10, time.Now())
+	want := randomCache(epoch, 20, int(CACHE_LEVEL))
+	// json caches before etchash have no epoch length
+	want.EpochLength = 0
+	old, err := json.Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want.EpochLength = epoch.Length
+	if err := os.MkdirAll(s.CacheDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(s.pathToJsonCache(epoch), old, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !s.ExistCache(epoch) {
+		t.Fatal("expect json cache found")
+	}
+	got, err := s.LoadCache(epoch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCache(t, got, want)
+	if exists(s.pathToJsonCache(epoch)) || !exists(s.PathToCache(epoch)) {
+		t.Fatal("expect json cache migrated")
+	}
+}
@@ -19,17 +19,12 @@ func pathExists(path string) (bool, error) {
 	return false, err
 }
 
-func CalculateProof(blockno uint64, index uint32, cache *DatasetMerkleTreeCache) (mtree.Word, []mtree.Hash, error) {
-	dt := mtree.NewSHA256DagTree()
+func CalculateProof(epoch ethash.Epoch, index uint32, cache *DatasetMerkleTreeCache) (mtree.Word, []mtree.Hash, error) {
+	return DefaultStore.CalculateProof(epoch, index, cache)
+}
 
-	fullSize := ethash.DAGSize(blockno)
-	fullSizeIn128Resolution := fullSize / 128
-	branchDepth := len(fmt.Sprintf("%b", fullSizeIn128Resolution-1))
-	dt.RegisterStoredLevel(uint32(uint64(branchDepth)-CACHE_LEVEL), uint32(0))
-	liveLevel := uint64(branchDepth) - CACHE_LEVEL
-	subtreeStart := index >> liveLevel << liveLevel
-	dt.RegisterIndex(index - subtreeStart)
-	path := ethash.PathToDAG(uint64(blockno/30000), ethash.DefaultDir)
+func (s *Store) CalculateProof(epoch ethash.Epoch, index uint32, cache *DatasetMerkleTreeCache) (mtree.Word, []mtree.Hash, error) {
+	path := s.PathToDAG(epoch)
 	e, err := pathExists(path)
 	if err != nil {
 		return mtree.Word{}, []mtree.Hash{}, err
@@ -42,11 +37,26 @@ func CalculateProof(blockno uint64, index uint32, cache *DatasetMerkleTreeCache)
 		return mtree.Word{}, []mtree.Hash{}, err
 	}
 	defer f.Close()
-	processDuringRead(f, int(subtreeStart), uint32(1<<(uint64(branchDepth)-CACHE_LEVEL)), dt)
-	dt.Finalize()
+	return calculateProof(f, epoch.DatasetSize()/128, CACHE_LEVEL, index, cache)
+}
+
+// calculateProof proves element index of the n elements dataset in f, the
+// subtree of index is read from f and the branch above it taken from cache.
+func calculateProof(f *os.File, n, cacheLevel uint64, index uint32, cache *DatasetMerkleTreeCache) (mtree.Word, []mtree.Hash, error) {
+	dt := mtree.NewSHA256DagTree()
+	branchDepth := uint64(len(fmt.Sprintf("%b", n-1)))
+	liveLevel := branchDepth - cacheLevel
+	dt.RegisterStoredLevel(uint32(liveLevel), uint32(0))
+	subtreeStart := index >> liveLevel << liveLevel
+	dt.RegisterIndex(index - subtreeStart)
+	if err := processDuringRead(f, int(subtreeStart), uint32(1<<liveLevel), dt); err != nil {
+		return mtree.Word{}, []mtree.Hash{}, err
+	}
+	// the last subtree is partial, pad it as in the whole tree
+	dt.FinalizeSubtree(1 << liveLevel)
 	element := dt.AllDAGElements()[0]
 	proof := dt.ProofsForRegisteredIndices()[0]
 	cacheIndex := index >> liveLevel
-	proof = append(proof, cache.Proofs[cacheIndex]...)
+	proof = append(proof, cache.Proof(uint64(cacheIndex))...)
 	return element, proof, nil
 }
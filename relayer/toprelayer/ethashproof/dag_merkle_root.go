@@ -5,9 +5,14 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
 
 	"toprelayer/relayer/toprelayer/ethash"
 	"toprelayer/relayer/toprelayer/mtree"
+
+	"github.com/wonderivan/logger"
 )
 
 func processDuringRead(f *os.File, startIn128Res int, fullSizeIn128Res uint32, mt *mtree.DagTree) error {
@@ -46,56 +51,134 @@ func processDuringRead(f *os.File, startIn128Res int, fullSizeIn128Res uint32, m
 // 3. If saveCache is true, save root merkle tree of 10 levels
 //    to disk
 // 4. Return merkle root
-func CalculateDatasetMerkleRoot(epoch uint64, saveCache bool) (mtree.Hash, error) {
-	blockno := epoch * 30000
-	path := ethash.PathToDAG(epoch, ethash.DefaultDir)
-	os.Remove(path)
-	ethash.MakeDAG(blockno, ethash.DefaultDir)
+func CalculateDatasetMerkleRoot(epoch ethash.Epoch, saveCache bool) (mtree.Hash, error) {
+	return DefaultStore.CalculateDatasetMerkleRoot(epoch, saveCache)
+}
 
-	dt := mtree.NewSHA256DagTree()
+// CalculateDatasetMerkleRoot makes room for the DAG of epoch within the
+// quota, sparing the DAGs of the keep epochs, before generating it.
+func (s *Store) CalculateDatasetMerkleRoot(epoch ethash.Epoch, saveCache bool, keep ...ethash.Epoch) (mtree.Hash, error) {
+	path := s.PathToDAG(epoch)
+	os.Remove(path)
+	if err := s.Reserve(epoch.DatasetSize(), append(keep, epoch)...); err != nil {
+		return mtree.Hash{}, err
+	}
+	epoch.MakeDAG(s.DagDir)
 
-	fullSize := ethash.DAGSize(blockno)
+	fullSize := epoch.DatasetSize()
 	fullSizeIn128Resolution := fullSize / 128
 	branchDepth := len(fmt.Sprintf("%b", fullSizeIn128Resolution-1))
-	dt.RegisterStoredLevel(uint32(branchDepth), uint32(0))
-	if saveCache {
-		indices := []uint32{}
-		for i := 0; i < 1<<CACHE_LEVEL; i++ {
-			eindex := i << (uint64(branchDepth) - CACHE_LEVEL)
-			if uint64(eindex) < fullSizeIn128Resolution {
-				indices = append(indices, uint32(eindex))
-			} else {
-				break
-			}
-		}
-		dt.RegisterIndex(indices...)
-	}
 
-	fmt.Printf("Calculating the proofs... Path to dag:%s\n", path)
+	logger.Info("Calculating the proofs... Path to dag:%s", path)
 	f, err := os.Open(path)
 	if err != nil {
 		return mtree.Hash{}, err
 	}
 	defer f.Close()
-	processDuringRead(f, 0, uint32(fullSizeIn128Resolution), dt)
-	dt.Finalize()
+	var reported uint64
+	root, proofs, err := datasetMerkleTree(f, fullSizeIn128Resolution, CACHE_LEVEL, saveCache, runtime.NumCPU(), func(done, total uint64) {
+		// every 10%, progress is called in order
+		if percent := done * 100 / total; percent >= reported+10 {
+			reported = percent / 10 * 10
+			logger.Info("epoch %v dataset merkle tree %v%%", epoch.Number, reported)
+		}
+	})
+	if err != nil {
+		return mtree.Hash{}, err
+	}
 	if saveCache {
 		result := &DatasetMerkleTreeCache{
-			Epoch:       epoch,
+			Epoch:       epoch.Number,
+			EpochLength: epoch.Length,
 			ProofLength: uint64(branchDepth),
 			CacheLength: CACHE_LEVEL,
-			RootHash:    dt.RootHash(),
-			Proofs:      [][]mtree.Hash{},
+			RootHash:    root,
+			Proofs:      proofs,
 		}
-		proofs := dt.ProofsForRegisteredIndices()
-		for _, proof := range proofs {
-			oneProof := proof[(uint64(branchDepth) - CACHE_LEVEL):len(proof)]
-			result.Proofs = append(result.Proofs, oneProof)
-		}
-		err = PersistCache(result)
+		err = s.PersistCache(result)
 		if err != nil {
 			return mtree.Hash{}, err
 		}
 	}
-	return dt.RootHash(), nil
+	return root, nil
+}
+
+// datasetMerkleTree builds the merkle tree of the n elements of the DAG in f
+// from 1<<cacheLevel subtrees hashed by concurrent workers. It returns the
+// root and with saveCache the proofs from each subtree root to the root.
+func datasetMerkleTree(f io.ReaderAt, n, cacheLevel uint64, saveCache bool, workers int, progress func(done, total uint64)) (mtree.Hash, [][]mtree.Hash, error) {
+	branchDepth := uint64(len(fmt.Sprintf("%b", n-1)))
+	if cacheLevel > branchDepth {
+		cacheLevel = branchDepth
+	}
+	leaves := uint64(1) << (branchDepth - cacheLevel)
+	subtrees := (n + leaves - 1) / leaves
+	roots := make([]mtree.Hash, subtrees)
+
+	var (
+		next, done uint64
+		wg         sync.WaitGroup
+		mu         sync.Mutex
+		firstErr   error
+	)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, leaves*128)
+			for {
+				i := atomic.AddUint64(&next, 1) - 1
+				if i >= subtrees {
+					return
+				}
+				start := i * leaves
+				count := leaves
+				if start+count > n {
+					count = n - start
+				}
+				if _, err := f.ReadAt(buf[:count*128], int64(8+start*128)); err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+					atomic.StoreUint64(&next, subtrees)
+					return
+				}
+				dt := mtree.NewSHA256DagTree()
+				for j := uint64(0); j < count; j++ {
+					var word mtree.Word
+					copy(word[:], buf[j*128:])
+					dt.Insert(word, uint32(j))
+				}
+				dt.FinalizeSubtree(uint32(leaves))
+				roots[i] = dt.RootHash()
+				if progress != nil {
+					mu.Lock()
+					done++
+					progress(done, subtrees)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return mtree.Hash{}, nil, firstErr
+	}
+
+	dt := mtree.NewSHA256DagTree()
+	if saveCache {
+		for i := range roots {
+			dt.RegisterIndex(uint32(i))
+		}
+	}
+	for i, root := range roots {
+		dt.InsertHash(root, uint32(i))
+	}
+	dt.Finalize()
+	if !saveCache {
+		return dt.RootHash(), nil, nil
+	}
+	return dt.RootHash(), dt.ProofsForRegisteredIndices(), nil
 }
@@ -0,0 +1,141 @@
+package ethashproof
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"toprelayer/relayer/toprelayer/mtree"
+)
+
+// syntheticDAG writes a dataset of n random elements after the 8 byte dump
+// header of a DAG file.
+func syntheticDAG(t testing.TB, n uint64) *os.File {
+	data := make([]byte, 8+n*128)
+	rand.New(rand.NewSource(int64(n))).Read(data)
+	path := filepath.Join(t.TempDir(), "full")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+// sequentialDatasetMerkleTree is the single reader implementation the
+// concurrent one replaced.
+func sequentialDatasetMerkleTree(f *os.File, n, cacheLevel uint64, saveCache bool) (mtree.Hash, [][]mtree.Hash) {
+	dt := mtree.NewSHA256DagTree()
+	branchDepth := uint64(len(fmt.Sprintf("%b", n-1)))
+	dt.RegisterStoredLevel(uint32(branchDepth), uint32(0))
+	if saveCache {
+		indices := []uint32{}
+		for i := 0; i < 1<<cacheLevel; i++ {
+			eindex := i << (branchDepth - cacheLevel)
+			if uint64(eindex) < n {
+				indices = append(indices, uint32(eindex))
+			} else {
+				break
+			}
+		}
+		dt.RegisterIndex(indices...)
+	}
+	processDuringRead(f, 0, uint32(n), dt)
+	dt.Finalize()
+	if !saveCache {
+		return dt.RootHash(), nil
+	}
+	var proofs [][]mtree.Hash
+	for _, proof := range dt.ProofsForRegisteredIndices() {
+		proofs = append(proofs, proof[branchDepth-cacheLevel:])
+	}
+	return dt.RootHash(), proofs
+}
+
+func TestDatasetMerkleTree(t *testing.T) {
+	tests := []struct {
+		n          uint64
+		cacheLevel uint64
+	}{
+		{1024, 4},
+		{1000, 4},
+		{1025, 4},
+		{3000, 6},
+		{777, 0},
+		{100, 7},
+	}
+	for _, test := range tests {
+		f := syntheticDAG(t, test.n)
+		wantRoot, wantProofs := sequentialDatasetMerkleTree(f, test.n, test.cacheLevel, true)
+		var calls, last uint64
+		root, proofs, err := datasetMerkleTree(f, test.n, test.cacheLevel, true, 3, func(done, total uint64) {
+			calls++
+			last = done
+			if done > total {
+				t.Errorf("n %v: progress %v beyond %v", test.n, done, total)
+			}
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if root != wantRoot {
+			t.Errorf("n %v: expect root %v, got %v", test.n, wantRoot, root)
+		}
+		if !reflect.DeepEqual(proofs, wantProofs) {
+			t.Errorf("n %v: unexpected proofs", test.n)
+		}
+		if calls == 0 || last != calls {
+			t.Errorf("n %v: unexpected progress %v of %v calls", test.n, last, calls)
+		}
+	}
+
+	f := syntheticDAG(t, 100)
+	if _, _, err := datasetMerkleTree(f, 200, 2, false, 2, nil); err == nil {
+		t.Fatal("expect error reading beyond the dataset")
+	}
+}
+
+func TestCalculateProof(t *testing.T) {
+	for _, n := range []uint64{1024, 1000, 777} {
+		f := syntheticDAG(t, n)
+		root, proofs, err := datasetMerkleTree(f, n, 4, true, 2, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cache := &DatasetMerkleTreeCache{RootHash: root, Proofs: proofs}
+		verifier := mtree.NewSHA256DagTree()
+		for index := uint32(0); uint64(index) < n; index++ {
+			element, proof, err := calculateProof(f, n, 4, index, cache)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !verifier.VerifyProof(element, index, proof, root) {
+				t.Fatalf("n %v: proof of %v rejected", n, index)
+			}
+		}
+	}
+}
+
+func BenchmarkDatasetMerkleTree(b *testing.B) {
+	const n = 1<<16 + 1000
+	f := syntheticDAG(b, n)
+	b.Run("sequential", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			sequentialDatasetMerkleTree(f, n, 8, true)
+		}
+	})
+	b.Run("parallel", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, _, err := datasetMerkleTree(f, n, 8, true, runtime.NumCPU(), nil); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
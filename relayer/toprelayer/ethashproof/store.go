@@ -0,0 +1,168 @@
+package ethashproof
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"toprelayer/errs"
+	"toprelayer/relayer/toprelayer/ethash"
+
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/wonderivan/logger"
+)
+
+var DefaultStore = NewStore("", "", 0)
+
+// Store keeps the DAGs and the dataset merkle tree caches of the proofs on
+// disk. With a quota the least recently used files are pruned to make room
+// for new ones.
+type Store struct {
+	DagDir   string
+	CacheDir string
+	Quota    uint64 // bytes, zero for unlimited
+
+	loaded *lru.Cache // caches in memory by epoch
+}
+
+// NewStore returns a store in the given dirs, empty dirs take the defaults.
+func NewStore(dagDir, cacheDir string, quota uint64) *Store {
+	if dagDir == "" {
+		dagDir = ethash.DefaultDir
+	}
+	if cacheDir == "" {
+		cacheDir = defaultCacheDir()
+	}
+	loaded, _ := lru.New(2)
+	return &Store{DagDir: dagDir, CacheDir: cacheDir, Quota: quota, loaded: loaded}
+}
+
+func (s *Store) PathToDAG(epoch ethash.Epoch) string {
+	return epoch.PathToDAG(s.DagDir)
+}
+
+// Remove deletes the DAG and the cache of epoch.
+func (s *Store) Remove(epoch ethash.Epoch) error {
+	s.loaded.Remove(epoch)
+	for _, path := range []string{s.PathToDAG(epoch), s.PathToCache(epoch), s.pathToJsonCache(epoch)} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+type storeFile struct {
+	path string
+	size uint64
+	used time.Time
+}
+
+// touch marks path as recently used.
+func touch(path string) {
+	now := time.Now()
+	os.Chtimes(path, now, now)
+}
+
+// files lists the DAGs and caches of the store, least recently used first.
+func (s *Store) files() ([]storeFile, error) {
+	var files []storeFile
+	patterns := []string{filepath.Join(s.DagDir, "full-R*"), filepath.Join(s.CacheDir, "*.bin"), filepath.Join(s.CacheDir, "*.json")}
+	for _, pattern := range patterns {
+		paths, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range paths {
+			info, err := os.Stat(path)
+			if err != nil || info.IsDir() {
+				continue
+			}
+			files = append(files, storeFile{path: path, size: uint64(info.Size()), used: info.ModTime()})
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].used.Before(files[j].used)
+	})
+	return files, nil
+}
+
+// Usage returns the bytes taken by the DAGs and caches of the store.
+func (s *Store) Usage() (uint64, error) {
+	files, err := s.files()
+	if err != nil {
+		return 0, err
+	}
+	var total uint64
+	for _, f := range files {
+		total += f.size
+	}
+	return total, nil
+}
+
+// Prune removes the least recently used DAGs and caches until the store
+// takes at most limit bytes, those of the keep epochs are spared. It fails
+// with ErrInsufficientSpace if the store still takes more.
+func (s *Store) Prune(limit uint64, keep ...ethash.Epoch) error {
+	files, err := s.files()
+	if err != nil {
+		return err
+	}
+	var total uint64
+	for _, f := range files {
+		total += f.size
+	}
+	kept := make(map[string]bool)
+	for _, epoch := range keep {
+		kept[s.PathToDAG(epoch)] = true
+		kept[s.PathToCache(epoch)] = true
+		kept[s.pathToJsonCache(epoch)] = true
+	}
+	for _, f := range files {
+		if total <= limit {
+			break
+		}
+		if kept[f.path] {
+			continue
+		}
+		if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		logger.Info("ethashproof pruned %v, %v bytes", f.path, f.size)
+		total -= f.size
+	}
+	usage, err := s.Usage()
+	if err != nil {
+		return err
+	}
+	if usage > limit {
+		return errs.Wrap(errs.ErrInsufficientSpace, fmt.Errorf("store takes %v bytes after pruning, limit is %v bytes", usage, limit))
+	}
+	return nil
+}
+
+// Reserve makes room for size bytes of new data, pruning the store to fit
+// the quota, and checks the free space of the DAG dir.
+func (s *Store) Reserve(size uint64, keep ...ethash.Epoch) error {
+	if err := os.MkdirAll(s.DagDir, 0755); err != nil {
+		return err
+	}
+	if s.Quota != 0 {
+		if size > s.Quota {
+			return errs.Wrap(errs.ErrInsufficientSpace, fmt.Errorf("need %v bytes, quota is %v bytes", size, s.Quota))
+		}
+		if err := s.Prune(s.Quota-size, keep...); err != nil {
+			return err
+		}
+	}
+	free, err := freeSpace(s.DagDir)
+	if err != nil {
+		return err
+	}
+	if free < size {
+		return errs.Wrap(errs.ErrInsufficientSpace, fmt.Errorf("need %v bytes, %v bytes free in %v", size, free, s.DagDir))
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package ethashproof
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"toprelayer/errs"
+	"toprelayer/relayer/toprelayer/ethash"
+)
+
+func writeFile(t *testing.T, path string, size int, used time.Time) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, make([]byte, size), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, used, used); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func TestStorePrune(t *testing.T) {
+	dir := t.TempDir()
+	s := NewStore(filepath.Join(dir, "dag"), filepath.Join(dir, "proof"), 0)
+	now := time.Now()
+	epochs := []ethash.Epoch{{Number: 195, Length: 60000}, {Number: 196, Length: 60000}, {Number: 197, Length: 60000}}
+	for i, epoch := range epochs {
+		used := now.Add(time.Duration(i-len(epochs)) * time.Hour)
+		writeFile(t, s.PathToDAG(epoch), 1000, used)
+		writeFile(t, s.PathToCache(epoch), 100, used)
+	}
+	// unrelated files are left alone
+	writeFile(t, filepath.Join(s.DagDir, "other"), 5000, now.Add(-10*time.Hour))
+
+	if usage, err := s.Usage(); err != nil || usage != 3300 {
+		t.Fatal("unexpected usage:", usage, err)
+	}
+	// the oldest epoch is spared, the next oldest goes
+	if err := s.Prune(2200, epochs[0]); err != nil {
+		t.Fatal(err)
+	}
+	if !s.ExistCache(epochs[0]) || s.ExistCache(epochs[1]) || !s.ExistCache(epochs[2]) {
+		t.Fatal("unexpected epochs pruned")
+	}
+	if !exists(filepath.Join(s.DagDir, "other")) {
+		t.Fatal("unrelated file pruned")
+	}
+
+	// a cache is useless without its DAG
+	if err := os.Remove(s.PathToDAG(epochs[2])); err != nil {
+		t.Fatal(err)
+	}
+	if s.ExistCache(epochs[2]) {
+		t.Fatal("expect missing DAG")
+	}
+	if _, err := s.LoadCache(epochs[2]); err == nil {
+		t.Fatal("expect error loading a cache without DAG")
+	}
+}
+
+func TestStoreReserve(t *testing.T) {
+	dir := t.TempDir()
+	s := NewStore(filepath.Join(dir, "dag"), filepath.Join(dir, "proof"), 2000)
+	old := ethash.Epoch{Number: 100, Length: 30000}
+	writeFile(t, s.PathToDAG(old), 1500, time.Now())
+
+	if err := s.Reserve(3000); !errors.Is(err, errs.ErrInsufficientSpace) {
+		t.Fatal("expect quota exceeded, got", err)
+	}
+	// the kept DAG leaves no room
+	if err := s.Reserve(1000, old); !errors.Is(err, errs.ErrInsufficientSpace) {
+		t.Fatal("expect quota exceeded by the kept DAG, got", err)
+	}
+	if !exists(s.PathToDAG(old)) {
+		t.Fatal("kept DAG pruned")
+	}
+	if err := s.Reserve(500, old); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Reserve(1000); err != nil {
+		t.Fatal(err)
+	}
+	if exists(s.PathToDAG(old)) {
+		t.Fatal("expect DAG pruned to fit the quota")
+	}
+	s.Quota = 0
+	if err := s.Reserve(1 << 62); !errors.Is(err, errs.ErrInsufficientSpace) {
+		t.Fatal("expect insufficient free space, got", err)
+	}
+}
@@ -0,0 +1,200 @@
+package ethtypes
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+	enginev1 "github.com/prysmaticlabs/prysm/v3/proto/engine/v1"
+)
+
+// ExecutionHeader is the execution layer block header rebuilt from a beacon
+// execution payload. It has the same rlp layout as types.Header, with the
+// withdrawals root appended after the base fee once Capella is active.
+type ExecutionHeader struct {
+	ParentHash  common.Hash      `json:"parentHash"`
+	UncleHash   common.Hash      `json:"sha3Uncles"`
+	Coinbase    common.Address   `json:"miner"`
+	Root        common.Hash      `json:"stateRoot"`
+	TxHash      common.Hash      `json:"transactionsRoot"`
+	ReceiptHash common.Hash      `json:"receiptsRoot"`
+	Bloom       types.Bloom      `json:"logsBloom"`
+	Difficulty  *big.Int         `json:"difficulty"`
+	Number      *big.Int         `json:"number"`
+	GasLimit    uint64           `json:"gasLimit"`
+	GasUsed     uint64           `json:"gasUsed"`
+	Time        uint64           `json:"timestamp"`
+	Extra       []byte           `json:"extraData"`
+	MixDigest   common.Hash      `json:"mixHash"`
+	Nonce       types.BlockNonce `json:"nonce"`
+
+	BaseFee         *big.Int     `json:"baseFeePerGas" rlp:"optional"`
+	WithdrawalsHash *common.Hash `json:"withdrawalsRoot" rlp:"optional"`
+}
+
+// Hash returns the keccak256 hash of the rlp encoded header, which is the
+// execution block hash.
+func (h *ExecutionHeader) Hash() common.Hash {
+	data, err := rlp.EncodeToBytes(h)
+	if err != nil {
+		panic("can't encode: " + err.Error())
+	}
+	return crypto.Keccak256Hash(data)
+}
+
+// rawList hashes already encoded items, such as the opaque transactions of
+// an execution payload.
+type rawList [][]byte
+
+func (l rawList) Len() int { return len(l) }
+
+func (l rawList) EncodeIndex(i int, w *bytes.Buffer) { w.Write(l[i]) }
+
+type withdrawalRlp struct {
+	Index     uint64
+	Validator uint64
+	Address   common.Address
+	Amount    uint64
+}
+
+func deriveWithdrawalsRoot(withdrawals []*enginev1.Withdrawal) (common.Hash, error) {
+	list := make(rawList, 0, len(withdrawals))
+	for _, w := range withdrawals {
+		if len(w.ExecutionAddress) != common.AddressLength {
+			return common.Hash{}, errors.New("invalid withdrawal address length")
+		}
+		b, err := rlp.EncodeToBytes(&withdrawalRlp{
+			Index:     w.WithdrawalIndex,
+			Validator: uint64(w.ValidatorIndex),
+			Address:   common.BytesToAddress(w.ExecutionAddress),
+			Amount:    w.Amount,
+		})
+		if err != nil {
+			return common.Hash{}, err
+		}
+		list = append(list, b)
+	}
+	return types.DeriveSha(list, trie.NewStackTrie(nil)), nil
+}
+
+// littleEndianToBig converts the ssz little endian uint256 to big.Int.
+func littleEndianToBig(b []byte) *big.Int {
+	be := make([]byte, len(b))
+	for i := range b {
+		be[len(b)-1-i] = b[i]
+	}
+	return new(big.Int).SetBytes(be)
+}
+
+func newExecutionHeader(
+	parentHash, feeRecipient, stateRoot, receiptsRoot, logsBloom, prevRandao []byte,
+	number, gasLimit, gasUsed, timestamp uint64,
+	extraData, baseFeePerGas []byte,
+	transactions [][]byte,
+) (*ExecutionHeader, error) {
+	if len(logsBloom) != types.BloomByteLength {
+		return nil, errors.New("invalid logs bloom length")
+	}
+	return &ExecutionHeader{
+		ParentHash:  common.BytesToHash(parentHash),
+		UncleHash:   types.EmptyUncleHash,
+		Coinbase:    common.BytesToAddress(feeRecipient),
+		Root:        common.BytesToHash(stateRoot),
+		TxHash:      types.DeriveSha(rawList(transactions), trie.NewStackTrie(nil)),
+		ReceiptHash: common.BytesToHash(receiptsRoot),
+		Bloom:       types.BytesToBloom(logsBloom),
+		Difficulty:  big.NewInt(0),
+		Number:      new(big.Int).SetUint64(number),
+		GasLimit:    gasLimit,
+		GasUsed:     gasUsed,
+		Time:        timestamp,
+		Extra:       extraData,
+		MixDigest:   common.BytesToHash(prevRandao),
+		Nonce:       types.BlockNonce{},
+		BaseFee:     littleEndianToBig(baseFeePerGas),
+	}, nil
+}
+
+func checkBlockHash(h *ExecutionHeader, blockHash []byte) error {
+	expect := common.BytesToHash(blockHash)
+	if hash := h.Hash(); hash != expect {
+		return fmt.Errorf("execution header hash mismatch, built %v, payload %v", hash, expect)
+	}
+	return nil
+}
+
+// ExecutionHeaderFromPayload rebuilds the execution header of a Bellatrix
+// payload and checks it against the payload block hash.
+func ExecutionHeaderFromPayload(payload *enginev1.ExecutionPayload) (*ExecutionHeader, error) {
+	if payload == nil {
+		return nil, errors.New("execution payload nil")
+	}
+	h, err := newExecutionHeader(
+		payload.ParentHash, payload.FeeRecipient, payload.StateRoot, payload.ReceiptsRoot, payload.LogsBloom, payload.PrevRandao,
+		payload.BlockNumber, payload.GasLimit, payload.GasUsed, payload.Timestamp,
+		payload.ExtraData, payload.BaseFeePerGas,
+		payload.Transactions,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkBlockHash(h, payload.BlockHash); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// ExecutionHeaderFromPayloadCapella rebuilds the execution header of a
+// Capella payload, including the withdrawals root, and checks it against the
+// payload block hash.
+func ExecutionHeaderFromPayloadCapella(payload *enginev1.ExecutionPayloadCapella) (*ExecutionHeader, error) {
+	if payload == nil {
+		return nil, errors.New("execution payload nil")
+	}
+	h, err := newExecutionHeader(
+		payload.ParentHash, payload.FeeRecipient, payload.StateRoot, payload.ReceiptsRoot, payload.LogsBloom, payload.PrevRandao,
+		payload.BlockNumber, payload.GasLimit, payload.GasUsed, payload.Timestamp,
+		payload.ExtraData, payload.BaseFeePerGas,
+		payload.Transactions,
+	)
+	if err != nil {
+		return nil, err
+	}
+	root, err := deriveWithdrawalsRoot(payload.Withdrawals)
+	if err != nil {
+		return nil, err
+	}
+	h.WithdrawalsHash = &root
+	if err := checkBlockHash(h, payload.BlockHash); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// ExecutionHeaderFromHeader wraps a header fetched from an execution node.
+func ExecutionHeaderFromHeader(header *types.Header) *ExecutionHeader {
+	return &ExecutionHeader{
+		ParentHash:  header.ParentHash,
+		UncleHash:   header.UncleHash,
+		Coinbase:    header.Coinbase,
+		Root:        header.Root,
+		TxHash:      header.TxHash,
+		ReceiptHash: header.ReceiptHash,
+		Bloom:       header.Bloom,
+		Difficulty:  header.Difficulty,
+		Number:      header.Number,
+		GasLimit:    header.GasLimit,
+		GasUsed:     header.GasUsed,
+		Time:        header.Time,
+		Extra:       header.Extra,
+		MixDigest:   header.MixDigest,
+		Nonce:       header.Nonce,
+		BaseFee:     header.BaseFee,
+	}
+}
@@ -0,0 +1,164 @@
+package ethtypes
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+	enginev1 "github.com/prysmaticlabs/prysm/v3/proto/engine/v1"
+)
+
+func testHeaderAndPayload(t *testing.T) (*types.Header, *enginev1.ExecutionPayload) {
+	var txs types.Transactions
+	var rawTxs [][]byte
+	for i := 0; i < 3; i++ {
+		tx := types.NewTx(&types.DynamicFeeTx{
+			ChainID:   big.NewInt(1),
+			Nonce:     uint64(i),
+			GasTipCap: big.NewInt(1e9),
+			GasFeeCap: big.NewInt(3e10),
+			Gas:       21000,
+			To:        &common.Address{0x01},
+			Value:     big.NewInt(int64(i)),
+		})
+		raw, err := tx.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		txs = append(txs, tx)
+		rawTxs = append(rawTxs, raw)
+	}
+	header := &types.Header{
+		ParentHash:  common.HexToHash("0x01"),
+		UncleHash:   types.EmptyUncleHash,
+		Coinbase:    common.HexToAddress("0x02"),
+		Root:        common.HexToHash("0x03"),
+		TxHash:      types.DeriveSha(txs, trie.NewStackTrie(nil)),
+		ReceiptHash: common.HexToHash("0x04"),
+		Difficulty:  big.NewInt(0),
+		Number:      big.NewInt(16000000),
+		GasLimit:    30000000,
+		GasUsed:     63000,
+		Time:        1668000000,
+		Extra:       []byte("top relayer"),
+		MixDigest:   common.HexToHash("0x05"),
+		BaseFee:     big.NewInt(12345678901),
+	}
+	baseFee := make([]byte, 32)
+	be := header.BaseFee.Bytes()
+	for i := range be {
+		baseFee[i] = be[len(be)-1-i]
+	}
+	payload := &enginev1.ExecutionPayload{
+		ParentHash:    header.ParentHash.Bytes(),
+		FeeRecipient:  header.Coinbase.Bytes(),
+		StateRoot:     header.Root.Bytes(),
+		ReceiptsRoot:  header.ReceiptHash.Bytes(),
+		LogsBloom:     header.Bloom.Bytes(),
+		PrevRandao:    header.MixDigest.Bytes(),
+		BlockNumber:   header.Number.Uint64(),
+		GasLimit:      header.GasLimit,
+		GasUsed:       header.GasUsed,
+		Timestamp:     header.Time,
+		ExtraData:     header.Extra,
+		BaseFeePerGas: baseFee,
+		BlockHash:     header.Hash().Bytes(),
+		Transactions:  rawTxs,
+	}
+	return header, payload
+}
+
+func TestExecutionHeaderFromPayload(t *testing.T) {
+	header, payload := testHeaderAndPayload(t)
+
+	h, err := ExecutionHeaderFromPayload(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.TxHash != header.TxHash {
+		t.Fatal("transactions root mismatch")
+	}
+	if h.Hash() != header.Hash() {
+		t.Fatal("header hash mismatch")
+	}
+	if ExecutionHeaderFromHeader(header).Hash() != header.Hash() {
+		t.Fatal("wrapped header hash mismatch")
+	}
+
+	payload.GasUsed += 1
+	if _, err := ExecutionHeaderFromPayload(payload); err == nil {
+		t.Fatal("expect block hash mismatch error")
+	}
+}
+
+func TestDeriveWithdrawalsRoot(t *testing.T) {
+	root, err := deriveWithdrawalsRoot(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root != types.EmptyRootHash {
+		t.Fatal("empty withdrawals root mismatch:", root)
+	}
+	_, err = deriveWithdrawalsRoot([]*enginev1.Withdrawal{{WithdrawalIndex: 1, ExecutionAddress: []byte{0x01}}})
+	if err == nil {
+		t.Fatal("expect invalid address error")
+	}
+}
+
+func TestExecutionHeaderFromPayloadCapella(t *testing.T) {
+	header, bellatrix := testHeaderAndPayload(t)
+	withdrawals := []*enginev1.Withdrawal{
+		{WithdrawalIndex: 0, ValidatorIndex: 7, ExecutionAddress: common.HexToAddress("0x06").Bytes(), Amount: 1},
+		{WithdrawalIndex: 1, ValidatorIndex: 8, ExecutionAddress: common.HexToAddress("0x07").Bytes(), Amount: 2000000},
+		{WithdrawalIndex: 2, ValidatorIndex: 9, ExecutionAddress: common.HexToAddress("0x08").Bytes(), Amount: 32000000000},
+	}
+	// the withdrawals trie built key by key, as execution clients do
+	tr := trie.NewEmpty(trie.NewDatabase(rawdb.NewMemoryDatabase()))
+	for i, w := range withdrawals {
+		key, _ := rlp.EncodeToBytes(uint64(i))
+		value, _ := rlp.EncodeToBytes([]interface{}{w.WithdrawalIndex, uint64(w.ValidatorIndex), common.BytesToAddress(w.ExecutionAddress), w.Amount})
+		if err := tr.TryUpdate(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	root := tr.Hash()
+	expect := ExecutionHeaderFromHeader(header)
+	expect.WithdrawalsHash = &root
+
+	payload := &enginev1.ExecutionPayloadCapella{
+		ParentHash:    bellatrix.ParentHash,
+		FeeRecipient:  bellatrix.FeeRecipient,
+		StateRoot:     bellatrix.StateRoot,
+		ReceiptsRoot:  bellatrix.ReceiptsRoot,
+		LogsBloom:     bellatrix.LogsBloom,
+		PrevRandao:    bellatrix.PrevRandao,
+		BlockNumber:   bellatrix.BlockNumber,
+		GasLimit:      bellatrix.GasLimit,
+		GasUsed:       bellatrix.GasUsed,
+		Timestamp:     bellatrix.Timestamp,
+		ExtraData:     bellatrix.ExtraData,
+		BaseFeePerGas: bellatrix.BaseFeePerGas,
+		BlockHash:     expect.Hash().Bytes(),
+		Transactions:  bellatrix.Transactions,
+		Withdrawals:   withdrawals,
+	}
+	h, err := ExecutionHeaderFromPayloadCapella(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.WithdrawalsHash == nil || *h.WithdrawalsHash != root {
+		t.Fatal("withdrawals root mismatch:", h.WithdrawalsHash, root)
+	}
+	if h.Hash() == header.Hash() {
+		t.Fatal("expect the withdrawals root in the block hash")
+	}
+
+	payload.Withdrawals[1].Amount += 1
+	if _, err := ExecutionHeaderFromPayloadCapella(payload); err == nil {
+		t.Fatal("expect block hash mismatch error")
+	}
+}
@@ -0,0 +1,139 @@
+// Package headerbatch fetches block headers with json-rpc batch requests,
+// limiting the request rate to the source chain node.
+package headerbatch
+
+import (
+	"context"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+const (
+	DEFAULT_BATCH_SIZE uint64  = 32
+	DEFAULT_RATE_LIMIT float64 = 10 // requests per second, a batch counts as one
+)
+
+type Fetcher struct {
+	client   *rpc.Client
+	size     uint64
+	interval time.Duration
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+// New creates a fetcher sending at most size headers per batch and rate
+// requests per second, zero values use the defaults and a negative rate
+// disables the limit.
+func New(client *rpc.Client, size uint64, rate float64) *Fetcher {
+	if size == 0 {
+		size = DEFAULT_BATCH_SIZE
+	}
+	if rate == 0 {
+		rate = DEFAULT_RATE_LIMIT
+	}
+	f := &Fetcher{client: client, size: size}
+	if rate > 0 {
+		f.interval = time.Duration(float64(time.Second) / rate)
+	}
+	return f
+}
+
+func (f *Fetcher) BatchSize() uint64 {
+	return f.size
+}
+
+// wait blocks until the next request is allowed.
+func (f *Fetcher) wait(ctx context.Context) error {
+	f.mu.Lock()
+	now := time.Now()
+	at := f.next
+	if at.Before(now) {
+		at = now
+	}
+	f.next = at.Add(f.interval)
+	f.mu.Unlock()
+
+	if d := time.Until(at); d > 0 {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// HeadersByNumber returns the headers from lo to hi in order. It stops at the
+// first missing header and returns ethereum.NotFound if lo is missing.
+func (f *Fetcher) HeadersByNumber(ctx context.Context, lo, hi uint64) ([]*types.Header, error) {
+	var headers []*types.Header
+	for start := lo; start <= hi; start += f.size {
+		end := start + f.size - 1
+		if end > hi || end < start {
+			end = hi
+		}
+		batch := make([]rpc.BatchElem, 0, end-start+1)
+		results := make([]*types.Header, end-start+1)
+		for i := range results {
+			batch = append(batch, rpc.BatchElem{
+				Method: "eth_getBlockByNumber",
+				Args:   []interface{}{hexutil.EncodeBig(new(big.Int).SetUint64(start + uint64(i))), false},
+				Result: &results[i],
+			})
+		}
+		if err := f.wait(ctx); err != nil {
+			return nil, err
+		}
+		if err := f.client.BatchCallContext(ctx, batch); err != nil {
+			return nil, err
+		}
+		for i := range batch {
+			if batch[i].Error != nil {
+				return nil, batch[i].Error
+			}
+			if results[i] == nil {
+				if len(headers) == 0 {
+					return nil, ethereum.NotFound
+				}
+				return headers, nil
+			}
+			headers = append(headers, results[i])
+		}
+		if end == hi {
+			break
+		}
+	}
+	return headers, nil
+}
+
+func (f *Fetcher) HeaderByNumber(ctx context.Context, number uint64) (*types.Header, error) {
+	headers, err := f.HeadersByNumber(ctx, number, number)
+	if err != nil {
+		return nil, err
+	}
+	return headers[0], nil
+}
+
+func (f *Fetcher) HeaderByHash(ctx context.Context, hash common.Hash) (*types.Header, error) {
+	if err := f.wait(ctx); err != nil {
+		return nil, err
+	}
+	var head *types.Header
+	if err := f.client.CallContext(ctx, &head, "eth_getBlockByHash", hash, false); err != nil {
+		return nil, err
+	}
+	if head == nil {
+		return nil, ethereum.NotFound
+	}
+	return head, nil
+}
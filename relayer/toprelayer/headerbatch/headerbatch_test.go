@@ -0,0 +1,126 @@
+package headerbatch
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+type fakeChain struct {
+	headers []*types.Header
+}
+
+func (c *fakeChain) GetBlockByNumber(number rpc.BlockNumber, full bool) (*types.Header, error) {
+	if number < 0 || int(number) >= len(c.headers) {
+		return nil, nil
+	}
+	return c.headers[number], nil
+}
+
+func (c *fakeChain) GetBlockByHash(hash common.Hash, full bool) (*types.Header, error) {
+	for _, h := range c.headers {
+		if h.Hash() == hash {
+			return h, nil
+		}
+	}
+	return nil, nil
+}
+
+// newFetcher serves length headers over http and counts the requests.
+func newFetcher(t *testing.T, length int, size uint64, rate float64) (*Fetcher, *fakeChain, *int32) {
+	chain := new(fakeChain)
+	for i := 0; i < length; i++ {
+		chain.headers = append(chain.headers, &types.Header{
+			Number:     big.NewInt(int64(i)),
+			Difficulty: big.NewInt(1),
+			Extra:      []byte{byte(i)},
+		})
+	}
+	server := rpc.NewServer()
+	if err := server.RegisterName("eth", chain); err != nil {
+		t.Fatal(err)
+	}
+	var requests int32
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		server.ServeHTTP(w, r)
+	}))
+	t.Cleanup(func() {
+		httpServer.Close()
+		server.Stop()
+	})
+	client, err := rpc.Dial(httpServer.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(client, size, rate), chain, &requests
+}
+
+func TestHeadersByNumber(t *testing.T) {
+	fetcher, chain, requests := newFetcher(t, 100, 8, -1)
+	headers, err := fetcher.HeadersByNumber(context.Background(), 10, 29)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(headers) != 20 {
+		t.Fatal("unexpected headers:", len(headers))
+	}
+	for i, h := range headers {
+		if h.Hash() != chain.headers[10+i].Hash() {
+			t.Fatal("unexpected header at", i)
+		}
+	}
+	if n := atomic.LoadInt32(requests); n != 3 {
+		t.Fatal("expect 3 batches, got", n)
+	}
+
+	header, err := fetcher.HeaderByHash(context.Background(), chain.headers[42].Hash())
+	if err != nil || header.Number.Uint64() != 42 {
+		t.Fatal("HeaderByHash:", header, err)
+	}
+}
+
+func TestHeadersByNumberMissing(t *testing.T) {
+	fetcher, _, _ := newFetcher(t, 10, 4, -1)
+	headers, err := fetcher.HeadersByNumber(context.Background(), 5, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(headers) != 5 {
+		t.Fatal("expect headers up to the head, got", len(headers))
+	}
+	if _, err := fetcher.HeadersByNumber(context.Background(), 10, 20); !errors.Is(err, ethereum.NotFound) {
+		t.Fatal("expect not found, got", err)
+	}
+	if _, err := fetcher.HeaderByHash(context.Background(), common.Hash{1}); !errors.Is(err, ethereum.NotFound) {
+		t.Fatal("expect not found, got", err)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	fetcher, _, _ := newFetcher(t, 10, 2, 20)
+	start := time.Now()
+	if _, err := fetcher.HeadersByNumber(context.Background(), 0, 7); err != nil {
+		t.Fatal(err)
+	}
+	// 4 batches, the first is sent at once
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Fatal("rate limit not applied:", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := fetcher.HeadersByNumber(ctx, 0, 1); !errors.Is(err, context.Canceled) {
+		t.Fatal("expect canceled, got", err)
+	}
+}
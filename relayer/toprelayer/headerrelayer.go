@@ -0,0 +1,533 @@
+package toprelayer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+	"toprelayer/config"
+	ethbridge "toprelayer/contract/top/ethclient"
+	"toprelayer/errs"
+	"toprelayer/relayer/monitor"
+	"toprelayer/relayer/toprelayer/bor"
+	"toprelayer/relayer/toprelayer/clique"
+	"toprelayer/relayer/toprelayer/congress"
+	"toprelayer/relayer/toprelayer/ethash"
+	"toprelayer/relayer/toprelayer/ethashproof"
+	"toprelayer/relayer/toprelayer/headerbatch"
+	"toprelayer/relayer/toprelayer/parlia"
+	"toprelayer/relayer/txmanager"
+	"toprelayer/rpcdial"
+	"toprelayer/wallet"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/wonderivan/logger"
+)
+
+const (
+	POSA_ENGINE_PARLIA   string = "parlia"
+	POSA_ENGINE_CONGRESS string = "congress"
+	POSA_ENGINE_BOR      string = "bor"
+	POSA_ENGINE_CLIQUE   string = "clique"
+	POW_ENGINE_ETCHASH   string = "etchash"
+)
+
+var (
+	bscClientContract  = common.HexToAddress("0xff00000000000000000000000000000000000003")
+	hecoClientContract = common.HexToAddress("0xff00000000000000000000000000000000000004")
+
+	chainProfiles = map[string]*ChainProfile{
+		config.BSC_CHAIN:  {Engine: POSA_ENGINE_PARLIA, Contract: bscClientContract, ChainId: big.NewInt(56)},
+		config.HECO_CHAIN: {Engine: POSA_ENGINE_CONGRESS, Contract: hecoClientContract},
+		// no system contract yet, set topcontract in the config
+		config.POLYGON_CHAIN: {Engine: POSA_ENGINE_BOR, ChainId: big.NewInt(137)},
+		config.ETC_CHAIN:     {Engine: POW_ENGINE_ETCHASH},
+	}
+)
+
+// ChainProfile describes a source chain whose headers are relayed to TOP.
+type ChainProfile struct {
+	Engine   string            // consensus engine, parlia, congress, bor, clique or etchash
+	Contract common.Address    // client contract on TOP
+	ChainId  *big.Int          // chain id signed into parlia seals, it selects the default forks
+	Epoch    uint64            // clique vote reset interval, zero for the default
+	Forks    map[string]uint64 // hard fork heights overriding the defaults of the chain id
+}
+
+// ChainEngine is the consensus engine of a source chain, it tracks the state
+// submitted along with each header, like the validator snapshots of PoSA
+// chains or the DAG proofs of etchash.
+type ChainEngine interface {
+	Init(height uint64) error
+	GetLastSnapBytes(header *types.Header) ([]byte, error)
+	VerifyHeader(header, parent *types.Header) error
+	// Apply moves the state past header, once it is submitted to TOP.
+	Apply(header *types.Header) error
+	Rollback(number uint64)
+}
+
+// IHeadTracker is implemented by engines which prepare for the blocks
+// ahead of the source head.
+type IHeadTracker interface {
+	SetHead(height uint64)
+}
+
+// HeaderRelayer relays the headers of a source chain to TOP, verified and
+// completed by the engine of its consensus: Parlia, Congress, Bor, Clique or
+// etchash.
+type HeaderRelayer struct {
+	name          string
+	profile       *ChainProfile
+	wallet        *wallet.Wallet
+	ethsdk        *ethclient.Client
+	transactor    *ethbridge.EthClientTransactor
+	callerSession *ethbridge.EthClientCallerSession
+	engine        ChainEngine
+	chainCfg      *config.Relayer
+	headers       *headerbatch.Fetcher
+	monitor       *monitor.Monitor
+	txm           *txmanager.Manager
+}
+
+func NewHeaderRelayer(name string) *HeaderRelayer {
+	return &HeaderRelayer{name: name}
+}
+
+// chainProfile returns the builtin profile of name overridden by cfg.
+func chainProfile(name string, cfg *config.Relayer) (*ChainProfile, error) {
+	profile := new(ChainProfile)
+	if p, ok := chainProfiles[name]; ok {
+		*profile = *p
+	}
+	if cfg != nil {
+		if cfg.Engine != "" {
+			profile.Engine = cfg.Engine
+		}
+		if cfg.TopContract != "" {
+			if !common.IsHexAddress(cfg.TopContract) {
+				return nil, fmt.Errorf("invalid topcontract %v", cfg.TopContract)
+			}
+			profile.Contract = common.HexToAddress(cfg.TopContract)
+		}
+		if cfg.ChainId != 0 {
+			profile.ChainId = new(big.Int).SetUint64(cfg.ChainId)
+		}
+		if cfg.Epoch != 0 {
+			profile.Epoch = cfg.Epoch
+		}
+		if len(cfg.Forks) != 0 {
+			profile.Forks = cfg.Forks
+		}
+	}
+	switch profile.Engine {
+	case POSA_ENGINE_PARLIA, POSA_ENGINE_CONGRESS, POSA_ENGINE_BOR, POSA_ENGINE_CLIQUE, POW_ENGINE_ETCHASH:
+	default:
+		return nil, fmt.Errorf("unknown engine %q of %v", profile.Engine, name)
+	}
+	if profile.Contract == (common.Address{}) {
+		return nil, fmt.Errorf("no topcontract of %v", name)
+	}
+	if profile.Engine == POSA_ENGINE_PARLIA && profile.ChainId == nil {
+		return nil, fmt.Errorf("no chainid of %v", name)
+	}
+	return profile, nil
+}
+
+// parliaConfig returns the parlia config of the chain id with the forks of
+// profile.
+func parliaConfig(profile *ChainProfile) (*parlia.ChainConfig, error) {
+	chainConfig := parlia.DefaultChainConfig(profile.ChainId)
+	if err := chainConfig.SetForks(profile.Forks); err != nil {
+		return nil, err
+	}
+	return chainConfig, nil
+}
+
+// borConfig returns the bor config of the chain id with the forks of profile.
+func borConfig(profile *ChainProfile) (*bor.ChainConfig, error) {
+	chainConfig := bor.DefaultChainConfig(profile.ChainId)
+	if err := chainConfig.SetForks(profile.Forks); err != nil {
+		return nil, err
+	}
+	return chainConfig, nil
+}
+
+// newChainEngine returns the engine of profile for the relayer of name, its
+// snapshots are stored in a database named after the relayer.
+func newChainEngine(name string, profile *ChainProfile, headers *headerbatch.Fetcher, cfg *config.Relayer) (ChainEngine, error) {
+	if profile.Engine == POSA_ENGINE_BOR {
+		if cfg == nil || cfg.Heimdall == "" {
+			return nil, fmt.Errorf("no heimdall url")
+		}
+		chainConfig, err := borConfig(profile)
+		if err != nil {
+			return nil, err
+		}
+		client, err := rpcdial.HttpClient(cfg.Heimdall)
+		if err != nil {
+			return nil, err
+		}
+		engine := bor.New(headers, bor.NewHeimdallClient(cfg.Heimdall, client))
+		engine.SetConfig(chainConfig)
+		return engine, nil
+	}
+	if profile.Engine == POW_ENGINE_ETCHASH {
+		store := ethashproof.NewStore("", "", 0)
+		if cfg != nil {
+			store = ethashproof.NewStore(cfg.DagDir, cfg.ProofDir, cfg.DiskQuota<<20)
+		}
+		return newPowEngine(ethash.EtchashParams, store), nil
+	}
+	var chainConfig *parlia.ChainConfig
+	if profile.Engine == POSA_ENGINE_PARLIA {
+		var err error
+		if chainConfig, err = parliaConfig(profile); err != nil {
+			return nil, err
+		}
+	}
+	db, err := openSnapshotDatabase(cfg, strings.ToLower(name))
+	if err != nil {
+		return nil, err
+	}
+	switch profile.Engine {
+	case POSA_ENGINE_PARLIA:
+		engine := parlia.New(headers, db)
+		engine.SetConfig(chainConfig)
+		return engine, nil
+	case POSA_ENGINE_CLIQUE:
+		return clique.New(headers, db, profile.Epoch), nil
+	}
+	return congress.New(headers, db), nil
+}
+
+func (relayer *HeaderRelayer) SetChainConfig(cfg *config.Relayer) {
+	relayer.chainCfg = cfg
+}
+
+func (relayer *HeaderRelayer) Init(cfg *config.Relayer, listenUrl []string, pass string) error {
+	profile, err := chainProfile(relayer.name, relayer.chainCfg)
+	if err != nil {
+		logger.Error("HeaderRelayer", relayer.name, "profile error:", err)
+		return err
+	}
+	relayer.profile = profile
+
+	w, err := wallet.NewEthWallet(cfg.Url[0], listenUrl[0], cfg.KeyPath, pass)
+	if err != nil {
+		logger.Error("HeaderRelayer", relayer.name, "NewWallet error:", err)
+		return err
+	}
+	relayer.wallet = w
+
+	rpcclient, err := rpcdial.DialRpc(listenUrl[0])
+	if err != nil {
+		logger.Error("HeaderRelayer", relayer.name, "ethsdk create error:", err)
+		return err
+	}
+
+	relayer.ethsdk = ethclient.NewClient(rpcclient)
+	if relayer.chainCfg != nil {
+		relayer.headers = headerbatch.New(rpcclient, relayer.chainCfg.HeaderBatch, relayer.chainCfg.HeaderRate)
+	} else {
+		relayer.headers = headerbatch.New(rpcclient, 0, 0)
+	}
+
+	topethlient, err := rpcdial.DialEth(cfg.Url[0])
+	if err != nil {
+		logger.Error("HeaderRelayer", relayer.name, "new topethlient error:", err)
+		return err
+	}
+	relayer.transactor, err = ethbridge.NewEthClientTransactor(profile.Contract, topethlient)
+	if err != nil {
+		logger.Error("HeaderRelayer", relayer.name, "NewEthClientTransactor error:", err)
+		return err
+	}
+
+	relayer.callerSession = new(ethbridge.EthClientCallerSession)
+	relayer.callerSession.Contract, err = ethbridge.NewEthClientCaller(profile.Contract, topethlient)
+	if err != nil {
+		logger.Error("HeaderRelayer", relayer.name, "NewEthClientCaller error:", err)
+		return err
+	}
+	relayer.callerSession.CallOpts = bind.CallOpts{
+		Pending:     false,
+		From:        relayer.wallet.Address(),
+		BlockNumber: nil,
+		Context:     context.Background(),
+	}
+
+	relayer.engine, err = newChainEngine(relayer.name, profile, relayer.headers, relayer.chainCfg)
+	if err != nil {
+		logger.Error("HeaderRelayer", relayer.name, "newChainEngine error:", err)
+		return err
+	}
+
+	relayer.monitor, err = monitor.New(relayer.name+"2TOP", config.TOP_CHAIN, relayer.wallet.Address(), cfg.Url[0])
+	if err != nil {
+		logger.Error("HeaderRelayer", relayer.name, "New monitor error:", err)
+		return err
+	}
+	relayer.txm, err = txmanager.New(relayer.name+"2TOP", relayer.wallet, cfg, relayer.monitor.AddTx)
+	if err != nil {
+		logger.Error("HeaderRelayer", relayer.name, "New txmanager error:", err)
+		return err
+	}
+	return nil
+}
+
+func (et *HeaderRelayer) submitEthHeader(header []byte) error {
+	nonce, err := et.txm.Nonce(context.Background())
+	if err != nil {
+		logger.Error("HeaderRelayer", et.name, "NonceAt error:", err)
+		return err
+	}
+	gaspric, err := et.wallet.SuggestGasPrice(context.Background())
+	if err != nil {
+		logger.Error("HeaderRelayer", et.name, "SuggestGasPrice error:", err)
+		return err
+	}
+	packHeader, err := ethbridge.PackSyncParam(header)
+	if err != nil {
+		logger.Error("HeaderRelayer", et.name, "PackSyncParam error:", err)
+		return err
+	}
+	gaslimit, err := et.wallet.EstimateGas(context.Background(), &et.profile.Contract, packHeader)
+	if err != nil {
+		logger.Error("HeaderRelayer", et.name, "EstimateGas error:", err)
+		return err
+	}
+	//must init ops as bellow
+	ops := &bind.TransactOpts{
+		From:      et.wallet.Address(),
+		Nonce:     big.NewInt(0).SetUint64(nonce),
+		GasLimit:  gaslimit,
+		GasFeeCap: gaspric,
+		GasTipCap: big.NewInt(0),
+		Signer:    et.signTransaction,
+		Context:   context.Background(),
+		NoSend:    false,
+	}
+	sigTx, err := et.transactor.Sync(ops, header)
+	if err != nil {
+		logger.Error("HeaderRelayer", et.name, "sync error:", err)
+		return errs.Classify(err)
+	}
+	et.txm.Track(sigTx)
+	et.monitor.AddTx(sigTx.Hash())
+	logger.Info("HeaderRelayer %v tx info, account[%v] nonce:%v,capfee:%v,hash:%v,size:%v", et.name, et.wallet.Address(), nonce, gaspric, sigTx.Hash(), len(header))
+	return nil
+}
+
+// callback function to sign tx before send.
+func (et *HeaderRelayer) signTransaction(addr common.Address, tx *types.Transaction) (*types.Transaction, error) {
+	acc := et.wallet.Address()
+	if strings.EqualFold(acc.Hex(), addr.Hex()) {
+		stx, err := et.wallet.SignTx(tx)
+		if err != nil {
+			return nil, err
+		}
+		return stx, nil
+	}
+	return nil, fmt.Errorf("TopRelayer address:%v not available", addr)
+}
+
+// Stop stops replacing the stuck transactions.
+func (et *HeaderRelayer) Stop() {
+	if et.txm != nil {
+		et.txm.Stop()
+	}
+	// engines with a snapshot database release it
+	if closer, ok := et.engine.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			logger.Error("HeaderRelayer", et.name, "close engine error:", err)
+		}
+	}
+}
+
+func (et *HeaderRelayer) StartRelayer(wg *sync.WaitGroup) error {
+	logger.Info("HeaderRelayer %v start... engine: %v subBatch: %v certaintyBlocks: %v", et.name, et.profile.Engine, BATCH_NUM, CONFIRM_NUM)
+	defer wg.Done()
+	defer et.Stop()
+
+	done := make(chan error)
+	defer close(done)
+
+	go func(done chan error) {
+		timeoutDuration := time.Duration(FATALTIMEOUT) * time.Hour
+		timeout := time.NewTimer(timeoutDuration)
+		defer timeout.Stop()
+		logger.Debug("HeaderRelayer %v set timeout: %v hours", et.name, FATALTIMEOUT)
+		var delay time.Duration = time.Duration(1)
+
+		for {
+			destHeight, err := et.callerSession.GetHeight()
+			if err != nil {
+				logger.Error("HeaderRelayer", et.name, "get height error:", err)
+				time.Sleep(time.Second * time.Duration(ERRDELAY))
+				continue
+			}
+			logger.Info("HeaderRelayer", et.name, "check dest top Height:", destHeight)
+			if destHeight != 0 {
+				err = et.engine.Init(destHeight)
+				if err == nil {
+					break
+				} else {
+					logger.Error("HeaderRelayer", et.name, "engine init error:", err)
+				}
+			} else {
+				logger.Info("HeaderRelayer", et.name, "not init yet")
+			}
+			time.Sleep(time.Second * time.Duration(ERRDELAY))
+		}
+
+		for {
+			time.Sleep(time.Second * delay)
+			select {
+			case <-timeout.C:
+				done <- errFatalTimeout
+				return
+			default:
+				destHeight, err := et.callerSession.GetHeight()
+				if err != nil {
+					logger.Error("HeaderRelayer", et.name, "get height error:", err)
+					delay = time.Duration(ERRDELAY)
+					break
+				}
+				logger.Info("HeaderRelayer", et.name, "check dest top Height:", destHeight)
+				if destHeight == 0 {
+					if set := timeout.Reset(timeoutDuration); !set {
+						logger.Error("HeaderRelayer", et.name, "reset timeout falied!")
+						delay = time.Duration(ERRDELAY)
+						break
+					}
+					logger.Info("HeaderRelayer", et.name, "not init yet")
+					delay = time.Duration(ERRDELAY)
+					break
+				}
+				srcHeight, err := et.ethsdk.BlockNumber(context.Background())
+				if err != nil {
+					logger.Error("HeaderRelayer", et.name, "get number error:", err)
+					delay = time.Duration(ERRDELAY)
+					break
+				}
+				logger.Info("HeaderRelayer", et.name, "check src Height:", srcHeight)
+				if tracker, ok := et.engine.(IHeadTracker); ok {
+					tracker.SetHead(srcHeight)
+				}
+
+				if destHeight+1+CONFIRM_NUM > srcHeight {
+					if set := timeout.Reset(timeoutDuration); !set {
+						logger.Error("HeaderRelayer", et.name, "reset timeout falied!")
+						delay = time.Duration(ERRDELAY)
+						break
+					}
+					logger.Debug("HeaderRelayer", et.name, "waiting src update, delay")
+					delay = time.Duration(WAITDELAY)
+					break
+				}
+
+				// check fork
+				destHeight, err = checkReorg(et.name, et.headers, et.callerSession.IsKnown, et.engine, et.monitor, destHeight)
+				if err != nil {
+					logger.Error("HeaderRelayer", et.name, "checkReorg error:", err)
+					if errors.Is(err, errReorgTooDeep) {
+						logger.Error("HeaderRelayer", et.name, "halt, need manual intervention")
+						done <- err
+						return
+					}
+					delay = time.Duration(ERRDELAY)
+					break
+				}
+
+				syncStartHeight := destHeight + 1
+				syncNum := srcHeight - CONFIRM_NUM - destHeight
+				if syncNum > BATCH_NUM {
+					syncNum = BATCH_NUM
+				}
+				syncEndHeight := syncStartHeight + syncNum - 1
+				logger.Info("HeaderRelayer %v sync from %v to %v", et.name, syncStartHeight, syncEndHeight)
+
+				err = et.signAndSendTransactions(syncStartHeight, syncEndHeight)
+				if err != nil {
+					logger.Error("HeaderRelayer", et.name, "signAndSendTransactions failed:", err)
+					if shouldHalt(err) {
+						logger.Error("HeaderRelayer", et.name, "halt, need manual intervention")
+						done <- err
+						return
+					}
+					delay = errDelay(err)
+					break
+				}
+				if set := timeout.Reset(timeoutDuration); !set {
+					logger.Error("HeaderRelayer", et.name, "reset timeout falied!")
+					delay = time.Duration(ERRDELAY)
+					break
+				}
+				logger.Info("HeaderRelayer", et.name, "sync round finish")
+				if syncNum == BATCH_NUM {
+					delay = time.Duration(SUCCESSDELAY)
+				} else {
+					delay = time.Duration(WAITDELAY)
+				}
+				// break
+			}
+		}
+	}(done)
+
+	err := <-done
+	logger.Error("HeaderRelayer", et.name, "stopped:", errs.Classify(err))
+	return nil
+}
+
+func (et *HeaderRelayer) signAndSendTransactions(lo, hi uint64) error {
+	headers, err := et.headers.HeadersByNumber(context.Background(), lo, hi)
+	if err != nil {
+		logger.Error("HeaderRelayer", et.name, "HeadersByNumber error:", err)
+		return err
+	}
+	var batch []byte
+	var parent *types.Header
+	for _, header := range headers {
+		// catch bad rpc responses before the TOP transaction reverts
+		if err := et.engine.VerifyHeader(header, parent); err != nil {
+			logger.Error("HeaderRelayer", et.name, "VerifyHeader error:", header.Number, err)
+			return err
+		}
+		parent = header
+		rlp_bytes, err := et.engine.GetLastSnapBytes(header)
+		if err != nil {
+			logger.Error(err)
+			return err
+		}
+		batch = append(batch, rlp_bytes...)
+	}
+
+	if len(batch) > 0 {
+		err := et.submitEthHeader(batch)
+		if err != nil {
+			logger.Error("HeaderRelayer", et.name, "submitHeaders failed:", err)
+			return err
+		}
+		for _, header := range headers {
+			if err := et.engine.Apply(header); err != nil {
+				// drop the partly applied state, the next round recomputes it
+				logger.Error("HeaderRelayer", et.name, "Apply error:", header.Number, err)
+				et.engine.Rollback(header.Number.Uint64() - 1)
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (relayer *HeaderRelayer) GetInitData() ([]byte, error) {
+	return nil, nil
+}
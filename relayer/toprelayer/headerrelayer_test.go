@@ -0,0 +1,200 @@
+package toprelayer
+
+import (
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"toprelayer/config"
+	"toprelayer/relayer/toprelayer/ethash"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestChainProfile(t *testing.T) {
+	custom := common.HexToAddress("0xff00000000000000000000000000000000000010")
+	tests := []struct {
+		name     string
+		cfg      *config.Relayer
+		engine   string
+		contract common.Address
+		chainId  uint64
+		fail     bool
+	}{
+		{"BSC", nil, POSA_ENGINE_PARLIA, bscClientContract, 56, false},
+		{"HECO", &config.Relayer{}, POSA_ENGINE_CONGRESS, hecoClientContract, 0, false},
+		{"BSC", &config.Relayer{ChainId: 97, TopContract: custom.Hex()}, POSA_ENGINE_PARLIA, custom, 97, false},
+		{"NEW", &config.Relayer{Engine: POSA_ENGINE_PARLIA, TopContract: custom.Hex(), ChainId: 204}, POSA_ENGINE_PARLIA, custom, 204, false},
+		{"NEW", &config.Relayer{Engine: POSA_ENGINE_CONGRESS, TopContract: custom.Hex()}, POSA_ENGINE_CONGRESS, custom, 0, false},
+		{"NEW", &config.Relayer{Engine: POSA_ENGINE_PARLIA, TopContract: custom.Hex()}, "", common.Address{}, 0, true},
+		{"NEW", &config.Relayer{Engine: POSA_ENGINE_CLIQUE, TopContract: custom.Hex(), Epoch: 100}, POSA_ENGINE_CLIQUE, custom, 0, false},
+		{"NEW", &config.Relayer{Engine: "ethash", TopContract: custom.Hex()}, "", common.Address{}, 0, true},
+		{"NEW", &config.Relayer{Engine: POSA_ENGINE_CONGRESS}, "", common.Address{}, 0, true},
+		{"NEW", &config.Relayer{Engine: POSA_ENGINE_CONGRESS, TopContract: "0x12"}, "", common.Address{}, 0, true},
+	}
+	for i, test := range tests {
+		profile, err := chainProfile(test.name, test.cfg)
+		if test.fail {
+			if err == nil {
+				t.Errorf("%v: expect error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", i, err)
+			continue
+		}
+		if profile.Engine != test.engine || profile.Contract != test.contract {
+			t.Errorf("%v: unexpected profile %v %v", i, profile.Engine, profile.Contract)
+		}
+		if test.chainId != 0 && (profile.ChainId == nil || profile.ChainId.Uint64() != test.chainId) {
+			t.Errorf("%v: expect chain id %v, got %v", i, test.chainId, profile.ChainId)
+		}
+	}
+	// fork heights follow the chain id
+	for _, test := range []struct {
+		cfg   *config.Relayer
+		luban uint64
+		plato uint64
+	}{
+		{nil, 29020050, 30720096},
+		{&config.Relayer{ChainId: 97}, 29295050, 29861024},
+		{&config.Relayer{ChainId: 97, Forks: map[string]uint64{"plato": 30000000}}, 29295050, 30000000},
+	} {
+		profile, err := chainProfile(config.BSC_CHAIN, test.cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chainConfig, err := parliaConfig(profile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if chainConfig.LubanBlock.Uint64() != test.luban || chainConfig.PlatoBlock.Uint64() != test.plato {
+			t.Errorf("%+v: unexpected forks %v %v", test.cfg, chainConfig.LubanBlock, chainConfig.PlatoBlock)
+		}
+	}
+	profile, _ := chainProfile(config.BSC_CHAIN, &config.Relayer{Forks: map[string]uint64{"lubaan": 1}})
+	if _, err := parliaConfig(profile); err == nil {
+		t.Error("expect unknown fork rejected")
+	}
+	// snapshots are stored by relayer
+	cfg := &config.Relayer{CacheDir: t.TempDir()}
+	for _, name := range []string{config.BSC_CHAIN, "OPBNB"} {
+		profile, err := chainProfile(name, &config.Relayer{Engine: POSA_ENGINE_PARLIA, TopContract: custom.Hex(), ChainId: 56})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := newChainEngine(name, profile, nil, cfg); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(filepath.Join(cfg.CacheDir, strings.ToLower(name))); err != nil {
+			t.Error("expect snapshot database of", name, err)
+		}
+	}
+	// overrides leave the builtin profile untouched
+	if chainProfiles[config.BSC_CHAIN].ChainId.Uint64() != 56 || chainProfiles[config.BSC_CHAIN].Contract != bscClientContract {
+		t.Fatal("builtin profile modified")
+	}
+}
+
+func TestStopClosesSnapshotDatabase(t *testing.T) {
+	cfg := &config.Relayer{CacheDir: t.TempDir()}
+	profile, err := chainProfile(config.BSC_CHAIN, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine, err := newChainEngine(config.BSC_CHAIN, profile, nil, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the database is locked while open
+	if _, err := newChainEngine(config.BSC_CHAIN, profile, nil, cfg); err == nil {
+		t.Fatal("expect the open database locked")
+	}
+	relayer := &HeaderRelayer{name: config.BSC_CHAIN, engine: engine}
+	relayer.Stop()
+	reopened, err := newChainEngine(config.BSC_CHAIN, profile, nil, cfg)
+	if err != nil {
+		t.Fatal("expect the database closed on stop:", err)
+	}
+	(&HeaderRelayer{name: config.BSC_CHAIN, engine: reopened}).Stop()
+}
+
+func TestPolygonProfile(t *testing.T) {
+	if _, err := chainProfile(config.POLYGON_CHAIN, nil); err == nil {
+		t.Fatal("expect error without topcontract")
+	}
+	contract := common.HexToAddress("0xff00000000000000000000000000000000000005")
+	cfg := &config.Relayer{TopContract: contract.Hex()}
+	profile, err := chainProfile(config.POLYGON_CHAIN, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.Engine != POSA_ENGINE_BOR || profile.Contract != contract {
+		t.Fatal("unexpected profile:", profile.Engine, profile.Contract)
+	}
+	if _, err := newChainEngine(config.POLYGON_CHAIN, profile, nil, cfg); err == nil {
+		t.Fatal("expect error without heimdall url")
+	}
+	cfg.Heimdall = "http://127.0.0.1:1317"
+	if _, err := newChainEngine(config.POLYGON_CHAIN, profile, nil, cfg); err != nil {
+		t.Fatal(err)
+	}
+	// forks by chain id
+	for _, test := range []struct {
+		cfg    *config.Relayer
+		jaipur uint64
+		delhi  uint64
+	}{
+		{cfg, 23850000, 38189056},
+		{&config.Relayer{TopContract: contract.Hex(), ChainId: 80001}, 22770000, 29638656},
+		{&config.Relayer{TopContract: contract.Hex(), Forks: map[string]uint64{"delhi": 40000000}}, 23850000, 40000000},
+	} {
+		profile, err := chainProfile(config.POLYGON_CHAIN, test.cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chainConfig, err := borConfig(profile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if chainConfig.JaipurBlock.Uint64() != test.jaipur || chainConfig.DelhiBlock.Uint64() != test.delhi {
+			t.Errorf("%+v: unexpected forks %v %v", test.cfg, chainConfig.JaipurBlock, chainConfig.DelhiBlock)
+		}
+	}
+	profile, _ = chainProfile(config.POLYGON_CHAIN, &config.Relayer{TopContract: contract.Hex(), Forks: map[string]uint64{"luban": 1}})
+	if _, err := borConfig(profile); err == nil {
+		t.Error("expect unknown fork rejected")
+	}
+}
+
+func TestEtcProfile(t *testing.T) {
+	if _, err := chainProfile(config.ETC_CHAIN, nil); err == nil {
+		t.Fatal("expect error without topcontract")
+	}
+	contract := common.HexToAddress("0xff00000000000000000000000000000000000006")
+	cfg := &config.Relayer{TopContract: contract.Hex()}
+	profile, err := chainProfile(config.ETC_CHAIN, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.Engine != POW_ENGINE_ETCHASH || profile.Contract != contract {
+		t.Fatal("unexpected profile:", profile.Engine, profile.Contract)
+	}
+	engine, err := newChainEngine(config.ETC_CHAIN, profile, nil, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := engine.(*powEngine); !ok {
+		t.Fatalf("unexpected engine %T", engine)
+	}
+	parent := &types.Header{Number: new(big.Int).SetUint64(ethash.ECIP1099Block)}
+	header := &types.Header{Number: new(big.Int).SetUint64(ethash.ECIP1099Block + 1)}
+	if err := engine.VerifyHeader(header, parent); !errors.Is(err, consensus.ErrUnknownAncestor) {
+		t.Fatal("expect unknown ancestor, got", err)
+	}
+}
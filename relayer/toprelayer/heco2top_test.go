@@ -6,11 +6,13 @@ import (
 	"testing"
 	"toprelayer/config"
 	"toprelayer/relayer/toprelayer/congress"
+	"toprelayer/relayer/toprelayer/headerbatch"
 
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/wonderivan/logger"
 )
 
@@ -42,11 +44,12 @@ func TestGetHecoSyncData(t *testing.T) {
 	var start_height uint64 = 17276022
 	var sync_num uint64 = 1
 
-	ethsdk, err := ethclient.Dial(hecoUrl)
+	client, err := rpc.Dial(hecoUrl)
 	if err != nil {
 		t.Fatal(err)
 	}
-	con := congress.New(ethsdk)
+	ethsdk := ethclient.NewClient(client)
+	con := congress.New(headerbatch.New(client, 0, 0), nil)
 	err = con.Init(start_height - 1)
 	if err != nil {
 		t.Fatal(err)
@@ -78,7 +81,7 @@ func TestHecoInit(t *testing.T) {
 		Url:     []string{topUrl},
 		KeyPath: keyPath,
 	}
-	relayer := &Heco2TopRelayer{}
+	relayer := NewHeaderRelayer(config.HECO_CHAIN)
 	err := relayer.Init(cfg, []string{ethUrl}, defaultPass)
 	if err != nil {
 		t.Fatal(err)
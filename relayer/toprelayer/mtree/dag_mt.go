@@ -125,6 +125,30 @@ func NewKeccak256DagTree() *DagTree {
 	}
 }
 
+// InsertHash inserts an already hashed leaf, e.g. the root of a subtree
+// built by FinalizeSubtree.
+func (dt *DagTree) InsertHash(h Hash, index uint32) {
+	dt.insertLeaf(DagData(h), nil, index)
+}
+
+// VerifyProof checks element at index against root, proof are the sibling
+// hashes from the leaf up as in ProofsForRegisteredIndices, hashed the way
+// the tree is.
+func (dt *DagTree) VerifyProof(element Word, index uint32, proof []Hash, root Hash) bool {
+	if index>>len(proof) != 0 {
+		return false
+	}
+	h := dt.eh(element)
+	for i, sibling := range proof {
+		if index>>i&1 == 0 {
+			h = dt.h(h, DagData(sibling))
+		} else {
+			h = dt.h(DagData(sibling), h)
+		}
+	}
+	return Hash(h.(DagData)) == root
+}
+
 func (dt DagTree) RootHash() Hash {
 	if dt.finalized {
 		return Hash(dt.Root().(DagData))
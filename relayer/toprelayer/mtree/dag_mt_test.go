@@ -0,0 +1,57 @@
+package mtree
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestVerifyProof(t *testing.T) {
+	for name, newTree := range map[string]func() *DagTree{
+		"sha256":    NewSHA256DagTree,
+		"keccak256": NewKeccak256DagTree,
+	} {
+		const n = 13
+		words := make([]Word, n)
+		indices := []uint32{0, 5, 12}
+		dt := newTree()
+		dt.RegisterIndex(indices...)
+		for i := range words {
+			rand.Read(words[i][:])
+			dt.Insert(words[i], uint32(i))
+		}
+		dt.Finalize()
+		root := dt.RootHash()
+		for i, proof := range dt.ProofsForRegisteredIndices() {
+			index := indices[i]
+			if len(proof) != 4 {
+				t.Fatalf("%v: unexpected proof length %v", name, len(proof))
+			}
+			if !dt.VerifyProof(words[index], index, proof, root) {
+				t.Errorf("%v: proof of %v rejected", name, index)
+			}
+			if dt.VerifyProof(words[(index+1)%n], index, proof, root) {
+				t.Errorf("%v: wrong element of %v accepted", name, index)
+			}
+			// the last element is padded with copies of itself
+			if index != n-1 && dt.VerifyProof(words[index], index^1, proof, root) {
+				t.Errorf("%v: wrong index of %v accepted", name, index)
+			}
+			if dt.VerifyProof(words[index], index|1<<4, proof, root) {
+				t.Errorf("%v: index beyond the tree of %v accepted", name, index)
+			}
+			bad := append([]Hash{}, proof...)
+			bad[2][0] ^= 1
+			if dt.VerifyProof(words[index], index, bad, root) {
+				t.Errorf("%v: altered proof of %v accepted", name, index)
+			}
+		}
+		// a tree of the other hash disagrees
+		other := NewSHA256DagTree()
+		if name == "sha256" {
+			other = NewKeccak256DagTree()
+		}
+		if other.VerifyProof(words[0], 0, dt.ProofsForRegisteredIndices()[0], root) {
+			t.Errorf("%v: proof accepted by the other hash", name)
+		}
+	}
+}
@@ -61,7 +61,11 @@ func (mt *MerkleTree) SetElementHashFunction(_h elementHashFunc) {
 }
 
 func (mt *MerkleTree) Insert(data ElementData, index uint32) {
-	_node := node{mt.eh(data), 1, &map[uint32]BranchTree{}}
+	mt.insertLeaf(mt.eh(data), data, index)
+}
+
+func (mt *MerkleTree) insertLeaf(hashed NodeData, data ElementData, index uint32) {
+	_node := node{hashed, 1, &map[uint32]BranchTree{}}
 	// fmt.Printf("Inserted node for word (%s): %4s\n", hex.EncodeToString(data[:]), hex.EncodeToString(_node.Data[:]))
 	if mt.indexes[index] {
 		(*_node.Branches)[index] = BranchTree{
@@ -135,6 +139,20 @@ func (mt *MerkleTree) Finalize() {
 	mt.finalized = true
 }
 
+// FinalizeSubtree finalizes the tree as the leftmost part of a subtree of
+// leaves elements, padding it the way Finalize pads the whole tree. The root
+// can then be inserted into a tree of subtree roots.
+func (mt *MerkleTree) FinalizeSubtree(leaves uint32) {
+	if !mt.finalized && mt.mtbuf.Len() > 0 {
+		for mt.mtbuf.Len() > 1 || mt.mtbuf.Front().Value.(node).NodeCount < 2*leaves-1 {
+			dupNode := mt.mtbuf.Back().Value.(node).Copy()
+			mt.dnf(dupNode.Data)
+			mt.insertNode(dupNode)
+		}
+	}
+	mt.finalized = true
+}
+
 func (mt MerkleTree) Root() NodeData {
 	if mt.finalized {
 		return mt.mtbuf.Front().Value.(node).Data
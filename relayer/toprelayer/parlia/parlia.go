@@ -7,16 +7,18 @@ import (
 	"fmt"
 	"io"
 	"math/big"
-	"time"
+	"toprelayer/relayer/toprelayer/headerbatch"
+	"toprelayer/relayer/toprelayer/posa"
 
 	lru "github.com/hashicorp/golang-lru"
 	"github.com/wonderivan/logger"
 	"golang.org/x/crypto/sha3"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
-	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/rlp"
 )
 
@@ -25,6 +27,7 @@ const (
 	inMemorySignatures = 4096 // Number of recent block signatures to keep in memory
 
 	checkpointInterval = 1024 // Number of blocks after which to save the snapshot to the database
+	resumeCheckpoints  = 4    // Number of stored checkpoints tried on resume, older ones replay more than an init
 
 	extraVanity = 32 // Fixed number of extra-data prefix bytes reserved for signer vanity
 	extraSeal   = 65 // Fixed number of extra-data suffix bytes reserved for signer seal
@@ -34,8 +37,7 @@ const (
 )
 
 var (
-	uncleHash  = types.CalcUncleHash(nil) // Always Keccak256(RLP([])) as uncles are meaningless outside of PoW.
-	bscChainid = big.NewInt(56)
+	uncleHash = types.CalcUncleHash(nil) // Always Keccak256(RLP([])) as uncles are meaningless outside of PoW.
 )
 
 // Various error messages to mark blocks invalid. These should be private to
@@ -43,20 +45,9 @@ var (
 // codebase, inherently breaking if the engine is swapped out. Please put common
 // error types into the consensus package.
 var (
-	// errMissingSignature is returned if a block's extra-data section doesn't seem
-	// to contain a 65 byte secp256k1 signature.
-	errMissingSignature = errors.New("extra-data 65 byte signature suffix missing")
-
 	// errOutOfRangeChain is returned if an authorization list is attempted to
 	// be modified via out-of-range or non-contiguous headers.
 	errOutOfRangeChain = errors.New("out of range or non-contiguous chain")
-
-	// errUnauthorizedValidator is returned if a header is signed by a non-authorized entity.
-	errUnauthorizedValidator = errors.New("unauthorized validator")
-
-	// errRecentlySigned is returned if a header is signed by an authorized entity
-	// that already signed a header recently, thus is temporarily not allowed to.
-	errRecentlySigned = errors.New("recently signed")
 )
 
 // ecrecover extracts the Ethereum account address from a signed header.
@@ -68,7 +59,7 @@ func ecrecover(header *types.Header, sigCache *lru.ARCCache, chainId *big.Int) (
 	}
 	// Retrieve the signature from the header extra-data
 	if len(header.Extra) < extraSeal {
-		return common.Address{}, errMissingSignature
+		return common.Address{}, posa.ErrMissingSignature
 	}
 	signature := header.Extra[len(header.Extra)-extraSeal:]
 
@@ -99,15 +90,20 @@ func ParliaRLP(header *types.Header, chainId *big.Int) []byte {
 
 // Parlia is the consensus engine of BSC
 type Parlia struct {
-	// db          ethdb.Database // Database to store and retrieve snapshot checkpoints
-
-	recentSnaps *lru.ARCCache // Snapshots for recent block to speed up
-	signatures  *lru.ARCCache // Signatures of recent blocks to speed up mining
-	client      *ethclient.Client
+	db          ethdb.Database // Database to store and retrieve snapshot checkpoints
+	recentSnaps *lru.ARCCache  // Snapshots for recent block to speed up
+	signatures  *lru.ARCCache  // Signatures of recent blocks to speed up mining
+	fetcher     *headerbatch.Fetcher
+	config      *ChainConfig // Chain id signed into seals and fork heights
+	verifier    *posa.Verifier
 }
 
-// New creates a Parlia consensus engine.
-func New(client *ethclient.Client) *Parlia {
+// New creates a Parlia consensus engine, snapshot checkpoints are kept in
+// memory if db is nil.
+func New(fetcher *headerbatch.Fetcher, db ethdb.Database) *Parlia {
+	if db == nil {
+		db = rawdb.NewMemoryDatabase()
+	}
 	// Allocate the snapshot caches and create the engine
 	recentSnaps, err := lru.NewARC(inMemorySnapshots)
 	if err != nil {
@@ -118,14 +114,35 @@ func New(client *ethclient.Client) *Parlia {
 		panic(err)
 	}
 	c := &Parlia{
+		db:          db,
 		recentSnaps: recentSnaps,
 		signatures:  signatures,
-		client:      client,
+		fetcher:     fetcher,
+		config:      BSCChainConfig,
+	}
+	c.verifier = &posa.Verifier{
+		Fetcher:     fetcher,
+		VerifyExtra: func(header *types.Header) error { return verifyExtra(header, c.config) },
+		Ecrecover: func(header *types.Header) (common.Address, error) {
+			return ecrecover(header, c.signatures, c.config.ChainId)
+		},
+		Snapshot: func(number uint64, hash common.Hash) (posa.Snapshot, error) {
+			snap, err := c.GetLastSnap(number, hash)
+			if err != nil {
+				return nil, err
+			}
+			return snap, nil
+		},
 	}
 
 	return c
 }
 
+// SetConfig sets the config of a Parlia chain other than BSC mainnet.
+func (c *Parlia) SetConfig(config *ChainConfig) {
+	c.config = config
+}
+
 func (c *Parlia) Init(height uint64) error {
 	var baseHeight uint64
 	if height < Epoch {
@@ -138,48 +155,85 @@ func (c *Parlia) Init(height uint64) error {
 		}
 	}
 
-	logger.Info("initing congress snapshot from %v to %v", baseHeight, height)
+	if c.resume(height) {
+		return nil
+	}
+
+	logger.Info("initing parlia snapshot from %v to %v", baseHeight, height)
 	// init baseheight
 	{
-		header, err := c.client.HeaderByNumber(context.Background(), big.NewInt(0).SetUint64(baseHeight))
+		header, err := c.fetcher.HeaderByNumber(context.Background(), baseHeight)
 		if err != nil {
 			logger.Error(err)
 			return err
 		}
-		hash := header.Hash()
-
-		validators := make([]common.Address, (len(header.Extra)-extraVanity-extraSeal)/common.AddressLength)
-		for i := 0; i < len(validators); i++ {
-			copy(validators[i][:], header.Extra[extraVanity+i*common.AddressLength:])
+		snap, err := newCheckpointSnapshot(c.signatures, header, c.config)
+		if err != nil {
+			logger.Error(err)
+			return err
 		}
-		snap := newSnapshot(c.signatures, baseHeight, hash, validators)
 		c.recentSnaps.Add(snap.Hash, snap)
 	}
 
-	for i := baseHeight + 1; i <= height; i++ {
-		header, err := c.client.HeaderByNumber(context.Background(), big.NewInt(0).SetUint64(i))
+	for i := baseHeight + 1; i <= height; {
+		headers, err := c.fetcher.HeadersByNumber(context.Background(), i, height)
 		if err != nil {
 			logger.Error(err)
 			return err
 		}
-		snap, err := c.GetLastSnap(header.Number.Uint64()-1, header.ParentHash)
+		for _, header := range headers {
+			err = c.Apply(header)
+			if err != nil {
+				logger.Error(err)
+				return err
+			}
+		}
+		i += uint64(len(headers))
+	}
+	return nil
+}
+
+// resume rebuilds the snapshot of height from the latest checkpoint stored
+// on disk, falling back to older ones when it is missing or was left on an
+// abandoned branch. It returns false if there is none.
+func (c *Parlia) resume(height uint64) bool {
+	number := height / checkpointInterval * checkpointInterval
+	for i := 0; i < resumeCheckpoints && number > 0; i, number = i+1, number-checkpointInterval {
+		checkpoint, err := c.fetcher.HeaderByNumber(context.Background(), number)
 		if err != nil {
 			logger.Error(err)
-			return err
+			return false
 		}
-		err = c.Apply(snap, header)
+		snap, err := loadSnapshot(c.signatures, c.db, checkpoint.Hash())
+		if err != nil {
+			logger.Debug("no parlia checkpoint %v: %v", number, err)
+			continue
+		}
+		c.recentSnaps.Add(snap.Hash, snap)
+		header, err := c.fetcher.HeaderByNumber(context.Background(), height)
 		if err != nil {
 			logger.Error(err)
-			return err
+			return false
+		}
+		if _, err = c.GetLastSnap(height, header.Hash()); err != nil {
+			logger.Error(err)
+			return false
 		}
-		time.Sleep(time.Millisecond * 100)
+		logger.Info("resumed parlia snapshot from checkpoint %v to %v", number, height)
+		return true
 	}
-	return nil
+	return false
+}
+
+// Close closes the snapshot database.
+func (c *Parlia) Close() error {
+	return c.db.Close()
 }
 
 func (c *Parlia) GetLastSnap(number uint64, hash common.Hash) (*Snapshot, error) {
 	var (
 		headers []*types.Header
+		pending []*types.Header // headers fetched by number, next one last
 		snap    *Snapshot
 	)
 	for snap == nil {
@@ -188,11 +242,16 @@ func (c *Parlia) GetLastSnap(number uint64, hash common.Hash) (*Snapshot, error)
 			snap = s.(*Snapshot)
 			break
 		}
-		// TODO: db
+		// If an on-disk checkpoint snapshot can be found, use that
 		if number%checkpointInterval == 0 {
+			if s, err := loadSnapshot(c.signatures, c.db, hash); err == nil {
+				logger.Debug("Loaded snapshot from disk", number, hash)
+				snap = s
+				break
+			}
 		}
 		if number == 0 || (number%Epoch == 0 && len(headers) >= int(maxValidators)) {
-			checkpoint, err := c.client.HeaderByNumber(context.Background(), big.NewInt(0).SetUint64(number))
+			checkpoint, err := c.fetcher.HeaderByNumber(context.Background(), number)
 			if err != nil {
 				logger.Error(err)
 				return nil, err
@@ -201,19 +260,44 @@ func (c *Parlia) GetLastSnap(number uint64, hash common.Hash) (*Snapshot, error)
 				logger.Error(err)
 				return nil, fmt.Errorf("header is nil")
 			}
-			hash := checkpoint.Hash()
-			validators := make([]common.Address, (len(checkpoint.Extra)-extraVanity-extraSeal)/common.AddressLength)
-			for i := 0; i < len(validators); i++ {
-				copy(validators[i][:], checkpoint.Extra[extraVanity+i*common.AddressLength:])
+			snap, err = newCheckpointSnapshot(c.signatures, checkpoint, c.config)
+			if err != nil {
+				logger.Error(err)
+				return nil, err
+			}
+			if err := snap.store(c.db); err != nil {
+				logger.Error("store snapshot error:", err)
+				return nil, err
 			}
-			snap = newSnapshot(c.signatures, number, hash, validators)
-			// TODO:db
 			break
 		}
-		h, err := c.client.HeaderByHash(context.Background(), hash)
-		if err != nil {
-			logger.Error(err)
-			return nil, fmt.Errorf("HeaderByHash error")
+		// the first header is usually the only one missing, fetch further
+		// ones in batches by number
+		if len(pending) == 0 && len(headers) > 0 {
+			lo := uint64(0)
+			if number >= c.fetcher.BatchSize() {
+				lo = number - c.fetcher.BatchSize() + 1
+			}
+			var err error
+			pending, err = c.fetcher.HeadersByNumber(context.Background(), lo, number)
+			if err != nil {
+				logger.Error(err)
+				return nil, fmt.Errorf("HeadersByNumber error")
+			}
+		}
+		var h *types.Header
+		if len(pending) > 0 {
+			h, pending = pending[len(pending)-1], pending[:len(pending)-1]
+		}
+		// not on the canonical chain any more, follow the hash
+		if h == nil || h.Number.Uint64() != number || h.Hash() != hash {
+			var err error
+			h, err = c.fetcher.HeaderByHash(context.Background(), hash)
+			if err != nil {
+				logger.Error(err)
+				return nil, fmt.Errorf("HeaderByHash error")
+			}
+			pending = nil
 		}
 		headers = append(headers, h)
 		number, hash = number-1, h.ParentHash
@@ -222,16 +306,52 @@ func (c *Parlia) GetLastSnap(number uint64, hash common.Hash) (*Snapshot, error)
 	for i := 0; i < len(headers)/2; i++ {
 		headers[i], headers[len(headers)-1-i] = headers[len(headers)-1-i], headers[i]
 	}
-	snap, err := snap.apply(headers, bscChainid)
+	snap, err := snap.apply(headers, c.config)
 	if err != nil {
 		return nil, err
 	}
 	c.recentSnaps.Add(snap.Hash, snap)
 	logger.Debug(snap)
-	// TODO:db
+	// If we've generated a new checkpoint snapshot, save to disk
+	if snap.Number%checkpointInterval == 0 && len(headers) > 0 {
+		if err = snap.store(c.db); err != nil {
+			logger.Error("store snapshot error:", err)
+			return nil, err
+		}
+	}
 	return snap, err
 }
 
+// VerifyHeader checks that header is well formed and sealed by a validator
+// allowed to seal on top of parent, parent is fetched if nil.
+func (c *Parlia) VerifyHeader(header, parent *types.Header) error {
+	return c.verifier.VerifyHeader(header, parent)
+}
+
+// verifyExtra checks the extra-data layout: validators on epoch headers,
+// nothing else before Luban and a valid vote attestation, if any, after Plato.
+func verifyExtra(header *types.Header, config *ChainConfig) error {
+	if len(header.Extra) < extraVanity {
+		return posa.ErrMissingVanity
+	}
+	if len(header.Extra) < extraVanity+extraSeal {
+		return posa.ErrMissingSignature
+	}
+	if header.Number.Uint64()%Epoch == 0 {
+		validators, _, err := parseValidators(header, config)
+		if err != nil || len(validators) == 0 {
+			return posa.ErrInvalidCheckpointValidators
+		}
+	} else if !config.IsLuban(header.Number) && len(header.Extra) != extraVanity+extraSeal {
+		return posa.ErrExtraValidators
+	}
+	if !config.IsPlato(header.Number) {
+		return nil
+	}
+	_, err := getVoteAttestationFromHeader(header, config)
+	return err
+}
+
 func (c *Parlia) GetLastSnapBytes(header *types.Header) ([]byte, error) {
 	snap, err := c.GetLastSnap(header.Number.Uint64()-1, header.ParentHash)
 	if err != nil {
@@ -246,20 +366,51 @@ func (c *Parlia) GetLastSnapBytes(header *types.Header) ([]byte, error) {
 	return bytes, nil
 }
 
-func (c *Parlia) Apply(snap *Snapshot, header *types.Header) error {
-	var headers []*types.Header
-	headers = append(headers, header)
-	snap, err := snap.apply(headers, bscChainid)
+// Apply moves the snapshot of the parent of header past header, checkpoints
+// are stored on disk.
+func (c *Parlia) Apply(header *types.Header) error {
+	snap, err := c.GetLastSnap(header.Number.Uint64()-1, header.ParentHash)
+	if err != nil {
+		return err
+	}
+	snap, err = snap.apply([]*types.Header{header}, c.config)
 	if err != nil {
 		return err
 	}
 	c.recentSnaps.Add(snap.Hash, snap)
+	if snap.Number%checkpointInterval == 0 {
+		return snap.store(c.db)
+	}
 	return nil
 }
 
+// Rollback drops the snapshots above number, they were built on a branch
+// abandoned by a reorg.
+func (c *Parlia) Rollback(number uint64) {
+	dropped := 0
+	for _, key := range c.recentSnaps.Keys() {
+		s, ok := c.recentSnaps.Peek(key)
+		if !ok {
+			continue
+		}
+		snap := s.(*Snapshot)
+		if snap.Number <= number {
+			continue
+		}
+		c.recentSnaps.Remove(key)
+		if snap.Number%checkpointInterval == 0 {
+			if err := snap.delete(c.db); err != nil {
+				logger.Error("delete snapshot error:", err)
+			}
+		}
+		dropped++
+	}
+	logger.Info("parlia rollback to %v, dropped %v snapshots", number, dropped)
+}
+
 // SealHash returns the hash of a block prior to it being sealed.
 func (p *Parlia) SealHash(header *types.Header) common.Hash {
-	return SealHash(header, bscChainid)
+	return SealHash(header, p.config.ChainId)
 }
 
 // ===========================     utility function        ==========================
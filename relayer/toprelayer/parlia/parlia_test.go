@@ -0,0 +1,320 @@
+package parlia
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"math/big"
+	"reflect"
+	"sort"
+	"testing"
+	"toprelayer/relayer/toprelayer/posa"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+// first epoch after Plato
+var platoEpoch = (BSCChainConfig.PlatoBlock.Uint64()/Epoch + 1) * Epoch
+
+type testValidators struct {
+	keys      []*ecdsa.PrivateKey
+	addrs     []common.Address
+	voteAddrs []BLSPublicKey
+}
+
+func newTestValidators(t *testing.T, n int) *testValidators {
+	v := new(testValidators)
+	for i := 0; i < n; i++ {
+		key, err := crypto.GenerateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var voteAddr BLSPublicKey
+		voteAddr[0] = byte(i + 1)
+		v.keys = append(v.keys, key)
+		v.addrs = append(v.addrs, crypto.PubkeyToAddress(key.PublicKey))
+		v.voteAddrs = append(v.voteAddrs, voteAddr)
+	}
+	// seal in address order so every header is in-turn
+	sort.Sort(v)
+	return v
+}
+
+func (v *testValidators) Len() int           { return len(v.addrs) }
+func (v *testValidators) Less(i, j int) bool { return bytes.Compare(v.addrs[i][:], v.addrs[j][:]) < 0 }
+func (v *testValidators) Swap(i, j int) {
+	v.keys[i], v.keys[j] = v.keys[j], v.keys[i]
+	v.addrs[i], v.addrs[j] = v.addrs[j], v.addrs[i]
+	v.voteAddrs[i], v.voteAddrs[j] = v.voteAddrs[j], v.voteAddrs[i]
+}
+
+// header builds a signed header, epoch headers list the validators in the
+// Luban layout and data is added as vote attestation.
+func (v *testValidators) header(t *testing.T, number uint64, parent common.Hash, data *VoteData) *types.Header {
+	extra := make([]byte, extraVanity)
+	if number%Epoch == 0 {
+		extra = append(extra, byte(len(v.addrs)))
+		for i := range v.addrs {
+			extra = append(extra, v.addrs[i].Bytes()...)
+			extra = append(extra, v.voteAddrs[i][:]...)
+		}
+	}
+	if data != nil {
+		attestation, err := rlp.EncodeToBytes(&VoteAttestation{VoteAddressSet: 7, Data: data})
+		if err != nil {
+			t.Fatal(err)
+		}
+		extra = append(extra, attestation...)
+	}
+	extra = append(extra, make([]byte, extraSeal)...)
+	header := &types.Header{
+		ParentHash: parent,
+		UncleHash:  uncleHash,
+		Difficulty: big.NewInt(2),
+		Number:     new(big.Int).SetUint64(number),
+		GasLimit:   30000000,
+		Extra:      extra,
+	}
+	seal(t, header, v.keys[number%uint64(len(v.keys))])
+	return header
+}
+
+func seal(t *testing.T, header *types.Header, key *ecdsa.PrivateKey) {
+	sig, err := crypto.Sign(SealHash(header, BSCChainConfig.ChainId).Bytes(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(header.Extra[len(header.Extra)-extraSeal:], sig)
+}
+
+func TestParseLubanExtra(t *testing.T) {
+	v := newTestValidators(t, 3)
+	data := &VoteData{SourceNumber: platoEpoch - 2, TargetNumber: platoEpoch - 1, TargetHash: common.Hash{1}}
+	header := v.header(t, platoEpoch, common.Hash{}, data)
+
+	validators, voteAddrs, err := parseValidators(header, BSCChainConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(validators, v.addrs) || !reflect.DeepEqual(voteAddrs, v.voteAddrs) {
+		t.Fatal("unexpected validators:", validators, voteAddrs)
+	}
+	attestation, err := getVoteAttestationFromHeader(header, BSCChainConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(attestation.Data, data) {
+		t.Fatal("unexpected attestation:", attestation.Data)
+	}
+
+	// before Luban the extra data is a plain list of addresses
+	old := &types.Header{Number: new(big.Int).SetUint64(Epoch), Extra: make([]byte, extraVanity)}
+	old.Extra = append(old.Extra, v.addrs[0].Bytes()...)
+	old.Extra = append(old.Extra, make([]byte, extraSeal)...)
+	validators, voteAddrs, err = parseValidators(old, BSCChainConfig)
+	if err != nil || len(validators) != 1 || validators[0] != v.addrs[0] || voteAddrs != nil {
+		t.Fatal("unexpected pre Luban validators:", validators, voteAddrs, err)
+	}
+	if attestation, err := getVoteAttestationFromHeader(old, BSCChainConfig); attestation != nil || err != nil {
+		t.Fatal("unexpected pre Luban attestation:", attestation, err)
+	}
+}
+
+func TestChainConfig(t *testing.T) {
+	chapel := DefaultChainConfig(big.NewInt(97))
+	if chapel.LubanBlock.Uint64() != 29295050 || chapel.PlatoBlock.Uint64() != 29861024 {
+		t.Fatal("unexpected chapel forks:", chapel.LubanBlock, chapel.PlatoBlock)
+	}
+	bsc := DefaultChainConfig(big.NewInt(56))
+	if !bsc.IsLuban(big.NewInt(29020050)) || bsc.IsLuban(big.NewInt(29020049)) || bsc.IsPlato(big.NewInt(30720095)) {
+		t.Fatal("unexpected bsc forks:", bsc.LubanBlock, bsc.PlatoBlock)
+	}
+	if err := bsc.SetForks(map[string]uint64{"luban": 1, "plato": 2}); err != nil {
+		t.Fatal(err)
+	}
+	if !bsc.IsPlato(big.NewInt(2)) || BSCChainConfig.IsPlato(big.NewInt(2)) {
+		t.Fatal("expect the override on the copy only")
+	}
+	if err := bsc.SetForks(map[string]uint64{"hertz": 1}); err == nil {
+		t.Fatal("expect unknown fork rejected")
+	}
+	other := DefaultChainConfig(big.NewInt(204))
+	if other.IsLuban(big.NewInt(1 << 40)) {
+		t.Fatal("expect no forks of an unknown chain")
+	}
+}
+
+func TestApplyAttestation(t *testing.T) {
+	sigCache, _ := lru.NewARC(inMemorySignatures)
+	v := newTestValidators(t, 3)
+	checkpoint := v.header(t, platoEpoch, common.Hash{}, nil)
+	snap, err := newCheckpointSnapshot(sigCache, checkpoint, BSCChainConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// each header justifies its parent and finalizes the grandparent
+	headers := []*types.Header{checkpoint}
+	for n := platoEpoch + 1; n <= platoEpoch+3; n++ {
+		parent := headers[len(headers)-1]
+		grandparent := parent.ParentHash
+		data := &VoteData{SourceNumber: n - 2, SourceHash: grandparent, TargetNumber: n - 1, TargetHash: parent.Hash()}
+		headers = append(headers, v.header(t, n, parent.Hash(), data))
+	}
+	snap, err = snap.apply(headers[1:], BSCChainConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if number, hash := snap.justified(); number != platoEpoch+2 || hash != headers[2].Hash() {
+		t.Fatal("unexpected justified:", number, hash)
+	}
+	if number, hash := snap.finalized(); number != platoEpoch+1 || hash != headers[1].Hash() {
+		t.Fatal("unexpected finalized:", number, hash)
+	}
+
+	// a vote skipping blocks justifies the target but keeps the source
+	skip := v.header(t, platoEpoch+4, headers[3].Hash(), &VoteData{SourceNumber: platoEpoch + 1, TargetNumber: platoEpoch + 3, TargetHash: headers[3].Hash()})
+	skipped, err := snap.apply([]*types.Header{skip}, BSCChainConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if number, _ := skipped.justified(); number != platoEpoch+3 {
+		t.Fatal("unexpected justified:", number)
+	}
+	if number, _ := skipped.finalized(); number != platoEpoch+1 {
+		t.Fatal("unexpected finalized:", number)
+	}
+	if number, _ := snap.justified(); number != platoEpoch+2 {
+		t.Fatal("apply modified the parent snapshot")
+	}
+	// a vote not targeting the parent is ignored
+	stale := v.header(t, platoEpoch+4, headers[3].Hash(), &VoteData{SourceNumber: platoEpoch + 2, TargetNumber: platoEpoch + 3, TargetHash: headers[2].Hash()})
+	ignored, err := snap.apply([]*types.Header{stale}, BSCChainConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if number, _ := ignored.justified(); number != platoEpoch+2 {
+		t.Fatal("unexpected justified:", number)
+	}
+
+	data, err := encodeSnapshot(skip, skipped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := new(SnapshotOut)
+	if err := rlp.DecodeBytes(data, out); err != nil {
+		t.Fatal(err)
+	}
+	if len(out.VoteAddresses) != 3 || out.JustifiedNumber != platoEpoch+3 || out.FinalizedNumber != platoEpoch+1 {
+		t.Fatal("unexpected snapshot out:", len(out.VoteAddresses), out.JustifiedNumber, out.FinalizedNumber)
+	}
+	for i, addr := range out.Validators {
+		key := skipped.VoteAddresses[common.BytesToAddress(addr)]
+		if !reflect.DeepEqual(out.VoteAddresses[i], key[:]) {
+			t.Fatal("vote address not in validator order at", i)
+		}
+	}
+
+	db := rawdb.NewMemoryDatabase()
+	if err := skipped.store(db); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := loadSnapshot(sigCache, db, skipped.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded.VoteAddresses, skipped.VoteAddresses) || !reflect.DeepEqual(loaded.Attestation, skipped.Attestation) {
+		t.Fatal("stored snapshot mismatch")
+	}
+}
+
+func TestEncodeSnapshotBeforeLuban(t *testing.T) {
+	snap := newSnapshot(nil, 100, common.Hash{}, []common.Address{{1}, {2}}, nil)
+	data, err := encodeSnapshot(&types.Header{Number: big.NewInt(101), Difficulty: big.NewInt(2)}, snap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the encoding stays readable by the old layout
+	var old struct {
+		Header        []byte
+		ValidatorsNum uint64
+		Validators    [][]byte
+		RecentsNum    uint64
+		Recents       [][]byte
+	}
+	if err := rlp.DecodeBytes(data, &old); err != nil {
+		t.Fatal(err)
+	}
+	if old.ValidatorsNum != 2 {
+		t.Fatal("unexpected validators:", old.ValidatorsNum)
+	}
+}
+
+func TestVerifySeal(t *testing.T) {
+	c := New(nil, nil)
+	v := newTestValidators(t, 3)
+	checkpoint := v.header(t, platoEpoch, common.Hash{}, nil)
+	snap, err := newCheckpointSnapshot(c.signatures, checkpoint, c.config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := v.header(t, platoEpoch+1, checkpoint.Hash(), nil)
+	if err := verifyExtra(header, c.config); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.verifier.VerifySeal(snap, header); err != nil {
+		t.Fatal(err)
+	}
+
+	// out of turn with in-turn difficulty
+	wrong := types.CopyHeader(header)
+	seal(t, wrong, v.keys[(platoEpoch+2)%3])
+	if err := c.verifier.VerifySeal(snap, wrong); err != posa.ErrWrongDifficulty {
+		t.Fatal("expect wrong difficulty, got", err)
+	}
+	wrong.Difficulty = posa.DiffNoTurn
+	seal(t, wrong, v.keys[(platoEpoch+2)%3])
+	if err := c.verifier.VerifySeal(snap, wrong); err != nil {
+		t.Fatal(err)
+	}
+	// the signer of the parent is still recent
+	parent, err := snap.apply([]*types.Header{header}, BSCChainConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrong = v.header(t, platoEpoch+2, header.Hash(), nil)
+	wrong.Difficulty = posa.DiffNoTurn
+	seal(t, wrong, v.keys[(platoEpoch+1)%3])
+	if err := c.verifier.VerifySeal(parent, wrong); err != posa.ErrRecentlySigned {
+		t.Fatal("expect recently signed, got", err)
+	}
+
+	bad := types.CopyHeader(header)
+	bad.Extra = append(bad.Extra[:extraVanity], []byte{1, 2, 3}...)
+	bad.Extra = append(bad.Extra, make([]byte, extraSeal)...)
+	if err := verifyExtra(bad, c.config); err == nil {
+		t.Fatal("expect invalid attestation error")
+	}
+	bad = types.CopyHeader(checkpoint)
+	bad.Extra = make([]byte, extraVanity+extraSeal)
+	if err := verifyExtra(bad, c.config); err != posa.ErrInvalidCheckpointValidators {
+		t.Fatal("expect invalid checkpoint validators, got", err)
+	}
+
+	// between Luban and Plato extra data is allowed, even a bad attestation
+	luban := c.config.LubanBlock.Uint64() + 1
+	bad = v.header(t, luban, common.Hash{}, nil)
+	bad.Extra = append(bad.Extra[:extraVanity], []byte{1, 2, 3}...)
+	bad.Extra = append(bad.Extra, make([]byte, extraSeal)...)
+	if err := verifyExtra(bad, c.config); err != nil {
+		t.Fatal(err)
+	}
+	bad.Number = new(big.Int).SetUint64(luban - 2)
+	if err := verifyExtra(bad, c.config); err != posa.ErrExtraValidators {
+		t.Fatal("expect extra validators before Luban, got", err)
+	}
+}
@@ -21,8 +21,8 @@ import (
 	"encoding/binary"
 	"encoding/json"
 	"errors"
-	"math/big"
 	"sort"
+	"toprelayer/relayer/toprelayer/posa"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/consensus"
@@ -47,6 +47,9 @@ type Snapshot struct {
 	Validators       map[common.Address]struct{} `json:"validators"`         // Set of authorized validators at this moment
 	Recents          map[uint64]common.Address   `json:"recents"`            // Set of recent validators for spam protections
 	RecentForkHashes map[uint64]string           `json:"recent_fork_hashes"` // Set of recent forkHash
+
+	VoteAddresses map[common.Address]BLSPublicKey `json:"vote_addresses,omitempty"` // BLS keys of the validators after Luban
+	Attestation   *VoteData                       `json:"attestation,omitempty"`    // Latest justified source and target
 }
 
 // newSnapshot creates a new snapshot with the specified startup parameters. This
@@ -57,6 +60,7 @@ func newSnapshot(
 	number uint64,
 	hash common.Hash,
 	validators []common.Address,
+	voteAddrs []BLSPublicKey,
 ) *Snapshot {
 	snap := &Snapshot{
 		sigCache:         sigCache,
@@ -65,13 +69,35 @@ func newSnapshot(
 		Recents:          make(map[uint64]common.Address),
 		RecentForkHashes: make(map[uint64]string),
 		Validators:       make(map[common.Address]struct{}),
+		VoteAddresses:    make(map[common.Address]BLSPublicKey),
 	}
-	for _, v := range validators {
+	for i, v := range validators {
 		snap.Validators[v] = struct{}{}
+		if i < len(voteAddrs) {
+			snap.VoteAddresses[v] = voteAddrs[i]
+		}
 	}
 	return snap
 }
 
+// newCheckpointSnapshot creates a snapshot with the validators and the vote
+// attestation of an epoch header.
+func newCheckpointSnapshot(sigCache *lru.ARCCache, header *types.Header, config *ChainConfig) (*Snapshot, error) {
+	validators, voteAddrs, err := parseValidators(header, config)
+	if err != nil {
+		return nil, err
+	}
+	snap := newSnapshot(sigCache, header.Number.Uint64(), header.Hash(), validators, voteAddrs)
+	attestation, err := getVoteAttestationFromHeader(header, config)
+	if err != nil {
+		return nil, err
+	}
+	if attestation != nil {
+		snap.Attestation = attestation.Data
+	}
+	return snap, nil
+}
+
 // validatorsAscending implements the sort interface to allow sorting a list of addresses
 type validatorsAscending []common.Address
 
@@ -103,6 +129,11 @@ func (s *Snapshot) store(db ethdb.Database) error {
 	return db.Put(append([]byte("parlia-"), s.Hash[:]...), blob)
 }
 
+// delete removes the snapshot from the database.
+func (s *Snapshot) delete(db ethdb.Database) error {
+	return db.Delete(append([]byte("parlia-"), s.Hash[:]...))
+}
+
 // copy creates a deep copy of the snapshot
 func (s *Snapshot) copy() *Snapshot {
 	cpy := &Snapshot{
@@ -112,8 +143,16 @@ func (s *Snapshot) copy() *Snapshot {
 		Validators:       make(map[common.Address]struct{}),
 		Recents:          make(map[uint64]common.Address),
 		RecentForkHashes: make(map[uint64]string),
+		VoteAddresses:    make(map[common.Address]BLSPublicKey),
 	}
 
+	for v, key := range s.VoteAddresses {
+		cpy.VoteAddresses[v] = key
+	}
+	if s.Attestation != nil {
+		attestation := *s.Attestation
+		cpy.Attestation = &attestation
+	}
 	for v := range s.Validators {
 		cpy.Validators[v] = struct{}{}
 	}
@@ -136,7 +175,7 @@ func (s *Snapshot) isMajorityFork(forkHash string) bool {
 	return ally > len(s.RecentForkHashes)/2
 }
 
-func (s *Snapshot) apply(headers []*types.Header, chainId *big.Int) (*Snapshot, error) {
+func (s *Snapshot) apply(headers []*types.Header, config *ChainConfig) (*Snapshot, error) {
 	// Allow passing in no headers for cleaner code
 	if len(headers) == 0 {
 		return s, nil
@@ -160,19 +199,22 @@ func (s *Snapshot) apply(headers []*types.Header, chainId *big.Int) (*Snapshot,
 			delete(snap.Recents, number-limit)
 		}
 		// Resolve the authorization key and check against signers
-		validator, err := ecrecover(header, s.sigCache, chainId)
+		validator, err := ecrecover(header, s.sigCache, config.ChainId)
 		if err != nil {
 			return nil, err
 		}
 		if _, ok := snap.Validators[validator]; !ok {
-			return nil, errUnauthorizedValidator
+			return nil, posa.ErrUnauthorizedValidator
 		}
 		for _, recent := range snap.Recents {
 			if recent == validator {
-				return nil, errRecentlySigned
+				return nil, posa.ErrRecentlySigned
 			}
 		}
 		snap.Recents[number] = validator
+		if err := snap.updateAttestation(header, config); err != nil {
+			return nil, err
+		}
 		// change validator set
 		if number > 0 && (number%Epoch == 0) {
 			checkpointHeader := header
@@ -180,15 +222,18 @@ func (s *Snapshot) apply(headers []*types.Header, chainId *big.Int) (*Snapshot,
 				return nil, consensus.ErrUnknownAncestor
 			}
 
-			validatorBytes := checkpointHeader.Extra[extraVanity : len(checkpointHeader.Extra)-extraSeal]
 			// get validators from headers and use that for new validator set
-			newValArr, err := ParseValidators(validatorBytes)
+			newValArr, voteAddrs, err := parseValidators(checkpointHeader, config)
 			if err != nil {
 				return nil, err
 			}
 			newVals := make(map[common.Address]struct{}, len(newValArr))
-			for _, val := range newValArr {
+			newVoteAddrs := make(map[common.Address]BLSPublicKey, len(voteAddrs))
+			for i, val := range newValArr {
 				newVals[val] = struct{}{}
+				if i < len(voteAddrs) {
+					newVoteAddrs[val] = voteAddrs[i]
+				}
 			}
 			oldLimit := len(snap.Validators)/2 + 1
 			newLimit := len(newVals)/2 + 1
@@ -199,6 +244,7 @@ func (s *Snapshot) apply(headers []*types.Header, chainId *big.Int) (*Snapshot,
 			}
 
 			snap.Validators = newVals
+			snap.VoteAddresses = newVoteAddrs
 		}
 	}
 	snap.Number += uint64(len(headers))
@@ -206,6 +252,63 @@ func (s *Snapshot) apply(headers []*types.Header, chainId *big.Int) (*Snapshot,
 	return snap, nil
 }
 
+// updateAttestation moves the justified and finalized blocks to the vote
+// attestation of header. Between Luban and Plato bad attestations are
+// accepted but ignored, as are votes not targeting the parent.
+func (s *Snapshot) updateAttestation(header *types.Header, config *ChainConfig) error {
+	attestation, err := getVoteAttestationFromHeader(header, config)
+	if err != nil {
+		if config.IsPlato(header.Number) {
+			return err
+		}
+		logger.Warn("ignore bad vote attestation of %v: %v", header.Number, err)
+		return nil
+	}
+	if attestation == nil {
+		return nil
+	}
+	if attestation.Data.TargetHash != header.ParentHash || attestation.Data.TargetNumber+1 != header.Number.Uint64() {
+		logger.Warn("ignore vote attestation of %v not targeting the parent, target %v", header.Number, attestation.Data.TargetNumber)
+		return nil
+	}
+	// a vote not directly on top of its source only justifies the target
+	if s.Attestation != nil && attestation.Data.SourceNumber+1 != attestation.Data.TargetNumber {
+		s.Attestation.TargetNumber = attestation.Data.TargetNumber
+		s.Attestation.TargetHash = attestation.Data.TargetHash
+	} else {
+		s.Attestation = attestation.Data
+	}
+	return nil
+}
+
+// justified returns the latest justified block, zero before Plato.
+func (s *Snapshot) justified() (uint64, common.Hash) {
+	if s.Attestation == nil {
+		return 0, common.Hash{}
+	}
+	return s.Attestation.TargetNumber, s.Attestation.TargetHash
+}
+
+// finalized returns the latest finalized block, zero before Plato.
+func (s *Snapshot) finalized() (uint64, common.Hash) {
+	if s.Attestation == nil {
+		return 0, common.Hash{}
+	}
+	return s.Attestation.SourceNumber, s.Attestation.SourceHash
+}
+
+// Signers returns the validators and the recent signers.
+func (s *Snapshot) Signers() (map[common.Address]struct{}, map[uint64]common.Address) {
+	return s.Validators, s.Recents
+}
+
+// Inturn returns if a validator at a given block height is in-turn or not.
+func (s *Snapshot) Inturn(number uint64, validator common.Address) bool {
+	validators := s.validators()
+	offset := number % uint64(len(validators))
+	return validators[offset] == validator
+}
+
 // validators retrieves the list of validators in ascending order.
 func (s *Snapshot) validators() []common.Address {
 	validators := make([]common.Address, 0, len(s.Validators))
@@ -236,6 +339,12 @@ type SnapshotOut struct {
 	Validators    [][]byte
 	RecentsNum    uint64
 	Recents       [][]byte
+	// fast finality, omitted before Luban
+	VoteAddresses   [][]byte `rlp:"optional"` // in the order of Validators
+	JustifiedNumber uint64   `rlp:"optional"`
+	JustifiedHash   []byte   `rlp:"optional"`
+	FinalizedNumber uint64   `rlp:"optional"`
+	FinalizedHash   []byte   `rlp:"optional"`
 }
 
 func encodeSnapshot(header *types.Header, snap *Snapshot) ([]byte, error) {
@@ -248,8 +357,11 @@ func encodeSnapshot(header *types.Header, snap *Snapshot) ([]byte, error) {
 	}
 	out.Header = headerRlp
 	out.ValidatorsNum = uint64(len(snap.Validators))
-	for k := range snap.Validators {
+	for _, k := range snap.validators() {
 		out.Validators = append(out.Validators, k.Bytes())
+		if key, ok := snap.VoteAddresses[k]; ok {
+			out.VoteAddresses = append(out.VoteAddresses, key[:])
+		}
 	}
 	out.RecentsNum = uint64(len(snap.Recents))
 	for k, v := range snap.Recents {
@@ -258,6 +370,12 @@ func encodeSnapshot(header *types.Header, snap *Snapshot) ([]byte, error) {
 		out.Recents = append(out.Recents, buf)
 		out.Recents = append(out.Recents, v.Bytes())
 	}
+	if snap.Attestation != nil {
+		number, hash := snap.justified()
+		out.JustifiedNumber, out.JustifiedHash = number, hash.Bytes()
+		number, hash = snap.finalized()
+		out.FinalizedNumber, out.FinalizedHash = number, hash.Bytes()
+	}
 	bytes, err := rlp.EncodeToBytes(out)
 	if err != nil {
 		logger.Error(err)
@@ -0,0 +1,180 @@
+package parlia
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+const (
+	BLSPublicKeyLength = 48
+	BLSSignatureLength = 96
+
+	validatorNumberSize            = 1 // Fixed number of extra prefix bytes reserved for validator number after Luban
+	validatorBytesLengthAfterLuban = common.AddressLength + BLSPublicKeyLength
+)
+
+var (
+	BSCChainConfig = &ChainConfig{
+		ChainId:    big.NewInt(56),
+		LubanBlock: big.NewInt(29020050),
+		PlatoBlock: big.NewInt(30720096),
+	}
+	ChapelChainConfig = &ChainConfig{
+		ChainId:    big.NewInt(97),
+		LubanBlock: big.NewInt(29295050),
+		PlatoBlock: big.NewInt(29861024),
+	}
+
+	errInvalidValidatorBytes = errors.New("invalid validators bytes")
+)
+
+// ChainConfig is the chain id and the fast finality hard forks of a Parlia
+// chain. Luban adds the validator vote addresses to epoch headers and vote
+// attestations to headers, Plato makes the attestations mandatory. A nil
+// fork is not scheduled.
+type ChainConfig struct {
+	ChainId    *big.Int
+	LubanBlock *big.Int
+	PlatoBlock *big.Int
+}
+
+// DefaultChainConfig returns the config of a known chain id, for others one
+// without forks.
+func DefaultChainConfig(chainId *big.Int) *ChainConfig {
+	for _, c := range []*ChainConfig{BSCChainConfig, ChapelChainConfig} {
+		if c.ChainId.Cmp(chainId) == 0 {
+			cpy := *c
+			return &cpy
+		}
+	}
+	return &ChainConfig{ChainId: chainId}
+}
+
+// SetForks overrides the fork heights by lowercase name.
+func (c *ChainConfig) SetForks(forks map[string]uint64) error {
+	for name, number := range forks {
+		switch name {
+		case "luban":
+			c.LubanBlock = new(big.Int).SetUint64(number)
+		case "plato":
+			c.PlatoBlock = new(big.Int).SetUint64(number)
+		default:
+			return fmt.Errorf("unknown parlia fork %v", name)
+		}
+	}
+	return nil
+}
+
+func (c *ChainConfig) IsLuban(number *big.Int) bool {
+	return isForked(c.LubanBlock, number)
+}
+
+func (c *ChainConfig) IsPlato(number *big.Int) bool {
+	return isForked(c.PlatoBlock, number)
+}
+
+func isForked(fork, number *big.Int) bool {
+	return fork != nil && number.Cmp(fork) >= 0
+}
+
+type BLSPublicKey [BLSPublicKeyLength]byte
+type BLSSignature [BLSSignatureLength]byte
+type ValidatorsBitSet uint64
+
+func (k BLSPublicKey) MarshalText() ([]byte, error) {
+	return hexutil.Bytes(k[:]).MarshalText()
+}
+
+func (k *BLSPublicKey) UnmarshalText(input []byte) error {
+	return hexutil.UnmarshalFixedText("BLSPublicKey", input, k[:])
+}
+
+// VoteData is the source and target of a fast finality vote.
+type VoteData struct {
+	SourceNumber uint64      // The source block number should be the latest justified block number.
+	SourceHash   common.Hash // The block hash of the source block.
+	TargetNumber uint64      // The target block number which validator wants to vote for.
+	TargetHash   common.Hash // The block hash of the target block.
+}
+
+// VoteAttestation is the aggregated vote of the validators in a header.
+type VoteAttestation struct {
+	VoteAddressSet ValidatorsBitSet // The bitset marks the voted validators.
+	AggSignature   BLSSignature     // The aggregated BLS signature of the voted validators' signatures.
+	Data           *VoteData        // The vote data for fast finality.
+	Extra          []byte           // Reserved for future usage.
+}
+
+// getValidatorBytesFromHeader returns the validator entries of an epoch header.
+func getValidatorBytesFromHeader(header *types.Header, config *ChainConfig) []byte {
+	if len(header.Extra) <= extraVanity+extraSeal {
+		return nil
+	}
+	if !config.IsLuban(header.Number) {
+		return header.Extra[extraVanity : len(header.Extra)-extraSeal]
+	}
+	if header.Number.Uint64()%Epoch != 0 {
+		return nil
+	}
+	num := int(header.Extra[extraVanity])
+	if num == 0 || len(header.Extra) < extraVanity+validatorNumberSize+num*validatorBytesLengthAfterLuban+extraSeal {
+		return nil
+	}
+	start := extraVanity + validatorNumberSize
+	return header.Extra[start : start+num*validatorBytesLengthAfterLuban]
+}
+
+// parseValidators returns the validators of an epoch header and, after Luban,
+// their vote addresses.
+func parseValidators(header *types.Header, config *ChainConfig) ([]common.Address, []BLSPublicKey, error) {
+	validatorBytes := getValidatorBytesFromHeader(header, config)
+	if !config.IsLuban(header.Number) {
+		validators, err := ParseValidators(validatorBytes)
+		return validators, nil, err
+	}
+	if len(validatorBytes) == 0 || len(validatorBytes)%validatorBytesLengthAfterLuban != 0 {
+		return nil, nil, errInvalidValidatorBytes
+	}
+	n := len(validatorBytes) / validatorBytesLengthAfterLuban
+	validators := make([]common.Address, n)
+	voteAddrs := make([]BLSPublicKey, n)
+	for i := 0; i < n; i++ {
+		entry := validatorBytes[i*validatorBytesLengthAfterLuban:]
+		copy(validators[i][:], entry[:common.AddressLength])
+		copy(voteAddrs[i][:], entry[common.AddressLength:validatorBytesLengthAfterLuban])
+	}
+	return validators, voteAddrs, nil
+}
+
+// getVoteAttestationFromHeader returns the vote attestation of a header, nil
+// if it has none. Headers carry attestations from Luban on.
+func getVoteAttestationFromHeader(header *types.Header, config *ChainConfig) (*VoteAttestation, error) {
+	if len(header.Extra) <= extraVanity+extraSeal || !config.IsLuban(header.Number) {
+		return nil, nil
+	}
+	var attestationBytes []byte
+	if header.Number.Uint64()%Epoch != 0 {
+		attestationBytes = header.Extra[extraVanity : len(header.Extra)-extraSeal]
+	} else {
+		num := int(header.Extra[extraVanity])
+		start := extraVanity + validatorNumberSize + num*validatorBytesLengthAfterLuban
+		if len(header.Extra) <= start+extraSeal {
+			return nil, nil
+		}
+		attestationBytes = header.Extra[start : len(header.Extra)-extraSeal]
+	}
+	attestation := new(VoteAttestation)
+	if err := rlp.DecodeBytes(attestationBytes, attestation); err != nil {
+		return nil, err
+	}
+	if attestation.Data == nil {
+		return nil, errors.New("vote attestation without data")
+	}
+	return attestation, nil
+}
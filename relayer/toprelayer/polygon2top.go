@@ -0,0 +1,15 @@
+package toprelayer
+
+import (
+	"toprelayer/config"
+)
+
+// Polygon2TopRelayer relays Polygon PoS headers sealed by Bor to TOP, the
+// Heimdall url and the TOP system contract come from the POLYGON config.
+type Polygon2TopRelayer struct {
+	*HeaderRelayer
+}
+
+func NewPolygon2TopRelayer() *Polygon2TopRelayer {
+	return &Polygon2TopRelayer{HeaderRelayer: NewHeaderRelayer(config.POLYGON_CHAIN)}
+}
@@ -0,0 +1,142 @@
+package posa
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"toprelayer/relayer/toprelayer/headerbatch"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/wonderivan/logger"
+)
+
+var (
+	DiffInTurn = big.NewInt(2) // Block difficulty for in-turn signatures
+	DiffNoTurn = big.NewInt(1) // Block difficulty for out-of-turn signatures
+)
+
+// Error messages to mark the headers of a PoSA chain invalid, shared by the
+// engines.
+var (
+	// ErrMissingSignature is returned if a block's extra-data section doesn't seem
+	// to contain a 65 byte secp256k1 signature.
+	ErrMissingSignature = errors.New("extra-data 65 byte signature suffix missing")
+
+	// ErrUnauthorizedValidator is returned if a header is signed by a non-authorized entity.
+	ErrUnauthorizedValidator = errors.New("unauthorized validator")
+
+	// ErrRecentlySigned is returned if a header is signed by an authorized entity
+	// that already signed a header recently, thus is temporarily not allowed to.
+	ErrRecentlySigned = errors.New("recently signed")
+
+	// ErrUnknownBlock is returned when the list of validators is requested for a block
+	// that is not part of the local blockchain.
+	ErrUnknownBlock = errors.New("unknown block")
+
+	// ErrMissingVanity is returned if a block's extra-data section is shorter than
+	// 32 bytes, which is required to store the validator vanity.
+	ErrMissingVanity = errors.New("extra-data 32 byte vanity prefix missing")
+
+	// ErrExtraValidators is returned if non-checkpoint block contain validator data in
+	// their extra-data fields.
+	ErrExtraValidators = errors.New("non-checkpoint block contains extra validator list")
+
+	// ErrInvalidCheckpointValidators is returned if a checkpoint block contains an
+	// invalid list of validators (i.e. non divisible by 20 bytes).
+	ErrInvalidCheckpointValidators = errors.New("invalid validator list on checkpoint block")
+
+	// ErrInvalidDifficulty is returned if the difficulty of a block is missing.
+	ErrInvalidDifficulty = errors.New("invalid difficulty")
+
+	// ErrWrongDifficulty is returned if the difficulty of a block doesn't match the
+	// turn of the validator.
+	ErrWrongDifficulty = errors.New("wrong difficulty")
+
+	// ErrInvalidTimestamp is returned if the timestamp of a block is not after
+	// the one of its parent.
+	ErrInvalidTimestamp = errors.New("invalid timestamp")
+)
+
+// Snapshot is the validator set a header is sealed against, the one after
+// its parent.
+type Snapshot interface {
+	Signers() (validators map[common.Address]struct{}, recents map[uint64]common.Address)
+	Inturn(number uint64, validator common.Address) bool
+}
+
+// Verifier checks the headers of a chain sealed in turn by a validator set,
+// the engines supply the extra-data layout, the signer of the seal and the
+// snapshots.
+type Verifier struct {
+	Fetcher     *headerbatch.Fetcher
+	VerifyExtra func(header *types.Header) error
+	Ecrecover   func(header *types.Header) (common.Address, error)
+	Snapshot    func(number uint64, hash common.Hash) (Snapshot, error)
+}
+
+// VerifyHeader checks that header is well formed and sealed by a validator
+// allowed to seal on top of parent, parent is fetched if nil.
+func (v *Verifier) VerifyHeader(header, parent *types.Header) error {
+	if header.Number == nil || header.Number.Sign() == 0 {
+		return ErrUnknownBlock
+	}
+	number := header.Number.Uint64()
+	if err := v.VerifyExtra(header); err != nil {
+		return err
+	}
+	if header.Difficulty == nil || (header.Difficulty.Cmp(DiffInTurn) != 0 && header.Difficulty.Cmp(DiffNoTurn) != 0) {
+		return ErrInvalidDifficulty
+	}
+	if parent == nil {
+		var err error
+		parent, err = v.Fetcher.HeaderByHash(context.Background(), header.ParentHash)
+		if err != nil {
+			logger.Error(err)
+			return err
+		}
+	}
+	if parent.Number.Uint64() != number-1 || parent.Hash() != header.ParentHash {
+		return consensus.ErrUnknownAncestor
+	}
+	if header.Time <= parent.Time {
+		return ErrInvalidTimestamp
+	}
+	snap, err := v.Snapshot(number-1, header.ParentHash)
+	if err != nil {
+		logger.Error(err)
+		return err
+	}
+	return v.VerifySeal(snap, header)
+}
+
+// VerifySeal checks the signer of header against the validators and recents
+// of the parent snapshot, and the difficulty against its turn.
+func (v *Verifier) VerifySeal(snap Snapshot, header *types.Header) error {
+	number := header.Number.Uint64()
+	signer, err := v.Ecrecover(header)
+	if err != nil {
+		return err
+	}
+	validators, recents := snap.Signers()
+	if _, ok := validators[signer]; !ok {
+		return ErrUnauthorizedValidator
+	}
+	for seen, recent := range recents {
+		if recent == signer {
+			// Signer is among recents, only fail if the current block doesn't shift it out
+			if limit := uint64(len(validators)/2 + 1); number < limit || seen > number-limit {
+				return ErrRecentlySigned
+			}
+		}
+	}
+	inturn := snap.Inturn(number, signer)
+	if inturn && header.Difficulty.Cmp(DiffInTurn) != 0 {
+		return ErrWrongDifficulty
+	}
+	if !inturn && header.Difficulty.Cmp(DiffNoTurn) != 0 {
+		return ErrWrongDifficulty
+	}
+	return nil
+}
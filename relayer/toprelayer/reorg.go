@@ -0,0 +1,72 @@
+package toprelayer
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"toprelayer/relayer/monitor"
+	"toprelayer/relayer/toprelayer/headerbatch"
+
+	"github.com/wonderivan/logger"
+)
+
+const (
+	MAX_REORG_DEPTH uint64 = 256
+)
+
+var errReorgTooDeep = errors.New("reorg deeper than max depth")
+
+// snapshotEngine is a consensus engine caching snapshots of the source chain.
+type snapshotEngine interface {
+	Rollback(number uint64)
+}
+
+// findCommonAncestor walks back from height to the latest source header known
+// to TOP, at most MAX_REORG_DEPTH blocks.
+func findCommonAncestor(headers *headerbatch.Fetcher, isKnown func(*big.Int, [32]byte) (bool, error), height uint64) (uint64, error) {
+	for hi := height; ; {
+		lo := uint64(0)
+		if hi+1 > headers.BatchSize() {
+			lo = hi + 1 - headers.BatchSize()
+		}
+		if height-lo > MAX_REORG_DEPTH {
+			lo = height - MAX_REORG_DEPTH
+		}
+		batch, err := headers.HeadersByNumber(context.Background(), lo, hi)
+		if err != nil {
+			return 0, err
+		}
+		// missing headers above the source head are unknown as well
+		for i := len(batch) - 1; i >= 0; i-- {
+			known, err := isKnown(batch[i].Number, batch[i].Hash())
+			if err != nil {
+				return 0, err
+			}
+			if known {
+				return batch[i].Number.Uint64(), nil
+			}
+			logger.Warn("%v hash is not known", batch[i].Number)
+		}
+		if lo == 0 || height-lo >= MAX_REORG_DEPTH {
+			return 0, errReorgTooDeep
+		}
+		hi = lo - 1
+	}
+}
+
+// checkReorg finds the common ancestor of the source chain and TOP at height,
+// and rolls the engine back to it after a reorg.
+func checkReorg(name string, headers *headerbatch.Fetcher, isKnown func(*big.Int, [32]byte) (bool, error), engine snapshotEngine, m *monitor.Monitor, height uint64) (uint64, error) {
+	ancestor, err := findCommonAncestor(headers, isKnown, height)
+	if err != nil {
+		return 0, err
+	}
+	if depth := height - ancestor; depth > 0 {
+		logger.Warn("%v reorg depth %v, rollback to %v", name, depth, ancestor)
+		engine.Rollback(ancestor)
+		if m != nil {
+			m.AddReorg(depth, ancestor)
+		}
+	}
+	return ancestor, nil
+}
@@ -0,0 +1,84 @@
+package toprelayer
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+	"toprelayer/relayer/toprelayer/headerbatch"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+type fakeHeaders []*types.Header
+
+func (h fakeHeaders) GetBlockByNumber(number rpc.BlockNumber, full bool) (*types.Header, error) {
+	if number < 0 || int(number) >= len(h) {
+		return nil, nil
+	}
+	return h[number], nil
+}
+
+func newFakeHeaders(t *testing.T, length uint64) (fakeHeaders, *headerbatch.Fetcher) {
+	var headers fakeHeaders
+	parent := common.Hash{}
+	for i := uint64(0); i <= length; i++ {
+		header := &types.Header{ParentHash: parent, Number: new(big.Int).SetUint64(i), Difficulty: common.Big1}
+		headers = append(headers, header)
+		parent = header.Hash()
+	}
+	server := rpc.NewServer()
+	if err := server.RegisterName("eth", headers); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Stop)
+	return headers, headerbatch.New(rpc.DialInProc(server), 8, -1)
+}
+
+// knownBelow reports the headers up to height as known to TOP.
+func knownBelow(headers fakeHeaders, height uint64) func(*big.Int, [32]byte) (bool, error) {
+	return func(number *big.Int, hash [32]byte) (bool, error) {
+		return number.Uint64() <= height && headers[number.Uint64()].Hash() == hash, nil
+	}
+}
+
+type fakeEngine struct {
+	rollback []uint64
+}
+
+func (e *fakeEngine) Rollback(number uint64) {
+	e.rollback = append(e.rollback, number)
+}
+
+func TestCheckReorg(t *testing.T) {
+	headers, fetcher := newFakeHeaders(t, MAX_REORG_DEPTH+100)
+	height := uint64(MAX_REORG_DEPTH + 50)
+
+	tests := []struct {
+		name     string
+		known    uint64
+		ancestor uint64
+		err      error
+	}{
+		{"no reorg", height, height, nil},
+		{"shallow", height - 3, height - 3, nil},
+		{"across batches", height - 20, height - 20, nil},
+		{"max depth", height - MAX_REORG_DEPTH, height - MAX_REORG_DEPTH, nil},
+		{"too deep", height - MAX_REORG_DEPTH - 1, 0, errReorgTooDeep},
+	}
+	for _, test := range tests {
+		engine := new(fakeEngine)
+		ancestor, err := checkReorg("test", fetcher, knownBelow(headers, test.known), engine, nil, height)
+		if !errors.Is(err, test.err) || ancestor != test.ancestor {
+			t.Errorf("%v: expect %v %v, got %v %v", test.name, test.ancestor, test.err, ancestor, err)
+			continue
+		}
+		if test.err == nil && test.ancestor != height && (len(engine.rollback) != 1 || engine.rollback[0] != test.ancestor) {
+			t.Errorf("%v: expect rollback to %v, got %v", test.name, test.ancestor, engine.rollback)
+		}
+		if (test.err != nil || test.ancestor == height) && len(engine.rollback) != 0 {
+			t.Errorf("%v: unexpected rollback %v", test.name, engine.rollback)
+		}
+	}
+}
@@ -2,21 +2,28 @@ package toprelayer
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math/big"
+	"path/filepath"
 	"strings"
 	"sync"
 	"time"
 	"toprelayer/config"
 	ethbridge "toprelayer/contract/top/ethclient"
+	"toprelayer/errs"
 	"toprelayer/relayer/monitor"
+	"toprelayer/relayer/txmanager"
 	"toprelayer/relayer/toprelayer/ethashapp"
+	"toprelayer/rpcdial"
 	"toprelayer/wallet"
 
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/wonderivan/logger"
 )
@@ -33,14 +40,47 @@ const (
 
 var (
 	ethClientSystemContract = common.HexToAddress("0xff00000000000000000000000000000000000002")
+
+	errFatalTimeout = fmt.Errorf("no progress in %v hours", FATALTIMEOUT)
 )
 
+// errDelay returns the delay in seconds before the next round after err,
+// rate limited endpoints get more time to recover.
+func errDelay(err error) time.Duration {
+	if errors.Is(err, errs.ErrRateLimited) {
+		return time.Duration(WAITDELAY)
+	}
+	return time.Duration(ERRDELAY)
+}
+
+// shouldHalt reports whether err cannot be fixed by retrying, e.g. the
+// relayer account ran out of funds.
+func shouldHalt(err error) bool {
+	return errs.ActionFor(err) == errs.ActionHalt
+}
+
+// shouldSkip reports whether the item behind err can be passed over, e.g. a
+// slot without a beacon block.
+func shouldSkip(err error) bool {
+	return errs.ActionFor(err) == errs.ActionSkip
+}
+
+// openSnapshotDatabase opens the database of consensus snapshots under the
+// cache dir of cfg, nil keeps them in memory only.
+func openSnapshotDatabase(cfg *config.Relayer, name string) (ethdb.Database, error) {
+	if cfg == nil || cfg.CacheDir == "" {
+		return nil, nil
+	}
+	return rawdb.NewLevelDBDatabase(filepath.Join(cfg.CacheDir, name), 16, 16, name, false)
+}
+
 type Eth2TopRelayer struct {
 	wallet        *wallet.Wallet
 	ethsdk        *ethclient.Client
 	transactor    *ethbridge.EthClientTransactor
 	callerSession *ethbridge.EthClientCallerSession
 	monitor       *monitor.Monitor
+	txm           *txmanager.Manager
 }
 
 func (relayer *Eth2TopRelayer) Init(cfg *config.Relayer, listenUrl string, pass string) error {
@@ -51,13 +91,13 @@ func (relayer *Eth2TopRelayer) Init(cfg *config.Relayer, listenUrl string, pass
 	}
 	relayer.wallet = w
 
-	relayer.ethsdk, err = ethclient.Dial(listenUrl)
+	relayer.ethsdk, err = rpcdial.DialEth(listenUrl)
 	if err != nil {
 		logger.Error("Eth2TopRelayer ethclient.Dial error:", err)
 		return err
 	}
 
-	topethlient, err := ethclient.Dial(cfg.Url[0])
+	topethlient, err := rpcdial.DialEth(cfg.Url[0])
 	if err != nil {
 		logger.Error("Eth2TopRelayer new topethlient error:", err)
 		return err
@@ -81,16 +121,21 @@ func (relayer *Eth2TopRelayer) Init(cfg *config.Relayer, listenUrl string, pass
 		BlockNumber: nil,
 		Context:     context.Background(),
 	}
-	relayer.monitor, err = monitor.New(relayer.wallet.Address(), cfg.Url[0])
+	relayer.monitor, err = monitor.New("ETH2TOP", config.TOP_CHAIN, relayer.wallet.Address(), cfg.Url[0])
 	if err != nil {
 		logger.Error("Eth2TopRelayer New monitor error", err)
 		return err
 	}
+	relayer.txm, err = txmanager.New("ETH2TOP", relayer.wallet, cfg, relayer.monitor.AddTx)
+	if err != nil {
+		logger.Error("Eth2TopRelayer New txmanager error", err)
+		return err
+	}
 	return nil
 }
 
 func (et *Eth2TopRelayer) submitEthHeader(header []byte) error {
-	nonce, err := et.wallet.NonceAt(context.Background(), et.wallet.Address(), nil)
+	nonce, err := et.txm.Nonce(context.Background())
 	if err != nil {
 		logger.Error("Eth2TopRelayer GetNonce error:", err)
 		return err
@@ -125,8 +170,9 @@ func (et *Eth2TopRelayer) submitEthHeader(header []byte) error {
 	sigTx, err := et.transactor.Sync(ops, header)
 	if err != nil {
 		logger.Error("Eth2TopRelayer sync error:", err)
-		return err
+		return errs.Classify(err)
 	}
+	et.txm.Track(sigTx)
 	et.monitor.AddTx(sigTx.Hash())
 	logger.Info("Eth2TopRelayer tx info, account[%v] nonce:%v,capfee:%v,hash:%v,size:%v", et.wallet.Address(), nonce, gaspric, sigTx.Hash(), len(header))
 	return nil
@@ -145,14 +191,22 @@ func (et *Eth2TopRelayer) signTransaction(addr common.Address, tx *types.Transac
 	return nil, fmt.Errorf("Eth2TopRelayer address:%v not available", addr)
 }
 
+// Stop stops replacing the stuck transactions.
+func (et *Eth2TopRelayer) Stop() {
+	if et.txm != nil {
+		et.txm.Stop()
+	}
+}
+
 func (et *Eth2TopRelayer) StartRelayer(wg *sync.WaitGroup) error {
 	logger.Info("Start Eth2TopRelayer, subBatch: %v certaintyBlocks: %v", BATCH_NUM, CONFIRM_NUM)
 	defer wg.Done()
+	defer et.Stop()
 
-	done := make(chan struct{})
+	done := make(chan error)
 	defer close(done)
 
-	go func(done chan struct{}) {
+	go func(done chan error) {
 		timeoutDuration := time.Duration(FATALTIMEOUT) * time.Hour
 		timeout := time.NewTimer(timeoutDuration)
 		defer timeout.Stop()
@@ -163,7 +217,7 @@ func (et *Eth2TopRelayer) StartRelayer(wg *sync.WaitGroup) error {
 			time.Sleep(time.Second * delay)
 			select {
 			case <-timeout.C:
-				done <- struct{}{}
+				done <- errFatalTimeout
 				return
 			default:
 				destHeight, err := et.callerSession.GetHeight()
@@ -190,6 +244,7 @@ func (et *Eth2TopRelayer) StartRelayer(wg *sync.WaitGroup) error {
 					break
 				}
 				logger.Info("Eth2TopRelayer check src eth Height:", srcHeight)
+				ethashapp.SetHead(srcHeight)
 
 				if destHeight+1+CONFIRM_NUM > srcHeight {
 					if set := timeout.Reset(timeoutDuration); !set {
@@ -241,7 +296,12 @@ func (et *Eth2TopRelayer) StartRelayer(wg *sync.WaitGroup) error {
 				err = et.signAndSendTransactions(syncStartHeight, syncEndHeight)
 				if err != nil {
 					logger.Error("Eth2TopRelayer signAndSendTransactions failed:", err)
-					delay = time.Duration(ERRDELAY)
+					if shouldHalt(err) {
+						logger.Error("Eth2TopRelayer halt, need manual intervention")
+						done <- err
+						return
+					}
+					delay = errDelay(err)
 					break
 				}
 				if set := timeout.Reset(timeoutDuration); !set {
@@ -260,8 +320,8 @@ func (et *Eth2TopRelayer) StartRelayer(wg *sync.WaitGroup) error {
 		}
 	}(done)
 
-	<-done
-	logger.Error("Eth2TopRelayer timeout")
+	err := <-done
+	logger.Error("Eth2TopRelayer stopped:", errs.Classify(err))
 	return nil
 }
 
@@ -0,0 +1,286 @@
+package txmanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	"toprelayer/config"
+	"toprelayer/errs"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/wonderivan/logger"
+)
+
+const (
+	STUCK_TIMEOUT = 3 * time.Minute
+	// percent fees are raised by, nodes replace a transaction only if both
+	// caps are raised by at least MIN_FEE_BUMP
+	DEFAULT_FEE_BUMP uint64 = 12
+	MIN_FEE_BUMP     uint64 = 10
+
+	checkInterval = 15 * time.Second
+)
+
+var (
+	ErrPending = errors.New("transaction pending")
+	ErrFeeCap  = errors.New("fee cap reached")
+)
+
+// Backend sends the transactions of an account, wallet.Wallet is one.
+type Backend interface {
+	Address() common.Address
+	NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (uint64, error)
+	SignTx(tx *types.Transaction) (*types.Transaction, error)
+	SendTransaction(ctx context.Context, tx *types.Transaction) error
+}
+
+type tracked struct {
+	tx   *types.Transaction // the latest version sent
+	sent time.Time
+}
+
+// Manager tracks the transactions sent by an account until they are mined,
+// one not mined in time is sent again with the same nonce and bumped fees.
+// Relayers take nonces from it so that a new submission waits for the last
+// one instead of racing it: the relay loops resume from what TOP has
+// confirmed, a second transaction sent meanwhile would repeat the first.
+type Manager struct {
+	name      string
+	backend   Backend
+	timeout   time.Duration
+	bump      uint64
+	maxFeeCap *big.Int // nil for none
+	onSent    func(common.Hash)
+
+	lock sync.Mutex
+	txs  map[uint64]*tracked // by nonce
+
+	ctx    context.Context // done on Stop
+	cancel context.CancelFunc
+}
+
+// New returns a manager of the transactions of backend configured by cfg and
+// starts checking them until Stop, onSent is called with the hash of each
+// replacement.
+func New(name string, backend Backend, cfg *config.Relayer, onSent func(common.Hash)) (*Manager, error) {
+	m, err := newManager(name, backend, cfg, onSent)
+	if err != nil {
+		return nil, err
+	}
+	go m.loop()
+	return m, nil
+}
+
+func (m *Manager) loop() {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.check(m.ctx)
+		case <-m.ctx.Done():
+			return
+		}
+	}
+}
+
+// Stop stops checking the transactions, it is safe to call more than once.
+func (m *Manager) Stop() {
+	m.cancel()
+}
+
+func newManager(name string, backend Backend, cfg *config.Relayer, onSent func(common.Hash)) (*Manager, error) {
+	m := &Manager{
+		name:    name,
+		backend: backend,
+		timeout: STUCK_TIMEOUT,
+		bump:    DEFAULT_FEE_BUMP,
+		onSent:  onSent,
+		txs:     make(map[uint64]*tracked),
+	}
+	if cfg.StuckTimeout != "" {
+		timeout, err := time.ParseDuration(cfg.StuckTimeout)
+		if err != nil {
+			return nil, err
+		}
+		m.timeout = timeout
+	}
+	if cfg.FeeBump != 0 {
+		if cfg.FeeBump < MIN_FEE_BUMP {
+			return nil, fmt.Errorf("fee bump %v%% below %v%%", cfg.FeeBump, MIN_FEE_BUMP)
+		}
+		m.bump = cfg.FeeBump
+	}
+	if cfg.Fee != nil && cfg.Fee.MaxFeeCap != 0 {
+		m.maxFeeCap = new(big.Int).SetUint64(cfg.Fee.MaxFeeCap)
+	}
+	if m.onSent == nil {
+		m.onSent = func(common.Hash) {}
+	}
+	m.ctx, m.cancel = context.WithCancel(context.Background())
+	return m, nil
+}
+
+// drop forgets the transactions mined below nonce, m.lock must be held.
+func (m *Manager) drop(nonce uint64) {
+	for n := range m.txs {
+		if n < nonce {
+			delete(m.txs, n)
+		}
+	}
+}
+
+// Nonce returns the nonce of the next transaction, or ErrPending while one
+// sent before is not mined.
+func (m *Manager) Nonce(ctx context.Context) (uint64, error) {
+	nonce, err := m.backend.NonceAt(ctx, m.backend.Address(), nil)
+	if err != nil {
+		return 0, err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.drop(nonce)
+	if len(m.txs) > 0 {
+		return 0, fmt.Errorf("%w: nonce %v", ErrPending, nonce)
+	}
+	return nonce, nil
+}
+
+// Track follows tx until it is mined.
+func (m *Manager) Track(tx *types.Transaction) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.txs[tx.Nonce()] = &tracked{tx: tx, sent: time.Now()}
+}
+
+// check replaces the transactions stuck for the timeout.
+func (m *Manager) check(ctx context.Context) {
+	nonce, err := m.backend.NonceAt(ctx, m.backend.Address(), nil)
+	if err != nil {
+		logger.Error("TxManager", m.name, "NonceAt error:", err)
+		return
+	}
+	m.lock.Lock()
+	m.drop(nonce)
+	var stuck []*tracked
+	for _, t := range m.txs {
+		if time.Since(t.sent) >= m.timeout {
+			stuck = append(stuck, t)
+		}
+	}
+	m.lock.Unlock()
+	sort.Slice(stuck, func(i, j int) bool { return stuck[i].tx.Nonce() < stuck[j].tx.Nonce() })
+	for _, t := range stuck {
+		m.replace(ctx, t)
+	}
+}
+
+func (m *Manager) replace(ctx context.Context, t *tracked) {
+	m.lock.Lock()
+	old := t.tx
+	m.lock.Unlock()
+	bumped, err := m.bumpFees(old)
+	if err != nil {
+		logger.Error("TxManager", m.name, "cannot replace stuck tx:", old.Hash(), err)
+		m.lock.Lock()
+		t.sent = time.Now()
+		m.lock.Unlock()
+		return
+	}
+	signed, err := m.backend.SignTx(bumped)
+	if err != nil {
+		logger.Error("TxManager", m.name, "SignTx error:", err)
+		return
+	}
+	err = m.backend.SendTransaction(ctx, signed)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	switch {
+	case err == nil:
+		t.tx, t.sent = signed, time.Now()
+		logger.Info("TxManager %v replaced stuck tx %v by %v, nonce:%v,%v", m.name, old.Hash(), signed.Hash(), signed.Nonce(), fees(signed))
+		m.onSent(signed.Hash())
+	case errors.Is(err, errs.ErrNonceTooLow):
+		// mined meanwhile
+		delete(m.txs, old.Nonce())
+	case errors.Is(err, errs.ErrUnderpriced):
+		// bump further from the rejected fees next time
+		t.tx = signed
+		logger.Warn("TxManager %v replacement of %v underpriced, %v", m.name, old.Hash(), fees(signed))
+	default:
+		logger.Error("TxManager", m.name, "SendTransaction error:", err)
+	}
+}
+
+// raise returns v raised by percent, rounded up.
+func raise(v *big.Int, percent uint64) *big.Int {
+	r := new(big.Int).Mul(v, new(big.Int).SetUint64(100+percent))
+	r.Add(r, big.NewInt(99))
+	return r.Div(r, big.NewInt(100))
+}
+
+// raiseCapped raises v by m.bump, but not beyond the fee cap as long as the
+// minimum bump fits under it.
+func (m *Manager) raiseCapped(v *big.Int) (*big.Int, error) {
+	r := raise(v, m.bump)
+	if m.maxFeeCap == nil || r.Cmp(m.maxFeeCap) <= 0 {
+		return r, nil
+	}
+	if raise(v, MIN_FEE_BUMP).Cmp(m.maxFeeCap) > 0 {
+		return nil, fmt.Errorf("%w: %v", ErrFeeCap, m.maxFeeCap)
+	}
+	return new(big.Int).Set(m.maxFeeCap), nil
+}
+
+// bumpFees returns tx with its fees raised, unsigned.
+func (m *Manager) bumpFees(tx *types.Transaction) (*types.Transaction, error) {
+	switch tx.Type() {
+	case types.LegacyTxType:
+		price, err := m.raiseCapped(tx.GasPrice())
+		if err != nil {
+			return nil, err
+		}
+		return types.NewTx(&types.LegacyTx{
+			Nonce:    tx.Nonce(),
+			GasPrice: price,
+			Gas:      tx.Gas(),
+			To:       tx.To(),
+			Value:    tx.Value(),
+			Data:     tx.Data(),
+		}), nil
+	case types.DynamicFeeTxType:
+		feeCap, err := m.raiseCapped(tx.GasFeeCap())
+		if err != nil {
+			return nil, err
+		}
+		tip := raise(tx.GasTipCap(), m.bump)
+		if tip.Cmp(feeCap) > 0 {
+			tip = new(big.Int).Set(feeCap)
+		}
+		return types.NewTx(&types.DynamicFeeTx{
+			ChainID:    tx.ChainId(),
+			Nonce:      tx.Nonce(),
+			GasTipCap:  tip,
+			GasFeeCap:  feeCap,
+			Gas:        tx.Gas(),
+			To:         tx.To(),
+			Value:      tx.Value(),
+			Data:       tx.Data(),
+			AccessList: tx.AccessList(),
+		}), nil
+	}
+	return nil, fmt.Errorf("unsupported tx type %v", tx.Type())
+}
+
+func fees(tx *types.Transaction) string {
+	if tx.Type() == types.LegacyTxType {
+		return fmt.Sprintf("gasprice:%v", tx.GasPrice())
+	}
+	return fmt.Sprintf("feecap:%v,tipcap:%v", tx.GasFeeCap(), tx.GasTipCap())
+}
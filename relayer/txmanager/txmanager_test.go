@@ -0,0 +1,182 @@
+package txmanager
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"toprelayer/config"
+	"toprelayer/errs"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+var testChainId = big.NewInt(1023)
+
+type fakeBackend struct {
+	signer  types.Signer
+	nonce   uint64
+	sendErr error
+	sent    []*types.Transaction
+}
+
+func newFakeBackend() *fakeBackend {
+	return &fakeBackend{signer: types.LatestSignerForChainID(testChainId)}
+}
+
+func (b *fakeBackend) Address() common.Address {
+	return common.HexToAddress("0x1")
+}
+
+func (b *fakeBackend) NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (uint64, error) {
+	return b.nonce, nil
+}
+
+func (b *fakeBackend) SignTx(tx *types.Transaction) (*types.Transaction, error) {
+	key, _ := crypto.GenerateKey()
+	return types.SignTx(tx, b.signer, key)
+}
+
+func (b *fakeBackend) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	if b.sendErr != nil {
+		return b.sendErr
+	}
+	b.sent = append(b.sent, tx)
+	return nil
+}
+
+func dynamicTx(nonce uint64, feeCap, tip int64) *types.Transaction {
+	to := common.HexToAddress("0x2")
+	return types.NewTx(&types.DynamicFeeTx{
+		ChainID:   testChainId,
+		Nonce:     nonce,
+		GasFeeCap: big.NewInt(feeCap),
+		GasTipCap: big.NewInt(tip),
+		Gas:       21000,
+		To:        &to,
+		Data:      []byte{1, 2, 3},
+	})
+}
+
+func TestManagerReplace(t *testing.T) {
+	backend := newFakeBackend()
+	var replaced []common.Hash
+	m, err := newManager("test", backend, &config.Relayer{StuckTimeout: "1h"}, func(hash common.Hash) {
+		replaced = append(replaced, hash)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend.nonce = 5
+	if nonce, err := m.Nonce(context.Background()); err != nil || nonce != 5 {
+		t.Fatal("unexpected nonce", nonce, err)
+	}
+	m.Track(dynamicTx(5, 1000, 100))
+	if _, err := m.Nonce(context.Background()); !errors.Is(err, ErrPending) {
+		t.Fatal("expect pending, got", err)
+	}
+
+	m.check(context.Background())
+	if len(backend.sent) != 0 {
+		t.Fatal("expect no replacement before the timeout")
+	}
+
+	m.timeout = 0
+	m.check(context.Background())
+	if len(backend.sent) != 1 || len(replaced) != 1 || replaced[0] != backend.sent[0].Hash() {
+		t.Fatal("expect one replacement reported")
+	}
+	tx := backend.sent[0]
+	if tx.Nonce() != 5 || tx.GasFeeCap().Int64() != 1120 || tx.GasTipCap().Int64() != 112 ||
+		tx.Gas() != 21000 || *tx.To() != common.HexToAddress("0x2") || len(tx.Data()) != 3 || tx.ChainId().Cmp(testChainId) != 0 {
+		t.Fatal("unexpected replacement", tx.Nonce(), tx.GasFeeCap(), tx.GasTipCap())
+	}
+
+	// a rejected replacement is bumped from its own fees next time
+	backend.sendErr = errs.Wrap(errs.ErrUnderpriced, errors.New("replacement transaction underpriced"))
+	m.check(context.Background())
+	backend.sendErr = nil
+	m.check(context.Background())
+	if len(backend.sent) != 2 || backend.sent[1].GasFeeCap().Int64() != 1406 {
+		t.Fatal("expect bump from the rejected fees, got", backend.sent[len(backend.sent)-1].GasFeeCap())
+	}
+
+	// mined
+	backend.nonce = 6
+	if nonce, err := m.Nonce(context.Background()); err != nil || nonce != 6 {
+		t.Fatal("unexpected nonce", nonce, err)
+	}
+	m.check(context.Background())
+	if len(backend.sent) != 2 {
+		t.Fatal("expect mined tx forgotten")
+	}
+
+	// mined between the nonce check and the replacement
+	m.Track(dynamicTx(6, 1000, 100))
+	backend.sendErr = errs.Wrap(errs.ErrNonceTooLow, errors.New("nonce too low"))
+	m.check(context.Background())
+	if len(m.txs) != 0 {
+		t.Fatal("expect tx forgotten on nonce too low")
+	}
+}
+
+func TestManagerUnminedAcrossRounds(t *testing.T) {
+	backend := newFakeBackend()
+	m, err := newManager("test", backend, &config.Relayer{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Track(dynamicTx(0, 1000, 100))
+	// every round while the tx is unmined waits instead of sending the same
+	// headers again under the next nonce
+	for round := 0; round < 2; round++ {
+		m.check(context.Background())
+		if _, err := m.Nonce(context.Background()); !errors.Is(err, ErrPending) {
+			t.Fatal("round", round, "expect pending, got", err)
+		}
+	}
+	if len(backend.sent) != 0 {
+		t.Fatal("unexpected sends:", len(backend.sent))
+	}
+	backend.nonce = 1
+	if nonce, err := m.Nonce(context.Background()); err != nil || nonce != 1 {
+		t.Fatal("unexpected nonce", nonce, err)
+	}
+
+	m.Stop()
+	m.Stop()
+	if m.ctx.Err() == nil {
+		t.Fatal("expect stopped")
+	}
+}
+
+func TestManagerFeeCap(t *testing.T) {
+	backend := newFakeBackend()
+	m, err := newManager("test", backend, &config.Relayer{StuckTimeout: "0s", FeeBump: 50, Fee: &config.Fee{MaxFeeCap: 1200}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	to := common.HexToAddress("0x2")
+	m.Track(types.NewTx(&types.LegacyTx{Nonce: 0, GasPrice: big.NewInt(1000), Gas: 21000, To: &to}))
+	// the bump is lowered to the cap while the minimum bump fits
+	m.check(context.Background())
+	if len(backend.sent) != 1 || backend.sent[0].Type() != types.LegacyTxType || backend.sent[0].GasPrice().Int64() != 1200 {
+		t.Fatal("expect a legacy replacement at the cap")
+	}
+	m.check(context.Background())
+	if len(backend.sent) != 1 {
+		t.Fatal("expect no replacement beyond the cap")
+	}
+	if _, err := m.bumpFees(backend.sent[0]); !errors.Is(err, ErrFeeCap) {
+		t.Fatal("expect fee cap reached, got", err)
+	}
+
+	for _, cfg := range []*config.Relayer{{FeeBump: 5}, {StuckTimeout: "soon"}} {
+		if _, err := newManager("test", backend, cfg, nil); err == nil {
+			t.Errorf("expect config %+v rejected", cfg)
+		}
+	}
+}
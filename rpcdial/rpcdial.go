@@ -0,0 +1,223 @@
+package rpcdial
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"toprelayer/config"
+
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/wonderivan/logger"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
+)
+
+const (
+	DEFAULT_TIMEOUT         = 2 * time.Minute
+	DEFAULT_KEEPALIVE_WAIT  = 20 * time.Second
+	RECONNECT_MAX_DELAY     = time.Minute
+	RECONNECT_MIN_CONN_TIME = 20 * time.Second
+)
+
+// Timeout returns the request timeout of url.
+func Timeout(url string) (time.Duration, error) {
+	return duration(config.EndpointConfig(url).Timeout, DEFAULT_TIMEOUT)
+}
+
+func duration(s string, def time.Duration) (time.Duration, error) {
+	if s == "" {
+		return def, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// TLSConfig builds the tls config of url from its ca bundle and client certificate.
+func TLSConfig(url string) (*tls.Config, error) {
+	e := config.EndpointConfig(url)
+	cfg := &tls.Config{InsecureSkipVerify: e.Insecure}
+	if e.CaFile != "" {
+		pem, err := os.ReadFile(e.CaFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate in %v", e.CaFile)
+		}
+		cfg.RootCAs = pool
+	}
+	if e.CertFile != "" || e.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(e.CertFile, e.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// Headers returns the custom headers of url including the auth header.
+func Headers(url string) map[string]string {
+	e := config.EndpointConfig(url)
+	headers := make(map[string]string, len(e.Headers)+1)
+	for k, v := range e.Headers {
+		headers[k] = v
+	}
+	if e.Token != "" {
+		headers["Authorization"] = "Bearer " + e.Token
+	} else if e.User != "" {
+		headers["Authorization"] = "Basic " + base64.StdEncoding.EncodeToString([]byte(e.User+":"+e.Password))
+	}
+	return headers
+}
+
+type headerTransport struct {
+	headers map[string]string
+	base    http.RoundTripper
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if len(t.headers) == 0 {
+		return t.base.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	for k, v := range t.headers {
+		req.Header.Set(k, v)
+	}
+	return t.base.RoundTrip(req)
+}
+
+// HttpClient returns a http client for url with its timeout, tls and headers.
+func HttpClient(url string) (*http.Client, error) {
+	timeout, err := Timeout(url)
+	if err != nil {
+		logger.Error("rpcdial timeout error:", err)
+		return nil, err
+	}
+	tlsConfig, err := TLSConfig(url)
+	if err != nil {
+		logger.Error("rpcdial TLSConfig error:", err)
+		return nil, err
+	}
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.TLSClientConfig = tlsConfig
+	return &http.Client{
+		Transport: &headerTransport{headers: Headers(url), base: tr},
+		Timeout:   timeout,
+	}, nil
+}
+
+// DialRpc dials a json rpc endpoint, options other than the timeout only
+// apply to http urls.
+func DialRpc(url string) (*rpc.Client, error) {
+	if strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://") {
+		client, err := HttpClient(url)
+		if err != nil {
+			return nil, err
+		}
+		return rpc.DialHTTPWithClient(url, client)
+	}
+	timeout, err := Timeout(url)
+	if err != nil {
+		logger.Error("rpcdial timeout error:", err)
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return rpc.DialContext(ctx, url)
+}
+
+func DialEth(url string) (*ethclient.Client, error) {
+	client, err := DialRpc(url)
+	if err != nil {
+		return nil, err
+	}
+	return ethclient.NewClient(client), nil
+}
+
+type headerCredentials struct {
+	headers map[string]string
+	secure  bool
+}
+
+func (c *headerCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	md := make(map[string]string, len(c.headers))
+	for k, v := range c.headers {
+		md[strings.ToLower(k)] = v
+	}
+	return md, nil
+}
+
+func (c *headerCredentials) RequireTransportSecurity() bool {
+	return c.secure
+}
+
+// GrpcDialOptions returns the dial options of a grpc target: transport
+// security, auth metadata, keepalive, reconnect backoff and a default
+// deadline for calls made without one.
+func GrpcDialOptions(target string) ([]grpc.DialOption, error) {
+	e := config.EndpointConfig(target)
+	timeout, err := Timeout(target)
+	if err != nil {
+		logger.Error("rpcdial timeout error:", err)
+		return nil, err
+	}
+	keepAlive, err := duration(e.KeepAlive, 0)
+	if err != nil {
+		logger.Error("rpcdial keepalive error:", err)
+		return nil, err
+	}
+
+	secure := e.Tls || e.CaFile != "" || e.CertFile != ""
+	var opts []grpc.DialOption
+	if secure {
+		tlsConfig, err := TLSConfig(target)
+		if err != nil {
+			logger.Error("rpcdial TLSConfig error:", err)
+			return nil, err
+		}
+		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	} else {
+		opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	}
+	if headers := Headers(target); len(headers) > 0 {
+		opts = append(opts, grpc.WithPerRPCCredentials(&headerCredentials{headers: headers, secure: secure}))
+	}
+	// off by default, servers reject pings more frequent than they allow
+	if keepAlive > 0 {
+		opts = append(opts, grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                keepAlive,
+			Timeout:             DEFAULT_KEEPALIVE_WAIT,
+			PermitWithoutStream: true,
+		}))
+	}
+	bc := backoff.DefaultConfig
+	bc.MaxDelay = RECONNECT_MAX_DELAY
+	opts = append(opts,
+		grpc.WithConnectParams(grpc.ConnectParams{Backoff: bc, MinConnectTimeout: RECONNECT_MIN_CONN_TIME}),
+		grpc.WithUnaryInterceptor(timeoutInterceptor(timeout)),
+	)
+	return opts, nil
+}
+
+func timeoutInterceptor(timeout time.Duration) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if _, ok := ctx.Deadline(); !ok && timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
@@ -0,0 +1,143 @@
+package rpcdial
+
+import (
+	"context"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"toprelayer/config"
+
+	"google.golang.org/grpc"
+)
+
+func TestHttpClientHeaders(t *testing.T) {
+	var auth, custom string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		custom = r.Header.Get("X-Api-Key")
+	}))
+	defer server.Close()
+
+	config.SetEndpointConfig(server.URL, &config.Endpoint{
+		Headers:  map[string]string{"X-Api-Key": "key"},
+		User:     "user",
+		Password: "pass",
+	})
+	client, err := HttpClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Get(server.URL); err != nil {
+		t.Fatal(err)
+	}
+	if auth != "Basic dXNlcjpwYXNz" || custom != "key" {
+		t.Fatal("unexpected headers:", auth, custom)
+	}
+
+	config.SetEndpointConfig(server.URL, &config.Endpoint{Token: "token"})
+	client, err = HttpClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Get(server.URL); err != nil {
+		t.Fatal(err)
+	}
+	if auth != "Bearer token" {
+		t.Fatal("unexpected auth:", auth)
+	}
+}
+
+func TestHttpClientTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(500 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	config.SetEndpointConfig(server.URL, &config.Endpoint{Timeout: "50ms"})
+	client, err := HttpClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Get(server.URL); err == nil {
+		t.Fatal("expect timeout")
+	}
+
+	config.SetEndpointConfig(server.URL, &config.Endpoint{Timeout: "soon"})
+	if _, err := HttpClient(server.URL); err == nil {
+		t.Fatal("expect bad timeout error")
+	}
+}
+
+func TestHttpClientCaFile(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	// the test server certificate is not trusted by default
+	client, err := HttpClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Get(server.URL); err == nil {
+		t.Fatal("expect unknown authority")
+	}
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caFile, ca, 0600); err != nil {
+		t.Fatal(err)
+	}
+	config.SetEndpointConfig(server.URL, &config.Endpoint{CaFile: caFile})
+	client, err = HttpClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Get(server.URL); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDialRpcHeaders(t *testing.T) {
+	var auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x10"}`))
+	}))
+	defer server.Close()
+
+	config.SetEndpointConfig(server.URL, &config.Endpoint{Token: "token"})
+	client, err := DialEth(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	number, err := client.BlockNumber(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if number != 16 || auth != "Bearer token" {
+		t.Fatal("unexpected result:", number, auth)
+	}
+}
+
+func TestTimeoutInterceptor(t *testing.T) {
+	interceptor := timeoutInterceptor(time.Second)
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		if _, ok := ctx.Deadline(); !ok {
+			t.Fatal("expect deadline")
+		}
+		return nil
+	}
+	if err := interceptor(context.Background(), "test", nil, nil, nil, invoker); err != nil {
+		t.Fatal(err)
+	}
+
+	config.SetEndpointConfig("localhost:4000", &config.Endpoint{KeepAlive: "bad"})
+	if _, err := GrpcDialOptions("localhost:4000"); err == nil {
+		t.Fatal("expect bad keepalive error")
+	}
+}
@@ -0,0 +1,179 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/urfave/cli/v2"
+
+	"toprelayer/config"
+	"toprelayer/relayer/toprelayer/ethash"
+	"toprelayer/relayer/toprelayer/ethashapp"
+	"toprelayer/relayer/toprelayer/ethashproof"
+	"toprelayer/rpcdial"
+)
+
+const (
+	FOLLOW_INTERVAL = time.Minute
+
+	HTTP_READ_TIMEOUT = 10 * time.Second
+	// longer than a proof request waits for its epoch
+	HTTP_WRITE_TIMEOUT = ethashapp.REQUEST_TIMEOUT + 10*time.Second
+)
+
+func chainParams(chain string) (*ethash.Params, error) {
+	switch chain {
+	case config.ETH_CHAIN:
+		return ethash.EthashParams, nil
+	case config.ETC_CHAIN:
+		return ethash.EtchashParams, nil
+	}
+	return nil, fmt.Errorf("unsupported chain %v", chain)
+}
+
+// readHeaderFile reads an RLP encoded header, either binary or hex.
+func readHeaderFile(path string) (*types.Header, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if text := strings.TrimPrefix(strings.TrimSpace(string(data)), "0x"); isHex(text) {
+		data = common.Hex2Bytes(text)
+	}
+	header := new(types.Header)
+	if err := rlp.DecodeBytes(data, header); err != nil {
+		return nil, err
+	}
+	return header, nil
+}
+
+func isHex(s string) bool {
+	if len(s) == 0 || len(s)%2 != 0 {
+		return false
+	}
+	for _, c := range s {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+			return false
+		}
+	}
+	return true
+}
+
+func ethashProof(ctx *cli.Context) error {
+	format := ctx.String(FormatFlag.Name)
+	if format != ethashapp.FORMAT_JSON && format != ethashapp.FORMAT_RLP {
+		return ethashapp.ErrUnknownFormat
+	}
+	params, err := chainParams(ctx.String(ChainFlag.Name))
+	if err != nil {
+		return err
+	}
+	store := ethashproof.NewStore(ctx.String(DagDirFlag.Name), ctx.String(ProofDirFlag.Name), ctx.Uint64(DiskQuotaFlag.Name)<<20)
+	prover := ethashapp.NewProver(params, store)
+
+	var source ethashapp.HeaderSource
+	if url := ctx.String(RpcUrlFlag.Name); url != "" {
+		client, err := rpcdial.DialEth(url)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+		source = client
+	}
+	service := ethashapp.NewService(prover, source)
+
+	if addr := ctx.String(HttpFlag.Name); addr != "" {
+		if source == nil {
+			return errors.New("need --rpc to serve proofs")
+		}
+		mux := http.NewServeMux()
+		mux.Handle(ethashapp.PROOF_PATH, service)
+		mux.Handle(ethashapp.STATUS_PATH, service)
+		go service.Follow(context.Background(), FOLLOW_INTERVAL)
+		fmt.Fprintln(os.Stderr, "serving proofs on", addr+ethashapp.PROOF_PATH+"<block>")
+		server := &http.Server{
+			Addr:         addr,
+			Handler:      mux,
+			ReadTimeout:  HTTP_READ_TIMEOUT,
+			WriteTimeout: HTTP_WRITE_TIMEOUT,
+		}
+		return server.ListenAndServe()
+	}
+
+	var header *types.Header
+	if path := ctx.String(RlpFileFlag.Name); path != "" {
+		if header, err = readHeaderFile(path); err != nil {
+			return err
+		}
+		if ctx.Args().Len() > 0 && ctx.Args().First() != header.Number.String() {
+			return fmt.Errorf("header of block %v, not %v", header.Number, ctx.Args().First())
+		}
+	} else {
+		if source == nil {
+			return errors.New("need --rpc or --rlp to read the header")
+		}
+		if ctx.Args().Len() != 1 {
+			return errors.New("need block number as the only argument")
+		}
+		number, err := strconv.ParseUint(ctx.Args().First(), 10, 64)
+		if err != nil {
+			return errors.New("invalid block number")
+		}
+		if header, err = source.HeaderByNumber(context.Background(), new(big.Int).SetUint64(number)); err != nil {
+			return err
+		}
+	}
+
+	out, err := service.HeaderProof(context.Background(), header)
+	if err != nil {
+		return err
+	}
+	data, err := ethashapp.EncodeOutput(out, format)
+	if err != nil {
+		return err
+	}
+	if format == ethashapp.FORMAT_RLP {
+		fmt.Println(common.Bytes2Hex(data))
+	} else {
+		fmt.Println(string(data))
+	}
+	return nil
+}
+
+var (
+	EthashProofCommand = &cli.Command{
+		Action:    ethashProof,
+		Name:      "ethash-proof",
+		Usage:     "Print the header of a block with its DAG merkle proofs",
+		ArgsUsage: "<block>",
+		Category:  "MISCELLANEOUS COMMANDS",
+		Flags: []cli.Flag{
+			&RpcUrlFlag,
+			&RlpFileFlag,
+			&FormatFlag,
+			&ChainFlag,
+			&DagDirFlag,
+			&ProofDirFlag,
+			&DiskQuotaFlag,
+			&HttpFlag,
+		},
+		Description: `
+The header is read from --rpc or from the --rlp file, the output is json or
+hex rlp. With --http the proofs of the --rpc chain are served on
+GET /proof/<block>?format=json|rlp instead, sharing the epoch caches
+between all consumers. Only blocks of cached epochs and of the epoch of the
+head of --rpc and the next one are served, the caches of the next epoch are
+generated ahead of the head. GET /status shows their states.
+`,
+	}
+)
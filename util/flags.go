@@ -27,6 +27,47 @@ var (
 		Usage: "Password file to use for non-interactive password input",
 		Value: "",
 	}
+	// ethash-proof config
+	RpcUrlFlag = cli.StringFlag{
+		Name:  "rpc",
+		Usage: "RPC url of the chain to read headers from",
+		Value: "",
+	}
+	RlpFileFlag = cli.StringFlag{
+		Name:  "rlp",
+		Usage: "File of the RLP encoded header, in binary or hex",
+		Value: "",
+	}
+	FormatFlag = cli.StringFlag{
+		Name:  "format",
+		Usage: "Output format, json or rlp",
+		Value: "json",
+	}
+	ChainFlag = cli.StringFlag{
+		Name:  "chain",
+		Usage: "Epoch schedule of the chain, ETH or ETC",
+		Value: "ETH",
+	}
+	DagDirFlag = cli.StringFlag{
+		Name:  "dagdir",
+		Usage: "Directory of the DAGs",
+		Value: "",
+	}
+	ProofDirFlag = cli.StringFlag{
+		Name:  "proofdir",
+		Usage: "Directory of the dataset merkle tree caches",
+		Value: "",
+	}
+	DiskQuotaFlag = cli.Uint64Flag{
+		Name:  "diskquota",
+		Usage: "Disk quota of the DAGs and caches in MB, 0 for unlimited",
+		Value: 0,
+	}
+	HttpFlag = cli.StringFlag{
+		Name:  "http",
+		Usage: "Serve proofs over HTTP on this address instead, e.g. :8545",
+		Value: "",
+	}
 )
 
 func MakePassword(ctx *cli.Context, cfg *config.Config) (string, error) {
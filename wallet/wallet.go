@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"math/big"
 
+	"toprelayer/errs"
+	"toprelayer/rpcdial"
 	top "toprelayer/types"
 
 	"github.com/wonderivan/logger"
@@ -36,7 +38,7 @@ func NewEthWallet(ethurl, topurl, path, pass string) (*Wallet, error) {
 	if err != nil {
 		return nil, err
 	}
-	rpcclient, err := rpc.Dial(topurl)
+	rpcclient, err := rpcdial.DialRpc(topurl)
 	if err != nil {
 		return nil, err
 	}
@@ -51,7 +53,7 @@ func newWallet(url, path, pass string) (*Wallet, error) {
 
 	w := new(Wallet)
 
-	ethclient, err := ethclient.Dial(url)
+	ethclient, err := rpcdial.DialEth(url)
 	if err != nil {
 		return nil, err
 	}
@@ -86,11 +88,13 @@ func newWallet(url, path, pass string) (*Wallet, error) {
 }
 
 func (w *Wallet) NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (uint64, error) {
-	return w.ethclient.NonceAt(ctx, account, blockNumber)
+	nonce, err := w.ethclient.NonceAt(ctx, account, blockNumber)
+	return nonce, errs.FromRpc(err)
 }
 
 func (w *Wallet) BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (balance *big.Int, err error) {
-	return w.ethclient.BalanceAt(ctx, account, nil)
+	balance, err = w.ethclient.BalanceAt(ctx, account, nil)
+	return balance, errs.FromRpc(err)
 }
 
 func (w *Wallet) Address() common.Address {
@@ -102,7 +106,8 @@ func (w *Wallet) ChainID(ctx context.Context) (*big.Int, error) {
 }
 
 func (w *Wallet) SuggestGasPrice(ctx context.Context) (*big.Int, error) {
-	return w.ethclient.SuggestGasPrice(ctx)
+	price, err := w.ethclient.SuggestGasPrice(ctx)
+	return price, errs.FromRpc(err)
 }
 
 func (w *Wallet) EstimateGas(ctx context.Context, target *common.Address, data []byte) (uint64, error) {
@@ -115,11 +120,18 @@ func (w *Wallet) EstimateGas(ctx context.Context, target *common.Address, data [
 		GasTipCap: nil,
 		Data:      data,
 	}
-	return w.ethclient.EstimateGas(ctx, msg)
+	gas, err := w.ethclient.EstimateGas(ctx, msg)
+	return gas, errs.FromRpc(err)
 }
 
 func (w *Wallet) SuggestGasTipCap(ctx context.Context) (*big.Int, error) {
-	return w.ethclient.SuggestGasTipCap(ctx)
+	tip, err := w.ethclient.SuggestGasTipCap(ctx)
+	return tip, errs.FromRpc(err)
+}
+
+func (w *Wallet) FeeHistory(ctx context.Context, blockCount uint64, lastBlock *big.Int, rewardPercentiles []float64) (*ethereum.FeeHistory, error) {
+	history, err := w.ethclient.FeeHistory(ctx, blockCount, lastBlock, rewardPercentiles)
+	return history, errs.FromRpc(err)
 }
 
 //sign tx
@@ -129,23 +141,24 @@ func (w *Wallet) SignTx(tx *types.Transaction) (signedTx *types.Transaction, err
 
 //send signed tx
 func (w *Wallet) SendTransaction(ctx context.Context, tx *types.Transaction) error {
-	return w.ethclient.SendTransaction(ctx, tx)
+	return errs.FromRpc(w.ethclient.SendTransaction(ctx, tx))
 }
 
 func (w *Wallet) TransactionReceipt(ctx context.Context, hash common.Hash) (*types.Receipt, error) {
-	return w.ethclient.TransactionReceipt(ctx, hash)
+	receipt, err := w.ethclient.TransactionReceipt(ctx, hash)
+	return receipt, errs.FromRpc(err)
 }
 
 func (w *Wallet) TopBalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (balance *big.Int, err error) {
 	var result hexutil.Big
 	err = w.rpc.CallContext(ctx, &result, "top_getBalance", account)
-	return (*big.Int)(&result), err
+	return (*big.Int)(&result), errs.FromRpc(err)
 }
 
 func (w *Wallet) TopBlockNumber(ctx context.Context) (uint64, error) {
 	var result hexutil.Uint64
 	err := w.rpc.CallContext(ctx, &result, "topRelay_blockNumber")
-	return uint64(result), err
+	return uint64(result), errs.FromRpc(err)
 }
 
 func toBlockNumArg(number *big.Int) string {
@@ -165,5 +178,5 @@ func (w *Wallet) TopHeaderByNumber(ctx context.Context, number *big.Int) (*top.T
 	if err == nil && head == nil {
 		err = ethereum.NotFound
 	}
-	return head, err
+	return head, errs.FromRpc(err)
 }